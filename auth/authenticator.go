@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrUnrecognized is returned by an Authenticator when a credential is not
+	// in a format it handles, so that the next one can be tried.
+	ErrUnrecognized = errors.New("unrecognized credential")
+	// ErrInvalidCredential is returned when a credential is recognised but is
+	// unknown, expired or revoked.
+	ErrInvalidCredential = errors.New("invalid credential")
+)
+
+// Authenticator resolves the principal for a credential taken from a request.
+type Authenticator interface {
+	Authenticate(ctx context.Context, credential string) (*Principal, error)
+}
+
+// AuthenticatorFunc adapts a function to an Authenticator.
+type AuthenticatorFunc func(ctx context.Context, credential string) (*Principal, error)
+
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, credential string) (*Principal, error) {
+	return f(ctx, credential)
+}
+
+// Chain tries each authenticator in order until one recognises the credential.
+func Chain(authenticators ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(ctx context.Context, credential string) (*Principal, error) {
+		for _, authenticator := range authenticators {
+			principal, err := authenticator.Authenticate(ctx, credential)
+			if errors.Is(err, ErrUnrecognized) {
+				continue
+			}
+			return principal, err
+		}
+		return nil, ErrUnrecognized
+	})
+}
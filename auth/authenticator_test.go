@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	prefixed := func(prefix, id string) Authenticator {
+		return AuthenticatorFunc(func(ctx context.Context, credential string) (*Principal, error) {
+			if len(credential) < len(prefix) || credential[:len(prefix)] != prefix {
+				return nil, ErrUnrecognized
+			}
+			if credential == prefix+"bad" {
+				return nil, ErrInvalidCredential
+			}
+			return &Principal{ID: id}, nil
+		})
+	}
+	chain := Chain(prefixed("a_", "first"), prefixed("b_", "second"))
+
+	tests := []struct {
+		credential string
+		wantID     string
+		wantErr    error
+	}{
+		{"a_key", "first", nil},
+		{"b_key", "second", nil},
+		{"a_bad", "", ErrInvalidCredential},
+		{"c_key", "", ErrUnrecognized},
+	}
+
+	for _, tt := range tests {
+		principal, err := chain.Authenticate(context.Background(), tt.credential)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: expected error %v, got %v", tt.credential, tt.wantErr, err)
+		}
+		if tt.wantID != "" && (principal == nil || principal.ID != tt.wantID) {
+			t.Errorf("%s: expected principal %s, got %+v", tt.credential, tt.wantID, principal)
+		}
+	}
+}
+
+func TestPrincipalFromContext(t *testing.T) {
+	if _, ok := PrincipalFromContext(context.Background()); ok {
+		t.Error("expected no principal in empty context")
+	}
+
+	ctx := WithPrincipal(context.Background(), &Principal{ID: "api_key:1", Scopes: []string{"admin"}})
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok || principal.ID != "api_key:1" {
+		t.Fatalf("expected principal api_key:1, got %+v", principal)
+	}
+	if !principal.HasScope("admin") || principal.HasScope("write") {
+		t.Errorf("unexpected scopes %v", principal.Scopes)
+	}
+}
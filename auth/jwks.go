@@ -0,0 +1,280 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// ErrKeyNotFound is returned when a key set has no key with the requested id.
+var ErrKeyNotFound = errors.New("signing key not found")
+
+// jwksFetchTimeout bounds a reload, which outlives the request that started it.
+const jwksFetchTimeout = 30 * time.Second
+
+// jsonWebKey is the subset of RFC 7517 needed for public signature keys.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey is a parsed JWK. Alg is empty when the JWK does not pin one.
+type publicKey struct {
+	Alg string
+	Key crypto.PublicKey
+}
+
+// JWKSConfig controls how often a JWKS is reloaded.
+type JWKSConfig struct {
+	// RefreshInterval is how long a loaded key set is used before reloading.
+	RefreshInterval time.Duration
+	// MinRefreshInterval limits reloads triggered by tokens signed with an
+	// unknown key id, which is how key rotation is picked up between refreshes.
+	MinRefreshInterval time.Duration
+	HTTPClient         *http.Client
+}
+
+// DefaultJWKSConfig returns a configuration with sensible defaults
+func DefaultJWKSConfig() JWKSConfig {
+	return JWKSConfig{
+		RefreshInterval:    time.Hour,
+		MinRefreshInterval: time.Minute,
+		HTTPClient:         &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// JWKS is a cached JSON Web Key Set loaded from a URL or a local file.
+type JWKS struct {
+	load   func(ctx context.Context) ([]byte, error)
+	config JWKSConfig
+
+	refreshes singleflight.Group
+
+	mu       sync.RWMutex
+	keys     map[string]publicKey
+	loadedAt time.Time
+	tried    time.Time
+}
+
+// NewRemoteJWKS returns a key set fetched from url, typically an identity
+// provider's jwks_uri.
+func NewRemoteJWKS(url string, config JWKSConfig) *JWKS {
+	client := config.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &JWKS{
+		config: config,
+		load: func(ctx context.Context) ([]byte, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return nil, err
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("unexpected status code %d fetching jwks", resp.StatusCode)
+			}
+			return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		},
+	}
+}
+
+// NewFileJWKS returns a key set read from a local file. The file is re-read on
+// the same schedule as a remote set, so keys can be rotated in place.
+func NewFileJWKS(path string, config JWKSConfig) *JWKS {
+	return &JWKS{
+		config: config,
+		load: func(ctx context.Context) ([]byte, error) {
+			return os.ReadFile(path)
+		},
+	}
+}
+
+// key returns the key with the given id, reloading the set when it is stale or
+// the id is unknown. An empty kid matches a set holding a single key.
+func (j *JWKS) key(ctx context.Context, kid string) (publicKey, error) {
+	j.mu.RLock()
+	key, found := j.lookup(kid)
+	stale := time.Since(j.loadedAt) > j.config.RefreshInterval
+	j.mu.RUnlock()
+
+	if found && !stale {
+		return key, nil
+	}
+
+	if err := j.refresh(ctx); err != nil {
+		// Keep serving the previous keys if the source is briefly unavailable
+		if found {
+			return key, nil
+		}
+		return publicKey{}, fmt.Errorf("failed to load jwks: %w", err)
+	}
+
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if key, found = j.lookup(kid); found {
+		return key, nil
+	}
+	return publicKey{}, ErrKeyNotFound
+}
+
+// refresh reloads the set at most once per MinRefreshInterval, unless no keys
+// were loaded yet. Concurrent callers share a single fetch, which runs detached
+// from ctx so a cancelled request does not fail the others waiting on it and
+// no lock is held while the source is slow.
+func (j *JWKS) refresh(ctx context.Context) error {
+	result := j.refreshes.DoChan("", func() (any, error) {
+		j.mu.RLock()
+		recent := time.Since(j.tried) < j.config.MinRefreshInterval && j.keys != nil
+		j.mu.RUnlock()
+		if recent {
+			return nil, nil
+		}
+
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jwksFetchTimeout)
+		defer cancel()
+		keys, err := j.fetch(fetchCtx)
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		j.tried = time.Now()
+		if err != nil {
+			return nil, err
+		}
+		j.keys = keys
+		j.loadedAt = j.tried
+		return nil, nil
+	})
+
+	select {
+	case res := <-result:
+		return res.Err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (j *JWKS) lookup(kid string) (publicKey, bool) {
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, true
+		}
+	}
+	key, ok := j.keys[kid]
+	return key, ok
+}
+
+func (j *JWKS) fetch(ctx context.Context) (map[string]publicKey, error) {
+	data, err := j.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("decode jwks: %w", err)
+	}
+
+	keys := make(map[string]publicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := parseJWK(jwk)
+		if err != nil {
+			// Skip keys of unsupported types instead of rejecting the whole set
+			continue
+		}
+		keys[jwk.Kid] = publicKey{Alg: jwk.Alg, Key: key}
+	}
+	return keys, nil
+}
+
+func parseJWK(jwk jsonWebKey) (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("rsa exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid P-256 coordinates")
+		}
+		// Uncompressed SEC 1 point, validated to be on the curve
+		point := append(append([]byte{4}, x...), y...)
+		return ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
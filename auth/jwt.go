@@ -0,0 +1,265 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/utilities"
+)
+
+// Supported JWS signature algorithms.
+const (
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
+// JWTConfig holds the settings for validating bearer tokens issued by an
+// identity provider.
+type JWTConfig struct {
+	// JWKSURL or JWKSFile is the source of the verification keys. JWT
+	// authentication is disabled when neither is set.
+	JWKSURL  string
+	JWKSFile string
+	// Issuer and Audience are checked against the iss and aud claims. Both
+	// are required, without them a token minted for any other application
+	// trusting the same identity provider would be accepted.
+	Issuer   string
+	Audience string
+	// ClockSkew is the leeway allowed when checking exp and nbf.
+	ClockSkew time.Duration
+}
+
+// DefaultJWTConfig returns a configuration read from the environment
+func DefaultJWTConfig() JWTConfig {
+	return JWTConfig{
+		JWKSURL:   utilities.GetEnvOrDefault("JWT_JWKS_URL", ""),
+		JWKSFile:  utilities.GetEnvOrDefault("JWT_JWKS_FILE", ""),
+		Issuer:    utilities.GetEnvOrDefault("JWT_ISSUER", ""),
+		Audience:  utilities.GetEnvOrDefault("JWT_AUDIENCE", ""),
+		ClockSkew: time.Minute,
+	}
+}
+
+// Enabled reports whether a key source is configured.
+func (c JWTConfig) Enabled() bool {
+	return c.JWKSURL != "" || c.JWKSFile != ""
+}
+
+// JWKS returns the key set configured by JWKSURL or JWKSFile.
+func (c JWTConfig) JWKS(config JWKSConfig) *JWKS {
+	if c.JWKSURL != "" {
+		return NewRemoteJWKS(c.JWKSURL, config)
+	}
+	return NewFileJWKS(c.JWKSFile, config)
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	ExpiresAt         *float64 `json:"exp"`
+	NotBefore         *float64 `json:"nbf"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+	// Scopes are read from the space separated OAuth scope claim or the scp array
+	Scope string   `json:"scope"`
+	Scp   []string `json:"scp"`
+	Roles []string `json:"roles"`
+	// Tenant binds the token to a single tenant
+	Tenant string `json:"tenant_id"`
+}
+
+// audience decodes an aud claim given either as a string or an array.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// JWTAuthenticator validates signed JWTs against a JWKS and maps their claims
+// to a user principal.
+type JWTAuthenticator struct {
+	keys   *JWKS
+	config JWTConfig
+	now    func() time.Time
+}
+
+// ErrJWTAudienceRequired is returned by NewJWTAuthenticator when the issuer
+// or audience a token must be minted for is not configured.
+var ErrJWTAudienceRequired = errors.New("jwt issuer and audience are required")
+
+func NewJWTAuthenticator(keys *JWKS, config JWTConfig) (*JWTAuthenticator, error) {
+	if config.Issuer == "" || config.Audience == "" {
+		return nil, ErrJWTAudienceRequired
+	}
+	return &JWTAuthenticator{keys: keys, config: config, now: time.Now}, nil
+}
+
+// Authenticate verifies a compact serialised JWS. Credentials that are not
+// shaped like a JWT are left to other authenticators.
+func (a *JWTAuthenticator) Authenticate(ctx context.Context, credential string) (*Principal, error) {
+	parts := strings.Split(credential, ".")
+	if len(parts) != 3 {
+		return nil, ErrUnrecognized
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, invalidToken("malformed header")
+	}
+	if !slices.Contains([]string{AlgRS256, AlgES256, AlgEdDSA}, header.Alg) {
+		return nil, invalidToken("unsupported algorithm %q", header.Alg)
+	}
+
+	key, err := a.keys.key(ctx, header.Kid)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, invalidToken("unknown key id %q", header.Kid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key.Alg != "" && key.Alg != header.Alg {
+		return nil, invalidToken("algorithm %q does not match key", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalidToken("malformed signature")
+	}
+	if !verifySignature(header.Alg, key.Key, []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, invalidToken("invalid signature")
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, invalidToken("malformed claims")
+	}
+	if err := a.validate(claims); err != nil {
+		return nil, err
+	}
+
+	return claims.principal(), nil
+}
+
+func (a *JWTAuthenticator) validate(claims jwtClaims) error {
+	now := a.now()
+
+	if claims.ExpiresAt == nil {
+		return invalidToken("missing exp claim")
+	}
+	if now.After(unixTime(*claims.ExpiresAt).Add(a.config.ClockSkew)) {
+		return invalidToken("token expired")
+	}
+	if claims.NotBefore != nil && now.Add(a.config.ClockSkew).Before(unixTime(*claims.NotBefore)) {
+		return invalidToken("token not yet valid")
+	}
+	if claims.Issuer != a.config.Issuer {
+		return invalidToken("unexpected issuer %q", claims.Issuer)
+	}
+	if !slices.Contains(claims.Audience, a.config.Audience) {
+		return invalidToken("token not issued for this audience")
+	}
+	if claims.Subject == "" {
+		return invalidToken("missing sub claim")
+	}
+	return nil
+}
+
+func (c jwtClaims) principal() *Principal {
+	name := c.Name
+	if name == "" {
+		name = c.PreferredUsername
+	}
+	if name == "" {
+		name = c.Subject
+	}
+
+	scopes := strings.Fields(c.Scope)
+	if len(scopes) == 0 {
+		scopes = c.Scp
+	}
+
+	return &Principal{
+		ID:     KindUser + ":" + c.Subject,
+		Name:   name,
+		Kind:   KindUser,
+		Scopes: scopes,
+		Roles:  c.Roles,
+		Tenant: c.Tenant,
+	}
+}
+
+func verifySignature(alg string, key crypto.PublicKey, input, signature []byte) bool {
+	switch alg {
+	case AlgRS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		digest := sha256.Sum256(input)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+
+	case AlgES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return false
+		}
+		// JWS encodes ECDSA signatures as the fixed width concatenation r || s
+		digest := sha256.Sum256(input)
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(pub, digest[:], r, s)
+
+	case AlgEdDSA:
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return false
+		}
+		return ed25519.Verify(pub, input, signature)
+	}
+	return false
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+func invalidToken(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidCredential, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,393 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testKey is a locally generated signing key and its public JWK.
+type testKey struct {
+	kid  string
+	alg  string
+	priv crypto.Signer
+}
+
+func newTestKey(t *testing.T, kid, alg string) testKey {
+	t.Helper()
+	var priv crypto.Signer
+	var err error
+	switch alg {
+	case AlgRS256:
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgES256:
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatalf("generate %s key: %v", alg, err)
+	}
+	return testKey{kid: kid, alg: alg, priv: priv}
+}
+
+func (k testKey) jwk() jsonWebKey {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pub := k.priv.Public().(type) {
+	case *rsa.PublicKey:
+		return jsonWebKey{Kty: "RSA", Kid: k.kid, Alg: k.alg, Use: "sig",
+			N: b64(pub.N.Bytes()), E: b64(big.NewInt(int64(pub.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		point, _ := pub.Bytes()
+		return jsonWebKey{Kty: "EC", Kid: k.kid, Alg: k.alg, Crv: "P-256",
+			X: b64(point[1:33]), Y: b64(point[33:])}
+	case ed25519.PublicKey:
+		return jsonWebKey{Kty: "OKP", Kid: k.kid, Crv: "Ed25519", X: b64(pub)}
+	}
+	return jsonWebKey{}
+}
+
+func (k testKey) sign(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": k.alg, "kid": k.kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var signature []byte
+	var err error
+	switch priv := k.priv.(type) {
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(input))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(input))
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, priv, digest[:])
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(priv, []byte(input))
+	}
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// jwksServer serves the public keys of whichever keys are currently set.
+type jwksServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	keys     []testKey
+	requests atomic.Int32
+}
+
+func newJWKSServer(t *testing.T, keys ...testKey) *jwksServer {
+	s := &jwksServer{keys: keys}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		json.NewEncoder(w).Encode(jwksDocument(s.keys...))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) rotate(keys ...testKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func jwksDocument(keys ...testKey) map[string][]jsonWebKey {
+	doc := map[string][]jsonWebKey{"keys": {}}
+	for _, k := range keys {
+		doc["keys"] = append(doc["keys"], k.jwk())
+	}
+	return doc
+}
+
+func testJWTConfig() JWTConfig {
+	return JWTConfig{Issuer: "https://sso.example.com", Audience: "go-server-starter", ClockSkew: 30 * time.Second}
+}
+
+func newTestAuthenticator(t *testing.T, keys *JWKS, config JWTConfig) *JWTAuthenticator {
+	t.Helper()
+	authenticator, err := NewJWTAuthenticator(keys, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authenticator
+}
+
+func TestNewJWTAuthenticatorRequiresAudience(t *testing.T) {
+	keys := NewRemoteJWKS("http://127.0.0.1:0", DefaultJWKSConfig())
+	for _, config := range []JWTConfig{
+		{Audience: "go-server-starter"},
+		{Issuer: "https://sso.example.com"},
+	} {
+		if _, err := NewJWTAuthenticator(keys, config); !errors.Is(err, ErrJWTAudienceRequired) {
+			t.Errorf("expected ErrJWTAudienceRequired for %+v, got %v", config, err)
+		}
+	}
+}
+
+func validClaims() map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":       "https://sso.example.com",
+		"aud":       []string{"go-server-starter", "other"},
+		"sub":       "42",
+		"name":      "Alice",
+		"scope":     "todos:read todos:write",
+		"tenant_id": "acme",
+		"exp":       now.Add(time.Hour).Unix(),
+		"nbf":       now.Add(-time.Minute).Unix(),
+	}
+}
+
+func TestJWTAuthenticator_Algorithms(t *testing.T) {
+	for _, alg := range []string{AlgRS256, AlgES256, AlgEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			key := newTestKey(t, "key-"+alg, alg)
+			server := newJWKSServer(t, key)
+			authenticator := newTestAuthenticator(t, NewRemoteJWKS(server.URL, DefaultJWKSConfig()), testJWTConfig())
+
+			principal, err := authenticator.Authenticate(context.Background(), key.sign(t, validClaims()))
+			if err != nil {
+				t.Fatalf("expected token to be accepted, got %v", err)
+			}
+			if principal.ID != "user:42" || principal.Kind != KindUser || principal.Name != "Alice" {
+				t.Errorf("unexpected principal %+v", principal)
+			}
+			if !principal.HasScope("todos:read") || !principal.HasScope("todos:write") {
+				t.Errorf("expected scopes from scope claim, got %v", principal.Scopes)
+			}
+			if principal.Tenant != "acme" {
+				t.Errorf("expected tenant from tenant_id claim, got %q", principal.Tenant)
+			}
+		})
+	}
+}
+
+func TestJWTAuthenticator_RejectsInvalidTokens(t *testing.T) {
+	key := newTestKey(t, "main", AlgES256)
+	other := newTestKey(t, "main", AlgES256)
+	server := newJWKSServer(t, key)
+	authenticator := newTestAuthenticator(t, NewRemoteJWKS(server.URL, DefaultJWKSConfig()), testJWTConfig())
+
+	with := func(changes map[string]any) map[string]any {
+		claims := validClaims()
+		for k, v := range changes {
+			if v == nil {
+				delete(claims, k)
+				continue
+			}
+			claims[k] = v
+		}
+		return claims
+	}
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"expired", key.sign(t, with(map[string]any{"exp": now.Add(-time.Minute).Unix()}))},
+		{"missing exp", key.sign(t, with(map[string]any{"exp": nil}))},
+		{"not yet valid", key.sign(t, with(map[string]any{"nbf": now.Add(time.Minute).Unix()}))},
+		{"wrong issuer", key.sign(t, with(map[string]any{"iss": "https://evil.example.com"}))},
+		{"wrong audience", key.sign(t, with(map[string]any{"aud": "someone-else"}))},
+		{"missing subject", key.sign(t, with(map[string]any{"sub": nil}))},
+		{"signed by another key", other.sign(t, validClaims())},
+		{"unknown key id", newTestKey(t, "unknown", AlgES256).sign(t, validClaims())},
+		{"tampered claims", func() string {
+			parts := strings.Split(key.sign(t, validClaims()), ".")
+			claims, _ := json.Marshal(with(map[string]any{"sub": "1"}))
+			return parts[0] + "." + base64.RawURLEncoding.EncodeToString(claims) + "." + parts[2]
+		}()},
+		{"alg none", func() string {
+			header, _ := json.Marshal(map[string]string{"alg": "none", "kid": "main"})
+			claims, _ := json.Marshal(validClaims())
+			return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims) + "."
+		}()},
+		{"algorithm mismatch", func() string {
+			// An RS256 header against the ES256 key must not verify
+			rsa := newTestKey(t, "main", AlgRS256)
+			return rsa.sign(t, validClaims())
+		}()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := authenticator.Authenticate(context.Background(), tt.token)
+			if !errors.Is(err, ErrInvalidCredential) {
+				t.Errorf("expected ErrInvalidCredential, got %v", err)
+			}
+			if principal != nil {
+				t.Errorf("expected no principal, got %+v", principal)
+			}
+		})
+	}
+}
+
+func TestJWTAuthenticator_ClockSkew(t *testing.T) {
+	key := newTestKey(t, "main", AlgEdDSA)
+	server := newJWKSServer(t, key)
+	authenticator := newTestAuthenticator(t, NewRemoteJWKS(server.URL, DefaultJWKSConfig()), testJWTConfig())
+
+	claims := validClaims()
+	claims["exp"] = time.Now().Add(-10 * time.Second).Unix()
+	claims["nbf"] = time.Now().Add(10 * time.Second).Unix()
+
+	if _, err := authenticator.Authenticate(context.Background(), key.sign(t, claims)); err != nil {
+		t.Errorf("expected token within clock skew to be accepted, got %v", err)
+	}
+}
+
+func TestJWTAuthenticator_UnrecognizedCredential(t *testing.T) {
+	authenticator := newTestAuthenticator(t, NewRemoteJWKS("http://127.0.0.1:0", DefaultJWKSConfig()), testJWTConfig())
+
+	if _, err := authenticator.Authenticate(context.Background(), "gss_0123456789abcdef"); !errors.Is(err, ErrUnrecognized) {
+		t.Errorf("expected ErrUnrecognized, got %v", err)
+	}
+}
+
+func TestJWKS_KeyRotation(t *testing.T) {
+	oldKey := newTestKey(t, "2024", AlgRS256)
+	newKey := newTestKey(t, "2025", AlgRS256)
+	server := newJWKSServer(t, oldKey)
+
+	config := DefaultJWKSConfig()
+	config.MinRefreshInterval = 0
+	authenticator := newTestAuthenticator(t, NewRemoteJWKS(server.URL, config), testJWTConfig())
+	ctx := context.Background()
+
+	if _, err := authenticator.Authenticate(ctx, oldKey.sign(t, validClaims())); err != nil {
+		t.Fatalf("expected old key to be accepted, got %v", err)
+	}
+	if _, err := authenticator.Authenticate(ctx, oldKey.sign(t, validClaims())); err != nil {
+		t.Fatalf("expected old key to be accepted, got %v", err)
+	}
+	if n := server.requests.Load(); n != 1 {
+		t.Errorf("expected the key set to be cached, got %d fetches", n)
+	}
+
+	server.rotate(newKey)
+
+	// The unknown kid triggers a reload that picks up the new key
+	if _, err := authenticator.Authenticate(ctx, newKey.sign(t, validClaims())); err != nil {
+		t.Fatalf("expected rotated key to be accepted, got %v", err)
+	}
+	if n := server.requests.Load(); n != 2 {
+		t.Errorf("expected one reload after rotation, got %d fetches", n)
+	}
+}
+
+func TestJWKS_UnknownKidReloadIsRateLimited(t *testing.T) {
+	key := newTestKey(t, "main", AlgES256)
+	server := newJWKSServer(t, key)
+	authenticator := newTestAuthenticator(t, NewRemoteJWKS(server.URL, DefaultJWKSConfig()), testJWTConfig())
+	ctx := context.Background()
+
+	authenticator.Authenticate(ctx, key.sign(t, validClaims()))
+	for range 5 {
+		authenticator.Authenticate(ctx, newTestKey(t, "random", AlgES256).sign(t, validClaims()))
+	}
+
+	if n := server.requests.Load(); n != 1 {
+		t.Errorf("expected unknown key ids not to force reloads, got %d fetches", n)
+	}
+}
+
+func TestJWKS_ConcurrentReloadsAreShared(t *testing.T) {
+	key := newTestKey(t, "main", AlgES256)
+	server := newJWKSServer(t, key)
+	authenticator := newTestAuthenticator(t, NewRemoteJWKS(server.URL, DefaultJWKSConfig()), testJWTConfig())
+	token := key.sign(t, validClaims())
+
+	// Hold the server so every caller arrives while the first fetch is in flight
+	server.mu.Lock()
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for range 10 {
+		wg.Go(func() {
+			_, err := authenticator.Authenticate(context.Background(), token)
+			errs <- err
+		})
+	}
+	for server.requests.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A caller giving up does not cancel the fetch the others wait on
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := authenticator.Authenticate(ctx, token); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancelled caller to stop waiting, got %v", err)
+	}
+	server.mu.Unlock()
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("expected token to be accepted, got %v", err)
+		}
+	}
+	if n := server.requests.Load(); n != 1 {
+		t.Errorf("expected concurrent callers to share one fetch, got %d fetches", n)
+	}
+}
+
+func TestJWKS_File(t *testing.T) {
+	key := newTestKey(t, "file", AlgEdDSA)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	data, _ := json.Marshal(jwksDocument(key))
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := testJWTConfig()
+	config.JWKSFile = path
+	authenticator := newTestAuthenticator(t, config.JWKS(DefaultJWKSConfig()), config)
+
+	if _, err := authenticator.Authenticate(context.Background(), key.sign(t, validClaims())); err != nil {
+		t.Errorf("expected token to be accepted, got %v", err)
+	}
+}
+
+func TestJWKS_UnavailableSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	key := newTestKey(t, "main", AlgES256)
+	authenticator := newTestAuthenticator(t, NewRemoteJWKS(server.URL, DefaultJWKSConfig()), testJWTConfig())
+
+	_, err := authenticator.Authenticate(context.Background(), key.sign(t, validClaims()))
+	if err == nil || errors.Is(err, ErrInvalidCredential) {
+		t.Errorf("expected a key loading error rather than an invalid credential, got %v", err)
+	}
+}
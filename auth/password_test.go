@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=4$") {
+		t.Errorf("unexpected hash format %q", hash)
+	}
+
+	again, _ := HashPassword("correct horse battery staple")
+	if again == hash {
+		t.Error("expected a random salt per hash")
+	}
+
+	if ok, err := VerifyPassword(hash, "correct horse battery staple"); !ok || err != nil {
+		t.Errorf("expected the password to match, got %v, %v", ok, err)
+	}
+	if ok, err := VerifyPassword(hash, "wrong password"); ok || err != nil {
+		t.Errorf("expected a wrong password not to match, got %v, %v", ok, err)
+	}
+}
+
+func TestVerifyPasswordReadsParameters(t *testing.T) {
+	// Generated with m=8192,t=1,p=1, hashes keep verifying after the defaults change
+	hash := "$argon2id$v=19$m=8192,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$gr2igq8kIMaGG2q4HuAOXTQvjon0oR+6cq80E334fGQ"
+	ok, err := VerifyPassword(hash, "password")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !ok {
+		t.Error("expected the password to match a hash with other parameters")
+	}
+}
+
+func TestVerifyPasswordMalformed(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"plaintext",
+		"$argon2i$v=19$m=65536,t=3,p=4$c2FsdA$aGFzaA",
+		"$argon2id$v=16$m=65536,t=3,p=4$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=x,t=3,p=4$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=65536,t=3,p=4$!!!$aGFzaA",
+	} {
+		if _, err := VerifyPassword(hash, "password"); !errors.Is(err, ErrMalformedHash) {
+			t.Errorf("expected ErrMalformedHash for %q, got %v", hash, err)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package auth
+
+import "slices"
+
+// Policy describes what a caller needs to access a route. A zero Policy only
+// requires an authenticated principal.
+type Policy struct {
+	// Public routes are served without a principal.
+	Public bool `json:"public"`
+	// Scopes must all be granted to the principal.
+	Scopes []string `json:"scopes,omitempty"`
+	// Roles are alternatives, the principal needs at least one of them.
+	Roles []string `json:"roles,omitempty"`
+	// Tenant routes only serve requests that were resolved to a tenant.
+	Tenant bool `json:"tenant"`
+}
+
+// PublicPolicy lets anyone access a route.
+func PublicPolicy() Policy {
+	return Policy{Public: true}
+}
+
+// RequireScopes requires every one of the scopes.
+func RequireScopes(scopes ...string) Policy {
+	return Policy{Scopes: scopes}
+}
+
+// RequireRoles requires any one of the roles.
+func RequireRoles(roles ...string) Policy {
+	return Policy{Roles: roles}
+}
+
+// RequireTenant returns a copy of the policy that also requires a tenant.
+func (p Policy) RequireTenant() Policy {
+	p.Tenant = true
+	return p
+}
+
+// Allows reports whether principal satisfies the policy.
+func (p Policy) Allows(principal *Principal) bool {
+	if p.Public {
+		return true
+	}
+	if principal == nil {
+		return false
+	}
+	for _, scope := range p.Scopes {
+		if !principal.HasScope(scope) {
+			return false
+		}
+	}
+	return len(p.Roles) == 0 || slices.ContainsFunc(p.Roles, principal.HasRole)
+}
+
+// Scopes granted to principals and required by routes.
+const (
+	ScopeTodosRead  = "todos:read"
+	ScopeTodosWrite = "todos:write"
+	ScopeAdmin      = "admin"
+	// ScopeOperator lets a principal that is not bound to a tenant act in any
+	// tenant it names. It is honoured as a role as well.
+	ScopeOperator = "operator"
+)
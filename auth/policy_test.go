@@ -0,0 +1,34 @@
+package auth
+
+import "testing"
+
+func TestPolicyAllows(t *testing.T) {
+	reader := &Principal{ID: "user:1", Scopes: []string{ScopeTodosRead}}
+	writer := &Principal{ID: "user:2", Scopes: []string{ScopeTodosRead, ScopeTodosWrite}, Roles: []string{"editor"}}
+
+	tests := []struct {
+		name      string
+		policy    Policy
+		principal *Principal
+		want      bool
+	}{
+		{"public without principal", PublicPolicy(), nil, true},
+		{"authenticated without principal", Policy{}, nil, false},
+		{"authenticated with principal", Policy{}, reader, true},
+		{"scope granted", RequireScopes(ScopeTodosRead), reader, true},
+		{"scope missing", RequireScopes(ScopeTodosWrite), reader, false},
+		{"all scopes required", RequireScopes(ScopeTodosRead, ScopeTodosWrite), reader, false},
+		{"all scopes granted", RequireScopes(ScopeTodosRead, ScopeTodosWrite), writer, true},
+		{"any role", RequireRoles("admin", "editor"), writer, true},
+		{"role missing", RequireRoles("admin"), writer, false},
+		{"scope and role", Policy{Scopes: []string{ScopeTodosWrite}, Roles: []string{"editor"}}, writer, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Allows(tt.principal); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
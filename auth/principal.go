@@ -0,0 +1,57 @@
+// Package auth holds the authenticated identity of a request and the
+// interfaces used to resolve it from credentials.
+package auth
+
+import (
+	"context"
+	"slices"
+)
+
+// Principal kinds.
+const (
+	KindAPIKey = "api_key"
+	KindUser   = "user"
+	// KindLocal principals are users that signed in with a password.
+	KindLocal = "local"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Kind   string   `json:"kind"`
+	Scopes []string `json:"scopes"`
+	Roles  []string `json:"roles,omitempty"`
+	// Tenant binds the principal to a single tenant, it is empty for
+	// principals that may act in any tenant.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// HasScope reports whether the principal was granted scope.
+func (p *Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// HasRole reports whether the principal was assigned role.
+func (p *Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+// IsOperator reports whether the principal may act in any tenant it names.
+// Principals bound to a tenant never are.
+func (p *Principal) IsOperator() bool {
+	return p.Tenant == "" && (p.HasScope(ScopeOperator) || p.HasRole(ScopeOperator))
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal.
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal stored in ctx, if any.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
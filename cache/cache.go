@@ -0,0 +1,49 @@
+// Package cache stores short-lived values in process, in Postgres for every
+// replica to share, or in both.
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrNotInteger is returned by Increment when the value stored for a key is
+// not an integer.
+var ErrNotInteger = errors.New("cached value is not an integer")
+
+// Cache stores values by key for a limited time. A ttl of zero keeps a value
+// until it is deleted or evicted.
+type Cache interface {
+	// Get returns the value stored for key, reporting false if there is none.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+	// Increment adds delta to the integer stored for key and returns the
+	// result. A missing key is created with delta, expiring after ttl; an
+	// existing key keeps its expiry.
+	Increment(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error)
+}
+
+// Get returns the value stored for key decoded from JSON.
+func Get[T any](ctx context.Context, c Cache, key string) (T, bool, error) {
+	var value T
+	data, ok, err := c.Get(ctx, key)
+	if err != nil || !ok {
+		return value, false, err
+	}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return value, false, err
+	}
+	return value, true, nil
+}
+
+// Set stores value for key encoded as JSON.
+func Set[T any](ctx context.Context, c Cache, key string, value T, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return c.Set(ctx, key, data, ttl)
+}
@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/utilities"
+)
+
+// MemoryConfig configures a MemoryCache.
+type MemoryConfig struct {
+	// MaxEntries and MaxBytes cap the entries held, the least recently used
+	// are evicted to stay under them. Zero leaves a cap off.
+	MaxEntries int
+	// MaxBytes counts the size of keys and values.
+	MaxBytes int64
+}
+
+// DefaultMemoryConfig returns the configuration read from the environment.
+func DefaultMemoryConfig() MemoryConfig {
+	maxEntries, err := strconv.Atoi(utilities.GetEnvOrDefault("CACHE_MAX_ENTRIES", "10000"))
+	if err != nil {
+		maxEntries = 10000
+	}
+	maxBytes, err := strconv.ParseInt(utilities.GetEnvOrDefault("CACHE_MAX_BYTES", "67108864"), 10, 64)
+	if err != nil {
+		maxBytes = 64 << 20
+	}
+	return MemoryConfig{MaxEntries: maxEntries, MaxBytes: maxBytes}
+}
+
+type memoryEntry struct {
+	key   string
+	value []byte
+	// expiresAt is zero for entries that do not expire.
+	expiresAt time.Time
+}
+
+func (e *memoryEntry) size() int64 {
+	return int64(len(e.key) + len(e.value))
+}
+
+// MemoryCache is a Cache held in process, evicting the least recently used
+// entries to stay under its caps.
+type MemoryCache struct {
+	config MemoryConfig
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // most recently used first
+	bytes   int64
+}
+
+func NewMemoryCache(config MemoryConfig) *MemoryCache {
+	return &MemoryCache{config: config, now: time.Now, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+func (c *MemoryCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.get(key)
+	if !ok {
+		return nil, false, nil
+	}
+	return bytes.Clone(entry.value), true, nil
+}
+
+func (c *MemoryCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(&memoryEntry{key: key, value: bytes.Clone(value), expiresAt: c.expiry(ttl)})
+	return nil
+}
+
+func (c *MemoryCache) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	return nil
+}
+
+func (c *MemoryCache) Increment(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.get(key)
+	if !ok {
+		c.set(&memoryEntry{key: key, value: strconv.AppendInt(nil, delta, 10), expiresAt: c.expiry(ttl)})
+		return delta, nil
+	}
+	n, err := strconv.ParseInt(string(entry.value), 10, 64)
+	if err != nil {
+		return 0, ErrNotInteger
+	}
+	n += delta
+	c.set(&memoryEntry{key: key, value: strconv.AppendInt(nil, n, 10), expiresAt: entry.expiresAt})
+	return n, nil
+}
+
+// Flush drops every entry.
+func (c *MemoryCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.entries)
+	c.lru.Init()
+	c.bytes = 0
+}
+
+// Len returns the number of entries held, including expired ones that have
+// not been evicted yet.
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// get returns the entry for key if it has not expired, marking it as used.
+func (c *MemoryCache) get(key string) (*memoryEntry, bool) {
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*memoryEntry)
+	if !entry.expiresAt.IsZero() && !c.now().Before(entry.expiresAt) {
+		c.remove(element)
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	return entry, true
+}
+
+// set stores entry, evicting the least recently used entries to make room.
+// Entries larger than MaxBytes are not stored.
+func (c *MemoryCache) set(entry *memoryEntry) {
+	if element, ok := c.entries[entry.key]; ok {
+		c.remove(element)
+	}
+	if c.config.MaxBytes > 0 && entry.size() > c.config.MaxBytes {
+		return
+	}
+
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.bytes += entry.size()
+	for (c.config.MaxEntries > 0 && c.lru.Len() > c.config.MaxEntries) ||
+		(c.config.MaxBytes > 0 && c.bytes > c.config.MaxBytes) {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *MemoryCache) remove(element *list.Element) {
+	entry := c.lru.Remove(element).(*memoryEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.size()
+}
+
+func (c *MemoryCache) expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return c.now().Add(ttl)
+}
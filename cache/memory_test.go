@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := NewMemoryCache(MemoryConfig{})
+	c.now = func() time.Time { return now }
+
+	if _, ok, _ := c.Get(ctx, "a"); ok {
+		t.Fatal("expected a miss for a missing key")
+	}
+	c.Set(ctx, "a", []byte("1"), time.Minute)
+	c.Set(ctx, "b", []byte("2"), 0)
+	if value, ok, _ := c.Get(ctx, "a"); !ok || string(value) != "1" {
+		t.Errorf("expected the stored value, got %q %v", value, ok)
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok, _ := c.Get(ctx, "a"); ok {
+		t.Error("expected the value to have expired")
+	}
+	if _, ok, _ := c.Get(ctx, "b"); !ok {
+		t.Error("expected a value without a ttl to be kept")
+	}
+
+	c.Delete(ctx, "b")
+	if _, ok, _ := c.Get(ctx, "b"); ok {
+		t.Error("expected the value to be deleted")
+	}
+}
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+
+	c := NewMemoryCache(MemoryConfig{MaxEntries: 2})
+	c.Set(ctx, "a", []byte("1"), 0)
+	c.Set(ctx, "b", []byte("2"), 0)
+	c.Get(ctx, "a")
+	c.Set(ctx, "c", []byte("3"), 0)
+	if _, ok, _ := c.Get(ctx, "b"); ok {
+		t.Error("expected the least recently used entry to be evicted")
+	}
+	if _, ok, _ := c.Get(ctx, "a"); !ok {
+		t.Error("expected a recently used entry to be kept")
+	}
+
+	// Keys and values of 2 bytes each
+	c = NewMemoryCache(MemoryConfig{MaxBytes: 6})
+	c.Set(ctx, "a", []byte("1"), 0)
+	c.Set(ctx, "b", []byte("2"), 0)
+	c.Set(ctx, "c", []byte("3"), 0)
+	c.Set(ctx, "d", []byte("4"), 0)
+	if c.Len() != 3 {
+		t.Errorf("expected 3 entries to fit, got %d", c.Len())
+	}
+	if _, ok, _ := c.Get(ctx, "a"); ok {
+		t.Error("expected the oldest entry to be evicted")
+	}
+	c.Set(ctx, "big", []byte("too large"), 0)
+	if _, ok, _ := c.Get(ctx, "big"); ok || c.Len() != 3 {
+		t.Error("expected an entry larger than the cap not to be stored")
+	}
+
+	c.Flush()
+	if c.Len() != 0 {
+		t.Errorf("expected no entries after a flush, got %d", c.Len())
+	}
+}
+
+func TestMemoryCacheIncrement(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := NewMemoryCache(MemoryConfig{})
+	c.now = func() time.Time { return now }
+
+	for i, want := range []int64{1, 3, 6} {
+		n, err := c.Increment(ctx, "count", int64(i+1), time.Minute)
+		if err != nil || n != want {
+			t.Fatalf("expected %d, got %d %v", want, n, err)
+		}
+	}
+
+	// Incrementing keeps the expiry of the first increment
+	now = now.Add(time.Minute)
+	if n, _ := c.Increment(ctx, "count", 1, time.Minute); n != 1 {
+		t.Errorf("expected the count to restart once expired, got %d", n)
+	}
+
+	c.Set(ctx, "name", []byte("todo"), 0)
+	if _, err := c.Increment(ctx, "name", 1, 0); !errors.Is(err, ErrNotInteger) {
+		t.Errorf("expected ErrNotInteger, got %v", err)
+	}
+}
+
+func TestTypedHelpers(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache(MemoryConfig{})
+
+	type todo struct {
+		Title string
+		Tags  []string
+	}
+	if err := Set(ctx, c, "todo", todo{Title: "Write", Tags: []string{"a"}}, 0); err != nil {
+		t.Fatal(err)
+	}
+	got, ok, err := Get[todo](ctx, c, "todo")
+	if err != nil || !ok || got.Title != "Write" || len(got.Tags) != 1 {
+		t.Errorf("expected the stored todo, got %+v %v %v", got, ok, err)
+	}
+	if _, ok, _ := Get[todo](ctx, c, "missing"); ok {
+		t.Error("expected a miss for a missing key")
+	}
+}
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// PostgresCache keeps entries in the cache_entries table, so that every
+// replica sees the same values. The table is unlogged, entries are lost if
+// Postgres crashes.
+type PostgresCache struct {
+	repo   repository.Querier
+	logger *slog.Logger
+	now    func() time.Time
+}
+
+func NewPostgresCache(repo repository.Querier, logger *slog.Logger) *PostgresCache {
+	return &PostgresCache{repo: repo, logger: logger, now: time.Now}
+}
+
+func (c *PostgresCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := c.repo.GetCacheEntry(ctx, repository.GetCacheEntryParams{Key: key, Now: c.now()})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (c *PostgresCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.repo.SetCacheEntry(ctx, repository.SetCacheEntryParams{Key: key, Value: value, ExpiresAt: c.expiry(ttl)})
+}
+
+func (c *PostgresCache) Delete(ctx context.Context, key string) error {
+	return c.repo.DeleteCacheEntry(ctx, key)
+}
+
+func (c *PostgresCache) Increment(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	value, err := c.repo.IncrementCacheEntry(ctx, repository.IncrementCacheEntryParams{
+		Key:       key,
+		Delta:     delta,
+		ExpiresAt: c.expiry(ttl),
+		Now:       c.now(),
+	})
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "22P02" {
+		// invalid_text_representation, the value is not a number
+		return 0, ErrNotInteger
+	}
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return 0, ErrNotInteger
+	}
+	return n, nil
+}
+
+// Purge deletes expired entries every interval until ctx is cancelled.
+func (c *PostgresCache) Purge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := c.repo.DeleteExpiredCacheEntries(ctx, c.now())
+			if err != nil {
+				c.logger.ErrorContext(ctx, "failed to purge cache entries", "error", err)
+				continue
+			}
+			if n > 0 {
+				c.logger.DebugContext(ctx, "purged cache entries", "count", n)
+			}
+		}
+	}
+}
+
+func (c *PostgresCache) expiry(ttl time.Duration) *time.Time {
+	if ttl <= 0 {
+		return nil
+	}
+	expiresAt := c.now().Add(ttl)
+	return &expiresAt
+}
@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/models"
+	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/doug-benn/go-server-starter/testutils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestPostgresCache(t *testing.T) {
+	rows := make(map[string]models.CacheEntry)
+	live := func(key string, now time.Time) (models.CacheEntry, bool) {
+		row, ok := rows[key]
+		return row, ok && (row.ExpiresAt == nil || row.ExpiresAt.After(now))
+	}
+	repo := &testutils.MockQuerier{
+		GetCacheEntryFunc: func(ctx context.Context, arg repository.GetCacheEntryParams) ([]byte, error) {
+			row, ok := live(arg.Key, arg.Now)
+			if !ok {
+				return nil, pgx.ErrNoRows
+			}
+			return row.Value, nil
+		},
+		SetCacheEntryFunc: func(ctx context.Context, arg repository.SetCacheEntryParams) error {
+			rows[arg.Key] = models.CacheEntry{Key: arg.Key, Value: arg.Value, ExpiresAt: arg.ExpiresAt}
+			return nil
+		},
+		DeleteCacheEntryFunc: func(ctx context.Context, key string) error {
+			delete(rows, key)
+			return nil
+		},
+		IncrementCacheEntryFunc: func(ctx context.Context, arg repository.IncrementCacheEntryParams) ([]byte, error) {
+			row, ok := live(arg.Key, arg.Now)
+			if !ok {
+				row = models.CacheEntry{Key: arg.Key, Value: []byte("0"), ExpiresAt: arg.ExpiresAt}
+			}
+			n, err := strconv.ParseInt(string(row.Value), 10, 64)
+			if err != nil {
+				return nil, &pgconn.PgError{Code: "22P02"}
+			}
+			row.Value = strconv.AppendInt(nil, n+arg.Delta, 10)
+			rows[arg.Key] = row
+			return row.Value, nil
+		},
+	}
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := NewPostgresCache(repo, slog.Default())
+	c.now = func() time.Time { return now }
+
+	if _, ok, err := c.Get(ctx, "a"); ok || err != nil {
+		t.Fatalf("expected a miss, got %v %v", ok, err)
+	}
+	c.Set(ctx, "a", []byte("1"), time.Minute)
+	c.Set(ctx, "b", []byte("2"), 0)
+	if rows["b"].ExpiresAt != nil {
+		t.Error("expected a value without a ttl not to expire")
+	}
+	if value, ok, _ := c.Get(ctx, "a"); !ok || string(value) != "1" {
+		t.Errorf("expected the stored value, got %q %v", value, ok)
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok, _ := c.Get(ctx, "a"); ok {
+		t.Error("expected the value to have expired")
+	}
+
+	if n, err := c.Increment(ctx, "count", 2, time.Minute); n != 2 || err != nil {
+		t.Errorf("expected 2, got %d %v", n, err)
+	}
+	if n, err := c.Increment(ctx, "count", 3, time.Minute); n != 5 || err != nil {
+		t.Errorf("expected 5, got %d %v", n, err)
+	}
+	c.Set(ctx, "name", []byte("todo"), 0)
+	if _, err := c.Increment(ctx, "name", 1, 0); !errors.Is(err, ErrNotInteger) {
+		t.Errorf("expected ErrNotInteger, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Tiered puts a Cache held in process in front of a shared one. Values read
+// from the shared tier are kept locally for up to localTTL, so a replica may
+// read a value for that long after another replica changed or deleted it.
+type Tiered struct {
+	local    Cache
+	shared   Cache
+	localTTL time.Duration
+}
+
+func NewTiered(local, shared Cache, localTTL time.Duration) *Tiered {
+	return &Tiered{local: local, shared: shared, localTTL: localTTL}
+}
+
+func (t *Tiered) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	if value, ok, err := t.local.Get(ctx, key); err == nil && ok {
+		return value, true, nil
+	}
+
+	value, ok, err := t.shared.Get(ctx, key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	t.local.Set(ctx, key, value, t.localTTL)
+	return value, true, nil
+}
+
+func (t *Tiered) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := t.shared.Set(ctx, key, value, ttl); err != nil {
+		return err
+	}
+	return t.local.Set(ctx, key, value, t.capTTL(ttl))
+}
+
+func (t *Tiered) Delete(ctx context.Context, key string) error {
+	if err := t.shared.Delete(ctx, key); err != nil {
+		return err
+	}
+	return t.local.Delete(ctx, key)
+}
+
+// Increment counts in the shared tier only, so that every replica adds to the
+// same value.
+func (t *Tiered) Increment(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	n, err := t.shared.Increment(ctx, key, delta, ttl)
+	if err != nil {
+		return 0, err
+	}
+	return n, t.local.Delete(ctx, key)
+}
+
+// capTTL keeps local copies for no longer than the value lives.
+func (t *Tiered) capTTL(ttl time.Duration) time.Duration {
+	if ttl > 0 && ttl < t.localTTL {
+		return ttl
+	}
+	return t.localTTL
+}
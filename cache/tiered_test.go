@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTiered(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	shared := NewMemoryCache(MemoryConfig{})
+	// Two replicas with their own local tier
+	local1, local2 := NewMemoryCache(MemoryConfig{}), NewMemoryCache(MemoryConfig{})
+	for _, c := range []*MemoryCache{shared, local1, local2} {
+		c.now = func() time.Time { return now }
+	}
+	replica1 := NewTiered(local1, shared, 10*time.Second)
+	replica2 := NewTiered(local2, shared, 10*time.Second)
+
+	replica1.Set(ctx, "a", []byte("1"), time.Hour)
+	if value, ok, _ := replica2.Get(ctx, "a"); !ok || string(value) != "1" {
+		t.Fatalf("expected the value from the shared tier, got %q %v", value, ok)
+	}
+	if _, ok, _ := local2.Get(ctx, "a"); !ok {
+		t.Error("expected the value to be kept in the local tier")
+	}
+
+	// Other replicas see changes once their local copy expires
+	replica1.Set(ctx, "a", []byte("2"), time.Hour)
+	if value, _, _ := replica2.Get(ctx, "a"); string(value) != "1" {
+		t.Errorf("expected the local copy, got %q", value)
+	}
+	now = now.Add(10 * time.Second)
+	if value, _, _ := replica2.Get(ctx, "a"); string(value) != "2" {
+		t.Errorf("expected the changed value, got %q", value)
+	}
+
+	replica1.Delete(ctx, "a")
+	if _, ok, _ := replica1.Get(ctx, "a"); ok {
+		t.Error("expected the value to be deleted from both tiers")
+	}
+
+	// Counters are shared
+	replica1.Increment(ctx, "count", 1, 0)
+	replica2.Get(ctx, "count")
+	if n, _ := replica2.Increment(ctx, "count", 1, 0); n != 2 {
+		t.Errorf("expected both replicas to count, got %d", n)
+	}
+	if value, _, _ := replica2.Get(ctx, "count"); string(value) != "2" {
+		t.Errorf("expected incrementing to drop the local copy, got %q", value)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/services"
+)
+
+const apiKeyUsage = `usage:
+  apikey create --name NAME [--scopes a,b] [--expires 720h] [--tenant ID]
+  apikey list
+  apikey revoke ID`
+
+// runAPIKeyCommand manages API keys from the command line, which is how the
+// first admin key is created.
+func runAPIKeyCommand(ctx context.Context, w io.Writer, apiKeyService services.APIKeyService, args []string) error {
+	if len(args) == 0 {
+		return errors.New(apiKeyUsage)
+	}
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("apikey create", flag.ContinueOnError)
+		fs.SetOutput(w)
+		name := fs.String("name", "", "name identifying the key")
+		scopes := fs.String("scopes", "", "comma separated scopes to grant")
+		expires := fs.Duration("expires", 0, "lifetime of the key, 0 never expires")
+		tenant := fs.String("tenant", "", "tenant the key is bound to, empty for an operator key")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		key, plaintext, err := apiKeyService.CreateKey(ctx, *name, splitScopes(*scopes), *expires, *tenant)
+		if err != nil {
+			return fmt.Errorf("failed to create api key: %w", err)
+		}
+		fmt.Fprintf(w, "created api key %d (%s)\n", key.ID, key.Name)
+		fmt.Fprintf(w, "key: %s\n", plaintext)
+		fmt.Fprintln(w, "store it now, it cannot be shown again")
+		return nil
+
+	case "list":
+		keys, err := apiKeyService.ListKeys(ctx, "")
+		if err != nil {
+			return fmt.Errorf("failed to list api keys: %w", err)
+		}
+
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tPREFIX\tTENANT\tSCOPES\tEXPIRES\tREVOKED\tLAST USED")
+		for _, key := range keys {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				key.ID, key.Name, key.Prefix, formatTenant(key.TenantID), strings.Join(key.Scopes, ","),
+				formatTime(key.ExpiresAt), formatTime(key.RevokedAt), formatTime(key.LastUsedAt),
+			)
+		}
+		return tw.Flush()
+
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New(apiKeyUsage)
+		}
+		id, err := strconv.ParseInt(args[1], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid id: %w", err)
+		}
+		if err := apiKeyService.RevokeKey(ctx, int32(id), ""); err != nil {
+			return fmt.Errorf("failed to revoke api key %d: %w", id, err)
+		}
+		fmt.Fprintf(w, "revoked api key %d\n", id)
+		return nil
+	}
+
+	return errors.New(apiKeyUsage)
+}
+
+func splitScopes(s string) []string {
+	var scopes []string
+	for scope := range strings.SplitSeq(s, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func formatTenant(tenant *string) string {
+	if tenant == nil {
+		return "-"
+	}
+	return *tenant
+}
+
+const tenantUsage = `usage:
+  tenant create --id ID [--name NAME]
+  tenant list`
+
+// runTenantCommand manages tenants from the command line.
+func runTenantCommand(ctx context.Context, w io.Writer, tenantService services.TenantService, args []string) error {
+	if len(args) == 0 {
+		return errors.New(tenantUsage)
+	}
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("tenant create", flag.ContinueOnError)
+		fs.SetOutput(w)
+		id := fs.String("id", "", "identifier used in subdomains, headers and token claims")
+		name := fs.String("name", "", "display name, defaults to the id")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		tenant, err := tenantService.CreateTenant(ctx, *id, *name)
+		if err != nil {
+			return fmt.Errorf("failed to create tenant: %w", err)
+		}
+		fmt.Fprintf(w, "created tenant %s (%s)\n", tenant.ID, tenant.Name)
+		return nil
+
+	case "list":
+		tenants, err := tenantService.ListTenants(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list tenants: %w", err)
+		}
+
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tCREATED")
+		for _, tenant := range tenants {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", tenant.ID, tenant.Name, formatTime(&tenant.CreatedAt))
+		}
+		return tw.Flush()
+	}
+
+	return errors.New(tenantUsage)
+}
+
+const userUsage = `usage:
+  user create --username NAME [--scopes a,b] [--tenant ID] < password`
+
+// runUserCommand creates users that sign in to the web UI with a password. The
+// password is read from the first line of r so that it stays out of the
+// shell history.
+func runUserCommand(ctx context.Context, w io.Writer, r io.Reader, accountService services.AccountService, args []string) error {
+	if len(args) == 0 || args[0] != "create" {
+		return errors.New(userUsage)
+	}
+
+	fs := flag.NewFlagSet("user create", flag.ContinueOnError)
+	fs.SetOutput(w)
+	username := fs.String("username", "", "name the user signs in with")
+	scopes := fs.String("scopes", "", "comma separated scopes to grant")
+	tenant := fs.String("tenant", "", "tenant the user belongs to, empty for any tenant")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	password, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to read password: %w", err)
+	}
+
+	user, err := accountService.CreateAccount(ctx, *username, strings.TrimRight(password, "\r\n"), splitScopes(*scopes), *tenant)
+	if err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	fmt.Fprintf(w, "created user %d (%s)\n", user.ID, user.Subject)
+	return nil
+}
@@ -119,6 +119,12 @@ func (db *PostgresDatabase) initPool(ctx context.Context) (*pgxpool.Pool, error)
 	config.MaxConnIdleTime = db.config.MaxConnIdleTime
 	config.HealthCheckPeriod = db.config.HealthCheckPeriod
 
+	// Scope every acquired connection to the tenant of the request it serves.
+	// PrepareConn replaces BeforeAcquire and fails the acquire when the tenant
+	// cannot be set, instead of retrying with another connection.
+	config.PrepareConn = setTenant
+	config.AfterRelease = resetTenant
+
 	pool, err := pgxpool.NewWithConfig(ctx, config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create connection pool: %w", err)
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TenantSetting is the session variable row-level security policies compare
+// tenant columns against.
+const TenantSetting = "app.tenant_id"
+
+// ErrBypassesRowLevelSecurity is returned by CheckRowLevelSecurity when the
+// database role ignores the tenant isolation policies.
+var ErrBypassesRowLevelSecurity = errors.New("database role bypasses row-level security")
+
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx whose queries run as tenant.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant stored in ctx, if any.
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok && tenant != ""
+}
+
+// setTenant runs before a pooled connection is handed out and sets the
+// session's tenant to the one in ctx. Without a tenant the setting is cleared,
+// so tenant tables read as empty rather than leaking another tenant's rows.
+func setTenant(ctx context.Context, conn *pgx.Conn) (bool, error) {
+	tenant, _ := TenantFromContext(ctx)
+	if _, err := conn.Exec(ctx, "SELECT set_config($1, $2, false)", TenantSetting, tenant); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// resetTenant runs when a connection is returned to the pool so that idle
+// connections never hold a tenant. Connections that cannot be reset are
+// destroyed.
+func resetTenant(conn *pgx.Conn) bool {
+	_, err := conn.Exec(context.Background(), "RESET "+TenantSetting)
+	return err == nil
+}
+
+// CheckRowLevelSecurity returns ErrBypassesRowLevelSecurity when the pool
+// connects as a superuser or a role with BYPASSRLS, which see and write every
+// tenant's rows whatever the policies say.
+func CheckRowLevelSecurity(ctx context.Context, pool *pgxpool.Pool) error {
+	var role string
+	var bypasses bool
+	err := pool.QueryRow(ctx, "SELECT rolname, rolsuper OR rolbypassrls FROM pg_roles WHERE rolname = current_user").Scan(&role, &bypasses)
+	if err != nil {
+		return fmt.Errorf("failed to check database role: %w", err)
+	}
+	if bypasses {
+		return fmt.Errorf("%w: %s", ErrBypassesRowLevelSecurity, role)
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+#!/bin/sh
+# Creates the role the application connects as. It owns nothing and cannot
+# bypass row-level security, unlike POSTGRES_USER which runs the migrations.
+set -e
+
+psql -v ON_ERROR_STOP=1 --username "$POSTGRES_USER" --dbname "$POSTGRES_DB" <<-EOSQL
+	CREATE ROLE "$APP_DB_USER" LOGIN PASSWORD '$APP_DB_PASSWORD' NOSUPERUSER NOBYPASSRLS;
+	ALTER DEFAULT PRIVILEGES FOR ROLE "$POSTGRES_USER" IN SCHEMA public
+		GRANT SELECT, INSERT, UPDATE, DELETE ON TABLES TO "$APP_DB_USER";
+	ALTER DEFAULT PRIVILEGES FOR ROLE "$POSTGRES_USER" IN SCHEMA public
+		GRANT USAGE, SELECT ON SEQUENCES TO "$APP_DB_USER";
+EOSQL
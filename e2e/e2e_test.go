@@ -3,26 +3,47 @@ package e2e
 import (
 	"context"
 	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/doug-benn/go-server-starter/database"
 	"github.com/doug-benn/go-server-starter/producer"
+	"github.com/doug-benn/go-server-starter/ratelimit"
 	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/doug-benn/go-server-starter/services"
 	"github.com/doug-benn/go-server-starter/sse"
+	"github.com/jackc/pgx/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
+	"golang.org/x/time/rate"
 )
 
+// loadMigrationSQL concatenates every up migration in version order.
 func loadMigrationSQL() (string, error) {
-	b, err := os.ReadFile("../migrations/000001_create_todo_table.up.sql")
+	files, err := filepath.Glob("../migrations/*.up.sql")
 	if err != nil {
 		return "", err
 	}
-	return string(b), nil
+	sort.Strings(files)
+
+	var sb strings.Builder
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		sb.Write(b)
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
 }
 
 func setupPostgresContainer(t *testing.T) (database.PostgresConfig, func()) {
@@ -108,7 +129,7 @@ func setupSSEPipeline(t *testing.T, ctx context.Context) (*database.PostgresData
 	require.NoError(t, err)
 
 	notifyCtx, notifyCancel := context.WithCancel(ctx)
-	go repository.NotificationProcessing(notifyCtx, logger, postgresListener, sseProducer)
+	go repository.NotificationProcessing(notifyCtx, logger, postgresListener, "events", sseProducer)
 
 	cleanup := func() {
 		notifyCancel()
@@ -137,12 +158,24 @@ func consumeInsertEvent(ctx context.Context, t *testing.T, sub *producer.Subscri
 	assert.Equal(t, description, dbEvent.Data["description"])
 }
 
+// createOwner returns the id of the user the test's todos belong to.
+func createOwner(ctx context.Context, t *testing.T, repo *repository.Queries) int32 {
+	t.Helper()
+	user, err := repo.UpsertUser(ctx, repository.UpsertUserParams{
+		Subject:   "user:e2e",
+		Name:      "E2E",
+		CreatedAt: time.Now(),
+	})
+	require.NoError(t, err)
+	return user.ID
+}
+
 func TestSSETodoCreated(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skipping E2E test in short mode")
 	}
 
-	ctx := context.Background()
+	ctx := database.WithTenant(context.Background(), "default")
 
 	db, sseProducer, cleanup := setupSSEPipeline(t, ctx)
 	defer cleanup()
@@ -156,6 +189,8 @@ func TestSSETodoCreated(t *testing.T) {
 		Completed:   false,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
+		OwnerID:     createOwner(ctx, t, repo),
+		TenantID:    "default",
 	})
 	require.NoError(t, err)
 	require.NotZero(t, todo.ID)
@@ -170,6 +205,7 @@ func TestSSETodoCreated(t *testing.T) {
 	require.True(t, ok, "event data should be *DatabaseEvent")
 	assert.Equal(t, "todos", dbEvent.Table)
 	assert.Equal(t, "INSERT", dbEvent.Action)
+	assert.Equal(t, "default", dbEvent.Tenant)
 	assert.False(t, dbEvent.Timestamp.IsZero())
 	assert.Equal(t, todo.Title, dbEvent.Data["title"])
 	assert.Equal(t, todo.Description, dbEvent.Data["description"])
@@ -181,7 +217,7 @@ func TestSSETodoUpdated(t *testing.T) {
 		t.Skip("Skipping E2E test in short mode")
 	}
 
-	ctx := context.Background()
+	ctx := database.WithTenant(context.Background(), "default")
 
 	db, sseProducer, cleanup := setupSSEPipeline(t, ctx)
 	defer cleanup()
@@ -196,6 +232,8 @@ func TestSSETodoUpdated(t *testing.T) {
 		Completed:   false,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
+		OwnerID:     createOwner(ctx, t, repo),
+		TenantID:    "default",
 	})
 	require.NoError(t, err)
 
@@ -204,6 +242,8 @@ func TestSSETodoUpdated(t *testing.T) {
 	_, err = repo.CompleteTodo(ctx, repository.CompleteTodoParams{
 		UpdatedAt: time.Now(),
 		ID:        todo.ID,
+		OwnerID:   todo.OwnerID,
+		TenantID:  "default",
 	})
 	require.NoError(t, err)
 
@@ -226,7 +266,7 @@ func TestSSETodoDeleted(t *testing.T) {
 		t.Skip("Skipping E2E test in short mode")
 	}
 
-	ctx := context.Background()
+	ctx := database.WithTenant(context.Background(), "default")
 
 	db, sseProducer, cleanup := setupSSEPipeline(t, ctx)
 	defer cleanup()
@@ -241,13 +281,16 @@ func TestSSETodoDeleted(t *testing.T) {
 		Completed:   false,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
+		OwnerID:     createOwner(ctx, t, repo),
+		TenantID:    "default",
 	})
 	require.NoError(t, err)
 
 	consumeInsertEvent(ctx, t, sub, "Delete Test", "Will be deleted")
 
-	err = repo.DeleteTodo(ctx, todo.ID)
+	deleted, err := repo.DeleteTodo(ctx, repository.DeleteTodoParams{ID: todo.ID, OwnerID: todo.OwnerID, TenantID: "default"})
 	require.NoError(t, err)
+	require.Equal(t, todo.ID, deleted.ID)
 
 	eventCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
@@ -267,7 +310,7 @@ func TestMultipleSSEClients(t *testing.T) {
 		t.Skip("Skipping E2E test in short mode")
 	}
 
-	ctx := context.Background()
+	ctx := database.WithTenant(context.Background(), "default")
 
 	db, sseProducer, cleanup := setupSSEPipeline(t, ctx)
 	defer cleanup()
@@ -283,9 +326,209 @@ func TestMultipleSSEClients(t *testing.T) {
 		Completed:   false,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
+		OwnerID:     createOwner(ctx, t, repo),
+		TenantID:    "default",
 	})
 	require.NoError(t, err)
 
 	consumeInsertEvent(ctx, t, subA, "Multi-Client Test", "Testing broadcast to multiple subscribers")
 	consumeInsertEvent(ctx, t, subB, "Multi-Client Test", "Testing broadcast to multiple subscribers")
 }
+
+func TestTenantIsolation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping E2E test in short mode")
+	}
+
+	ctx := context.Background()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	config, cleanupContainer := setupPostgresContainer(t)
+	defer cleanupContainer()
+
+	db, err := database.NewDatabase(ctx, logger, config)
+	require.NoError(t, err)
+	defer db.Close()
+
+	migrationSQL, err := loadMigrationSQL()
+	require.NoError(t, err)
+	_, err = db.Pool().Exec(ctx, migrationSQL)
+	require.NoError(t, err)
+
+	// The container user is a superuser, which bypasses row-level security, so
+	// the application is run as an ordinary role like in production
+	_, err = db.Pool().Exec(ctx, `
+		CREATE ROLE app LOGIN PASSWORD 'app';
+		GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA public TO app;
+		GRANT USAGE ON ALL SEQUENCES IN SCHEMA public TO app;
+		INSERT INTO tenants (id, name) VALUES ('acme', 'Acme');
+	`)
+	require.NoError(t, err)
+
+	require.ErrorIs(t, database.CheckRowLevelSecurity(ctx, db.Pool()), database.ErrBypassesRowLevelSecurity)
+
+	config.Username, config.Password = "app", "app"
+	appDB, err := database.NewDatabase(ctx, logger, config)
+	require.NoError(t, err)
+	defer appDB.Close()
+	require.NoError(t, database.CheckRowLevelSecurity(ctx, appDB.Pool()))
+
+	repo := repository.New(appDB.Pool())
+	acme := database.WithTenant(ctx, "acme")
+	other := database.WithTenant(ctx, "default")
+
+	todo, err := repo.CreateTodo(acme, repository.CreateTodoParams{
+		Title:     "Acme only",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		OwnerID:   createOwner(acme, t, repo),
+		TenantID:  "acme",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "acme", todo.TenantID)
+
+	_, err = repo.GetTodo(acme, repository.GetTodoParams{ID: todo.ID, OwnerID: todo.OwnerID, TenantID: "acme"})
+	require.NoError(t, err)
+
+	_, err = repo.GetTodo(other, repository.GetTodoParams{ID: todo.ID, OwnerID: todo.OwnerID, TenantID: "acme"})
+	assert.ErrorIs(t, err, pgx.ErrNoRows, "another tenant must not see the todo")
+
+	_, err = repo.DeleteTodo(other, repository.DeleteTodoParams{ID: todo.ID, OwnerID: todo.OwnerID, TenantID: "acme"})
+	assert.ErrorIs(t, err, pgx.ErrNoRows, "another tenant must not delete the todo")
+
+	todos, err := repo.ListTodos(ctx, repository.ListTodosParams{OwnerID: todo.OwnerID, TenantID: "acme"})
+	require.NoError(t, err)
+	assert.Empty(t, todos, "requests without a tenant must not see any todos")
+}
+
+func TestMultiNodeFanOut(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping E2E test in short mode")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	config, cleanupContainer := setupPostgresContainer(t)
+	defer cleanupContainer()
+
+	db, err := database.NewDatabase(ctx, logger, config)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// Two producers sharing one database behave like two replicas
+	nodeA := producer.NewProducer(
+		producer.WithBus[sse.Event](producer.NewPostgresBus[sse.Event](db.Pool(), "producer_bus", logger)),
+		producer.WithNodeID[sse.Event]("node-a"),
+	)
+	nodeB := producer.NewProducer(
+		producer.WithBus[sse.Event](producer.NewPostgresBus[sse.Event](db.Pool(), "producer_bus", logger)),
+		producer.WithNodeID[sse.Event]("node-b"),
+	)
+	go nodeA.Start(ctx)
+	go nodeB.Start(ctx)
+
+	subA := nodeA.Subscribe(10)
+	subB := nodeB.Subscribe(10)
+
+	// Give both nodes time to LISTEN before publishing
+	time.Sleep(200 * time.Millisecond)
+
+	err = nodeA.Publish(ctx, sse.Event{Type: "ping", Data: map[string]string{"from": "a"}})
+	require.NoError(t, err)
+
+	for _, sub := range []*producer.Subscription[sse.Event]{subA, subB} {
+		eventCtx, eventCancel := context.WithTimeout(ctx, 5*time.Second)
+		event, err := sub.Next(eventCtx)
+		eventCancel()
+		require.NoError(t, err)
+		assert.Equal(t, "ping", event.Type)
+	}
+
+	// node-a must not receive its own event a second time from the bus
+	select {
+	case event := <-subA.Events():
+		t.Fatalf("unexpected duplicate event %+v", event)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping E2E test in short mode")
+	}
+
+	ctx := context.Background()
+
+	db, _, cleanup := setupSSEPipeline(t, ctx)
+	defer cleanup()
+
+	received := make(chan string, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get(services.WebhookEventHeader)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer receiver.Close()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	repo := repository.New(db.Pool())
+	webhookService := services.NewWebhookService(repo, logger)
+	config := services.DefaultWebhookWorkerConfig()
+	config.AllowedNetworks = []string{"127.0.0.1"}
+	worker, err := services.NewWebhookWorker(repo, logger, config)
+	require.NoError(t, err)
+
+	subscription, err := webhookService.CreateSubscription(ctx, receiver.URL, []string{"todo.*"})
+	require.NoError(t, err)
+
+	event := sse.Event{Type: "todo.created", Data: map[string]any{"todo_id": 1}}
+	require.NoError(t, worker.Enqueue(ctx, event))
+	// A second replica enqueueing the same event must not create a duplicate
+	require.NoError(t, worker.Enqueue(ctx, event))
+
+	n, err := worker.DispatchDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "todo.created", <-received)
+
+	deliveries, err := webhookService.ListDeliveries(ctx, subscription.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, services.WebhookStatusSucceeded, deliveries[0].Status)
+	assert.Equal(t, int32(1), deliveries[0].Attempts)
+
+	redelivered, err := webhookService.Redeliver(ctx, subscription.ID, deliveries[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, services.WebhookStatusPending, redelivered.Status)
+}
+
+func TestDistributedRateLimit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping E2E test in short mode")
+	}
+
+	ctx := context.Background()
+
+	db, _, cleanup := setupSSEPipeline(t, ctx)
+	defer cleanup()
+
+	// Two replicas sharing the same buckets
+	config := ratelimit.PostgresConfig{LeaseSize: 1, LeaseTTL: time.Second, Local: ratelimit.DefaultMemoryConfig()}
+	replicas := []*ratelimit.PostgresStore{
+		ratelimit.NewPostgresStore(repository.New(db.Pool()), slog.Default(), config),
+		ratelimit.NewPostgresStore(repository.New(db.Pool()), slog.Default(), config),
+	}
+
+	allowed := 0
+	for range 3 {
+		for _, replica := range replicas {
+			result, err := replica.Take(ctx, "client", rate.Every(time.Minute), 4, 0)
+			require.NoError(t, err)
+			if result.Allowed {
+				allowed++
+			}
+		}
+	}
+	assert.Equal(t, 4, allowed, "expected the burst to be shared across replicas")
+}
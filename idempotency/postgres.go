@@ -0,0 +1,106 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrContended is returned by Claim when a key keeps being claimed and expired
+// by other requests while it is looked up.
+var ErrContended = errors.New("idempotency key contended")
+
+// PostgresStore keeps records in the idempotency_keys table, so that a retry
+// is recognised whichever replica it reaches.
+type PostgresStore struct {
+	repo   repository.Querier
+	logger *slog.Logger
+	now    func() time.Time
+}
+
+func NewPostgresStore(repo repository.Querier, logger *slog.Logger) *PostgresStore {
+	return &PostgresStore{repo: repo, logger: logger, now: time.Now}
+}
+
+func (s *PostgresStore) Claim(ctx context.Context, key, fingerprint string, lockTimeout, ttl time.Duration) (bool, *Record, error) {
+	// The row can expire and be purged between claiming and reading it
+	for range 3 {
+		now := s.now()
+		n, err := s.repo.ClaimIdempotencyKey(ctx, repository.ClaimIdempotencyKeyParams{
+			Key:         key,
+			Fingerprint: fingerprint,
+			LockedUntil: now.Add(lockTimeout),
+			ExpiresAt:   now.Add(ttl),
+			Now:         now,
+		})
+		if err != nil {
+			return false, nil, err
+		}
+		if n == 1 {
+			return true, nil, nil
+		}
+
+		row, err := s.repo.GetIdempotencyKey(ctx, key)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return false, nil, err
+		}
+
+		record := &Record{Fingerprint: row.Fingerprint}
+		if row.StatusCode != nil {
+			record.Response = &Response{StatusCode: int(*row.StatusCode), Body: row.Body}
+			if err := json.Unmarshal(row.Headers, &record.Response.Header); err != nil {
+				return false, nil, err
+			}
+		}
+		return false, record, nil
+	}
+	return false, nil, ErrContended
+}
+
+func (s *PostgresStore) Complete(ctx context.Context, key string, response Response) error {
+	headers, err := json.Marshal(response.Header)
+	if err != nil {
+		return err
+	}
+	status := int32(response.StatusCode)
+	return s.repo.CompleteIdempotencyKey(ctx, repository.CompleteIdempotencyKeyParams{
+		Key:        key,
+		StatusCode: &status,
+		Headers:    headers,
+		Body:       response.Body,
+	})
+}
+
+func (s *PostgresStore) Release(ctx context.Context, key string) error {
+	return s.repo.ReleaseIdempotencyKey(ctx, key)
+}
+
+// Purge deletes expired records every interval until ctx is cancelled.
+func (s *PostgresStore) Purge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := s.repo.DeleteExpiredIdempotencyKeys(ctx, s.now())
+			if err != nil {
+				s.logger.ErrorContext(ctx, "failed to purge idempotency keys", "error", err)
+				continue
+			}
+			if n > 0 {
+				s.logger.DebugContext(ctx, "purged idempotency keys", "count", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package idempotency
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/models"
+	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/doug-benn/go-server-starter/testutils"
+	"github.com/jackc/pgx/v5"
+)
+
+func TestPostgresStore(t *testing.T) {
+	rows := make(map[string]models.IdempotencyKey)
+	repo := &testutils.MockQuerier{
+		ClaimIdempotencyKeyFunc: func(ctx context.Context, arg repository.ClaimIdempotencyKeyParams) (int64, error) {
+			if row, ok := rows[arg.Key]; ok && !row.ExpiresAt.Before(arg.Now) &&
+				(row.StatusCode != nil || !row.LockedUntil.Before(arg.Now)) {
+				return 0, nil
+			}
+			rows[arg.Key] = models.IdempotencyKey{Key: arg.Key, Fingerprint: arg.Fingerprint, LockedUntil: arg.LockedUntil, ExpiresAt: arg.ExpiresAt}
+			return 1, nil
+		},
+		GetIdempotencyKeyFunc: func(ctx context.Context, key string) (models.IdempotencyKey, error) {
+			row, ok := rows[key]
+			if !ok {
+				return row, pgx.ErrNoRows
+			}
+			return row, nil
+		},
+		CompleteIdempotencyKeyFunc: func(ctx context.Context, arg repository.CompleteIdempotencyKeyParams) error {
+			row := rows[arg.Key]
+			row.StatusCode, row.Headers, row.Body = arg.StatusCode, arg.Headers, arg.Body
+			rows[arg.Key] = row
+			return nil
+		},
+	}
+	store := NewPostgresStore(repo, slog.Default())
+	ctx := context.Background()
+
+	if claimed, _, err := store.Claim(ctx, "a", "fp", time.Minute, time.Hour); !claimed || err != nil {
+		t.Fatalf("expected the first request to claim the key, got %v", err)
+	}
+	if claimed, record, _ := store.Claim(ctx, "a", "fp", time.Minute, time.Hour); claimed || record.Response != nil {
+		t.Fatalf("expected the key to be in flight, got %+v", record)
+	}
+
+	header := http.Header{"Location": {"/todos/1"}}
+	if err := store.Complete(ctx, "a", Response{StatusCode: http.StatusCreated, Header: header, Body: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+	_, record, err := store.Claim(ctx, "a", "fp", time.Minute, time.Hour)
+	if err != nil || record.Response == nil {
+		t.Fatalf("expected the stored response, got %+v, %v", record, err)
+	}
+	if record.Response.StatusCode != http.StatusCreated || record.Response.Header.Get("Location") != "/todos/1" || string(record.Response.Body) != "{}" {
+		t.Errorf("unexpected response %+v", record.Response)
+	}
+}
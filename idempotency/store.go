@@ -0,0 +1,110 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Response is a response stored for replay.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Record is what a Store holds for a key.
+type Record struct {
+	// Fingerprint identifies the request that claimed the key.
+	Fingerprint string
+	// Response is nil while that request is in flight.
+	Response *Response
+}
+
+// Store keeps the responses to requests sent with an idempotency key.
+type Store interface {
+	// Claim claims key for a request with the fingerprint for up to
+	// lockTimeout, keeping it for ttl. If the key is already claimed the
+	// existing record is returned instead.
+	Claim(ctx context.Context, key, fingerprint string, lockTimeout, ttl time.Duration) (bool, *Record, error)
+	// Complete stores the response to the request that claimed key.
+	Complete(ctx context.Context, key string, response Response) error
+	// Release gives up an unfinished claim, so that a retry runs again.
+	Release(ctx context.Context, key string) error
+}
+
+type memoryRecord struct {
+	Record
+	lockedUntil time.Time
+	expiresAt   time.Time
+}
+
+// MemoryStore keeps records in process, for tests and single node development.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]*memoryRecord
+	now     func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]*memoryRecord), now: time.Now}
+}
+
+func (s *MemoryStore) Claim(ctx context.Context, key, fingerprint string, lockTimeout, ttl time.Duration) (bool, *Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if record, ok := s.records[key]; ok && now.Before(record.expiresAt) &&
+		(record.Response != nil || now.Before(record.lockedUntil)) {
+		existing := record.Record
+		return false, &existing, nil
+	}
+	s.records[key] = &memoryRecord{
+		Record:      Record{Fingerprint: fingerprint},
+		lockedUntil: now.Add(lockTimeout),
+		expiresAt:   now.Add(ttl),
+	}
+	return true, nil, nil
+}
+
+func (s *MemoryStore) Complete(ctx context.Context, key string, response Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if record, ok := s.records[key]; ok && record.Response == nil {
+		record.Response = &response
+	}
+	return nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if record, ok := s.records[key]; ok && record.Response == nil {
+		delete(s.records, key)
+	}
+	return nil
+}
+
+// Purge drops expired records every interval until ctx is cancelled.
+func (s *MemoryStore) Purge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			now := s.now()
+			for key, record := range s.records {
+				if !now.Before(record.expiresAt) {
+					delete(s.records, key)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
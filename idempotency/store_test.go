@@ -0,0 +1,54 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	claimed, _, _ := store.Claim(ctx, "a", "fp", time.Minute, time.Hour)
+	if !claimed {
+		t.Fatal("expected the first request to claim the key")
+	}
+	claimed, record, _ := store.Claim(ctx, "a", "fp", time.Minute, time.Hour)
+	if claimed || record.Response != nil {
+		t.Fatalf("expected the key to be in flight, got %v %+v", claimed, record)
+	}
+
+	store.Complete(ctx, "a", Response{StatusCode: http.StatusCreated, Body: []byte("{}")})
+	_, record, _ = store.Claim(ctx, "a", "other", time.Minute, time.Hour)
+	if record.Fingerprint != "fp" || record.Response == nil || record.Response.StatusCode != http.StatusCreated {
+		t.Fatalf("expected the stored response, got %+v", record)
+	}
+
+	// Expired records are claimed again
+	now = now.Add(time.Hour)
+	if claimed, _, _ := store.Claim(ctx, "a", "other", time.Minute, time.Hour); !claimed {
+		t.Error("expected an expired key to be claimed again")
+	}
+}
+
+func TestMemoryStore_TakeOver(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	store.Claim(ctx, "a", "fp", time.Minute, time.Hour)
+	now = now.Add(2 * time.Minute)
+	if claimed, _, _ := store.Claim(ctx, "a", "fp", time.Minute, time.Hour); !claimed {
+		t.Error("expected a retry to take over a stale claim")
+	}
+
+	store.Release(ctx, "a")
+	if claimed, _, _ := store.Claim(ctx, "a", "fp", time.Minute, time.Hour); !claimed {
+		t.Error("expected a released key to be claimed again")
+	}
+}
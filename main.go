@@ -11,19 +11,25 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	metrics "github.com/slok/go-http-metrics/metrics/prometheus"
 	metricsware "github.com/slok/go-http-metrics/middleware"
 	"github.com/slok/go-http-metrics/middleware/std"
 
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/cache"
 	"github.com/doug-benn/go-server-starter/database"
+	"github.com/doug-benn/go-server-starter/idempotency"
 	"github.com/doug-benn/go-server-starter/middleware"
 	"github.com/doug-benn/go-server-starter/producer"
+	"github.com/doug-benn/go-server-starter/ratelimit"
 	"github.com/doug-benn/go-server-starter/repository"
 	"github.com/doug-benn/go-server-starter/router"
 	"github.com/doug-benn/go-server-starter/services"
+	"github.com/doug-benn/go-server-starter/session"
 	"github.com/doug-benn/go-server-starter/sse"
-	"github.com/patrickmn/go-cache"
+	"github.com/doug-benn/go-server-starter/utilities"
 )
 
 func main() {
@@ -44,40 +50,191 @@ func run(w io.Writer, args []string) error {
 
 	logger := slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelInfo}))
 
-	appCache := cache.New(5*time.Minute, 10*time.Minute)
-
 	// Database Connection
 	postgresDatabase, err := database.NewDatabase(ctx, logger, database.DefaultConfig())
 	if err != nil {
 		logger.Error("error creating database pool on startup", "error", err)
 		return err
 	}
+	// Tenants are only isolated from each other if the policies apply to the
+	// role, a superuser is only acceptable for local development
+	if err := database.CheckRowLevelSecurity(ctx, postgresDatabase.Pool()); err != nil {
+		if utilities.GetEnvOrDefault("DATABASE_ALLOW_RLS_BYPASS", "false") != "true" {
+			postgresDatabase.Close()
+			return fmt.Errorf("refusing to start: %w, connect as a role without BYPASSRLS or set DATABASE_ALLOW_RLS_BYPASS=true", err)
+		}
+		logger.Warn("tenant isolation relies on the application only", "error", err)
+	}
 
-	todoService := services.NewTodoService(repository.New(postgresDatabase.Pool()), logger)
+	// Values are shared by every replica through Postgres, with a short-lived
+	// copy in process so hot keys do not cost a query each
+	cacheLocalTTL, err := time.ParseDuration(utilities.GetEnvOrDefault("CACHE_LOCAL_TTL", "30s"))
+	if err != nil {
+		return fmt.Errorf("invalid CACHE_LOCAL_TTL: %w", err)
+	}
+	postgresCache := cache.NewPostgresCache(repository.New(postgresDatabase.Pool()), logger)
+	go postgresCache.Purge(ctx, time.Hour)
+	appCache := cache.NewTiered(cache.NewMemoryCache(cache.DefaultMemoryConfig()), postgresCache, cacheLocalTTL)
+
+	apiKeyService := services.NewAPIKeyService(repository.New(postgresDatabase.Pool()), logger)
+	tenantService := services.NewTenantService(repository.New(postgresDatabase.Pool()), logger)
+	accountService := services.NewAccountService(repository.New(postgresDatabase.Pool()), logger)
+
+	if len(args) > 1 && args[1] == "apikey" {
+		defer postgresDatabase.Close()
+		return runAPIKeyCommand(ctx, w, apiKeyService, args[2:])
+	}
+	if len(args) > 1 && args[1] == "tenant" {
+		defer postgresDatabase.Close()
+		return runTenantCommand(ctx, w, tenantService, args[2:])
+	}
+	if len(args) > 1 && args[1] == "user" {
+		defer postgresDatabase.Close()
+		return runUserCommand(ctx, w, os.Stdin, accountService, args[2:])
+	}
 
 	// Create a producer for FizzBuzz events with a 5-second broadcast timeout
 	sseProducer := producer.NewProducer(
 		producer.WithBroadcastTimeout[sse.Event](5*time.Second),
 		producer.WithCustomLogger[sse.Event](logger),
+		// Relay events published by this node's API to the other replicas
+		producer.WithBus[sse.Event](producer.NewPostgresBus[sse.Event](postgresDatabase.Pool(), "producer_bus", logger)),
+		// Tells subscribers, such as the todo cache, to reload when events
+		// were dropped on this node or failed to reach the others
+		producer.WithResync(repository.NewResyncEvent),
 	)
 
 	// Start the producer in a goroutine
 	go sseProducer.Start(ctx)
 
+	userService := services.NewUserService(repository.New(postgresDatabase.Pool()), logger)
+	todoCacheTTL, err := time.ParseDuration(utilities.GetEnvOrDefault("TODO_CACHE_TTL", "1m"))
+	if err != nil {
+		return fmt.Errorf("invalid TODO_CACHE_TTL: %w", err)
+	}
+	// Reads are cached on each replica and evicted by the todo events every
+	// replica receives, the TTL bounds staleness should one be lost
+	todoService := services.NewCachingTodoService(
+		services.NewTodoService(repository.New(postgresDatabase.Pool()), logger,
+			services.WithEventPublisher(sseProducer),
+			services.WithUserService(userService),
+		),
+		userService, cache.NewMemoryCache(cache.DefaultMemoryConfig()), todoCacheTTL, logger,
+	)
+	todoService.Invalidate(ctx, sseProducer)
+
+	webhookService := services.NewWebhookService(repository.New(postgresDatabase.Pool()), logger)
+	webhookWorker, err := services.NewWebhookWorker(repository.New(postgresDatabase.Pool()), logger, services.DefaultWebhookWorkerConfig())
+	if err != nil {
+		return fmt.Errorf("invalid webhook config: %w", err)
+	}
+	go webhookWorker.Start(ctx, sseProducer)
+
 	postgresListener := database.NewListener(postgresDatabase.Pool())
 	postgresListener.Connect(ctx)
 	postgresListener.ListenToChannel(ctx, "events")
 
-	go repository.NotificationProcessing(ctx, logger, postgresListener, sseProducer)
+	go repository.NotificationProcessing(ctx, logger, postgresListener, "events", sseProducer)
+
+	sessionConfig := session.DefaultConfig()
+	sessions := session.NewManager(session.NewPostgresStore(repository.New(postgresDatabase.Pool())), accountService, logger, sessionConfig)
+	go sessions.PurgeExpired(ctx, time.Hour)
 
 	mux := http.NewServeMux()
-	router.AddRoutes(mux, logger, appCache, sseProducer, todoService)
+	routes := router.AddRoutes(mux, logger, appCache, sseProducer, userService, todoService, webhookService, apiKeyService, accountService, sessions)
+
+	// API keys and browser sessions are always accepted, tokens from an identity
+	// provider when a JWKS is configured
+	authenticators := []auth.Authenticator{apiKeyService, sessions}
+	if jwtConfig := auth.DefaultJWTConfig(); jwtConfig.Enabled() {
+		jwtAuthenticator, err := auth.NewJWTAuthenticator(jwtConfig.JWKS(auth.DefaultJWKSConfig()), jwtConfig)
+		if err != nil {
+			return fmt.Errorf("invalid jwt config: %w", err)
+		}
+		authenticators = append(authenticators, jwtAuthenticator)
+	}
+
+	rateLimitConfig := middleware.DefaultRateLimitConfig()
+	rateLimitOptions, err := rateLimitConfig.Options()
+	if err != nil {
+		return fmt.Errorf("invalid rate limit config: %w", err)
+	}
+	// Shared by every replica so the limits do not scale with their number
+	rateLimitStore := ratelimit.NewPostgresStore(repository.New(postgresDatabase.Pool()), logger, ratelimit.DefaultPostgresConfig())
+	go rateLimitStore.Purge(ctx, time.Minute)
+	prometheus.MustRegister(rateLimitStore)
+	rateLimitOptions = append(rateLimitOptions, middleware.WithStore(rateLimitStore))
+	ipRateLimitOptions, err := rateLimitConfig.IPOptions()
+	if err != nil {
+		return fmt.Errorf("invalid rate limit config: %w", err)
+	}
+	ipRateLimitOptions = append(ipRateLimitOptions, middleware.WithStore(rateLimitStore))
+
+	var idempotencyStore interface {
+		idempotency.Store
+		Purge(ctx context.Context, interval time.Duration)
+	}
+	if utilities.GetEnvOrDefault("IDEMPOTENCY_STORE", "postgres") == "memory" {
+		// Only for a single replica, a retry reaching another one runs again
+		idempotencyStore = idempotency.NewMemoryStore()
+	} else {
+		idempotencyStore = idempotency.NewPostgresStore(repository.New(postgresDatabase.Pool()), logger)
+	}
+	go idempotencyStore.Purge(ctx, time.Hour)
+
+	corsConfig := middleware.DefaultCORSConfig()
+	corsConfig.Mux = mux
+	cors, err := middleware.CORS(logger, corsConfig)
+	if err != nil {
+		return fmt.Errorf("invalid cors config: %w", err)
+	}
+
+	requestLimits := middleware.DefaultRequestLimitsConfig()
+	// Leaves room for the request line above the middleware's own limit, zero
+	// keeps the server default
+	maxHeaderBytes := 0
+	if requestLimits.MaxHeaderBytes > 0 {
+		maxHeaderBytes = requestLimits.MaxHeaderBytes + 8<<10
+	}
 
 	// Create middleware chain with proper chaining
 	middlewareChain := middleware.NewChain(
 		middleware.Recovery(logger),
-		middleware.RateLimiter(10, 20),
+		middleware.SecurityHeaders(middleware.DefaultSecurityHeadersConfig()),
+		// Answers preflights before anything can reject them, and adds CORS
+		// headers to every response so that errors are readable cross-origin
+		cors,
+		// Outside authentication so that rejected credentials are logged too,
+		// Authenticate and Tenant record the principal and tenant for it
 		middleware.AccessLogger(logger, middleware.IgnorePath("/events")),
+		middleware.RequestLimits(logger, requestLimits),
+		middleware.Timeout(logger, middleware.DefaultTimeoutConfig()),
+		// Shed load before doing any work for a request, such as authenticating it
+		middleware.ConcurrencyLimiter(logger, middleware.DefaultConcurrencyConfig()),
+		// Bounds each client address before its credentials are checked
+		middleware.RateLimiter(ctx, rateLimitConfig.IPLimit, rateLimitConfig.IPBurst, ipRateLimitOptions...),
+		middleware.Authenticate(logger, auth.Chain(authenticators...),
+			// Routes declared public in the route table
+			middleware.PublicRequests(router.PublicRoutes(mux, routes)),
+			// EventSource cannot send an Authorization header
+			middleware.QueryTokenPaths("/events"),
+			middleware.SessionCookie(sessionConfig.CookieName),
+		),
+		middleware.CSRF(logger, middleware.CSRFConfig{
+			SessionCookie: sessionConfig.CookieName,
+			Cookie:        sessionConfig.CSRFCookieName,
+			Header:        session.CSRFHeader,
+		}),
+		middleware.Tenant(logger, tenantService, middleware.DefaultTenantConfig()),
+		middleware.RateLimiter(ctx, rateLimitConfig.Limit, rateLimitConfig.Burst, rateLimitOptions...),
+		middleware.RequireIfMatch(middleware.DefaultPreconditionConfig()),
+		// Outside compression, so stored responses do not depend on the first
+		// request's Accept-Encoding
+		middleware.Idempotency(logger, idempotencyStore, middleware.DefaultIdempotencyConfig()),
+		// Inside the access logger so it sees both compressed and uncompressed sizes
+		middleware.Compress(middleware.DefaultCompressConfig()),
+		// Inside compression, so responses are stored once and encoded for each client
+		middleware.ResponseCache(logger, appCache, middleware.DefaultResponseCacheConfig()),
 	)
 
 	handler := std.Handler("", metricsware.New(metricsware.Config{
@@ -86,11 +243,14 @@ func run(w io.Writer, args []string) error {
 
 	// HTTP Server
 	server := &http.Server{
-		Addr:         fmt.Sprintf("127.0.0.1:%d", config.Port),
-		Handler:      handler,
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		Addr:           fmt.Sprintf("127.0.0.1:%d", config.Port),
+		Handler:        handler,
+		IdleTimeout:    time.Minute,
+		ReadTimeout:    10 * time.Second,
+		MaxHeaderBytes: maxHeaderBytes,
+		// Requests are timed out per route by middleware.Timeout, a write
+		// timeout here would also end SSE streams and profiles
+		WriteTimeout: 0,
 	}
 
 	metrics := &http.Server{Addr: fmt.Sprintf("127.0.0.1:%d", 9201), Handler: promhttp.Handler()}
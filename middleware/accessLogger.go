@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
@@ -9,16 +10,56 @@ import (
 	"net/http"
 	"slices"
 	"time"
+
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/database"
 )
 
 type Filter func(w WriterProxy, r *http.Request) bool
 
+// logIdentity is filled in by Authenticate and Tenant, so that the access
+// logger sees who a request was from when it runs outside them.
+type logIdentity struct {
+	principal string
+	tenant    string
+}
+
+type logIdentityKey struct{}
+
+func identityForLog(r *http.Request) *logIdentity {
+	identity, _ := r.Context().Value(logIdentityKey{}).(*logIdentity)
+	return identity
+}
+
+func logPrincipal(r *http.Request, principal *auth.Principal) {
+	if identity := identityForLog(r); identity != nil {
+		identity.principal = principal.ID
+	}
+}
+
+func logTenant(r *http.Request, tenant string) {
+	if identity := identityForLog(r); identity != nil {
+		identity.tenant = tenant
+	}
+}
+
+// AccessLogger logs every request once it has been served. It can run outside
+// Authenticate and Tenant, which record the principal and tenant for it, so
+// that requests they reject are logged as well.
 func AccessLogger(logger *slog.Logger, filters ...Filter) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 			start := time.Now()
 			lw := WrapWriter(w)
+			identity := &logIdentity{}
+			if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+				identity.principal = principal.ID
+			}
+			if tenant, ok := database.TenantFromContext(r.Context()); ok {
+				identity.tenant = tenant
+			}
+			r = r.WithContext(context.WithValue(r.Context(), logIdentityKey{}, identity))
 
 			defer func() {
 
@@ -40,6 +81,18 @@ func AccessLogger(logger *slog.Logger, filters ...Filter) func(http.Handler) htt
 					slog.Duration("elapsed_ms", time.Since(start)),
 					slog.String("remote_ip", r.RemoteAddr),
 				}
+				if encoding := lw.Encoding(); encoding != "" {
+					attributes = append(attributes,
+						slog.String("encoding", encoding),
+						slog.Int("decoded_bytes", lw.DecodedBytes()),
+					)
+				}
+				if identity.principal != "" {
+					attributes = append(attributes, slog.String("principal", identity.principal))
+				}
+				if identity.tenant != "" {
+					attributes = append(attributes, slog.String("tenant", identity.tenant))
+				}
 
 				level := slog.LevelInfo
 				if status >= http.StatusInternalServerError {
@@ -70,6 +123,12 @@ type WriterProxy interface {
 	Status() int
 	// BytesWritten returns the total number of bytes sent to the client.
 	BytesWritten() int
+	// Encoding returns the content encoding Compress applied to the response,
+	// or "" if it was sent as written.
+	Encoding() string
+	// DecodedBytes returns the number of bytes written before compression,
+	// which is BytesWritten if the response was not compressed.
+	DecodedBytes() int
 	// Unwrap returns the original proxied target.
 	Unwrap() http.ResponseWriter
 }
@@ -95,9 +154,11 @@ func WrapWriter(w http.ResponseWriter) WriterProxy {
 // http.ResponseWriter interface.
 type basicWriter struct {
 	http.ResponseWriter
-	wroteHeader bool
-	code        int
-	bytes       int
+	wroteHeader  bool
+	code         int
+	bytes        int
+	encoding     string
+	decodedBytes int
 }
 
 func (b *basicWriter) WriteHeader(code int) {
@@ -129,6 +190,22 @@ func (b *basicWriter) BytesWritten() int {
 	return b.bytes
 }
 
+func (b *basicWriter) Encoding() string {
+	return b.encoding
+}
+
+func (b *basicWriter) DecodedBytes() int {
+	if b.encoding == "" {
+		return b.bytes
+	}
+	return b.decodedBytes
+}
+
+func (b *basicWriter) recordEncoding(encoding string, decodedBytes int) {
+	b.encoding = encoding
+	b.decodedBytes = decodedBytes
+}
+
 func (b *basicWriter) Unwrap() http.ResponseWriter {
 	return b.ResponseWriter
 }
@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/doug-benn/go-server-starter/auth"
+)
+
+const (
+	// APIKeyHeader is read for a credential when no Authorization header is sent.
+	APIKeyHeader = "X-API-Key"
+	// AccessTokenParam is the query parameter read on paths allowed by QueryTokenPaths.
+	AccessTokenParam = "access_token"
+)
+
+type authConfig struct {
+	publicPaths     []string
+	public          func(r *http.Request) bool
+	queryTokenPaths []string
+	sessionCookie   string
+}
+
+type AuthOption func(*authConfig)
+
+// PublicPaths lets requests to the given paths through without a credential.
+// A path ending in "/" matches everything below it.
+func PublicPaths(paths ...string) AuthOption {
+	return func(c *authConfig) {
+		c.publicPaths = append(c.publicPaths, paths...)
+	}
+}
+
+// PublicRequests lets the requests public reports through without a
+// credential, such as those to routes that anyone may call.
+func PublicRequests(public func(r *http.Request) bool) AuthOption {
+	return func(c *authConfig) {
+		c.public = public
+	}
+}
+
+// QueryTokenPaths accepts the credential in the access_token query parameter on
+// the given paths, for clients such as EventSource that cannot set headers. The
+// parameter is removed before the request is passed on, so it is not logged.
+func QueryTokenPaths(paths ...string) AuthOption {
+	return func(c *authConfig) {
+		c.queryTokenPaths = append(c.queryTokenPaths, paths...)
+	}
+}
+
+// SessionCookie reads the credential from the named cookie when the request
+// sends none in its headers. An invalid cookie, such as one for an expired
+// session, is ignored rather than rejected so that public paths like the login
+// page stay reachable.
+func SessionCookie(name string) AuthOption {
+	return func(c *authConfig) {
+		c.sessionCookie = name
+	}
+}
+
+// Authenticate resolves the principal for the credential sent with each request
+// and stores it in the request context. Requests without a valid credential are
+// rejected with 401, unless the path is public.
+func Authenticate(logger *slog.Logger, authenticator auth.Authenticator, opts ...AuthOption) func(http.Handler) http.Handler {
+	config := authConfig{}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			credential := credentialFromRequest(r)
+			if credential == "" && matchPath(config.queryTokenPaths, r.URL.Path) {
+				credential, r = queryToken(r)
+			}
+			fromCookie := false
+			if credential == "" && config.sessionCookie != "" {
+				if cookie, err := r.Cookie(config.sessionCookie); err == nil && cookie.Value != "" {
+					credential, fromCookie = cookie.Value, true
+				}
+			}
+			if credential == "" {
+				if config.isPublic(r) {
+					next.ServeHTTP(w, r)
+					return
+				}
+				unauthorized(w, "missing credential")
+				return
+			}
+
+			principal, err := authenticator.Authenticate(r.Context(), credential)
+			if err != nil {
+				rejected := errors.Is(err, auth.ErrUnrecognized) || errors.Is(err, auth.ErrInvalidCredential)
+				if rejected && fromCookie && config.isPublic(r) {
+					next.ServeHTTP(w, r)
+					return
+				}
+				if rejected {
+					logger.WarnContext(r.Context(), "authentication failed",
+						slog.String("path", r.URL.Path),
+						slog.String("remote_ip", r.RemoteAddr),
+						slog.Any("error", err),
+					)
+					unauthorized(w, "invalid credential")
+					return
+				}
+				logger.ErrorContext(r.Context(), "authentication error", slog.Any("error", err))
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			logPrincipal(r, principal)
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+func (c authConfig) isPublic(r *http.Request) bool {
+	return matchPath(c.publicPaths, r.URL.Path) || (c.public != nil && c.public(r))
+}
+
+func matchPath(paths []string, path string) bool {
+	for _, p := range paths {
+		if path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+func credentialFromRequest(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	return strings.TrimSpace(r.Header.Get(APIKeyHeader))
+}
+
+// queryToken takes the access token from the query string and returns a copy
+// of the request without it.
+func queryToken(r *http.Request) (string, *http.Request) {
+	query := r.URL.Query()
+	token := strings.TrimSpace(query.Get(AccessTokenParam))
+	if token == "" {
+		return "", r
+	}
+	query.Del(AccessTokenParam)
+
+	r = r.Clone(r.Context())
+	r.URL.RawQuery = query.Encode()
+	r.RequestURI = r.URL.RequestURI()
+	return token, r
+}
+
+func unauthorized(w http.ResponseWriter, detail string) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	WriteProblem(w, http.StatusUnauthorized, detail)
+}
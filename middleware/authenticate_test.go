@@ -0,0 +1,326 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/ratelimit"
+)
+
+var testAuthenticator = auth.AuthenticatorFunc(func(ctx context.Context, credential string) (*auth.Principal, error) {
+	switch credential {
+	case "good":
+		return &auth.Principal{ID: "api_key:1", Kind: auth.KindAPIKey, Scopes: []string{"read"}}, nil
+	case "admin":
+		return &auth.Principal{ID: "api_key:2", Kind: auth.KindAPIKey, Scopes: []string{"admin"}}, nil
+	case "broken":
+		return nil, errors.New("database down")
+	}
+	return nil, auth.ErrInvalidCredential
+})
+
+func principalHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+			w.Write([]byte(principal.ID))
+			return
+		}
+		w.Write([]byte("anonymous"))
+	})
+}
+
+func TestAuthenticate(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		headers        map[string]string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "bearer token",
+			path:           "/todos",
+			headers:        map[string]string{"Authorization": "Bearer good"},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "api_key:1",
+		},
+		{
+			name:           "api key header",
+			path:           "/todos",
+			headers:        map[string]string{APIKeyHeader: "good"},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "api_key:1",
+		},
+		{
+			name:           "missing credential",
+			path:           "/todos",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "invalid credential",
+			path:           "/todos",
+			headers:        map[string]string{"Authorization": "Bearer bad"},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "unsupported scheme",
+			path:           "/todos",
+			headers:        map[string]string{"Authorization": "Basic Zm9vOmJhcg=="},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "authenticator error",
+			path:           "/todos",
+			headers:        map[string]string{"Authorization": "Bearer broken"},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:           "public path",
+			path:           "/health",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "anonymous",
+		},
+		{
+			name:           "public prefix",
+			path:           "/public/logo.png",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "anonymous",
+		},
+		{
+			name:           "credential on public path",
+			path:           "/health",
+			headers:        map[string]string{"Authorization": "Bearer good"},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "api_key:1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+			handler := Authenticate(logger, testAuthenticator, PublicPaths("/health", "/public/"))(principalHandler())
+
+			req := httptest.NewRequest("GET", tt.path, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedStatus == http.StatusUnauthorized {
+				assert.Equal(t, "Bearer", rr.Header().Get("WWW-Authenticate"))
+			}
+			if tt.expectedBody != "" {
+				assert.Equal(t, tt.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestAuthenticatePublicRequests(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	public := func(r *http.Request) bool { return r.Method == http.MethodPost && r.URL.Path == "/login" }
+	handler := Authenticate(logger, testAuthenticator, PublicRequests(public))(principalHandler())
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/login", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/login", nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestAuthenticateLogsFailures(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buffer, nil))
+	handler := Authenticate(logger, testAuthenticator)(principalHandler())
+
+	req := httptest.NewRequest("GET", "/todos", nil)
+	req.Header.Set("Authorization", "Bearer bad")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Contains(t, buffer.String(), `"level":"WARN"`)
+	assert.Contains(t, buffer.String(), "authentication failed")
+	assert.NotContains(t, buffer.String(), "bad\"", "credential should not be logged")
+}
+
+func TestRequireScope(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	handler := NewChain(
+		Authenticate(logger, testAuthenticator),
+		RequireScope("admin"),
+	).Build(principalHandler())
+
+	for credential, expected := range map[string]int{
+		"good":  http.StatusForbidden,
+		"admin": http.StatusOK,
+	} {
+		req := httptest.NewRequest("GET", "/admin/api-keys", nil)
+		req.Header.Set("Authorization", "Bearer "+credential)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, expected, rr.Code, credential)
+	}
+
+	rr := httptest.NewRecorder()
+	RequireScope("admin")(principalHandler()).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestRateLimiterKeysOnPrincipal(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	handler := NewChain(
+		Authenticate(logger, testAuthenticator),
+		RateLimiter(t.Context(), rate.Limit(1), 1),
+	).Build(okHandler())
+
+	request := func(credential string) int {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = "10.0.0.1:12345"
+		req.Header.Set("Authorization", "Bearer "+credential)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	require.Equal(t, http.StatusOK, request("good"))
+	require.Equal(t, http.StatusTooManyRequests, request("good"))
+	// Same address, different principal
+	assert.Equal(t, http.StatusOK, request("admin"))
+}
+
+func TestAccessLoggerIncludesPrincipal(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buffer, nil))
+	handler := NewChain(
+		Authenticate(logger, testAuthenticator),
+		AccessLogger(logger),
+	).Build(okHandler())
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Authorization", "Bearer good")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Contains(t, buffer.String(), `"principal":"api_key:1"`)
+}
+
+func TestAccessLoggerOutsideAuthenticate(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buffer, nil))
+	handler := NewChain(
+		AccessLogger(logger),
+		Authenticate(logger, testAuthenticator),
+	).Build(okHandler())
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Authorization", "Bearer good")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Contains(t, buffer.String(), `"principal":"api_key:1"`)
+
+	buffer.Reset()
+	req = httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Contains(t, buffer.String(), `"status_code":401`)
+}
+
+func TestRateLimiterByIPBeforeAuthenticate(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	config := RateLimitConfig{Limit: rate.Limit(1), Burst: 5, IPLimit: rate.Limit(1), IPBurst: 2}
+	ipOptions, err := config.IPOptions()
+	require.NoError(t, err)
+	options, err := config.Options()
+	require.NoError(t, err)
+	store := ratelimit.NewMemoryStore(ratelimit.DefaultMemoryConfig())
+	handler := NewChain(
+		RateLimiter(t.Context(), config.IPLimit, config.IPBurst, append(ipOptions, WithStore(store))...),
+		Authenticate(logger, testAuthenticator),
+		RateLimiter(t.Context(), config.Limit, config.Burst, append(options, WithStore(store))...),
+	).Build(okHandler())
+
+	request := func(credential string) int {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = "10.0.0.1:12345"
+		req.Header.Set("Authorization", "Bearer "+credential)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// Invalid credentials are counted against the address
+	require.Equal(t, http.StatusUnauthorized, request("wrong"))
+	require.Equal(t, http.StatusUnauthorized, request("wrong"))
+	assert.Equal(t, http.StatusTooManyRequests, request("good"))
+}
+
+func TestAuthenticateQueryToken(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	var seenQuery, seenURI string
+	handler := Authenticate(logger, testAuthenticator, QueryTokenPaths("/events"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seenQuery, seenURI = r.URL.RawQuery, r.RequestURI
+		principalHandler().ServeHTTP(w, r)
+	}))
+
+	req := httptest.NewRequest("GET", "/events?access_token=good&topic=todos", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "api_key:1", rr.Body.String())
+	assert.Equal(t, "topic=todos", seenQuery, "token should be removed from the query")
+	assert.NotContains(t, seenURI, "access_token")
+
+	// Only allowed on the configured paths
+	req = httptest.NewRequest("GET", "/todos?access_token=good", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestAuthenticateSessionCookie(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	handler := Authenticate(logger, testAuthenticator, PublicPaths("/login"), SessionCookie("session"))(principalHandler())
+
+	tests := []struct {
+		name           string
+		path           string
+		cookie         string
+		header         string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"valid cookie", "/todos", "good", "", http.StatusOK, "api_key:1"},
+		{"header wins over cookie", "/todos", "good", "admin", http.StatusOK, "api_key:2"},
+		{"invalid cookie", "/todos", "expired", "", http.StatusUnauthorized, ""},
+		{"invalid cookie on public path", "/login", "expired", "", http.StatusOK, "anonymous"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.path, nil)
+			req.AddCookie(&http.Cookie{Name: "session", Value: tt.cookie})
+			if tt.header != "" {
+				req.Header.Set(APIKeyHeader, tt.header)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedBody != "" {
+				assert.Equal(t, tt.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/database"
+)
+
+// Authorize enforces policy on the principal stored by Authenticate. Requests
+// without a principal get 401 and principals lacking a scope or role get 403.
+// Tenant policies reject requests that Tenant did not resolve with 400.
+func Authorize(policy auth.Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if policy.Public {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := auth.PrincipalFromContext(r.Context())
+			if !ok {
+				unauthorized(w, "missing credential")
+				return
+			}
+			if !policy.Allows(principal) {
+				forbidden(w, policy)
+				return
+			}
+			if _, ok := database.TenantFromContext(r.Context()); policy.Tenant && !ok {
+				WriteProblem(w, http.StatusBadRequest, "tenant required")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireScope rejects requests whose principal was not granted scope with 403.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return Authorize(auth.RequireScopes(scope))
+}
+
+func forbidden(w http.ResponseWriter, policy auth.Policy) {
+	var requirements []string
+	if len(policy.Scopes) > 0 {
+		requirements = append(requirements, "scopes "+strings.Join(policy.Scopes, ", "))
+		// RFC 6750 lets clients discover which scopes to request
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, strings.Join(policy.Scopes, " ")))
+	}
+	if len(policy.Roles) > 0 {
+		requirements = append(requirements, "one of the roles "+strings.Join(policy.Roles, ", "))
+	}
+	WriteProblem(w, http.StatusForbidden, "requires "+strings.Join(requirements, " and "))
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/doug-benn/go-server-starter/auth"
+)
+
+func TestAuthorize(t *testing.T) {
+	reader := &auth.Principal{ID: "user:1", Scopes: []string{auth.ScopeTodosRead}}
+
+	tests := []struct {
+		name           string
+		policy         auth.Policy
+		principal      *auth.Principal
+		expectedStatus int
+	}{
+		{"public", auth.PublicPolicy(), nil, http.StatusOK},
+		{"no principal", auth.Policy{}, nil, http.StatusUnauthorized},
+		{"authenticated", auth.Policy{}, reader, http.StatusOK},
+		{"scope granted", auth.RequireScopes(auth.ScopeTodosRead), reader, http.StatusOK},
+		{"scope missing", auth.RequireScopes(auth.ScopeTodosWrite), reader, http.StatusForbidden},
+		{"role missing", auth.RequireRoles("admin"), reader, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/todos", nil)
+			if tt.principal != nil {
+				req = req.WithContext(auth.WithPrincipal(req.Context(), tt.principal))
+			}
+			rr := httptest.NewRecorder()
+			Authorize(tt.policy)(okHandler()).ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedStatus == http.StatusOK {
+				return
+			}
+
+			assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+			var problem Problem
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&problem))
+			assert.Equal(t, tt.expectedStatus, problem.Status)
+			assert.Equal(t, http.StatusText(tt.expectedStatus), problem.Title)
+		})
+	}
+}
+
+func TestAuthorizeForbiddenDetail(t *testing.T) {
+	req := httptest.NewRequest("POST", "/todos", nil)
+	req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{ID: "user:1"}))
+	rr := httptest.NewRecorder()
+	Authorize(auth.RequireScopes(auth.ScopeTodosWrite))(okHandler()).ServeHTTP(rr, req)
+
+	var problem Problem
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&problem))
+	assert.Equal(t, "requires scopes todos:write", problem.Detail)
+	assert.Equal(t, `Bearer error="insufficient_scope", scope="todos:write"`, rr.Header().Get("WWW-Authenticate"))
+}
@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// TrustedProxies derives the client address of requests forwarded by proxies
+// in a known set of networks, such as our load balancers.
+type TrustedProxies struct {
+	prefixes []netip.Prefix
+}
+
+// ParseTrustedProxies parses CIDRs such as 10.0.0.0/8, a bare address is
+// trusted on its own.
+func ParseTrustedProxies(cidrs []string) (*TrustedProxies, error) {
+	proxies := &TrustedProxies{}
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(cidr); err == nil {
+			proxies.prefixes = append(proxies.prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", cidr)
+		}
+		proxies.prefixes = append(proxies.prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return proxies, nil
+}
+
+// Trusts reports whether addr belongs to a trusted proxy.
+func (p *TrustedProxies) Trusts(addr netip.Addr) bool {
+	if p == nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client that sent r. Forwarding headers
+// are only believed when the request came from a trusted proxy, and are read
+// right to left so that a client cannot spoof its address by sending its own
+// header: the first hop that is not a trusted proxy is the client. The
+// Forwarded header is preferred over X-Forwarded-For.
+func (p *TrustedProxies) ClientIP(r *http.Request) string {
+	remote := remoteAddr(r)
+	addr, err := netip.ParseAddr(remote)
+	if err != nil || !p.Trusts(addr) {
+		return remote
+	}
+
+	hops := forwardedFor(r.Header.Values("Forwarded"))
+	if len(hops) == 0 {
+		hops = xForwardedFor(r.Header.Values("X-Forwarded-For"))
+	}
+
+	client := addr
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			// Obfuscated or malformed, the last proxy is as close as we get
+			break
+		}
+		client = hop.Unmap()
+		if !p.Trusts(client) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// remoteAddr returns the host of r.RemoteAddr without the port.
+func remoteAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func xForwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for hop := range strings.SplitSeq(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// forwardedFor returns the for= parameters of RFC 7239 Forwarded headers.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for element := range strings.SplitSeq(value, ",") {
+			for pair := range strings.SplitSeq(element, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(key, "for") {
+					continue
+				}
+				hops = append(hops, forwardedNode(strings.Trim(val, `"`)))
+			}
+		}
+	}
+	return hops
+}
+
+// forwardedNode strips the port and IPv6 brackets from a Forwarded node such as
+// "[2001:db8::17]:4711" or "192.0.2.60:8080".
+func forwardedNode(node string) string {
+	if strings.HasPrefix(node, "[") {
+		if end := strings.Index(node, "]"); end > 0 {
+			return node[1:end]
+		}
+		return node
+	}
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return node
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", " 2001:db8::1 "})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name:   "direct client",
+			remote: "203.0.113.7:4711",
+			want:   "203.0.113.7",
+		},
+		{
+			name:    "untrusted remote cannot spoof",
+			remote:  "203.0.113.7:4711",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "trusted proxy",
+			remote:  "10.0.0.1:4711",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "spoofed hop before the client is ignored",
+			remote:  "10.0.0.1:4711",
+			headers: map[string]string{"X-Forwarded-For": "192.0.2.1, 198.51.100.1, 10.0.0.2"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "forwarded header preferred",
+			remote:  "10.0.0.1:4711",
+			headers: map[string]string{"Forwarded": `for=192.0.2.60;proto=https, for="[2001:db8::17]:4711"`, "X-Forwarded-For": "198.51.100.1"},
+			want:    "2001:db8::17",
+		},
+		{
+			name:    "trusted IPv6 proxy",
+			remote:  "[2001:db8::1]:4711",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "obfuscated hop",
+			remote:  "10.0.0.1:4711",
+			headers: map[string]string{"Forwarded": "for=_hidden"},
+			want:    "10.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/test", nil)
+			req.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			if got := proxies.ClientIP(req); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxiesInvalid(t *testing.T) {
+	if _, err := ParseTrustedProxies([]string{"not-a-network"}); err == nil {
+		t.Error("expected an error for an invalid proxy")
+	}
+}
@@ -0,0 +1,369 @@
+package middleware
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	EncodingZstd = "zstd"
+	EncodingGzip = "gzip"
+)
+
+// encoder is implemented by the gzip and zstd writers.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+var encoderPools = map[string]*sync.Pool{
+	EncodingZstd: {New: func() any {
+		// Browsers only accept windows of up to 8MB
+		e, _ := zstd.NewWriter(nil,
+			zstd.WithEncoderLevel(zstd.SpeedFastest),
+			zstd.WithEncoderConcurrency(1),
+			zstd.WithWindowSize(1<<20),
+		)
+		return e
+	}},
+	EncodingGzip: {New: func() any {
+		e, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return e
+	}},
+}
+
+// CompressConfig configures Compress.
+type CompressConfig struct {
+	// MinSize is the smallest body compressed, smaller ones gain too little to
+	// be worth it. Streams that flush are compressed regardless.
+	MinSize int
+	// Encodings are offered in order of preference, of EncodingZstd and
+	// EncodingGzip.
+	Encodings []string
+}
+
+// DefaultCompressConfig prefers zstd, which compresses faster than gzip at a
+// similar ratio.
+func DefaultCompressConfig() CompressConfig {
+	return CompressConfig{
+		MinSize:   1024,
+		Encodings: []string{EncodingZstd, EncodingGzip},
+	}
+}
+
+// Compress compresses responses with the encoding the client prefers from
+// Accept-Encoding. Bodies are buffered up to MinSize to decide, and responses
+// that are small, already encoded or of an already compressed type are sent as
+// they are. Flushing sends everything written so far, so streams such as SSE
+// can be compressed too.
+//
+// Responses marked Cache-Control: no-transform are never compressed. A strong
+// ETag of a compressed response has the encoding appended, as in "3-gzip", so
+// that each encoding has its own validator. The encoding is removed again from
+// the ETags in If-Match and If-None-Match, so handlers only see their own.
+//
+// When the writer comes from WrapWriter, such as in AccessLogger, the encoding
+// and uncompressed size are recorded on it.
+func Compress(config CompressConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			ifNoneMatch := r.Header.Get("If-None-Match")
+			r = stripEncodingETags(r, config.Encodings)
+
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), config.Encodings)
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: config.MinSize, ifNoneMatch: ifNoneMatch}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// encodingETag returns the ETag of a response compressed with encoding. Weak
+// ETags are kept, the encodings of a response are semantically equivalent.
+func encodingETag(etag, encoding string) string {
+	if strings.HasPrefix(etag, "W/") || len(etag) < 2 || !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+	return etag[:len(etag)-1] + "-" + encoding + `"`
+}
+
+// stripEncodingETags returns r with the encodings Compress appends removed
+// from the ETags in its preconditions.
+func stripEncodingETags(r *http.Request, encodings []string) *http.Request {
+	var header http.Header
+	for _, name := range []string{"If-Match", "If-None-Match"} {
+		value := r.Header.Get(name)
+		if value == "" {
+			continue
+		}
+		tags := strings.Split(value, ",")
+		changed := false
+		for i, tag := range tags {
+			tag = strings.TrimSpace(tag)
+			tags[i] = tag
+			for _, encoding := range encodings {
+				if stripped, ok := strings.CutSuffix(tag, "-"+encoding+`"`); ok && !strings.HasPrefix(tag, "W/") {
+					tags[i], changed = stripped+`"`, true
+					break
+				}
+			}
+		}
+		if !changed {
+			continue
+		}
+		if header == nil {
+			header = r.Header.Clone()
+		}
+		header.Set(name, strings.Join(tags, ", "))
+	}
+	if header == nil {
+		return r
+	}
+	r = r.WithContext(r.Context())
+	r.Header = header
+	return r
+}
+
+// noTransform reports whether a Cache-Control header forbids changing the
+// response's content coding.
+func noTransform(cacheControl string) bool {
+	for directive := range strings.SplitSeq(cacheControl, ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "no-transform") {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding returns the supported encoding with the highest quality in
+// an Accept-Encoding header, preferring earlier supported encodings on ties.
+func negotiateEncoding(header string, supported []string) string {
+	if header == "" {
+		return ""
+	}
+
+	qualities := make(map[string]float64)
+	for part := range strings.SplitSeq(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		qualities[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range supported {
+		q, ok := qualities[encoding]
+		if !ok {
+			q, ok = qualities["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// compressible reports whether a response of the content type is worth
+// compressing.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// Left for net/http to sniff, which is usually text
+		return contentType == ""
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"),
+		slices.Contains([]string{
+			"application/json",
+			"application/javascript",
+			"application/xml",
+			"image/svg+xml",
+		}, mediaType):
+		return true
+	}
+	return false
+}
+
+// compressWriter holds back the response until it knows whether to compress
+// it: once MinSize bytes are written, the handler flushes or the handler
+// returns.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+	// ifNoneMatch is the request's If-None-Match before encodings were removed
+	ifNoneMatch string
+
+	status  int
+	buf     []byte
+	decided bool
+	encoder encoder
+	// decodedBytes counts what the handler wrote, before compression
+	decodedBytes int
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided || cw.status != 0 {
+		return
+	}
+	// Informational responses are sent straight away
+	if status >= 100 && status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.decodedBytes += len(b)
+
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < cw.minSize {
+			return len(b), nil
+		}
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if err := cw.decide(true); err != nil {
+			return
+		}
+	}
+	if cw.encoder != nil {
+		if err := cw.encoder.Flush(); err != nil {
+			return
+		}
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// decide sends the header, compressing the response if it is worth it, and
+// writes out what was buffered.
+func (cw *compressWriter) decide(bigEnough bool) error {
+	cw.decided = true
+	h := cw.ResponseWriter.Header()
+
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	if cw.status == http.StatusNotModified {
+		// Tagged as the client has it, if that is the compressed response
+		if etag := encodingETag(h.Get("ETag"), cw.encoding); etag != h.Get("ETag") &&
+			slices.ContainsFunc(strings.Split(cw.ifNoneMatch, ","), func(tag string) bool {
+				return strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag
+			}) {
+			h.Set("ETag", etag)
+		}
+	}
+	if bigEnough &&
+		h.Get("Content-Encoding") == "" &&
+		h.Get("Content-Range") == "" &&
+		cw.status != http.StatusNoContent &&
+		cw.status != http.StatusNotModified &&
+		!noTransform(h.Get("Cache-Control")) &&
+		compressible(h.Get("Content-Type")) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+		if etag := h.Get("ETag"); etag != "" {
+			h.Set("ETag", encodingETag(etag, cw.encoding))
+		}
+
+		cw.encoder = encoderPools[cw.encoding].Get().(encoder)
+		cw.encoder.Reset(cw.ResponseWriter)
+	}
+
+	if cw.status != 0 {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	buf := cw.buf
+	cw.buf = nil
+	if cw.encoder != nil {
+		_, err := cw.encoder.Write(buf)
+		return err
+	}
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+// close finishes the response once the handler returns.
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		cw.decide(false)
+	}
+	if cw.encoder == nil {
+		return
+	}
+	cw.encoder.Close()
+	cw.encoder.Reset(io.Discard)
+	encoderPools[cw.encoding].Put(cw.encoder)
+
+	recordEncoding(cw.ResponseWriter, cw.encoding, cw.decodedBytes)
+}
+
+// encodingRecorder is implemented by the writers WrapWriter returns.
+type encodingRecorder interface {
+	recordEncoding(encoding string, decodedBytes int)
+}
+
+// recordEncoding records the encoding on the first writer in w's chain that
+// records it.
+func recordEncoding(w http.ResponseWriter, encoding string, decodedBytes int) {
+	for {
+		if recorder, ok := w.(encodingRecorder); ok {
+			recorder.recordEncoding(encoding, decodedBytes)
+			return
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return
+		}
+		w = unwrapper.Unwrap()
+	}
+}
@@ -0,0 +1,229 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	supported := []string{EncodingZstd, EncodingGzip}
+	tests := map[string]string{
+		"":                         "",
+		"gzip":                     EncodingGzip,
+		"gzip, deflate, br, zstd":  EncodingZstd,
+		"zstd;q=0.5, gzip":         EncodingGzip,
+		"zstd;q=0, gzip;q=0":       "",
+		"*":                        EncodingZstd,
+		"gzip;q=0.8, *;q=0.1":      EncodingGzip,
+		"identity":                 "",
+		"GZIP;q=1.0, deflate;q=.5": EncodingGzip,
+	}
+	for header, want := range tests {
+		if got := negotiateEncoding(header, supported); got != want {
+			t.Errorf("%q: expected %q, got %q", header, want, got)
+		}
+	}
+}
+
+func serveCompressed(handler http.Handler, acceptEncoding string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/todos", nil)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	rr := httptest.NewRecorder()
+	Compress(DefaultCompressConfig())(handler).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCompress(t *testing.T) {
+	body := strings.Repeat(`{"title":"buy milk"}`, 200)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", "4000")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, body)
+	})
+
+	rr := serveCompressed(handler, "gzip")
+	if rr.Code != http.StatusCreated || rr.Header().Get("Content-Encoding") != EncodingGzip {
+		t.Fatalf("expected a gzip response, got %d %q", rr.Code, rr.Header().Get("Content-Encoding"))
+	}
+	if rr.Header().Get("Content-Length") != "" || rr.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("unexpected headers %v", rr.Header())
+	}
+	gr, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, _ := io.ReadAll(gr); string(decoded) != body {
+		t.Error("expected the body to round trip")
+	}
+
+	rr = serveCompressed(handler, "zstd")
+	zr, err := zstd.NewReader(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, _ := io.ReadAll(zr); string(decoded) != body {
+		t.Error("expected the body to round trip")
+	}
+}
+
+func TestCompressSkips(t *testing.T) {
+	tests := map[string]http.HandlerFunc{
+		"small": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"ok":true}`)
+		},
+		"already compressed type": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(make([]byte, 4096))
+		},
+		"already encoded": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "br")
+			w.Write(make([]byte, 4096))
+		},
+		"no content": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		},
+		"no-transform": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Cache-Control", "private, no-transform")
+			w.Write(make([]byte, 4096))
+		},
+	}
+	for name, handler := range tests {
+		t.Run(name, func(t *testing.T) {
+			rr := serveCompressed(handler, "gzip")
+			if encoding := rr.Header().Get("Content-Encoding"); encoding == EncodingGzip {
+				t.Errorf("expected the response to be sent as written, got %q", encoding)
+			}
+		})
+	}
+}
+
+func TestCompressETags(t *testing.T) {
+	body := strings.Repeat(`{"title":"buy milk"}`, 200)
+	var ifMatch, ifNoneMatch string
+	handler := Compress(DefaultCompressConfig())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifMatch, ifNoneMatch = r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+		w.Header().Set("ETag", `"3"`)
+		if ifNoneMatch == `"3"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}))
+	serve := func(acceptEncoding, header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/todos/1", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if etag := serve("gzip", "", "").Header().Get("ETag"); etag != `"3-gzip"` {
+		t.Errorf("expected the encoding in the ETag, got %q", etag)
+	}
+	if etag := serve("", "", "").Header().Get("ETag"); etag != `"3"` {
+		t.Errorf("expected the ETag unchanged, got %q", etag)
+	}
+
+	serve("gzip", "If-Match", `"3-gzip", "4"`)
+	if ifMatch != `"3", "4"` {
+		t.Errorf("expected the encoding removed from If-Match, got %q", ifMatch)
+	}
+
+	rr := serve("gzip", "If-None-Match", `"3-gzip"`)
+	if rr.Code != http.StatusNotModified || rr.Header().Get("ETag") != `"3-gzip"` {
+		t.Errorf("expected 304 with the client's ETag, got %d %q", rr.Code, rr.Header().Get("ETag"))
+	}
+	rr = serve("gzip", "If-None-Match", `"3"`)
+	if rr.Code != http.StatusNotModified || rr.Header().Get("ETag") != `"3"` {
+		t.Errorf("expected 304 with the uncompressed ETag, got %d %q", rr.Code, rr.Header().Get("ETag"))
+	}
+}
+
+func TestCompressStreams(t *testing.T) {
+	const event = "data: connected\n\n"
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, event)
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("expected flushing to be supported, got %v", err)
+		}
+
+		// The event is readable before the stream ends
+		gr, err := gzip.NewReader(bytes.NewReader(rr.Body.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := make([]byte, len(event))
+		if _, err := io.ReadFull(gr, decoded); err != nil || string(decoded) != event {
+			t.Errorf("unexpected flushed stream %q, %v", decoded, err)
+		}
+	})
+
+	req := httptest.NewRequest("GET", "/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	Compress(DefaultCompressConfig())(handler).ServeHTTP(rr, req)
+
+	if !rr.Flushed {
+		t.Error("expected the stream to be flushed")
+	}
+	gr, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, _ := io.ReadAll(gr); string(decoded) != event {
+		t.Errorf("unexpected stream %q", decoded)
+	}
+}
+
+func TestCompressAccessLog(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buffer, nil))
+	body := strings.Repeat("a", 4096)
+	handler := NewChain(
+		AccessLogger(logger),
+		Compress(DefaultCompressConfig()),
+	).Build(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, body)
+	}))
+
+	req := httptest.NewRequest("GET", "/todos", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var entry struct {
+		Status       int    `json:"status_code"`
+		Size         int    `json:"size_bytes"`
+		Encoding     string `json:"encoding"`
+		DecodedBytes int    `json:"decoded_bytes"`
+	}
+	if err := json.Unmarshal(buffer.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Status != http.StatusAccepted || entry.Encoding != EncodingGzip || entry.DecodedBytes != len(body) {
+		t.Errorf("unexpected access log %+v", entry)
+	}
+	if entry.Size != rr.Body.Len() || entry.Size >= len(body) {
+		t.Errorf("expected the compressed size to be logged, got %d", entry.Size)
+	}
+}
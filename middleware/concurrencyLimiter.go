@@ -0,0 +1,302 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/utilities"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	concurrencyLimit = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_concurrency_limit",
+		Help: "Current limit on in-flight requests, by group.",
+	}, []string{"group"})
+	concurrencyInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_concurrency_in_flight",
+		Help: "Requests in flight, by group.",
+	}, []string{"group"})
+	concurrencyQueued = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_concurrency_queued",
+		Help: "Requests waiting for an in-flight slot, by group.",
+	}, []string{"group"})
+	concurrencyShed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_concurrency_shed_total",
+		Help: "Requests rejected because too many were in flight, by group.",
+	}, []string{"group"})
+)
+
+// ConcurrencyGroup caps in-flight requests to a group of routes.
+type ConcurrencyGroup struct {
+	Name string
+	// Methods and Paths select the requests in the group, as for
+	// RateLimitPolicy.
+	Methods []string
+	Paths   []string
+	Limit   int
+	// Adaptive adjusts the limit to the latency of the group's requests.
+	Adaptive bool
+	// SkipGlobal leaves the group's requests out of the global limit, for
+	// long-lived requests such as SSE streams that would otherwise hold global
+	// slots for as long as they are connected.
+	SkipGlobal bool
+}
+
+func (g ConcurrencyGroup) matches(r *http.Request) bool {
+	return (len(g.Methods) == 0 || slices.Contains(g.Methods, r.Method)) &&
+		(len(g.Paths) == 0 || matchPath(g.Paths, r.URL.Path))
+}
+
+// ConcurrencyConfig configures ConcurrencyLimiter.
+type ConcurrencyConfig struct {
+	// Limit caps in-flight requests across all routes, zero disables it.
+	Limit int
+	// Adaptive adjusts the global limit to observed latency.
+	Adaptive bool
+	Groups   []ConcurrencyGroup
+	// MaxQueue requests may wait up to QueueTimeout for a slot before they are
+	// shed.
+	MaxQueue     int
+	QueueTimeout time.Duration
+	// LatencyTarget is the latency above which an adaptive limit backs off.
+	LatencyTarget time.Duration
+	// MinLimit is the lowest an adaptive limit backs off to.
+	MinLimit int
+	// ExemptPaths are never limited, so that health checks still answer
+	// under load.
+	ExemptPaths []string
+}
+
+// DefaultConcurrencyConfig returns the configuration read from the environment.
+// SSE streams are limited on their own, writes share a smaller part of the
+// global limit.
+func DefaultConcurrencyConfig() ConcurrencyConfig {
+	limit, err := strconv.Atoi(utilities.GetEnvOrDefault("CONCURRENCY_LIMIT", "200"))
+	if err != nil || limit < 0 {
+		limit = 200
+	}
+	latencyTarget, err := time.ParseDuration(utilities.GetEnvOrDefault("CONCURRENCY_LATENCY_TARGET", "500ms"))
+	if err != nil {
+		latencyTarget = 500 * time.Millisecond
+	}
+	adaptive := utilities.GetEnvOrDefault("CONCURRENCY_ADAPTIVE", "false") == "true"
+
+	return ConcurrencyConfig{
+		Limit:    limit,
+		Adaptive: adaptive,
+		Groups: []ConcurrencyGroup{
+			{
+				Name:       "events",
+				Methods:    []string{http.MethodGet},
+				Paths:      []string{"/events"},
+				Limit:      1000,
+				SkipGlobal: true,
+			},
+			{
+				Name:     "writes",
+				Methods:  []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+				Limit:    max(limit/4, 1),
+				Adaptive: adaptive,
+			},
+		},
+		MaxQueue:      100,
+		QueueTimeout:  time.Second,
+		LatencyTarget: latencyTarget,
+		MinLimit:      10,
+		ExemptPaths:   []string{"/health"},
+	}
+}
+
+// ConcurrencyLimiter caps the requests in flight globally and per group, queuing
+// a few for a short while and shedding the rest with 503 so that a slow
+// dependency such as the database cannot pile up unbounded work. Adaptive
+// limits increase additively while requests stay under the latency target and
+// back off multiplicatively when they do not.
+func ConcurrencyLimiter(logger *slog.Logger, config ConcurrencyConfig) func(http.Handler) http.Handler {
+	var global *concurrencyLimiter
+	if config.Limit > 0 {
+		global = newConcurrencyLimiter("global", config.Limit, config.Adaptive, config)
+	}
+	groups := make([]*concurrencyLimiter, len(config.Groups))
+	for i, group := range config.Groups {
+		groups[i] = newConcurrencyLimiter(group.Name, group.Limit, group.Adaptive, config)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if matchPath(config.ExemptPaths, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			limiters := make([]*concurrencyLimiter, 0, 2)
+			skipGlobal := false
+			for i, group := range config.Groups {
+				if group.matches(r) {
+					limiters = append(limiters, groups[i])
+					skipGlobal = group.SkipGlobal
+					break
+				}
+			}
+			if global != nil && !skipGlobal {
+				limiters = append(limiters, global)
+			}
+
+			// Acquire the group first, so a request waiting on its group holds no
+			// global slot
+			for i, limiter := range limiters {
+				if !limiter.acquire(r) {
+					for _, acquired := range limiters[:i] {
+						acquired.release(0)
+					}
+					if r.Context().Err() != nil {
+						return
+					}
+					logger.WarnContext(r.Context(), "shedding request", "group", limiter.name, "path", r.URL.Path)
+					w.Header().Set("Retry-After", "1")
+					http.Error(w, "server overloaded\n", http.StatusServiceUnavailable)
+					return
+				}
+			}
+
+			start := time.Now()
+			defer func() {
+				latency := time.Since(start)
+				for _, limiter := range limiters {
+					limiter.release(latency)
+				}
+			}()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+type concurrencyLimiter struct {
+	name          string
+	adaptive      bool
+	minLimit      float64
+	maxLimit      float64
+	maxQueue      int
+	queueTimeout  time.Duration
+	latencyTarget time.Duration
+
+	mu          sync.Mutex
+	limit       float64
+	inFlight    int
+	waiters     []*waiter
+	lastBackoff time.Time
+}
+
+func newConcurrencyLimiter(name string, limit int, adaptive bool, config ConcurrencyConfig) *concurrencyLimiter {
+	l := &concurrencyLimiter{
+		name:          name,
+		adaptive:      adaptive,
+		minLimit:      float64(max(min(config.MinLimit, limit), 1)),
+		maxLimit:      float64(limit),
+		maxQueue:      config.MaxQueue,
+		queueTimeout:  config.QueueTimeout,
+		latencyTarget: config.LatencyTarget,
+		limit:         float64(limit),
+	}
+	concurrencyLimit.WithLabelValues(name).Set(l.limit)
+	return l
+}
+
+// acquire takes an in-flight slot, waiting in the queue if there is room.
+func (l *concurrencyLimiter) acquire(r *http.Request) bool {
+	l.mu.Lock()
+	if l.inFlight < int(l.limit) && len(l.waiters) == 0 {
+		l.inFlight++
+		l.updateGauges()
+		l.mu.Unlock()
+		return true
+	}
+	if len(l.waiters) >= l.maxQueue || l.queueTimeout <= 0 {
+		l.mu.Unlock()
+		concurrencyShed.WithLabelValues(l.name).Inc()
+		return false
+	}
+	w := &waiter{ready: make(chan struct{})}
+	l.waiters = append(l.waiters, w)
+	l.updateGauges()
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+		return true
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Granted a slot while timing out
+	if w.granted {
+		return true
+	}
+	l.waiters = slices.DeleteFunc(l.waiters, func(other *waiter) bool { return other == w })
+	l.updateGauges()
+	concurrencyShed.WithLabelValues(l.name).Inc()
+	return false
+}
+
+// release frees a slot held for latency, or for a request that never ran when
+// zero, and hands it to the next waiter.
+func (l *concurrencyLimiter) release(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	if l.adaptive && latency > 0 {
+		l.adapt(latency)
+	}
+	for len(l.waiters) > 0 && l.inFlight < int(l.limit) {
+		w := l.waiters[0]
+		l.waiters = l.waiters[1:]
+		w.granted = true
+		l.inFlight++
+		close(w.ready)
+	}
+	l.updateGauges()
+}
+
+// adapt grows the limit by about one per limit requests while latency stays
+// under target and the limit is in use, and cuts it by a tenth when it does
+// not. Backing off at most once per target interval keeps a burst of slow
+// requests completing together from collapsing the limit.
+func (l *concurrencyLimiter) adapt(latency time.Duration) {
+	now := time.Now()
+	switch {
+	case latency > l.latencyTarget:
+		if now.Sub(l.lastBackoff) < l.latencyTarget {
+			return
+		}
+		l.lastBackoff = now
+		l.limit = max(l.minLimit, l.limit*0.9)
+	case float64(l.inFlight+1) >= l.limit/2:
+		l.limit = min(l.maxLimit, l.limit+1/l.limit)
+	default:
+		return
+	}
+	concurrencyLimit.WithLabelValues(l.name).Set(l.limit)
+}
+
+// updateGauges must be called with l.mu held.
+func (l *concurrencyLimiter) updateGauges() {
+	concurrencyInFlight.WithLabelValues(l.name).Set(float64(l.inFlight))
+	concurrencyQueued.WithLabelValues(l.name).Set(float64(len(l.waiters)))
+}
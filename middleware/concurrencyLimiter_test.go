@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingHandler holds requests in flight until release is closed.
+func blockingHandler(started chan<- struct{}, release <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func TestConcurrencyLimiterSheds(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	handler := ConcurrencyLimiter(logger, ConcurrencyConfig{
+		Limit:       2,
+		ExemptPaths: []string{"/health"},
+	})(blockingHandler(started, release))
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Go(func() {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/todos", nil))
+		})
+	}
+	<-started
+	<-started
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/todos", nil))
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("expected 503 with Retry-After, got %d", rr.Code)
+	}
+
+	// Health checks are never shed
+	close(release)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/health", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rr.Code)
+	}
+	wg.Wait()
+}
+
+func TestConcurrencyLimiterQueues(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	handler := ConcurrencyLimiter(logger, ConcurrencyConfig{
+		Limit:        1,
+		MaxQueue:     1,
+		QueueTimeout: time.Second,
+	})(blockingHandler(started, release))
+
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/todos", nil))
+	})
+	<-started
+
+	queued := httptest.NewRecorder()
+	wg.Go(func() {
+		handler.ServeHTTP(queued, httptest.NewRequest("GET", "/todos", nil))
+	})
+	time.Sleep(20 * time.Millisecond)
+
+	// The queue is full
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/todos", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 with a full queue, got %d", rr.Code)
+	}
+
+	close(release)
+	wg.Wait()
+	if queued.Code != http.StatusOK {
+		t.Errorf("expected the queued request to run, got %d", queued.Code)
+	}
+}
+
+func TestConcurrencyLimiterGroups(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	handler := ConcurrencyLimiter(logger, ConcurrencyConfig{
+		Limit: 1,
+		Groups: []ConcurrencyGroup{
+			{Name: "events", Paths: []string{"/events"}, Limit: 2, SkipGlobal: true},
+			{Name: "writes", Methods: []string{"POST"}, Limit: 1},
+		},
+	})(blockingHandler(started, release))
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Go(func() {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/events", nil))
+		})
+		<-started
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/events", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected the events group to be full, got %d", rr.Code)
+	}
+
+	// Streams do not hold global slots
+	wg.Go(func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/todos", nil))
+	})
+	<-started
+
+	// The write holds the only global slot
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/todos", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected the global limit to be full, got %d", rr.Code)
+	}
+
+	close(release)
+	wg.Wait()
+}
+
+func TestConcurrencyLimiterAdapts(t *testing.T) {
+	l := newConcurrencyLimiter("test", 100, true, ConcurrencyConfig{
+		LatencyTarget: 10 * time.Millisecond,
+		MinLimit:      10,
+	})
+	r := httptest.NewRequest("GET", "/todos", nil)
+
+	l.acquire(r)
+	l.release(50 * time.Millisecond)
+	if l.limit != 90 {
+		t.Errorf("expected a slow request to back off the limit, got %v", l.limit)
+	}
+
+	// Backs off at most once per target interval
+	l.acquire(r)
+	l.release(50 * time.Millisecond)
+	if l.limit != 90 {
+		t.Errorf("expected a single back off, got %v", l.limit)
+	}
+
+	for range 60 {
+		l.acquire(r)
+	}
+	for range 60 {
+		l.release(time.Millisecond)
+	}
+	if l.limit <= 90 || l.limit > 100 {
+		t.Errorf("expected fast requests to grow the limit up to its maximum, got %v", l.limit)
+	}
+}
@@ -0,0 +1,316 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/session"
+	"github.com/doug-benn/go-server-starter/utilities"
+)
+
+// CORSPolicy allows cross-origin requests from a set of origins.
+type CORSPolicy struct {
+	Name string
+	// Paths select the requests the policy applies to, as for RateLimitPolicy.
+	// Empty matches every path.
+	Paths []string
+	// Origins are exact origins such as "https://app.example.com", wildcard
+	// subdomains such as "https://*.example.com", or "*" for any origin.
+	Origins []string
+	// OriginPatterns are regular expressions an origin must match in full.
+	OriginPatterns []string
+	Methods        []string
+	// Headers may be sent by the client, "*" allows any.
+	Headers []string
+	// ExposedHeaders may be read by the client beyond the safelisted ones.
+	ExposedHeaders []string
+	// AllowCredentials lets the client send cookies and read the response.
+	// It cannot be combined with the "*" origin.
+	AllowCredentials bool
+	// MaxAge is how long the client may cache a preflight response.
+	MaxAge time.Duration
+}
+
+// CORSConfig configures CORS.
+type CORSConfig struct {
+	// Policies are checked in order, the first whose paths and origins match
+	// the request applies.
+	Policies []CORSPolicy
+	// Mux, when set, answers preflights only for methods it has a route for,
+	// leaving it to respond 404 or 405 to the others. Only routes registered
+	// for a method count, a catch-all such as "/" matches every request.
+	Mux *http.ServeMux
+}
+
+// DefaultCORSConfig returns the configuration read from the environment. No
+// origin is allowed unless CORS_ALLOWED_ORIGINS or CORS_ALLOWED_ORIGIN_PATTERNS
+// is set. The allowed origins may call the API with their session and open the
+// SSE stream with an EventSource created withCredentials.
+func DefaultCORSConfig() CORSConfig {
+	origins := splitList(utilities.GetEnvOrDefault("CORS_ALLOWED_ORIGINS", ""))
+	patterns := splitList(utilities.GetEnvOrDefault("CORS_ALLOWED_ORIGIN_PATTERNS", ""))
+	if len(origins) == 0 && len(patterns) == 0 {
+		return CORSConfig{}
+	}
+	maxAge, err := time.ParseDuration(utilities.GetEnvOrDefault("CORS_MAX_AGE", "10m"))
+	if err != nil {
+		maxAge = 10 * time.Minute
+	}
+	credentials := utilities.GetEnvOrDefault("CORS_ALLOW_CREDENTIALS", "true") != "false"
+
+	return CORSConfig{
+		Policies: []CORSPolicy{
+			{
+				Name:             "events",
+				Paths:            []string{"/events"},
+				Origins:          origins,
+				OriginPatterns:   patterns,
+				Methods:          []string{http.MethodGet},
+				Headers:          []string{"Authorization", "Last-Event-ID"},
+				AllowCredentials: credentials,
+				MaxAge:           maxAge,
+			},
+			{
+				Name:           "api",
+				Origins:        origins,
+				OriginPatterns: patterns,
+				Methods:        []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+				Headers: []string{
+					"Authorization", "Content-Type", APIKeyHeader, session.CSRFHeader,
+					IdempotencyKeyHeader, "If-Match", "If-None-Match",
+				},
+				ExposedHeaders: []string{
+					"Location", "ETag", "Retry-After", "RateLimit", "RateLimit-Policy",
+					"X-RateLimit-Limit", "X-RateLimit-Policy", IdempotentReplayedHeader,
+				},
+				AllowCredentials: credentials,
+				MaxAge:           maxAge,
+			},
+		},
+	}
+}
+
+// splitList splits a comma separated list, dropping empty entries.
+func splitList(s string) []string {
+	var list []string
+	for item := range strings.SplitSeq(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// corsPolicy is a CORSPolicy ready to match requests.
+type corsPolicy struct {
+	CORSPolicy
+	anyOrigin bool
+	exact     []string
+	wildcards [][2]string
+	patterns  []*regexp.Regexp
+	anyHeader bool
+	headers   []string
+	methods   string
+	exposed   string
+	maxAge    string
+}
+
+func newCORSPolicy(p CORSPolicy) (*corsPolicy, error) {
+	policy := &corsPolicy{
+		CORSPolicy: p,
+		methods:    strings.Join(p.Methods, ", "),
+		exposed:    strings.Join(p.ExposedHeaders, ", "),
+	}
+	if p.MaxAge > 0 {
+		policy.maxAge = strconv.Itoa(int(p.MaxAge.Seconds()))
+	}
+
+	for _, origin := range p.Origins {
+		origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+		switch {
+		case origin == "*":
+			if p.AllowCredentials {
+				return nil, fmt.Errorf("cors policy %q: the * origin cannot allow credentials", p.Name)
+			}
+			policy.anyOrigin = true
+		case strings.Contains(origin, "*"):
+			scheme, host, ok := strings.Cut(origin, "://*.")
+			if !ok || strings.Contains(host, "*") || host == "" {
+				return nil, fmt.Errorf("cors policy %q: invalid wildcard origin %q", p.Name, origin)
+			}
+			policy.wildcards = append(policy.wildcards, [2]string{scheme + "://", "." + host})
+		default:
+			policy.exact = append(policy.exact, origin)
+		}
+	}
+	for _, pattern := range p.OriginPatterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("cors policy %q: invalid origin pattern: %w", p.Name, err)
+		}
+		policy.patterns = append(policy.patterns, re)
+	}
+	for _, header := range p.Headers {
+		if header == "*" {
+			policy.anyHeader = true
+		}
+		policy.headers = append(policy.headers, strings.ToLower(header))
+	}
+	return policy, nil
+}
+
+func (p *corsPolicy) allowsOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if slices.Contains(p.exact, origin) {
+		return true
+	}
+	for _, wildcard := range p.wildcards {
+		prefix, suffix := wildcard[0], wildcard[1]
+		if len(origin) > len(prefix)+len(suffix) &&
+			strings.HasPrefix(origin, prefix) &&
+			strings.HasSuffix(origin, suffix) &&
+			!strings.ContainsAny(origin[len(prefix):len(origin)-len(suffix)], ":/") {
+			return true
+		}
+	}
+	for _, re := range p.patterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *corsPolicy) allowsMethod(method string) bool {
+	// HEAD is answered by GET routes
+	return slices.Contains(p.Methods, method) || (method == http.MethodHead && slices.Contains(p.Methods, http.MethodGet))
+}
+
+// allowsHeaders reports whether every header in a comma separated
+// Access-Control-Request-Headers value may be sent.
+func (p *corsPolicy) allowsHeaders(requested string) bool {
+	if p.anyHeader {
+		return true
+	}
+	for _, header := range splitList(requested) {
+		if !slices.Contains(p.headers, strings.ToLower(header)) {
+			return false
+		}
+	}
+	return true
+}
+
+// setOrigin sets the headers every response to an allowed origin carries.
+func (p *corsPolicy) setOrigin(h http.Header, origin string) {
+	if p.anyOrigin {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// methodPattern reports whether a ServeMux pattern is restricted to a method.
+func methodPattern(pattern string) bool {
+	method, _, ok := strings.Cut(pattern, " ")
+	return ok && method != "" && !strings.Contains(method, "/")
+}
+
+// CORS allows cross-origin requests from the origins in the configured
+// policies. Preflight requests are answered here, before authentication, since
+// browsers send them without credentials and ServeMux would otherwise answer
+// 405 to OPTIONS on routes registered for a method. Other requests get the CORS
+// headers before being passed on, so that errors such as 401 or 429 are
+// readable by the client too. Requests from origins no policy allows are
+// passed on without CORS headers, leaving the browser to block them.
+func CORS(logger *slog.Logger, config CORSConfig) (func(http.Handler) http.Handler, error) {
+	policies := make([]*corsPolicy, len(config.Policies))
+	for i, p := range config.Policies {
+		policy, err := newCORSPolicy(p)
+		if err != nil {
+			return nil, err
+		}
+		policies[i] = policy
+	}
+
+	policyFor := func(r *http.Request, origin string) *corsPolicy {
+		for _, policy := range policies {
+			if (len(policy.Paths) == 0 || matchPath(policy.Paths, r.URL.Path)) && policy.allowsOrigin(origin) {
+				return policy
+			}
+		}
+		return nil
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" || len(policies) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			requestMethod := r.Header.Get("Access-Control-Request-Method")
+			if r.Method != http.MethodOptions || requestMethod == "" {
+				w.Header().Add("Vary", "Origin")
+				if policy := policyFor(r, origin); policy != nil {
+					policy.setOrigin(w.Header(), origin)
+					if policy.exposed != "" {
+						w.Header().Set("Access-Control-Expose-Headers", policy.exposed)
+					}
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Preflight
+			if config.Mux != nil {
+				probe := r.Clone(r.Context())
+				probe.Method = requestMethod
+				if _, pattern := config.Mux.Handler(probe); !methodPattern(pattern) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+
+			requestHeaders := r.Header.Get("Access-Control-Request-Headers")
+			policy := policyFor(r, origin)
+			if policy == nil || !policy.allowsMethod(requestMethod) || !policy.allowsHeaders(requestHeaders) {
+				logger.DebugContext(r.Context(), "cors preflight rejected",
+					slog.String("origin", origin),
+					slog.String("path", r.URL.Path),
+					slog.String("method", requestMethod),
+					slog.String("headers", requestHeaders),
+				)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			policy.setOrigin(h, origin)
+			h.Set("Access-Control-Allow-Methods", policy.methods)
+			if requestHeaders != "" {
+				h.Set("Access-Control-Allow-Headers", requestHeaders)
+			}
+			if policy.maxAge != "" {
+				h.Set("Access-Control-Max-Age", policy.maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}, nil
+}
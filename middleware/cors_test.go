@@ -0,0 +1,174 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestCORS(t *testing.T, config CORSConfig) func(http.Handler) http.Handler {
+	t.Helper()
+	cors, err := CORS(slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cors
+}
+
+func TestCORSOrigins(t *testing.T) {
+	cors := newTestCORS(t, CORSConfig{Policies: []CORSPolicy{{
+		Name:             "api",
+		Origins:          []string{"https://app.example.com", "https://*.example.org"},
+		OriginPatterns:   []string{`https://pr-[0-9]+\.preview\.example\.net`},
+		Methods:          []string{http.MethodGet},
+		ExposedHeaders:   []string{"Retry-After"},
+		AllowCredentials: true,
+	}}})
+	handler := cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := map[string]bool{
+		"https://app.example.com":                    true,
+		"https://APP.example.com":                    true,
+		"http://app.example.com":                     false,
+		"https://evil.com":                           false,
+		"https://a.example.org":                      true,
+		"https://a.b.example.org":                    true,
+		"https://example.org":                        false,
+		"https://a.example.org.evil.com":             false,
+		"https://evil.com/.example.org":              false,
+		"https://pr-42.preview.example.net":          true,
+		"https://pr-42.preview.example.net.evil.com": false,
+	}
+	for origin, allowed := range tests {
+		req := httptest.NewRequest("GET", "/todos", nil)
+		req.Header.Set("Origin", origin)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); (got == origin) != allowed {
+			t.Errorf("%s: expected allowed %v, got %q", origin, allowed, got)
+		}
+		if allowed && (rr.Header().Get("Access-Control-Allow-Credentials") != "true" ||
+			rr.Header().Get("Access-Control-Expose-Headers") != "Retry-After") {
+			t.Errorf("%s: unexpected headers %v", origin, rr.Header())
+		}
+		if rr.Header().Get("Vary") != "Origin" {
+			t.Errorf("%s: expected to vary on origin, got %q", origin, rr.Header().Get("Vary"))
+		}
+	}
+}
+
+func TestCORSInvalidConfig(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	tests := map[string]CORSPolicy{
+		"credentials with any origin": {Origins: []string{"*"}, AllowCredentials: true},
+		"wildcard in the middle":      {Origins: []string{"https://app.*.example.com"}},
+		"invalid pattern":             {OriginPatterns: []string{"https://(app"}},
+	}
+	for name, policy := range tests {
+		if _, err := CORS(logger, CORSConfig{Policies: []CORSPolicy{policy}}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /todos", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("POST /todos", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("PUT /todos", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /events", func(w http.ResponseWriter, r *http.Request) {})
+	mux.Handle("/", http.NotFoundHandler())
+
+	origins := []string{"https://app.example.com"}
+	handler := newTestCORS(t, CORSConfig{
+		Policies: []CORSPolicy{
+			{Name: "events", Paths: []string{"/events"}, Origins: origins, Methods: []string{http.MethodGet}, AllowCredentials: true},
+			{
+				Name:             "api",
+				Origins:          origins,
+				Methods:          []string{http.MethodGet, http.MethodPost, http.MethodDelete},
+				Headers:          []string{"Content-Type", "X-CSRF-Token"},
+				AllowCredentials: true,
+				MaxAge:           10 * time.Minute,
+			},
+		},
+		Mux: mux,
+	})(mux)
+
+	tests := []struct {
+		name           string
+		path           string
+		method         string
+		headers        string
+		expectedStatus int
+		allowed        bool
+	}{
+		{"allowed", "/todos", "POST", "content-type, x-csrf-token", http.StatusNoContent, true},
+		{"header not allowed", "/todos", "POST", "X-Other", http.StatusNoContent, false},
+		{"method not allowed by policy", "/todos", "PUT", "", http.StatusNoContent, false},
+		{"method without a route", "/todos", "DELETE", "", http.StatusNotFound, false},
+		{"path without a route", "/other", "GET", "", http.StatusNotFound, false},
+		{"route with its own policy", "/events", "GET", "", http.StatusNoContent, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("OPTIONS", tt.path, nil)
+			req.Header.Set("Origin", "https://app.example.com")
+			req.Header.Set("Access-Control-Request-Method", tt.method)
+			if tt.headers != "" {
+				req.Header.Set("Access-Control-Request-Headers", tt.headers)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("expected %d, got %d", tt.expectedStatus, rr.Code)
+			}
+			if got := rr.Header().Get("Access-Control-Allow-Origin") != ""; got != tt.allowed {
+				t.Fatalf("expected allowed %v, got headers %v", tt.allowed, rr.Header())
+			}
+			if tt.allowed && tt.path == "/todos" {
+				if rr.Header().Get("Access-Control-Allow-Methods") != "GET, POST, DELETE" ||
+					rr.Header().Get("Access-Control-Allow-Headers") != tt.headers ||
+					rr.Header().Get("Access-Control-Max-Age") != "600" {
+					t.Errorf("unexpected headers %v", rr.Header())
+				}
+			}
+		})
+	}
+}
+
+func TestCORSEventSource(t *testing.T) {
+	// An EventSource created withCredentials sends a plain GET with cookies and
+	// needs the exact origin echoed back with credentials allowed
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://app.example.com")
+	handler := NewChain(
+		newTestCORS(t, DefaultCORSConfig()),
+		Timeout(slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)), TimeoutConfig{Default: time.Second}),
+		Compress(DefaultCompressConfig()),
+	).Build(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: connected\n\n")
+	}))
+
+	req := httptest.NewRequest("GET", "/events", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.AddCookie(&http.Cookie{Name: "session", Value: "token"})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		rr.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("unexpected headers %v", rr.Header())
+	}
+	if vary := strings.Join(rr.Header().Values("Vary"), ", "); vary != "Origin, Accept-Encoding" {
+		t.Errorf("expected to vary on origin and encoding, got %q", vary)
+	}
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+
+	"github.com/doug-benn/go-server-starter/session"
+)
+
+// CSRFConfig names the cookies and header the CSRF middleware compares.
+type CSRFConfig struct {
+	SessionCookie string
+	Cookie        string
+	Header        string
+}
+
+// DefaultCSRFConfig matches the cookies set by a session.Manager with the
+// default configuration.
+func DefaultCSRFConfig() CSRFConfig {
+	return CSRFConfig{
+		SessionCookie: session.DefaultCookieName,
+		Cookie:        session.DefaultCSRFCookieName,
+		Header:        session.CSRFHeader,
+	}
+}
+
+// CSRF protects cookie authenticated requests with the double-submit pattern.
+// Unsafe requests that carry the session cookie must echo the CSRF cookie in
+// the CSRF header, and the token must belong to the session. Safe methods and
+// requests that authenticate with a header, which browsers never add on their
+// own, are passed through.
+func CSRF(logger *slog.Logger, config CSRFConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if safeMethod(r.Method) || credentialFromRequest(r) != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			sessionCookie, err := r.Cookie(config.SessionCookie)
+			if err != nil || sessionCookie.Value == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			header := r.Header.Get(config.Header)
+			cookie, err := r.Cookie(config.Cookie)
+			if header == "" || err != nil ||
+				subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) != 1 ||
+				subtle.ConstantTimeCompare([]byte(header), []byte(session.CSRFToken(sessionCookie.Value))) != 1 {
+				logger.WarnContext(r.Context(), "csrf check failed",
+					slog.String("method", r.Method),
+					slog.String("path", r.URL.Path),
+					slog.String("remote_ip", r.RemoteAddr),
+				)
+				WriteProblem(w, http.StatusForbidden, "missing or invalid CSRF token")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/doug-benn/go-server-starter/session"
+)
+
+func TestCSRF(t *testing.T) {
+	sessionToken := session.TokenPrefix + "abc"
+	token := session.CSRFToken(sessionToken)
+	planted := session.CSRFToken(session.TokenPrefix + "attacker")
+
+	tests := []struct {
+		name           string
+		method         string
+		session        bool
+		cookie         string
+		header         string
+		authorization  string
+		expectedStatus int
+	}{
+		{"safe method", "GET", true, "", "", "", http.StatusOK},
+		{"no session cookie", "POST", false, "", "", "", http.StatusOK},
+		{"matching token", "POST", true, token, token, "", http.StatusOK},
+		{"missing header", "POST", true, token, "", "", http.StatusForbidden},
+		{"missing cookie", "DELETE", true, "", token, "", http.StatusForbidden},
+		{"header differs from cookie", "PUT", true, token, planted, "", http.StatusForbidden},
+		{"planted cookie for another session", "POST", true, planted, planted, "", http.StatusForbidden},
+		{"header authentication", "POST", true, "", "", "Bearer gss_key", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/todos", nil)
+			if tt.session {
+				req.AddCookie(&http.Cookie{Name: session.DefaultCookieName, Value: sessionToken})
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: session.DefaultCSRFCookieName, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				req.Header.Set(session.CSRFHeader, tt.header)
+			}
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rr := httptest.NewRecorder()
+			CSRF(slog.Default(), DefaultCSRFConfig())(okHandler()).ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/database"
+	"github.com/doug-benn/go-server-starter/idempotency"
+	"github.com/doug-benn/go-server-starter/utilities"
+)
+
+const (
+	// IdempotencyKeyHeader is the request header naming a retryable request.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on responses replayed for a retry.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+)
+
+// IdempotencyConfig configures Idempotency.
+type IdempotencyConfig struct {
+	Methods []string
+	// TTL is how long responses are kept for retries.
+	TTL time.Duration
+	// LockTimeout is how long a request holds its key before a retry may take
+	// over, in case the replica handling it stopped. It should be longer than
+	// the request timeout.
+	LockTimeout time.Duration
+	// Key scopes keys to the caller, so that one client cannot replay another's
+	// response. Requests it returns no key for are handled as usual.
+	Key KeyFunc
+}
+
+// DefaultIdempotencyConfig returns the configuration read from the environment.
+// Keys are scoped to the authenticated principal.
+func DefaultIdempotencyConfig() IdempotencyConfig {
+	ttl, err := time.ParseDuration(utilities.GetEnvOrDefault("IDEMPOTENCY_TTL", "24h"))
+	if err != nil {
+		ttl = 24 * time.Hour
+	}
+	return IdempotencyConfig{
+		Methods:     []string{http.MethodPost, http.MethodPatch, http.MethodDelete},
+		TTL:         ttl,
+		LockTimeout: time.Minute,
+		Key:         KeyByPrincipal(),
+	}
+}
+
+// Idempotency makes requests sent with an Idempotency-Key header safe to
+// retry. The first request with a key runs and its response is stored; retries
+// with the same key and body get the stored response back with the
+// Idempotent-Replayed header, a retry while the first request is still running
+// gets 409 and reusing a key for a different request gets 422. Server errors
+// are not stored, so that they can be retried.
+func Idempotency(logger *slog.Logger, store idempotency.Store, config IdempotencyConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" || !slices.Contains(config.Methods, r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			scope := config.Key(r)
+			if scope == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				WriteProblem(w, http.StatusBadRequest, "Idempotency-Key is too long")
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					WriteProblem(w, http.StatusRequestEntityTooLarge, "request body too large")
+					return
+				}
+				WriteProblem(w, http.StatusBadRequest, "failed to read request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			fingerprint := requestFingerprint(r, body)
+
+			// A key reused in another tenant is another request
+			tenant, _ := database.TenantFromContext(r.Context())
+			key = scope + "|" + tenant + "|" + key
+			claimed, record, err := store.Claim(r.Context(), key, fingerprint, config.LockTimeout, config.TTL)
+			if err != nil {
+				logger.ErrorContext(r.Context(), "failed to claim idempotency key", "error", err)
+				WriteProblem(w, http.StatusServiceUnavailable, "idempotency store unavailable")
+				return
+			}
+			if !claimed {
+				switch {
+				case record.Fingerprint != fingerprint:
+					WriteProblem(w, http.StatusUnprocessableEntity, "Idempotency-Key was used for a different request")
+				case record.Response == nil:
+					w.Header().Set("Retry-After", "1")
+					WriteProblem(w, http.StatusConflict, "a request with this Idempotency-Key is in progress")
+				default:
+					replay(w, record.Response)
+				}
+				return
+			}
+
+			// Only what the handler sets is stored, not headers such as the rate
+			// limits set for this attempt
+			before := w.Header().Clone()
+			iw := &idempotencyWriter{ResponseWriter: w}
+			completed := false
+			defer func() {
+				// Stored even if the client went away, it will retry
+				ctx := context.WithoutCancel(r.Context())
+				if !completed || iw.status >= http.StatusInternalServerError {
+					if err := store.Release(ctx, key); err != nil {
+						logger.ErrorContext(ctx, "failed to release idempotency key", "error", err)
+					}
+					return
+				}
+				response := idempotency.Response{StatusCode: iw.status, Header: storedHeaders(before, w.Header()), Body: iw.body.Bytes()}
+				if err := store.Complete(ctx, key, response); err != nil {
+					logger.ErrorContext(ctx, "failed to store idempotent response", "error", err)
+				}
+			}()
+
+			next.ServeHTTP(iw, r)
+			if iw.status == 0 {
+				iw.status = http.StatusOK
+			}
+			completed = true
+		})
+	}
+}
+
+// requestFingerprint identifies a request by its method, target and body.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// storedHeaders returns the headers set or changed since before, leaving out
+// cookies that must not be handed to a retry.
+func storedHeaders(before, after http.Header) http.Header {
+	stored := make(http.Header)
+	for name, values := range after {
+		if name == "Set-Cookie" || slices.Equal(before[name], values) {
+			continue
+		}
+		stored[name] = slices.Clone(values)
+	}
+	return stored
+}
+
+func replay(w http.ResponseWriter, response *idempotency.Response) {
+	h := w.Header()
+	for name, values := range response.Header {
+		h[name] = values
+	}
+	h.Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(response.StatusCode)
+	w.Write(response.Body)
+}
+
+// idempotencyWriter keeps a copy of the response as it is written.
+type idempotencyWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (iw *idempotencyWriter) WriteHeader(status int) {
+	if iw.status == 0 && status >= 200 {
+		iw.status = status
+	}
+	iw.ResponseWriter.WriteHeader(status)
+}
+
+func (iw *idempotencyWriter) Write(b []byte) (int, error) {
+	if iw.status == 0 {
+		iw.status = http.StatusOK
+	}
+	iw.body.Write(b)
+	return iw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (iw *idempotencyWriter) Unwrap() http.ResponseWriter {
+	return iw.ResponseWriter
+}
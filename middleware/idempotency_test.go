@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/database"
+	"github.com/doug-benn/go-server-starter/idempotency"
+)
+
+func idempotentRequest(principal, key, body string) *http.Request {
+	req := httptest.NewRequest("POST", "/todos", strings.NewReader(body))
+	req.Header.Set(IdempotencyKeyHeader, key)
+	if principal != "" {
+		req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{ID: principal}))
+	}
+	return req
+}
+
+func TestIdempotency(t *testing.T) {
+	var calls atomic.Int32
+	handler := Idempotency(slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)), idempotency.NewMemoryStore(), DefaultIdempotencyConfig())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := calls.Add(1)
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+			w.Header().Set("Location", "/todos/1")
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"id":%d}`, n)
+		}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, idempotentRequest("user:1", "k1", `{"title":"a"}`))
+	if rr.Code != http.StatusCreated || rr.Body.String() != `{"id":1}` {
+		t.Fatalf("unexpected first response %d %s", rr.Code, rr.Body)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, idempotentRequest("user:1", "k1", `{"title":"a"}`))
+	if rr.Code != http.StatusCreated || rr.Body.String() != `{"id":1}` ||
+		rr.Header().Get("Location") != "/todos/1" || rr.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Fatalf("expected the response to be replayed, got %d %v %s", rr.Code, rr.Header(), rr.Body)
+	}
+	if rr.Header().Get("Set-Cookie") != "" {
+		t.Error("expected cookies not to be replayed")
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, idempotentRequest("user:1", "k1", `{"title":"b"}`))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected a different body to be rejected, got %d", rr.Code)
+	}
+
+	// Keys are scoped to the principal, anonymous requests are not tracked
+	for _, principal := range []string{"user:2", "", ""} {
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, idempotentRequest(principal, "k1", `{"title":"a"}`))
+		if rr.Header().Get(IdempotentReplayedHeader) != "" {
+			t.Errorf("%q: expected the request to run", principal)
+		}
+	}
+	// and to the tenant
+	rr = httptest.NewRecorder()
+	req := idempotentRequest("user:1", "k1", `{"title":"a"}`)
+	handler.ServeHTTP(rr, req.WithContext(database.WithTenant(req.Context(), "globex")))
+	if rr.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Error("expected the request in another tenant to run")
+	}
+	if calls.Load() != 5 {
+		t.Errorf("expected 5 calls, got %d", calls.Load())
+	}
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := Idempotency(slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)), idempotency.NewMemoryStore(), DefaultIdempotencyConfig())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			w.WriteHeader(http.StatusCreated)
+		}))
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), idempotentRequest("user:1", "k1", "{}"))
+		close(done)
+	}()
+	<-started
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, idempotentRequest("user:1", "k1", "{}"))
+	if rr.Code != http.StatusConflict || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("expected 409 while the first request runs, got %d", rr.Code)
+	}
+	close(release)
+	<-done
+}
+
+func TestIdempotencyServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	store := idempotency.NewMemoryStore()
+	handler := Idempotency(slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)), store, DefaultIdempotencyConfig())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) == 1 {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		}))
+
+	for _, expected := range []int{http.StatusServiceUnavailable, http.StatusCreated} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, idempotentRequest("user:1", "k1", "{}"))
+		if rr.Code != expected {
+			t.Errorf("expected %d, got %d", expected, rr.Code)
+		}
+	}
+	if claimed, _, _ := store.Claim(context.Background(), "principal:user:1||k1", "", 0, 0); claimed {
+		t.Error("expected the successful response to be stored")
+	}
+}
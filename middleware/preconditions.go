@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/doug-benn/go-server-starter/utilities"
+)
+
+// PreconditionConfig configures RequireIfMatch.
+type PreconditionConfig struct {
+	// Required rejects the matching requests without an If-Match header.
+	Required bool
+	// Methods and Paths select the requests, as for RateLimitPolicy.
+	Methods []string
+	Paths   []string
+}
+
+// DefaultPreconditionConfig returns the configuration read from the
+// environment. If-Match is optional on todo updates and deletes unless
+// REQUIRE_IF_MATCH is set.
+func DefaultPreconditionConfig() PreconditionConfig {
+	return PreconditionConfig{
+		Required: utilities.GetEnvOrDefault("REQUIRE_IF_MATCH", "false") == "true",
+		Methods:  []string{http.MethodPut, http.MethodPatch, http.MethodDelete},
+		Paths:    []string{"/todos/"},
+	}
+}
+
+// RequireIfMatch answers 428 to the configured requests when they are sent
+// without an If-Match header, so that clients cannot overwrite changes they
+// have not seen. Handlers still check the header against the current ETag.
+func RequireIfMatch(config PreconditionConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !config.Required {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("If-Match") == "" &&
+				(len(config.Methods) == 0 || slices.Contains(config.Methods, r.Method)) &&
+				(len(config.Paths) == 0 || matchPath(config.Paths, r.URL.Path)) {
+				WriteProblem(w, http.StatusPreconditionRequired, "this request must be made conditional with If-Match")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
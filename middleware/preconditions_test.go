@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireIfMatch(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	t.Setenv("REQUIRE_IF_MATCH", "true")
+	handler := RequireIfMatch(DefaultPreconditionConfig())(ok)
+	tests := []struct {
+		method, path, ifMatch string
+		expectedStatus        int
+	}{
+		{"PUT", "/todos/1", "", http.StatusPreconditionRequired},
+		{"DELETE", "/todos/1", "", http.StatusPreconditionRequired},
+		{"PATCH", "/todos/1", `"3"`, http.StatusOK},
+		{"GET", "/todos/1", "", http.StatusOK},
+		{"POST", "/todos", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.ifMatch != "" {
+			req.Header.Set("If-Match", tt.ifMatch)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != tt.expectedStatus {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.expectedStatus, rr.Code)
+		}
+	}
+
+	t.Setenv("REQUIRE_IF_MATCH", "false")
+	rr := httptest.NewRecorder()
+	RequireIfMatch(DefaultPreconditionConfig())(ok).ServeHTTP(rr, httptest.NewRequest("PUT", "/todos/1", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected If-Match to be optional by default, got %d", rr.Code)
+	}
+}
@@ -1,70 +1,427 @@
 package middleware
 
 import (
-	"net"
+	"context"
+	"fmt"
+	"math"
 	"net/http"
+	"slices"
 	"strconv"
-	"sync"
+	"strings"
 	"time"
 
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/database"
+	"github.com/doug-benn/go-server-starter/ratelimit"
+	"github.com/doug-benn/go-server-starter/utilities"
 	"golang.org/x/time/rate"
 )
 
-type clientLimiter struct {
-	limiter  *rate.Limiter
-	lastSeen time.Time
-}
+// KeyFunc returns the bucket a request is counted against, or "" when it does
+// not apply to the request so that the next key can be tried.
+type KeyFunc func(r *http.Request) string
 
-func RateLimiter(r rate.Limit, burst int) func(http.Handler) http.Handler {
-	var mu sync.Mutex
-	clients := make(map[string]*clientLimiter)
+// KeyByIP keys on the client address, see TrustedProxies.ClientIP.
+func KeyByIP(proxies *TrustedProxies) KeyFunc {
+	return func(r *http.Request) string {
+		return "ip:" + proxies.ClientIP(r)
+	}
+}
 
-	getLimiter := func(key string) *rate.Limiter {
-		mu.Lock()
-		defer mu.Unlock()
+// KeyByPrincipal keys on any authenticated principal.
+func KeyByPrincipal() KeyFunc {
+	return func(r *http.Request) string {
+		if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+			return "principal:" + principal.ID
+		}
+		return ""
+	}
+}
 
-		now := time.Now()
+// KeyByAPIKey keys on the API key a request authenticated with.
+func KeyByAPIKey() KeyFunc {
+	return func(r *http.Request) string {
+		if principal, ok := auth.PrincipalFromContext(r.Context()); ok && principal.Kind == auth.KindAPIKey {
+			return "principal:" + principal.ID
+		}
+		return ""
+	}
+}
 
-		if cl, ok := clients[key]; ok {
-			cl.lastSeen = now
-			return cl.limiter
+// KeyByUser keys on the user a request authenticated as, with a token or a
+// session.
+func KeyByUser() KeyFunc {
+	return func(r *http.Request) string {
+		if principal, ok := auth.PrincipalFromContext(r.Context()); ok &&
+			(principal.Kind == auth.KindUser || principal.Kind == auth.KindLocal) {
+			return "principal:" + principal.ID
 		}
+		return ""
+	}
+}
 
-		limiter := rate.NewLimiter(r, burst)
-		clients[key] = &clientLimiter{limiter: limiter, lastSeen: now}
+// KeyByTenant shares one bucket between every caller in a tenant.
+func KeyByTenant() KeyFunc {
+	return func(r *http.Request) string {
+		if tenant, ok := database.TenantFromContext(r.Context()); ok {
+			return "tenant:" + tenant
+		}
+		return ""
+	}
+}
 
-		cleaned := 0
-		for k, cl := range clients {
-			if now.Sub(cl.lastSeen) > 5*time.Minute {
-				delete(clients, k)
-				cleaned++
-				if cleaned >= 10 {
-					break
-				}
+// FirstKey uses the first of keys that applies to a request.
+func FirstKey(keys ...KeyFunc) KeyFunc {
+	return func(r *http.Request) string {
+		for _, key := range keys {
+			if k := key(r); k != "" {
+				return k
 			}
 		}
+		return ""
+	}
+}
 
-		return limiter
+// RateLimitPolicy applies its own rate to the requests it matches, counted in
+// buckets separate from every other policy.
+type RateLimitPolicy struct {
+	// Name separates the policy's buckets and is reported in responses.
+	Name string
+	// Methods and Paths restrict the requests the policy applies to, empty
+	// matches any. Paths ending in "/" match everything below them.
+	Methods []string
+	Paths   []string
+	Limit   rate.Limit
+	Burst   int
+	// Key overrides the limiter's key function for this policy.
+	Key KeyFunc
+	// KeyBy names the key function to override it with in configuration, see
+	// RateLimitConfig.Options.
+	KeyBy string
+}
+
+func (p RateLimitPolicy) matches(r *http.Request) bool {
+	return (len(p.Methods) == 0 || slices.Contains(p.Methods, r.Method)) &&
+		(len(p.Paths) == 0 || matchPath(p.Paths, r.URL.Path))
+}
+
+type rateLimitConfig struct {
+	name     string
+	key      KeyFunc
+	proxies  *TrustedProxies
+	policies []RateLimitPolicy
+	maxWait  time.Duration
+	store    ratelimit.Store
+}
+
+type RateLimitOption func(*rateLimitConfig)
+
+// WithKeyFunc sets how requests are grouped into buckets. Requests the key
+// does not apply to are keyed by client address.
+func WithKeyFunc(key KeyFunc) RateLimitOption {
+	return func(c *rateLimitConfig) {
+		c.key = key
+	}
+}
+
+// WithName names the limiter's default policy, keeping its buckets apart from
+// those of other limiters sharing a store.
+func WithName(name string) RateLimitOption {
+	return func(c *rateLimitConfig) {
+		c.name = name
+	}
+}
+
+// WithTrustedProxies derives the client address from forwarding headers set
+// by the proxies, instead of counting everyone behind them as one client.
+func WithTrustedProxies(proxies *TrustedProxies) RateLimitOption {
+	return func(c *rateLimitConfig) {
+		c.proxies = proxies
+	}
+}
+
+// WithPolicies adds per route and method rates. The first matching policy
+// applies, requests matching none use the limiter's default rate.
+func WithPolicies(policies ...RateLimitPolicy) RateLimitOption {
+	return func(c *rateLimitConfig) {
+		c.policies = append(c.policies, policies...)
+	}
+}
+
+// WithMaxWait delays requests that would be allowed within d instead of
+// rejecting them, smoothing out clients that are only slightly too fast.
+func WithMaxWait(d time.Duration) RateLimitOption {
+	return func(c *rateLimitConfig) {
+		c.maxWait = d
+	}
+}
+
+// WithStore keeps buckets in store, such as one shared by every replica,
+// instead of in process.
+func WithStore(store ratelimit.Store) RateLimitOption {
+	return func(c *rateLimitConfig) {
+		c.store = store
+	}
+}
+
+// RateLimitConfig is the rate limiting configuration of the server.
+type RateLimitConfig struct {
+	Limit rate.Limit
+	Burst int
+	// TrustedProxies are CIDRs of proxies whose forwarding headers are believed.
+	TrustedProxies []string
+	Policies       []RateLimitPolicy
+	// MaxWait is how long a request may be queued before it is rejected.
+	MaxWait time.Duration
+	// IPLimit and IPBurst bound each client address before requests are
+	// authenticated, so that credentials cannot be guessed without limit.
+	IPLimit rate.Limit
+	IPBurst int
+	// KeyBy names the key function of requests no policy with its own key
+	// matches, empty for the limiter's default.
+	KeyBy string
+}
+
+// DefaultRateLimitConfig returns the configuration read from the environment.
+// Writes are limited more strictly than reads, login attempts are limited per
+// client address and SSE connection attempts are counted separately from API
+// requests. The rate and burst of each of these policies can be set with
+// RATE_LIMIT_LOGIN_RPS, RATE_LIMIT_EVENTS_RPS, RATE_LIMIT_WRITES_RPS and the
+// matching _BURST variables, and what they are counted by with the matching
+// _KEY variables or RATE_LIMIT_KEY for every request, see RateLimitConfig.Options.
+func DefaultRateLimitConfig() RateLimitConfig {
+	limit, err := strconv.ParseFloat(utilities.GetEnvOrDefault("RATE_LIMIT_RPS", "10"), 64)
+	if err != nil {
+		limit = 10
+	}
+	burst, err := strconv.Atoi(utilities.GetEnvOrDefault("RATE_LIMIT_BURST", "20"))
+	if err != nil {
+		burst = 20
+	}
+
+	maxWait, err := time.ParseDuration(utilities.GetEnvOrDefault("RATE_LIMIT_MAX_WAIT", "0s"))
+	if err != nil {
+		maxWait = 0
+	}
+
+	ipLimit, err := strconv.ParseFloat(utilities.GetEnvOrDefault("RATE_LIMIT_IP_RPS", "50"), 64)
+	if err != nil {
+		ipLimit = 50
+	}
+	ipBurst, err := strconv.Atoi(utilities.GetEnvOrDefault("RATE_LIMIT_IP_BURST", "100"))
+	if err != nil {
+		ipBurst = 100
+	}
+
+	var proxies []string
+	if cidrs := utilities.GetEnvOrDefault("RATE_LIMIT_TRUSTED_PROXIES", ""); cidrs != "" {
+		proxies = strings.Split(cidrs, ",")
+	}
+
+	return RateLimitConfig{
+		Limit:          rate.Limit(limit),
+		Burst:          burst,
+		TrustedProxies: proxies,
+		MaxWait:        maxWait,
+		IPLimit:        rate.Limit(ipLimit),
+		IPBurst:        ipBurst,
+		KeyBy:          utilities.GetEnvOrDefault("RATE_LIMIT_KEY", ""),
+		Policies: []RateLimitPolicy{
+			policyFromEnv(RateLimitPolicy{
+				Name:    "login",
+				Methods: []string{http.MethodPost},
+				Paths:   []string{"/login"},
+				Limit:   rate.Every(6 * time.Second),
+				Burst:   5,
+			}),
+			policyFromEnv(RateLimitPolicy{
+				Name:    "events",
+				Methods: []string{http.MethodGet},
+				Paths:   []string{"/events"},
+				Limit:   rate.Every(5 * time.Second),
+				Burst:   5,
+			}),
+			policyFromEnv(RateLimitPolicy{
+				Name:    "writes",
+				Methods: []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+				Limit:   rate.Limit(limit / 2),
+				Burst:   max(burst/2, 1),
+			}),
+		},
+	}
+}
+
+// policyFromEnv overrides the rate, burst and key of a policy with
+// RATE_LIMIT_<NAME>_RPS, RATE_LIMIT_<NAME>_BURST and RATE_LIMIT_<NAME>_KEY when
+// they are set.
+func policyFromEnv(policy RateLimitPolicy) RateLimitPolicy {
+	prefix := "RATE_LIMIT_" + strings.ToUpper(policy.Name)
+	if limit, err := strconv.ParseFloat(utilities.GetEnvOrDefault(prefix+"_RPS", ""), 64); err == nil {
+		policy.Limit = rate.Limit(limit)
+	}
+	if burst, err := strconv.Atoi(utilities.GetEnvOrDefault(prefix+"_BURST", "")); err == nil {
+		policy.Burst = burst
+	}
+	policy.KeyBy = utilities.GetEnvOrDefault(prefix+"_KEY", policy.KeyBy)
+	return policy
+}
+
+// Options returns the RateLimiter options for the configuration. Keys are
+// named "principal", "user", "api_key", "tenant" or "ip", requests a key does
+// not apply to are counted by client address.
+func (c RateLimitConfig) Options() ([]RateLimitOption, error) {
+	proxies, err := ParseTrustedProxies(c.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	policies := slices.Clone(c.Policies)
+	for i, policy := range policies {
+		if policy.KeyBy == "" {
+			continue
+		}
+		if policies[i].Key, err = namedKey(policy.KeyBy, proxies); err != nil {
+			return nil, fmt.Errorf("rate limit policy %s: %w", policy.Name, err)
+		}
+	}
+	options := []RateLimitOption{WithTrustedProxies(proxies), WithPolicies(policies...), WithMaxWait(c.MaxWait)}
+
+	if c.KeyBy != "" {
+		key, err := namedKey(c.KeyBy, proxies)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, WithKeyFunc(key))
+	}
+	return options, nil
+}
+
+func namedKey(name string, proxies *TrustedProxies) (KeyFunc, error) {
+	switch name {
+	case "principal":
+		return KeyByPrincipal(), nil
+	case "user":
+		return KeyByUser(), nil
+	case "api_key":
+		return KeyByAPIKey(), nil
+	case "tenant":
+		return KeyByTenant(), nil
+	case "ip":
+		return KeyByIP(proxies), nil
+	}
+	return nil, fmt.Errorf("unknown rate limit key %q", name)
+}
+
+// IPOptions returns the RateLimiter options for the per address limit applied
+// before authentication, to be used with IPLimit and IPBurst.
+func (c RateLimitConfig) IPOptions() ([]RateLimitOption, error) {
+	proxies, err := ParseTrustedProxies(c.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return []RateLimitOption{WithName("ip"), WithTrustedProxies(proxies), WithKeyFunc(KeyByIP(proxies))}, nil
+}
+
+// RateLimiter limits each client to r requests per second with the given burst.
+// By default authenticated callers are limited by principal, so clients sharing
+// an address do not share a budget, and everyone else by client address.
+// Without WithStore buckets are kept in process and purged until ctx is
+// cancelled.
+func RateLimiter(ctx context.Context, r rate.Limit, burst int, opts ...RateLimitOption) func(http.Handler) http.Handler {
+	config := rateLimitConfig{name: "default", key: KeyByPrincipal()}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if config.store == nil {
+		config.store = ratelimit.StartMemoryStore(ctx, ratelimit.DefaultMemoryConfig())
+	}
+	fallback := KeyByIP(config.proxies)
+	defaultPolicy := RateLimitPolicy{Name: config.name, Limit: r, Burst: burst}
+
+	policyFor := func(r *http.Request) RateLimitPolicy {
+		for _, policy := range config.policies {
+			if policy.matches(r) {
+				return policy
+			}
+		}
+		return defaultPolicy
 	}
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			host, _, err := net.SplitHostPort(r.RemoteAddr)
-			if err != nil {
-				host = r.RemoteAddr
+			policy := policyFor(r)
+			keyFunc := config.key
+			if policy.Key != nil {
+				keyFunc = policy.Key
+			}
+			key := keyFunc(r)
+			if key == "" {
+				key = fallback(r)
 			}
-			key := host
-
-			limiter := getLimiter(key)
 
-			if !limiter.Allow() {
+			result, err := config.store.Take(r.Context(), policy.Name+"|"+key, policy.Limit, policy.Burst, config.maxWait)
+			if err != nil {
 				w.Header().Set("Retry-After", "1")
-				w.Header().Set("X-RateLimit-Limit", strconv.Itoa(burst))
+				http.Error(w, "rate limiter unavailable\n", http.StatusServiceUnavailable)
+				return
+			}
+			setRateLimitHeaders(w.Header(), policy, result)
+
+			if !result.Allowed {
+				// Left out when retrying would never help
+				if result.Delay > 0 {
+					w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.Delay)))
+				}
+				limit, _ := quota(policy)
+				w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit))
+				w.Header().Set("X-RateLimit-Policy", policy.Name)
 				http.Error(w, "too many requests\n", http.StatusTooManyRequests)
 				return
 			}
 
+			if result.Delay > 0 {
+				timer := time.NewTimer(result.Delay)
+				select {
+				case <-timer.C:
+				case <-r.Context().Done():
+					timer.Stop()
+					return
+				}
+			}
+
 			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+// setRateLimitHeaders describes the policy and the client's state in its bucket
+// with the RateLimit-Policy and RateLimit fields of the IETF httpapi draft.
+func setRateLimitHeaders(h http.Header, policy RateLimitPolicy, result ratelimit.Result) {
+	q, window := quota(policy)
+	h.Set("RateLimit-Policy", fmt.Sprintf("%q;q=%d;w=%d", policy.Name, q, window))
+	h.Set("RateLimit", fmt.Sprintf("%q;r=%d;t=%d", policy.Name, result.Remaining, ceilSeconds(result.Reset)))
+}
+
+// quota expresses the rate of a policy as a number of requests per window in
+// seconds: per second for rates of a request a second or more, otherwise one
+// request per interval between them. The burst lets clients briefly exceed
+// it, which the remaining count of the RateLimit field shows. Policies whose
+// buckets never refill report their burst without a window.
+func quota(policy RateLimitPolicy) (q, window int) {
+	switch {
+	case policy.Limit <= 0 || policy.Limit == rate.Inf:
+		return policy.Burst, 0
+	case policy.Limit >= 1:
+		return int(policy.Limit), 1
+	default:
+		return 1, ceilSeconds(time.Duration(float64(time.Second) / float64(policy.Limit)))
+	}
+}
+
+// ceilSeconds rounds d up to whole seconds, as HTTP delays are in seconds.
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
@@ -1,10 +1,16 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/database"
+	"github.com/doug-benn/go-server-starter/ratelimit"
 	"golang.org/x/time/rate"
 )
 
@@ -16,7 +22,7 @@ func okHandler() http.Handler {
 }
 
 func TestRateLimiterAllowsWithinBurst(t *testing.T) {
-	middleware := RateLimiter(rate.Limit(1), 5)
+	middleware := RateLimiter(t.Context(), rate.Limit(1), 5)
 	handler := middleware(okHandler())
 
 	for i := range 5 {
@@ -31,7 +37,7 @@ func TestRateLimiterAllowsWithinBurst(t *testing.T) {
 }
 
 func TestRateLimiterBlocksExcess(t *testing.T) {
-	middleware := RateLimiter(rate.Limit(1), 5)
+	middleware := RateLimiter(t.Context(), rate.Limit(1), 5)
 	handler := middleware(okHandler())
 
 	for range 5 {
@@ -52,13 +58,27 @@ func TestRateLimiterBlocksExcess(t *testing.T) {
 		t.Error("expected Retry-After header")
 	}
 
-	if rr.Header().Get("X-RateLimit-Limit") != "5" {
-		t.Errorf("expected X-RateLimit-Limit: 5, got %s", rr.Header().Get("X-RateLimit-Limit"))
+	if rr.Header().Get("X-RateLimit-Limit") != "1" {
+		t.Errorf("expected X-RateLimit-Limit: 1, got %s", rr.Header().Get("X-RateLimit-Limit"))
+	}
+}
+
+func TestRateLimiterZeroBurst(t *testing.T) {
+	handler := RateLimiter(t.Context(), rate.Limit(1), 0)(okHandler())
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/test", nil))
+
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429, got %d", rr.Code)
+	}
+	if retryAfter := rr.Header().Get("Retry-After"); retryAfter != "" {
+		t.Errorf("expected no Retry-After, got %s", retryAfter)
 	}
 }
 
 func TestRateLimiterDifferentIPs(t *testing.T) {
-	middleware := RateLimiter(rate.Limit(1), 3)
+	middleware := RateLimiter(t.Context(), rate.Limit(1), 3)
 	handler := middleware(okHandler())
 
 	clientA := func() *httptest.ResponseRecorder {
@@ -97,3 +117,189 @@ func TestRateLimiterDifferentIPs(t *testing.T) {
 		t.Errorf("client B expected 200, got %d", rr.Code)
 	}
 }
+
+func TestRateLimiterTrustedProxies(t *testing.T) {
+	proxies, _ := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	handler := RateLimiter(t.Context(), rate.Limit(1), 1, WithTrustedProxies(proxies))(okHandler())
+
+	request := func(client string) int {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = "10.0.0.1:12345"
+		req.Header.Set("X-Forwarded-For", client)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := request("198.51.100.1"); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if code := request("198.51.100.1"); code != http.StatusTooManyRequests {
+		t.Errorf("expected 429, got %d", code)
+	}
+	// Another client behind the same proxy
+	if code := request("198.51.100.2"); code != http.StatusOK {
+		t.Errorf("expected 200, got %d", code)
+	}
+}
+
+func TestRateLimiterPolicies(t *testing.T) {
+	handler := RateLimiter(t.Context(), rate.Limit(1), 3, WithPolicies(
+		RateLimitPolicy{Name: "events", Methods: []string{"GET"}, Paths: []string{"/events"}, Limit: rate.Limit(1), Burst: 1},
+		RateLimitPolicy{Name: "writes", Methods: []string{"POST", "DELETE"}, Limit: rate.Limit(1), Burst: 2},
+	))(okHandler())
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for range 2 {
+		if rr := request("POST", "/todos"); rr.Code != http.StatusOK {
+			t.Fatalf("expected writes within their burst, got %d", rr.Code)
+		}
+	}
+	rr := request("DELETE", "/todos/1")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("X-RateLimit-Policy") != "writes" {
+		t.Errorf("expected writes to share the stricter limit, got %d %s", rr.Code, rr.Header().Get("X-RateLimit-Policy"))
+	}
+
+	// Reads and SSE connections are counted separately from writes
+	if rr := request("GET", "/events"); rr.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rr.Code)
+	}
+	if rr := request("GET", "/events"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429, got %d", rr.Code)
+	}
+	for range 3 {
+		if rr := request("GET", "/todos"); rr.Code != http.StatusOK {
+			t.Errorf("expected reads within the default burst, got %d", rr.Code)
+		}
+	}
+}
+
+func TestDefaultRateLimitConfigPolicies(t *testing.T) {
+	t.Setenv("RATE_LIMIT_LOGIN_RPS", "0.5")
+	t.Setenv("RATE_LIMIT_LOGIN_BURST", "2")
+	t.Setenv("RATE_LIMIT_WRITES_BURST", "7")
+	t.Setenv("RATE_LIMIT_LOGIN_KEY", "ip")
+
+	policies := make(map[string]RateLimitPolicy)
+	for _, policy := range DefaultRateLimitConfig().Policies {
+		policies[policy.Name] = policy
+	}
+
+	if login := policies["login"]; login.Limit != 0.5 || login.Burst != 2 {
+		t.Errorf("expected login 0.5/2, got %v/%d", login.Limit, login.Burst)
+	}
+	if events := policies["events"]; events.Limit != rate.Every(5*time.Second) || events.Burst != 5 {
+		t.Errorf("expected the events default, got %v/%d", events.Limit, events.Burst)
+	}
+	if writes := policies["writes"]; writes.Burst != 7 {
+		t.Errorf("expected writes burst 7, got %d", writes.Burst)
+	}
+	if login := policies["login"]; login.KeyBy != "ip" {
+		t.Errorf("expected login keyed by ip, got %q", login.KeyBy)
+	}
+	if _, err := DefaultRateLimitConfig().Options(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	t.Setenv("RATE_LIMIT_KEY", "session")
+	if _, err := DefaultRateLimitConfig().Options(); err == nil {
+		t.Error("expected an error for an unknown key")
+	}
+}
+
+func TestRateLimiterKeyFuncs(t *testing.T) {
+	apiKey := httptest.NewRequest("GET", "/test", nil).WithContext(
+		auth.WithPrincipal(context.Background(), &auth.Principal{ID: "key-1", Kind: auth.KindAPIKey}))
+	user := httptest.NewRequest("GET", "/test", nil).WithContext(
+		database.WithTenant(auth.WithPrincipal(context.Background(), &auth.Principal{ID: "local:alice", Kind: auth.KindLocal}), "acme"))
+
+	tests := []struct {
+		name string
+		key  KeyFunc
+		req  *http.Request
+		want string
+	}{
+		{"api key", KeyByAPIKey(), apiKey, "principal:key-1"},
+		{"api key not applicable", KeyByAPIKey(), user, ""},
+		{"user", KeyByUser(), user, "principal:local:alice"},
+		{"tenant", KeyByTenant(), user, "tenant:acme"},
+		{"tenant not applicable", KeyByTenant(), apiKey, ""},
+		{"first applicable", FirstKey(KeyByTenant(), KeyByAPIKey()), apiKey, "principal:key-1"},
+		{"ip", KeyByIP(nil), apiKey, "ip:192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.key(tt.req); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRateLimiterHeaders(t *testing.T) {
+	handler := RateLimiter(t.Context(), rate.Every(10*time.Second), 2)(okHandler())
+
+	request := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/test", nil))
+		return rr
+	}
+
+	rr := request()
+	if got := rr.Header().Get("RateLimit-Policy"); got != `"default";q=1;w=10` {
+		t.Errorf("unexpected RateLimit-Policy %s", got)
+	}
+	if got := rr.Header().Get("RateLimit"); got != `"default";r=1;t=10` {
+		t.Errorf("unexpected RateLimit %s", got)
+	}
+
+	request()
+	rr = request()
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "10" {
+		t.Errorf("expected Retry-After: 10, got %s", got)
+	}
+	if got := rr.Header().Get("RateLimit"); got != `"default";r=0;t=20` {
+		t.Errorf("unexpected RateLimit %s", got)
+	}
+}
+
+func TestRateLimiterMaxWait(t *testing.T) {
+	handler := RateLimiter(t.Context(), rate.Every(50*time.Millisecond), 1, WithMaxWait(time.Second))(okHandler())
+
+	start := time.Now()
+	for range 2 {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/test", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected the request to be queued, got %d", rr.Code)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("expected the second request to wait, took %v", elapsed)
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, limit rate.Limit, burst int, maxWait time.Duration) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("database unavailable")
+}
+
+func TestRateLimiterStoreUnavailable(t *testing.T) {
+	handler := RateLimiter(t.Context(), rate.Limit(1), 1, WithStore(failingStore{}))(okHandler())
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/test", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", rr.Code)
+	}
+}
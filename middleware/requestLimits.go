@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/doug-benn/go-server-starter/utilities"
+)
+
+// RouteLimits overrides the body limits for the requests it matches.
+type RouteLimits struct {
+	// Methods and Paths select the requests, as for RateLimitPolicy.
+	Methods []string
+	Paths   []string
+	// MaxBodyBytes replaces the default when set, negative removes the limit.
+	MaxBodyBytes int64
+	// ContentTypes replace the default when set.
+	ContentTypes []string
+}
+
+func (l RouteLimits) matches(r *http.Request) bool {
+	return (len(l.Methods) == 0 || slices.Contains(l.Methods, r.Method)) &&
+		(len(l.Paths) == 0 || matchPath(l.Paths, r.URL.Path))
+}
+
+// RequestLimitsConfig configures RequestLimits.
+type RequestLimitsConfig struct {
+	// Methods are the only methods accepted.
+	Methods []string
+	// MaxBodyBytes limits request bodies, zero disables it.
+	MaxBodyBytes int64
+	// ContentTypes are the media types accepted for the bodies of POST, PUT
+	// and PATCH requests, empty accepts any.
+	ContentTypes []string
+	// MaxHeaderCount and MaxHeaderBytes limit the request headers, zero
+	// disables them. The server's own MaxHeaderBytes should be at least as
+	// large, it is cheaper but counts the request line too.
+	MaxHeaderCount int
+	MaxHeaderBytes int
+	// Routes are checked in order, the first match applies.
+	Routes []RouteLimits
+}
+
+// DefaultRequestLimitsConfig returns the configuration read from the
+// environment. The API only accepts JSON bodies.
+func DefaultRequestLimitsConfig() RequestLimitsConfig {
+	maxBody, err := strconv.ParseInt(utilities.GetEnvOrDefault("MAX_BODY_BYTES", "1048576"), 10, 64)
+	if err != nil {
+		maxBody = 1 << 20
+	}
+	maxHeaderBytes, err := strconv.Atoi(utilities.GetEnvOrDefault("MAX_HEADER_BYTES", "32768"))
+	if err != nil {
+		maxHeaderBytes = 32 << 10
+	}
+	return RequestLimitsConfig{
+		Methods: []string{
+			http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+			http.MethodPatch, http.MethodDelete, http.MethodOptions,
+		},
+		MaxBodyBytes:   maxBody,
+		ContentTypes:   []string{"application/json"},
+		MaxHeaderCount: 100,
+		MaxHeaderBytes: maxHeaderBytes,
+	}
+}
+
+// RequestLimits rejects requests the API never expects before any work is done
+// for them: methods outside the allow list with 501, too many or too large
+// headers with 431, bodies declared larger than the limit with 413 and bodies
+// of an unexpected type with 415. Bodies without a declared length are cut off
+// at the limit by http.MaxBytesReader, failing the handler's read.
+func RequestLimits(logger *slog.Logger, config RequestLimitsConfig) func(http.Handler) http.Handler {
+	limitsFor := func(r *http.Request) (int64, []string) {
+		for _, route := range config.Routes {
+			if !route.matches(r) {
+				continue
+			}
+			maxBody, contentTypes := config.MaxBodyBytes, config.ContentTypes
+			if route.MaxBodyBytes != 0 {
+				maxBody = max(route.MaxBodyBytes, 0)
+			}
+			if route.ContentTypes != nil {
+				contentTypes = route.ContentTypes
+			}
+			return maxBody, contentTypes
+		}
+		return config.MaxBodyBytes, config.ContentTypes
+	}
+
+	reject := func(w http.ResponseWriter, r *http.Request, status int, detail string) {
+		logger.WarnContext(r.Context(), "request rejected",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.String("reason", detail),
+		)
+		WriteProblem(w, status, detail)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(config.Methods) > 0 && !slices.Contains(config.Methods, r.Method) {
+				reject(w, r, http.StatusNotImplemented, "method not supported")
+				return
+			}
+
+			if config.MaxHeaderCount > 0 || config.MaxHeaderBytes > 0 {
+				count, size := 0, 0
+				for name, values := range r.Header {
+					for _, value := range values {
+						count++
+						size += len(name) + len(value) + len(": \r\n")
+					}
+				}
+				if (config.MaxHeaderCount > 0 && count > config.MaxHeaderCount) ||
+					(config.MaxHeaderBytes > 0 && size > config.MaxHeaderBytes) {
+					reject(w, r, http.StatusRequestHeaderFieldsTooLarge, "request headers too large")
+					return
+				}
+			}
+
+			if r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			maxBody, contentTypes := limitsFor(r)
+			if maxBody > 0 {
+				if r.ContentLength > maxBody {
+					reject(w, r, http.StatusRequestEntityTooLarge, "request body too large")
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+			}
+
+			if len(contentTypes) > 0 && r.ContentLength != 0 && hasBodyMethod(r.Method) {
+				mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+				if err != nil || !slices.Contains(contentTypes, mediaType) {
+					reject(w, r, http.StatusUnsupportedMediaType, "unsupported content type, expected "+strings.Join(contentTypes, " or "))
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func hasBodyMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		return true
+	}
+	return false
+}
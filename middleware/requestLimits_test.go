@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestLimits(t *testing.T) {
+	config := DefaultRequestLimitsConfig()
+	config.MaxBodyBytes = 16
+	config.MaxHeaderCount = 5
+	config.MaxHeaderBytes = 256
+	config.Routes = []RouteLimits{
+		{Paths: []string{"/uploads"}, MaxBodyBytes: -1, ContentTypes: []string{"text/csv"}},
+	}
+	handler := RequestLimits(slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)), config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		}
+	}))
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		contentType    string
+		headers        int
+		expectedStatus int
+	}{
+		{"read", "GET", "/todos", "", "", 0, http.StatusOK},
+		{"json write", "POST", "/todos", `{"title":"a"}`, "application/json; charset=utf-8", 0, http.StatusOK},
+		{"empty write", "DELETE", "/todos/1", "", "", 0, http.StatusOK},
+		{"method not allowed", "TRACE", "/todos", "", "", 0, http.StatusNotImplemented},
+		{"body too large", "POST", "/todos", strings.Repeat("a", 17), "application/json", 0, http.StatusRequestEntityTooLarge},
+		{"wrong content type", "POST", "/todos", "title=a", "application/x-www-form-urlencoded", 0, http.StatusUnsupportedMediaType},
+		{"missing content type", "PUT", "/todos/1", "{}", "", 0, http.StatusUnsupportedMediaType},
+		{"route limits", "POST", "/uploads", strings.Repeat("a,", 100), "text/csv", 0, http.StatusOK},
+		{"too many headers", "GET", "/todos", "", "", 6, http.StatusRequestHeaderFieldsTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			req := httptest.NewRequest(tt.method, tt.path, body)
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			for i := range tt.headers {
+				req.Header.Set("X-Header-"+string(rune('a'+i)), "value")
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body)
+			}
+		})
+	}
+}
+
+func TestRequestLimitsHeaderBytes(t *testing.T) {
+	config := DefaultRequestLimitsConfig()
+	config.MaxHeaderBytes = 256
+	handler := RequestLimits(slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)), config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/todos", nil)
+	req.Header.Set("Cookie", strings.Repeat("a", 300))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("expected 431, got %d", rr.Code)
+	}
+}
+
+func TestRequestLimitsUnknownLength(t *testing.T) {
+	config := DefaultRequestLimitsConfig()
+	config.MaxBodyBytes = 16
+	var readErr error
+	handler := RequestLimits(slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)), config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, readErr = io.ReadAll(r.Body)
+	}))
+
+	req := httptest.NewRequest("POST", "/todos", strings.NewReader(strings.Repeat("a", 32)))
+	req.Header.Set("Content-Type", "application/json")
+	req.ContentLength = -1
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var tooLarge *http.MaxBytesError
+	if !errors.As(readErr, &tooLarge) {
+		t.Errorf("expected the read to fail at the limit, got %v", readErr)
+	}
+}
@@ -0,0 +1,474 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/cache"
+	"github.com/doug-benn/go-server-starter/database"
+	"github.com/doug-benn/go-server-starter/utilities"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// CacheStatusHeader tells clients how a response was served, HIT, STALE, MISS
+// or BYPASS.
+const CacheStatusHeader = "X-Cache"
+
+var (
+	responseCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_response_cache_requests_total",
+		Help: "Requests to cached routes, by result (hit, stale, coalesced, miss or bypass).",
+	}, []string{"result"})
+	responseCacheRevalidations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_response_cache_revalidations_total",
+		Help: "Stale responses refreshed in the background, by result (stored or dropped).",
+	}, []string{"result"})
+)
+
+// Cacheable statuses when the handler allows caching, as in RFC 9110.
+var cacheableStatuses = []int{
+	http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+	http.StatusMultipleChoices, http.StatusMovedPermanently, http.StatusNotFound,
+	http.StatusMethodNotAllowed, http.StatusGone, http.StatusRequestURITooLong,
+	http.StatusNotImplemented,
+}
+
+// ResponseCacheConfig configures ResponseCache.
+type ResponseCacheConfig struct {
+	// Paths selects the routes cached, as for RateLimitPolicy. Responses are
+	// held until the handler returns, so streams must not be included.
+	Paths []string
+	// MaxBodyBytes is the largest body stored.
+	MaxBodyBytes int
+	// PassTTL is how long a route whose response could not be stored is
+	// passed straight to the handler, without waiting on other requests for it.
+	PassTTL time.Duration
+	// RevalidateTimeout bounds the background requests refreshing stale
+	// responses.
+	RevalidateTimeout time.Duration
+	// Key scopes entries to the caller. Requests it returns no key for share
+	// entries, and private responses are not stored for them.
+	Key KeyFunc
+}
+
+// DefaultResponseCacheConfig returns the configuration read from the
+// environment, caching the routes in RESPONSE_CACHE_PATHS for each principal.
+func DefaultResponseCacheConfig() ResponseCacheConfig {
+	maxBody, err := strconv.Atoi(utilities.GetEnvOrDefault("RESPONSE_CACHE_MAX_BODY_BYTES", "1048576"))
+	if err != nil {
+		maxBody = 1 << 20
+	}
+	return ResponseCacheConfig{
+		Paths:             splitList(utilities.GetEnvOrDefault("RESPONSE_CACHE_PATHS", "/admin/routes")),
+		MaxBodyBytes:      maxBody,
+		PassTTL:           time.Minute,
+		RevalidateTimeout: 30 * time.Second,
+		Key:               KeyByPrincipal(),
+	}
+}
+
+// cachedResponse is a response stored in the cache.
+type cachedResponse struct {
+	Key    string      `json:"key"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+	// Vary names the request headers the response was selected by.
+	Vary     []string  `json:"vary"`
+	StoredAt time.Time `json:"stored_at"`
+	// Fresh is how long the response is served as it is, Stale for how much
+	// longer it is served while it is refreshed.
+	Fresh time.Duration `json:"fresh"`
+	Stale time.Duration `json:"stale"`
+}
+
+// cacheCall is a request in flight for a key, that others wait on.
+type cacheCall struct {
+	done  chan struct{}
+	entry *cachedResponse
+}
+
+type responseCache struct {
+	logger *slog.Logger
+	cache  cache.Cache
+	config ResponseCacheConfig
+	now    func() time.Time
+
+	mu       sync.Mutex
+	inFlight map[string]*cacheCall
+}
+
+// ResponseCache stores GET responses in c and serves them again while they
+// are fresh, as set by the handler with Cache-Control max-age or s-maxage.
+// Responses are keyed by path, query, tenant, the Key of the caller and the
+// request headers named in Vary. A response past its max-age but within its
+// stale-while-revalidate is served while a background request refreshes it.
+// Concurrent misses for the same key wait for a single call to the handler.
+//
+// Clients bypass the cache with Cache-Control no-store, and refresh the entry
+// with no-cache.
+func ResponseCache(logger *slog.Logger, c cache.Cache, config ResponseCacheConfig) func(http.Handler) http.Handler {
+	rc := &responseCache{logger: logger, cache: c, config: config, now: time.Now, inFlight: make(map[string]*cacheCall)}
+	return rc.middleware
+}
+
+func (rc *responseCache) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		directives := cacheControl(r.Header)
+		_, noStore := directives["no-store"]
+		if r.Method != http.MethodGet || noStore ||
+			(len(rc.config.Paths) > 0 && !matchPath(rc.config.Paths, r.URL.Path)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		scope := rc.config.Key(r)
+		base := rc.baseKey(r, scope)
+		if _, pass, _ := rc.cache.Get(r.Context(), "pass|"+base); pass {
+			responseCacheRequests.WithLabelValues("bypass").Inc()
+			w.Header().Set(CacheStatusHeader, "BYPASS")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		vary, _, err := cache.Get[[]string](r.Context(), rc.cache, "vary|"+base)
+		if err != nil {
+			rc.logger.ErrorContext(r.Context(), "failed to read response cache", "error", err)
+		}
+		key := entryKey(base, r, vary)
+
+		_, noCache := directives["no-cache"]
+		if !noCache && r.Header.Get("Pragma") != "no-cache" {
+			entry, ok, err := cache.Get[*cachedResponse](r.Context(), rc.cache, key)
+			if err != nil {
+				rc.logger.ErrorContext(r.Context(), "failed to read response cache", "error", err)
+			}
+			if ok {
+				age := rc.now().Sub(entry.StoredAt)
+				if age < entry.Fresh {
+					responseCacheRequests.WithLabelValues("hit").Inc()
+					rc.serve(w, r, entry, "HIT")
+					return
+				}
+				if age < entry.Fresh+entry.Stale {
+					responseCacheRequests.WithLabelValues("stale").Inc()
+					rc.revalidate(next, r, scope, base, key)
+					rc.serve(w, r, entry, "STALE")
+					return
+				}
+			}
+		}
+
+		call, leader := rc.join(key)
+		if !leader {
+			select {
+			case <-call.done:
+			case <-r.Context().Done():
+				return
+			}
+			if entry := call.entry; entry != nil && entryKey(base, r, entry.Vary) == entry.Key {
+				responseCacheRequests.WithLabelValues("coalesced").Inc()
+				rc.serve(w, r, entry, "HIT")
+				return
+			}
+			responseCacheRequests.WithLabelValues("miss").Inc()
+			w.Header().Set(CacheStatusHeader, "MISS")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		responseCacheRequests.WithLabelValues("miss").Inc()
+		w.Header().Set(CacheStatusHeader, "MISS")
+		before := w.Header().Clone()
+		cw := &cacheWriter{ResponseWriter: w, max: rc.config.MaxBodyBytes}
+		completed := false
+		defer func() {
+			// Waiting requests call the handler themselves if it panicked
+			var entry *cachedResponse
+			if completed {
+				entry = rc.store(r, scope, base, before, cw)
+			}
+			rc.finish(key, call, entry)
+		}()
+		next.ServeHTTP(cw, r)
+		completed = true
+	})
+}
+
+// join returns the call in flight for key, or starts one if there is none.
+func (rc *responseCache) join(key string) (*cacheCall, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if call, ok := rc.inFlight[key]; ok {
+		return call, false
+	}
+	call := &cacheCall{done: make(chan struct{})}
+	rc.inFlight[key] = call
+	return call, true
+}
+
+func (rc *responseCache) finish(key string, call *cacheCall, entry *cachedResponse) {
+	rc.mu.Lock()
+	delete(rc.inFlight, key)
+	rc.mu.Unlock()
+	call.entry = entry
+	close(call.done)
+}
+
+// revalidate refreshes a stale entry in the background, unless it already is.
+func (rc *responseCache) revalidate(next http.Handler, r *http.Request, scope, base, key string) {
+	call, leader := rc.join(key)
+	if !leader {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), rc.config.RevalidateTimeout)
+	req := r.Clone(ctx)
+	// The handler must send the whole response to store it
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+
+	go func() {
+		defer cancel()
+		var entry *cachedResponse
+		defer func() {
+			if err := recover(); err != nil {
+				rc.logger.ErrorContext(ctx, "panic while revalidating cached response", "error", err, "path", req.URL.Path)
+				entry = nil
+			}
+			if entry == nil {
+				rc.cache.Delete(ctx, key)
+				responseCacheRevalidations.WithLabelValues("dropped").Inc()
+			} else {
+				responseCacheRevalidations.WithLabelValues("stored").Inc()
+			}
+			rc.finish(key, call, entry)
+		}()
+
+		bw := &cacheWriter{ResponseWriter: discardWriter{header: make(http.Header)}, max: rc.config.MaxBodyBytes}
+		next.ServeHTTP(bw, req)
+		entry = rc.store(req, scope, base, make(http.Header), bw)
+	}()
+}
+
+// store keeps the response written to cw if the handler allows it, returning
+// nil otherwise. Only headers set since before are stored.
+func (rc *responseCache) store(r *http.Request, scope, base string, before http.Header, cw *cacheWriter) *cachedResponse {
+	if cw.status == 0 {
+		return nil
+	}
+	header := storedHeaders(before, cw.Header())
+	delete(header, CacheStatusHeader)
+
+	vary := addedValues(before, cw.Header(), "Vary")
+	fresh, stale, ok := cacheLifetime(cw.status, cw.Header(), scope)
+	if !ok || cw.overflow || cw.streamed || slices.Contains(vary, "*") {
+		rc.set(r.Context(), "pass|"+base, true, rc.config.PassTTL)
+		return nil
+	}
+
+	entry := &cachedResponse{
+		Key:      entryKey(base, r, vary),
+		Status:   cw.status,
+		Header:   header,
+		Body:     bytes.Clone(cw.body.Bytes()),
+		Vary:     vary,
+		StoredAt: rc.now(),
+		Fresh:    fresh,
+		Stale:    stale,
+	}
+	rc.set(r.Context(), "vary|"+base, vary, fresh+stale)
+	rc.set(r.Context(), entry.Key, entry, fresh+stale)
+	return entry
+}
+
+// set stores value in the cache, a failure only costs a later miss.
+func (rc *responseCache) set(ctx context.Context, key string, value any, ttl time.Duration) {
+	if err := cache.Set(ctx, rc.cache, key, value, ttl); err != nil {
+		rc.logger.ErrorContext(ctx, "failed to store cached response", "error", err)
+	}
+}
+
+func (rc *responseCache) serve(w http.ResponseWriter, r *http.Request, entry *cachedResponse, status string) {
+	h := w.Header()
+	for name, values := range entry.Header {
+		if name == "Vary" {
+			for _, v := range values {
+				if !slices.Contains(h.Values("Vary"), v) {
+					h.Add("Vary", v)
+				}
+			}
+			continue
+		}
+		h[name] = slices.Clone(values)
+	}
+	h.Set("Age", strconv.Itoa(int(rc.now().Sub(entry.StoredAt).Seconds())))
+	h.Set(CacheStatusHeader, status)
+
+	if etag := entry.Header.Get("ETag"); etag != "" && entry.Status == http.StatusOK &&
+		noneMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(entry.Status)
+	w.Write(entry.Body)
+}
+
+// baseKey identifies a request before the headers it varies by are known.
+// Query parameters are sorted, so that their order does not matter.
+func (rc *responseCache) baseKey(r *http.Request, scope string) string {
+	tenant, _ := database.TenantFromContext(r.Context())
+	return "response|" + r.URL.Path + "?" + r.URL.Query().Encode() + "|" + tenant + "|" + scope
+}
+
+func entryKey(base string, r *http.Request, vary []string) string {
+	var b strings.Builder
+	b.WriteString(base)
+	for _, name := range vary {
+		b.WriteString("|" + strings.Join(r.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+// cacheLifetime returns how long a response may be served fresh and then
+// stale according to its Cache-Control header, and whether it may be stored.
+func cacheLifetime(status int, header http.Header, scope string) (time.Duration, time.Duration, bool) {
+	if !slices.Contains(cacheableStatuses, status) || len(header.Values("Set-Cookie")) > 0 {
+		return 0, 0, false
+	}
+	directives := cacheControl(header)
+	if _, ok := directives["no-store"]; ok {
+		return 0, 0, false
+	}
+	if _, ok := directives["no-cache"]; ok {
+		return 0, 0, false
+	}
+	// Shared entries must not hold responses meant for one caller
+	if _, ok := directives["private"]; ok && scope == "" {
+		return 0, 0, false
+	}
+
+	maxAge, ok := directives["s-maxage"]
+	if !ok {
+		maxAge = directives["max-age"]
+	}
+	fresh, err := strconv.Atoi(maxAge)
+	if err != nil || fresh <= 0 {
+		return 0, 0, false
+	}
+	stale, _ := strconv.Atoi(directives["stale-while-revalidate"])
+	_, mustRevalidate := directives["must-revalidate"]
+	if stale < 0 || mustRevalidate {
+		stale = 0
+	}
+	return time.Duration(fresh) * time.Second, time.Duration(stale) * time.Second, true
+}
+
+// cacheControl parses the Cache-Control header into its directives.
+func cacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name != "" {
+				directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+			}
+		}
+	}
+	return directives
+}
+
+// addedValues returns the values of the comma separated header name in after
+// that were not in before, canonicalised.
+func addedValues(before, after http.Header, name string) []string {
+	var existing, added []string
+	for _, value := range before.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			existing = append(existing, http.CanonicalHeaderKey(strings.TrimSpace(v)))
+		}
+	}
+	for _, value := range after.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			v = http.CanonicalHeaderKey(strings.TrimSpace(v))
+			if v != "" && !slices.Contains(existing, v) && !slices.Contains(added, v) {
+				added = append(added, v)
+			}
+		}
+	}
+	slices.Sort(added)
+	return added
+}
+
+// noneMatch reports whether an If-None-Match header matches etag, comparing
+// weakly as RFC 9110 requires.
+func noneMatch(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheWriter keeps a copy of the response as it is written, up to max bytes.
+type cacheWriter struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	max      int
+	overflow bool
+	// streamed is set when the handler flushes, streams are not stored.
+	streamed bool
+}
+
+func (cw *cacheWriter) WriteHeader(status int) {
+	if cw.status == 0 && status >= 200 {
+		cw.status = status
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *cacheWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if !cw.overflow {
+		if cw.body.Len()+len(b) > cw.max {
+			cw.overflow = true
+			cw.body.Reset()
+		} else {
+			cw.body.Write(b)
+		}
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *cacheWriter) Flush() {
+	cw.streamed = true
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *cacheWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// discardWriter is the writer of background requests, whose responses are
+// only stored.
+type discardWriter struct {
+	header http.Header
+}
+
+func (d discardWriter) Header() http.Header         { return d.header }
+func (d discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (d discardWriter) WriteHeader(int)             {}
@@ -0,0 +1,235 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/cache"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newTestResponseCache() *responseCache {
+	config := DefaultResponseCacheConfig()
+	config.Paths = nil
+	return &responseCache{
+		logger:   slog.Default(),
+		cache:    cache.NewMemoryCache(cache.MemoryConfig{}),
+		config:   config,
+		now:      time.Now,
+		inFlight: make(map[string]*cacheCall),
+	}
+}
+
+func cachedGet(handler http.Handler, path, principal string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if principal != "" {
+		req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{ID: principal}))
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+// countingHandler answers with the number of times it was called.
+func countingHandler(cacheControl string, calls *atomic.Int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte{byte('0' + n)})
+	})
+}
+
+func TestResponseCacheHit(t *testing.T) {
+	var calls atomic.Int32
+	handler := newTestResponseCache().middleware(countingHandler("max-age=60", &calls))
+	hits := testutil.ToFloat64(responseCacheRequests.WithLabelValues("hit"))
+
+	first := cachedGet(handler, "/items?b=2&a=1", "user:1")
+	if first.Header().Get(CacheStatusHeader) != "MISS" || first.Body.String() != "1" {
+		t.Fatalf("expected a miss, got %q %q", first.Header().Get(CacheStatusHeader), first.Body.String())
+	}
+	// The order of query parameters does not matter
+	second := cachedGet(handler, "/items?a=1&b=2", "user:1")
+	if second.Header().Get(CacheStatusHeader) != "HIT" || second.Body.String() != "1" || second.Header().Get("ETag") != `"v1"` {
+		t.Errorf("expected the stored response, got %q %q", second.Header().Get(CacheStatusHeader), second.Body.String())
+	}
+	if second.Header().Get("Age") == "" {
+		t.Error("expected an Age header on a hit")
+	}
+	if rr := cachedGet(handler, "/items?a=1&b=2", "user:1", "If-None-Match", `"v1"`); rr.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for a matching If-None-Match, got %d", rr.Code)
+	}
+	if got := testutil.ToFloat64(responseCacheRequests.WithLabelValues("hit")) - hits; got != 2 {
+		t.Errorf("expected 2 hits to be counted, got %v", got)
+	}
+
+	// Other principals and clients refreshing get a fresh response
+	if rr := cachedGet(handler, "/items?a=1&b=2", "user:2"); rr.Body.String() != "2" {
+		t.Errorf("expected another principal to miss, got %q", rr.Body.String())
+	}
+	if rr := cachedGet(handler, "/items?a=1&b=2", "user:1", "Cache-Control", "no-cache"); rr.Body.String() != "3" {
+		t.Errorf("expected no-cache to call the handler, got %q", rr.Body.String())
+	}
+	if rr := cachedGet(handler, "/items?a=1&b=2", "user:1"); rr.Body.String() != "3" {
+		t.Errorf("expected the refreshed response to be stored, got %q", rr.Body.String())
+	}
+}
+
+func TestResponseCacheHonoursCacheControl(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		principal    string
+		expectStored bool
+	}{
+		{"no header", "", "user:1", false},
+		{"no-store", "no-store, max-age=60", "user:1", false},
+		{"no-cache", "no-cache, max-age=60", "user:1", false},
+		{"private for a principal", "private, max-age=60", "user:1", true},
+		{"private for anyone", "private, max-age=60", "", false},
+		{"public for anyone", "public, max-age=60", "", true},
+		{"s-maxage", "max-age=0, s-maxage=60", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			handler := newTestResponseCache().middleware(countingHandler(tt.cacheControl, &calls))
+			cachedGet(handler, "/items", tt.principal)
+			rr := cachedGet(handler, "/items", tt.principal)
+			if stored := calls.Load() == 1; stored != tt.expectStored {
+				t.Errorf("expected stored %v, got %v", tt.expectStored, stored)
+			}
+			if !tt.expectStored && rr.Header().Get(CacheStatusHeader) != "BYPASS" {
+				t.Errorf("expected later requests to bypass the cache, got %q", rr.Header().Get(CacheStatusHeader))
+			}
+		})
+	}
+}
+
+func TestResponseCacheVary(t *testing.T) {
+	var calls atomic.Int32
+	handler := newTestResponseCache().middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte(r.Header.Get("Accept-Language")))
+	}))
+
+	for _, lang := range []string{"en", "fr", "en", "fr"} {
+		if rr := cachedGet(handler, "/items", "user:1", "Accept-Language", lang); rr.Body.String() != lang {
+			t.Errorf("expected the %s response, got %q", lang, rr.Body.String())
+		}
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected one call for each language, got %d", calls.Load())
+	}
+}
+
+func TestResponseCacheStaleWhileRevalidate(t *testing.T) {
+	now := time.Now()
+	rc := newTestResponseCache()
+	rc.now = func() time.Time { return now }
+
+	var calls atomic.Int32
+	handler := rc.middleware(countingHandler("max-age=10, stale-while-revalidate=30", &calls))
+	cachedGet(handler, "/items", "user:1")
+
+	now = now.Add(20 * time.Second)
+	rr := cachedGet(handler, "/items", "user:1")
+	if rr.Header().Get(CacheStatusHeader) != "STALE" || rr.Body.String() != "1" {
+		t.Fatalf("expected the stale response, got %q %q", rr.Header().Get(CacheStatusHeader), rr.Body.String())
+	}
+
+	// Wait for the background refresh
+	deadline := time.Now().Add(time.Second)
+	for {
+		rr = cachedGet(handler, "/items", "user:1")
+		if rr.Body.String() == "2" && rr.Header().Get(CacheStatusHeader) == "HIT" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the refreshed response, got %q %q", rr.Header().Get(CacheStatusHeader), rr.Body.String())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Past stale-while-revalidate the handler is called in the request
+	now = now.Add(time.Minute)
+	if rr := cachedGet(handler, "/items", "user:1"); rr.Header().Get(CacheStatusHeader) != "MISS" || rr.Body.String() != "3" {
+		t.Errorf("expected a miss, got %q %q", rr.Header().Get(CacheStatusHeader), rr.Body.String())
+	}
+}
+
+func TestResponseCacheCoalescesMisses(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	handler := newTestResponseCache().middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("done"))
+	}))
+	served := func() float64 {
+		return testutil.ToFloat64(responseCacheRequests.WithLabelValues("coalesced")) +
+			testutil.ToFloat64(responseCacheRequests.WithLabelValues("hit"))
+	}
+	before := served()
+
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, 10)
+	for i := range responses {
+		wg.Go(func() {
+			responses[i] = cachedGet(handler, "/items", "user:1")
+		})
+	}
+	// Let the requests reach the cache before the first one finishes
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Errorf("expected a single call to the handler, got %d", calls.Load())
+	}
+	for _, rr := range responses {
+		if rr.Body.String() != "done" {
+			t.Errorf("expected every request to get the response, got %q", rr.Body.String())
+		}
+	}
+	// Requests arriving after the first one finished are plain hits
+	if got := served() - before; got != 9 {
+		t.Errorf("expected 9 requests served from the cache, got %v", got)
+	}
+}
+
+func TestResponseCacheSkipsStreamsAndOtherRoutes(t *testing.T) {
+	rc := newTestResponseCache()
+	rc.config.Paths = []string{"/items", "/events"}
+
+	var calls atomic.Int32
+	handler := rc.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("data"))
+		http.NewResponseController(w).Flush()
+	}))
+
+	cachedGet(handler, "/events", "user:1")
+	cachedGet(handler, "/events", "user:1")
+	cachedGet(handler, "/other", "user:1")
+	cachedGet(handler, "/other", "user:1")
+	if calls.Load() != 4 {
+		t.Errorf("expected streams and other routes not to be stored, got %d calls", calls.Load())
+	}
+}
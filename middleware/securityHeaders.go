@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/doug-benn/go-server-starter/utilities"
+)
+
+// RouteHeaders overrides security headers for the requests it matches.
+type RouteHeaders struct {
+	// Methods and Paths select the requests, as for RateLimitPolicy.
+	Methods []string
+	Paths   []string
+	// Headers replace the defaults of the same name, an empty value removes
+	// the header.
+	Headers map[string]string
+}
+
+func (h RouteHeaders) matches(r *http.Request) bool {
+	return (len(h.Methods) == 0 || slices.Contains(h.Methods, r.Method)) &&
+		(len(h.Paths) == 0 || matchPath(h.Paths, r.URL.Path))
+}
+
+// SecurityHeadersConfig configures SecurityHeaders.
+type SecurityHeadersConfig struct {
+	// Headers are set on every response.
+	Headers map[string]string
+	// Routes are checked in order, the first match applies.
+	Routes []RouteHeaders
+}
+
+// DefaultSecurityHeadersConfig returns the configuration read from the
+// environment. The API only serves JSON, so nothing may be loaded or framed; the
+// pprof index under /debug/ needs its inline styles.
+func DefaultSecurityHeadersConfig() SecurityHeadersConfig {
+	hsts := ""
+	maxAge, err := strconv.Atoi(utilities.GetEnvOrDefault("HSTS_MAX_AGE", "63072000"))
+	if err != nil {
+		maxAge = 63072000
+	}
+	if maxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(maxAge) + "; includeSubDomains"
+	}
+
+	return SecurityHeadersConfig{
+		Headers: map[string]string{
+			"Strict-Transport-Security": hsts,
+			"Content-Security-Policy":   utilities.GetEnvOrDefault("CONTENT_SECURITY_POLICY", "default-src 'none'; frame-ancestors 'none'"),
+			"X-Content-Type-Options":    "nosniff",
+			"X-Frame-Options":           "DENY",
+			"Referrer-Policy":           "no-referrer",
+			"Permissions-Policy":        "camera=(), microphone=(), geolocation=(), payment=()",
+		},
+		Routes: []RouteHeaders{
+			{
+				Paths: []string{"/debug/"},
+				Headers: map[string]string{
+					"Content-Security-Policy": "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'",
+				},
+			},
+		},
+	}
+}
+
+// SecurityHeaders sets the configured security headers before passing requests
+// on, so that error responses from later middleware carry them too.
+func SecurityHeaders(config SecurityHeadersConfig) func(http.Handler) http.Handler {
+	// Each route's headers are merged over the defaults once
+	routeHeaders := make([]map[string]string, len(config.Routes))
+	for i, route := range config.Routes {
+		headers := maps.Clone(config.Headers)
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		maps.Copy(headers, route.Headers)
+		routeHeaders[i] = headers
+	}
+
+	headersFor := func(r *http.Request) map[string]string {
+		for i, route := range config.Routes {
+			if route.matches(r) {
+				return routeHeaders[i]
+			}
+		}
+		return config.Headers
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			for name, value := range headersFor(r) {
+				if value != "" {
+					h.Set(name, value)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	t.Setenv("HSTS_MAX_AGE", "3600")
+	handler := SecurityHeaders(DefaultSecurityHeadersConfig())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteProblem(w, http.StatusNotFound, "")
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/todos", nil))
+	expected := map[string]string{
+		"Strict-Transport-Security": "max-age=3600; includeSubDomains",
+		"Content-Security-Policy":   "default-src 'none'; frame-ancestors 'none'",
+		"X-Content-Type-Options":    "nosniff",
+		"X-Frame-Options":           "DENY",
+		"Referrer-Policy":           "no-referrer",
+	}
+	for name, value := range expected {
+		if got := rr.Header().Get(name); got != value {
+			t.Errorf("expected %s %q, got %q", name, value, got)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	if csp := rr.Header().Get("Content-Security-Policy"); csp != "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'" {
+		t.Errorf("expected the route's CSP, got %q", csp)
+	}
+	if rr.Header().Get("X-Frame-Options") != "DENY" {
+		t.Error("expected the other defaults to be kept")
+	}
+}
+
+func TestSecurityHeadersRemoved(t *testing.T) {
+	t.Setenv("HSTS_MAX_AGE", "0")
+	config := DefaultSecurityHeadersConfig()
+	config.Routes = append(config.Routes, RouteHeaders{
+		Paths:   []string{"/embed"},
+		Headers: map[string]string{"X-Frame-Options": ""},
+	})
+	handler := SecurityHeaders(config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/embed", nil))
+	if _, ok := rr.Header()["X-Frame-Options"]; ok {
+		t.Error("expected the route to remove X-Frame-Options")
+	}
+	if _, ok := rr.Header()["Strict-Transport-Security"]; ok {
+		t.Error("expected HSTS to be disabled")
+	}
+}
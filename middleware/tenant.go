@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/database"
+	"github.com/doug-benn/go-server-starter/utilities"
+)
+
+// TenantHeader is read for the tenant when the host has no tenant subdomain.
+const TenantHeader = "X-Tenant-ID"
+
+// TenantStore reports whether a tenant exists.
+type TenantStore interface {
+	TenantExists(ctx context.Context, id string) (bool, error)
+}
+
+// TenantConfig configures how Tenant resolves the tenant of a request.
+type TenantConfig struct {
+	// BaseDomain enables subdomain resolution, a request to acme.example.com
+	// resolves to tenant acme when BaseDomain is example.com.
+	BaseDomain string
+	// Header is read when the host does not name a tenant.
+	Header string
+}
+
+// DefaultTenantConfig returns the configuration read from the environment.
+func DefaultTenantConfig() TenantConfig {
+	return TenantConfig{
+		BaseDomain: utilities.GetEnvOrDefault("TENANT_BASE_DOMAIN", ""),
+		Header:     utilities.GetEnvOrDefault("TENANT_HEADER", TenantHeader),
+	}
+}
+
+// Tenant resolves the tenant of each request from the principal's tenant
+// claim, the subdomain or the tenant header, in that order, and stores it in
+// the request context where the database pool sets it on every connection.
+// A principal bound to a tenant cannot ask for another one, and a principal
+// bound to none may only ask for one if it is an operator. Unknown tenants are
+// rejected. Requests that name no tenant are passed on without one.
+func Tenant(logger *slog.Logger, store TenantStore, config TenantConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested := config.requested(r)
+
+			tenant := requested
+			if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+				if requested != "" && requested != principal.Tenant && !principal.IsOperator() {
+					logger.WarnContext(r.Context(), "tenant mismatch",
+						slog.String("principal", principal.ID),
+						slog.String("tenant", principal.Tenant),
+						slog.String("requested", requested),
+					)
+					WriteProblem(w, http.StatusForbidden, "credential is not valid for tenant "+requested)
+					return
+				}
+				if principal.Tenant != "" {
+					tenant = principal.Tenant
+				}
+			}
+			if tenant == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			exists, err := store.TenantExists(r.Context(), tenant)
+			if err != nil {
+				logger.ErrorContext(r.Context(), "tenant lookup error", slog.Any("error", err))
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if !exists {
+				WriteProblem(w, http.StatusNotFound, "unknown tenant "+tenant)
+				return
+			}
+
+			logTenant(r, tenant)
+			next.ServeHTTP(w, r.WithContext(database.WithTenant(r.Context(), tenant)))
+		})
+	}
+}
+
+// requested returns the tenant named by the host or header, if any.
+func (c TenantConfig) requested(r *http.Request) string {
+	if c.BaseDomain != "" {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		// Only a single label directly below the base domain names a tenant
+		sub, ok := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(c.BaseDomain))
+		if ok && sub != "" && !strings.Contains(sub, ".") {
+			return sub
+		}
+	}
+	if c.Header != "" {
+		return strings.TrimSpace(r.Header.Get(c.Header))
+	}
+	return ""
+}
@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/database"
+)
+
+type testTenantStore map[string]bool
+
+func (s testTenantStore) TenantExists(ctx context.Context, id string) (bool, error) {
+	if id == "broken" {
+		return false, errors.New("database down")
+	}
+	return s[id], nil
+}
+
+func tenantHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tenant, ok := database.TenantFromContext(r.Context()); ok {
+			w.Write([]byte(tenant))
+			return
+		}
+		w.Write([]byte("none"))
+	})
+}
+
+func TestTenant(t *testing.T) {
+	store := testTenantStore{"acme": true, "globex": true}
+	config := TenantConfig{BaseDomain: "example.com", Header: TenantHeader}
+
+	tests := []struct {
+		name           string
+		host           string
+		header         string
+		principal      *auth.Principal
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"no tenant", "example.com", "", nil, http.StatusOK, "none"},
+		{"subdomain", "acme.example.com", "", nil, http.StatusOK, "acme"},
+		{"subdomain with port", "acme.example.com:9200", "", nil, http.StatusOK, "acme"},
+		{"nested subdomain is ignored", "a.acme.example.com", "", nil, http.StatusOK, "none"},
+		{"header", "example.com", "globex", nil, http.StatusOK, "globex"},
+		{"subdomain wins over header", "acme.example.com", "globex", nil, http.StatusOK, "acme"},
+		{"claim", "example.com", "", &auth.Principal{ID: "user:1", Tenant: "acme"}, http.StatusOK, "acme"},
+		{"claim matching header", "example.com", "acme", &auth.Principal{ID: "user:1", Tenant: "acme"}, http.StatusOK, "acme"},
+		{"claim for another tenant", "globex.example.com", "", &auth.Principal{ID: "user:1", Tenant: "acme"}, http.StatusForbidden, ""},
+		{"unbound principal cannot pick tenant", "example.com", "globex", &auth.Principal{ID: "api_key:1"}, http.StatusForbidden, ""},
+		{"unbound principal on a subdomain", "acme.example.com", "", &auth.Principal{ID: "user:2"}, http.StatusForbidden, ""},
+		{"unbound principal without tenant", "example.com", "", &auth.Principal{ID: "api_key:1"}, http.StatusOK, "none"},
+		{"operator picks tenant", "example.com", "globex", &auth.Principal{ID: "api_key:1", Scopes: []string{auth.ScopeOperator}}, http.StatusOK, "globex"},
+		{"operator role picks tenant", "acme.example.com", "", &auth.Principal{ID: "user:2", Roles: []string{auth.ScopeOperator}}, http.StatusOK, "acme"},
+		{"unknown tenant", "example.com", "initech", nil, http.StatusNotFound, ""},
+		{"store error", "example.com", "broken", nil, http.StatusInternalServerError, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/todos", nil)
+			req.Host = tt.host
+			if tt.header != "" {
+				req.Header.Set(TenantHeader, tt.header)
+			}
+			if tt.principal != nil {
+				req = req.WithContext(auth.WithPrincipal(req.Context(), tt.principal))
+			}
+			rr := httptest.NewRecorder()
+			Tenant(slog.Default(), store, config)(tenantHandler()).ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedBody != "" {
+				assert.Equal(t, tt.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestAuthorizeRequiresTenant(t *testing.T) {
+	policy := auth.RequireScopes(auth.ScopeTodosRead).RequireTenant()
+	principal := &auth.Principal{ID: "user:1", Scopes: []string{auth.ScopeTodosRead}}
+
+	req := httptest.NewRequest("GET", "/todos", nil)
+	req = req.WithContext(auth.WithPrincipal(req.Context(), principal))
+	rr := httptest.NewRecorder()
+	Authorize(policy)(okHandler()).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	req = req.WithContext(database.WithTenant(req.Context(), "acme"))
+	rr = httptest.NewRecorder()
+	Authorize(policy)(okHandler()).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/utilities"
+)
+
+// RouteTimeout sets the timeout of the requests it matches, zero disables it
+// for streaming routes.
+type RouteTimeout struct {
+	// Methods and Paths select the requests, as for RateLimitPolicy.
+	Methods []string
+	Paths   []string
+	Timeout time.Duration
+}
+
+func (t RouteTimeout) matches(r *http.Request) bool {
+	return (len(t.Methods) == 0 || slices.Contains(t.Methods, r.Method)) &&
+		(len(t.Paths) == 0 || matchPath(t.Paths, r.URL.Path))
+}
+
+// TimeoutConfig configures Timeout.
+type TimeoutConfig struct {
+	// Default applies to requests no route matches.
+	Default time.Duration
+	// Routes are checked in order, the first match applies.
+	Routes []RouteTimeout
+}
+
+// DefaultTimeoutConfig returns the configuration read from the environment.
+// SSE streams and profiles, which take as long as they are asked to, have no
+// timeout.
+func DefaultTimeoutConfig() TimeoutConfig {
+	timeout, err := time.ParseDuration(utilities.GetEnvOrDefault("REQUEST_TIMEOUT", "30s"))
+	if err != nil {
+		timeout = 30 * time.Second
+	}
+	return TimeoutConfig{
+		Default: timeout,
+		Routes: []RouteTimeout{
+			{Paths: []string{"/events", "/debug/pprof/profile", "/debug/pprof/trace"}, Timeout: 0},
+		},
+	}
+}
+
+func (c TimeoutConfig) timeout(r *http.Request) time.Duration {
+	for _, route := range c.Routes {
+		if route.matches(r) {
+			return route.Timeout
+		}
+	}
+	return c.Default
+}
+
+// Timeout cancels the request context once the route's timeout passes. If the
+// handler has not started its response by then, a 503 problem is sent in its
+// place and anything the handler writes afterwards is discarded; a response
+// already started is left to the handler to finish or abandon.
+func Timeout(logger *slog.Logger, config TimeoutConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout := config.timeout(r)
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			tw := &timeoutWriter{w: w, header: w.Header().Clone(), ctx: ctx, onTimeout: func() {
+				logger.WarnContext(ctx, "request timed out", "method", r.Method, "path", r.URL.Path, "timeout", timeout)
+			}}
+			timer := time.AfterFunc(timeout, tw.timeout)
+
+			next.ServeHTTP(tw, r.WithContext(ctx))
+
+			// Taking the lock waits for a timeout response being written
+			timer.Stop()
+			tw.mu.Lock()
+			tw.done = true
+			tw.mu.Unlock()
+		})
+	}
+}
+
+// timeoutWriter lets the timeout respond in the handler's place until the
+// handler starts its response. The handler works on a copy of the headers set so
+// far until then, so that the two never touch the same header map.
+type timeoutWriter struct {
+	w         http.ResponseWriter
+	header    http.Header
+	ctx       context.Context
+	onTimeout func()
+
+	mu          sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+	done        bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.writeHeader(status)
+}
+
+func (tw *timeoutWriter) writeHeader(status int) {
+	tw.checkDeadline()
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+	dst := tw.w.Header()
+	clear(dst)
+	for k, v := range tw.header {
+		dst[k] = v
+	}
+	tw.w.WriteHeader(status)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.checkDeadline()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.writeHeader(http.StatusOK)
+	return tw.w.Write(b)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.checkDeadline()
+	if tw.timedOut {
+		return
+	}
+	if f, ok := tw.w.(http.Flusher); ok {
+		tw.writeHeader(http.StatusOK)
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.w
+}
+
+// timeout responds with a problem if the handler has not started its response.
+func (tw *timeoutWriter) timeout() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if !tw.done {
+		tw.respondTimeout()
+	}
+}
+
+// checkDeadline times out a handler that writes after its deadline but before
+// the timer has fired, which can happen as both fire at the same time. It must
+// be called with tw.mu held.
+func (tw *timeoutWriter) checkDeadline() {
+	if errors.Is(tw.ctx.Err(), context.DeadlineExceeded) {
+		tw.respondTimeout()
+	}
+}
+
+func (tw *timeoutWriter) respondTimeout() {
+	if tw.wroteHeader || tw.timedOut {
+		return
+	}
+	tw.timedOut = true
+	tw.w.Header().Set("Retry-After", "1")
+	WriteProblem(tw.w, http.StatusServiceUnavailable, "request timed out")
+	tw.onTimeout()
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	config := TimeoutConfig{
+		Default: 20 * time.Millisecond,
+		Routes:  []RouteTimeout{{Paths: []string{"/events"}, Timeout: 0}},
+	}
+
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		w.Header().Set("X-Late", "true")
+		http.Error(w, "too late", http.StatusInternalServerError)
+	})
+	rr := httptest.NewRecorder()
+	Timeout(logger, config)(slow).ServeHTTP(rr, httptest.NewRequest("GET", "/todos", nil))
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("expected a 503 problem, got %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if rr.Header().Get("X-Late") != "" || strings.Contains(rr.Body.String(), "too late") {
+		t.Error("expected the handler's late response to be discarded")
+	}
+
+	fast := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); !ok {
+			t.Error("expected the request context to have a deadline")
+		}
+		w.Header().Set("X-Fast", "true")
+		w.WriteHeader(http.StatusCreated)
+	})
+	rr = httptest.NewRecorder()
+	Timeout(logger, config)(fast).ServeHTTP(rr, httptest.NewRequest("GET", "/todos", nil))
+	if rr.Code != http.StatusCreated || rr.Header().Get("X-Fast") != "true" {
+		t.Errorf("expected the handler's response, got %d", rr.Code)
+	}
+}
+
+func TestTimeoutStartedResponse(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("expected flushing to be supported, got %v", err)
+		}
+		<-r.Context().Done()
+	})
+
+	rr := httptest.NewRecorder()
+	Timeout(logger, TimeoutConfig{Default: 20 * time.Millisecond})(handler).ServeHTTP(rr, httptest.NewRequest("GET", "/todos", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "partial" {
+		t.Errorf("expected a started response to be left alone, got %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestTimeoutOptOut(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); ok {
+			t.Error("expected streaming routes to have no deadline")
+		}
+	})
+
+	config := TimeoutConfig{
+		Default: time.Second,
+		Routes:  []RouteTimeout{{Paths: []string{"/events"}, Timeout: 0}},
+	}
+	Timeout(logger, config)(handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/events", nil))
+}
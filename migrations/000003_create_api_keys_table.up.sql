@@ -0,0 +1,13 @@
+CREATE TABLE api_keys (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    -- First characters of the key, shown in listings to identify it
+    prefix TEXT NOT NULL,
+    -- SHA-256 of the full key, the key itself is never stored
+    key_hash TEXT NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS todos_owner_id_created_at_idx;
+ALTER TABLE todos DROP COLUMN IF EXISTS owner_id;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,16 @@
+CREATE TABLE users (
+    id SERIAL PRIMARY KEY,
+    -- Principal ID the user authenticates as, such as "user:<sub>" or "api_key:<id>"
+    subject TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Todos created before ownership existed are assigned to the system user
+INSERT INTO users (subject, name) VALUES ('system', 'System');
+
+ALTER TABLE todos ADD COLUMN owner_id INTEGER REFERENCES users (id) ON DELETE CASCADE;
+UPDATE todos SET owner_id = (SELECT id FROM users WHERE subject = 'system');
+ALTER TABLE todos ALTER COLUMN owner_id SET NOT NULL;
+
+CREATE INDEX todos_owner_id_created_at_idx ON todos (owner_id, created_at DESC);
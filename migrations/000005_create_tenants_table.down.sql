@@ -0,0 +1,47 @@
+CREATE OR REPLACE FUNCTION notify_event()
+    RETURNS trigger
+    LANGUAGE 'plpgsql'
+AS $$
+    DECLARE 
+        data jsonb;
+        notification jsonb;
+
+    BEGIN
+        IF (TG_OP = 'DELETE') THEN
+            data = to_jsonb(OLD);
+        ELSE 
+            data = to_jsonb(NEW);
+        END IF;
+
+        notification = jsonb_build_object(
+            'table',
+            TG_TABLE_NAME,
+            'action',
+            TG_OP,
+            'timestamp',
+            NOW(),
+            'record',
+            data
+        );
+
+        BEGIN
+                PERFORM pg_notify('events', notification::text);
+            EXCEPTION WHEN OTHERS THEN
+                RAISE WARNING 'Notification failed: %', SQLERRM;
+        END;
+
+        RETURN NULL;
+    END;
+$$;
+
+DROP POLICY IF EXISTS todos_tenant_isolation ON todos;
+ALTER TABLE todos NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE todos DISABLE ROW LEVEL SECURITY;
+
+ALTER TABLE api_keys DROP COLUMN IF EXISTS tenant_id;
+
+DROP INDEX IF EXISTS todos_tenant_id_owner_id_created_at_idx;
+CREATE INDEX todos_owner_id_created_at_idx ON todos (owner_id, created_at DESC);
+ALTER TABLE todos DROP COLUMN IF EXISTS tenant_id;
+
+DROP TABLE IF EXISTS tenants;
@@ -0,0 +1,71 @@
+CREATE TABLE tenants (
+    -- Short identifier used in subdomains, the X-Tenant-ID header and token claims
+    id TEXT PRIMARY KEY CHECK (id ~ '^[a-z0-9][a-z0-9-]*$'),
+    name TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Existing todos are moved into a default tenant
+INSERT INTO tenants (id, name) VALUES ('default', 'Default');
+
+-- New todos belong to the tenant of the connection that inserts them, which the
+-- application sets per request as app.tenant_id
+ALTER TABLE todos ADD COLUMN tenant_id TEXT REFERENCES tenants (id) ON DELETE CASCADE;
+UPDATE todos SET tenant_id = 'default';
+ALTER TABLE todos ALTER COLUMN tenant_id SET NOT NULL;
+ALTER TABLE todos ALTER COLUMN tenant_id SET DEFAULT NULLIF(current_setting('app.tenant_id', true), '');
+
+DROP INDEX IF EXISTS todos_owner_id_created_at_idx;
+CREATE INDEX todos_tenant_id_owner_id_created_at_idx ON todos (tenant_id, owner_id, created_at DESC);
+
+-- API keys may be bound to a tenant, keys without one are operator keys
+ALTER TABLE api_keys ADD COLUMN tenant_id TEXT REFERENCES tenants (id) ON DELETE CASCADE;
+
+-- Rows are only visible to, and can only be written by, connections whose
+-- app.tenant_id matches. FORCE applies the policy to the table owner as well;
+-- superusers and roles with BYPASSRLS still bypass it, so the application must
+-- not connect as one.
+ALTER TABLE todos ENABLE ROW LEVEL SECURITY;
+ALTER TABLE todos FORCE ROW LEVEL SECURITY;
+CREATE POLICY todos_tenant_isolation ON todos
+    USING (tenant_id = current_setting('app.tenant_id', true))
+    WITH CHECK (tenant_id = current_setting('app.tenant_id', true));
+
+-- Notifications carry the tenant so listeners can fan them out per tenant
+CREATE OR REPLACE FUNCTION notify_event()
+    RETURNS trigger
+    LANGUAGE 'plpgsql'
+AS $$
+    DECLARE 
+        data jsonb;
+        notification jsonb;
+
+    BEGIN
+        IF (TG_OP = 'DELETE') THEN
+            data = to_jsonb(OLD);
+        ELSE 
+            data = to_jsonb(NEW);
+        END IF;
+
+        notification = jsonb_build_object(
+            'table',
+            TG_TABLE_NAME,
+            'action',
+            TG_OP,
+            'timestamp',
+            NOW(),
+            'tenant_id',
+            data->>'tenant_id',
+            'record',
+            data
+        );
+
+        BEGIN
+                PERFORM pg_notify('events', notification::text);
+            EXCEPTION WHEN OTHERS THEN
+                RAISE WARNING 'Notification failed: %', SQLERRM;
+        END;
+
+        RETURN NULL;
+    END;
+$$;
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS sessions;
+
+ALTER TABLE users DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE users DROP COLUMN IF EXISTS scopes;
+ALTER TABLE users DROP COLUMN IF EXISTS password_hash;
+ALTER TABLE users DROP COLUMN IF EXISTS username;
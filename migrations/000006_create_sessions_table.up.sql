@@ -0,0 +1,19 @@
+-- Users that sign in to the web UI with a password. Their subject is
+-- "local:<username>" and they are granted scopes directly.
+ALTER TABLE users ADD COLUMN username TEXT UNIQUE;
+ALTER TABLE users ADD COLUMN password_hash TEXT;
+ALTER TABLE users ADD COLUMN scopes TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE users ADD COLUMN tenant_id TEXT REFERENCES tenants (id) ON DELETE CASCADE;
+
+CREATE TABLE sessions (
+    -- SHA-256 of the session token, the token itself is only sent in the cookie
+    id TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    -- Absolute expiry, idle expiry is derived from last_seen_at
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions (user_id);
+CREATE INDEX sessions_expires_at_idx ON sessions (expires_at);
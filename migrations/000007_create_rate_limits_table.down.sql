@@ -0,0 +1,2 @@
+DROP FUNCTION IF EXISTS take_rate_limit;
+DROP TABLE IF EXISTS rate_limits;
@@ -0,0 +1,54 @@
+-- Rate limit buckets shared by every replica. Each bucket is tracked with the
+-- generic cell rate algorithm: tat is the theoretical arrival time, in
+-- microseconds since the epoch, at which the bucket would be full again.
+-- Losing the table in a crash only resets limits, so it is not WAL logged.
+CREATE UNLOGGED TABLE rate_limits (
+    key TEXT PRIMARY KEY,
+    tat BIGINT NOT NULL
+);
+
+CREATE INDEX rate_limits_tat_idx ON rate_limits (tat);
+
+-- take_rate_limit takes up to cost requests from a bucket refilled every
+-- emission_interval microseconds that holds burst requests. At least one
+-- request is taken if it would be allowed within max_wait microseconds.
+-- Returns how many were taken, zero when rejected, and the bucket's tat.
+CREATE OR REPLACE FUNCTION take_rate_limit(
+    bucket TEXT,
+    now_us BIGINT,
+    emission_interval BIGINT,
+    burst INTEGER,
+    max_wait BIGINT,
+    cost INTEGER
+)
+    RETURNS TABLE (granted INTEGER, tat BIGINT)
+    LANGUAGE 'plpgsql'
+AS $$
+    DECLARE
+        current_tat BIGINT;
+        available BIGINT;
+
+    BEGIN
+        INSERT INTO rate_limits (key, tat) VALUES (bucket, now_us)
+        ON CONFLICT (key) DO NOTHING;
+
+        SELECT GREATEST(r.tat, now_us) INTO current_tat
+        FROM rate_limits r
+        WHERE r.key = bucket
+        FOR UPDATE;
+
+        available := LEAST(cost, (now_us + burst * emission_interval - current_tat) / emission_interval);
+        IF available < 1 THEN
+            IF current_tat + emission_interval - burst * emission_interval > now_us + max_wait THEN
+                RETURN QUERY SELECT 0, current_tat;
+                RETURN;
+            END IF;
+            available := 1;
+        END IF;
+
+        current_tat := current_tat + available * emission_interval;
+        UPDATE rate_limits r SET tat = current_tat WHERE r.key = bucket;
+
+        RETURN QUERY SELECT available::INTEGER, current_tat;
+    END;
+$$;
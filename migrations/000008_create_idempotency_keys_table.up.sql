@@ -0,0 +1,19 @@
+-- Responses to requests sent with an Idempotency-Key, replayed when the
+-- request is retried
+CREATE TABLE idempotency_keys (
+    -- The client's key, scoped to the principal that sent it
+    key TEXT PRIMARY KEY,
+    -- SHA-256 of the method, path and body of the first request
+    fingerprint TEXT NOT NULL,
+    -- NULL while the first request is in flight
+    status_code INTEGER,
+    headers JSONB,
+    body BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    -- An unfinished request may be taken over after this, in case the replica
+    -- handling it stopped
+    locked_until TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
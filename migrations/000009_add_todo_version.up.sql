@@ -0,0 +1,2 @@
+-- Incremented on every update, so that clients can detect concurrent edits
+ALTER TABLE todos ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,10 @@
+-- Cache entries shared by every replica. Losing them in a crash only costs
+-- cache misses, so the table is not WAL logged.
+CREATE UNLOGGED TABLE cache_entries (
+    key TEXT PRIMARY KEY,
+    value BYTEA NOT NULL,
+    -- NULL for entries that do not expire
+    expires_at TIMESTAMPTZ
+);
+
+CREATE INDEX cache_entries_expires_at_idx ON cache_entries (expires_at);
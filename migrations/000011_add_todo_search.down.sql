@@ -0,0 +1,40 @@
+CREATE OR REPLACE FUNCTION notify_event()
+    RETURNS trigger
+    LANGUAGE 'plpgsql'
+AS $$
+    DECLARE 
+        data jsonb;
+        notification jsonb;
+
+    BEGIN
+        IF (TG_OP = 'DELETE') THEN
+            data = to_jsonb(OLD);
+        ELSE 
+            data = to_jsonb(NEW);
+        END IF;
+
+        notification = jsonb_build_object(
+            'table',
+            TG_TABLE_NAME,
+            'action',
+            TG_OP,
+            'timestamp',
+            NOW(),
+            'tenant_id',
+            data->>'tenant_id',
+            'record',
+            data
+        );
+
+        BEGIN
+                PERFORM pg_notify('events', notification::text);
+            EXCEPTION WHEN OTHERS THEN
+                RAISE WARNING 'Notification failed: %', SQLERRM;
+        END;
+
+        RETURN NULL;
+    END;
+$$;
+
+DROP INDEX IF EXISTS todos_search_vector_idx;
+ALTER TABLE todos DROP COLUMN IF EXISTS search_vector;
@@ -0,0 +1,47 @@
+-- Titles rank above descriptions in search results
+ALTER TABLE todos ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('english', title), 'A') ||
+    setweight(to_tsvector('english', description), 'B')
+) STORED;
+
+CREATE INDEX todos_search_vector_idx ON todos USING GIN (search_vector);
+
+-- The search vector is left out of notifications, it only repeats the title
+-- and description and notifications are limited to 8000 bytes
+CREATE OR REPLACE FUNCTION notify_event()
+    RETURNS trigger
+    LANGUAGE 'plpgsql'
+AS $$
+    DECLARE 
+        data jsonb;
+        notification jsonb;
+
+    BEGIN
+        IF (TG_OP = 'DELETE') THEN
+            data = to_jsonb(OLD) - 'search_vector';
+        ELSE 
+            data = to_jsonb(NEW) - 'search_vector';
+        END IF;
+
+        notification = jsonb_build_object(
+            'table',
+            TG_TABLE_NAME,
+            'action',
+            TG_OP,
+            'timestamp',
+            NOW(),
+            'tenant_id',
+            data->>'tenant_id',
+            'record',
+            data
+        );
+
+        BEGIN
+                PERFORM pg_notify('events', notification::text);
+            EXCEPTION WHEN OTHERS THEN
+                RAISE WARNING 'Notification failed: %', SQLERRM;
+        END;
+
+        RETURN NULL;
+    END;
+$$;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS webhook_subscriptions_tenant_id_idx;
+ALTER TABLE webhook_subscriptions DROP COLUMN IF EXISTS tenant_id;
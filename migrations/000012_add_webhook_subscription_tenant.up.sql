@@ -0,0 +1,5 @@
+-- Subscriptions bound to a tenant only receive its events, those without one
+-- are operator subscriptions and receive every event
+ALTER TABLE webhook_subscriptions ADD COLUMN tenant_id TEXT REFERENCES tenants (id) ON DELETE CASCADE;
+
+CREATE INDEX webhook_subscriptions_tenant_id_idx ON webhook_subscriptions (tenant_id) WHERE active;
@@ -5,9 +5,59 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
+type ApiKey struct {
+	ID         int32      `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	TenantID   *string    `json:"tenant_id"`
+}
+
+type CacheEntry struct {
+	Key       string     `json:"key"`
+	Value     []byte     `json:"value"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type IdempotencyKey struct {
+	Key         string    `json:"key"`
+	Fingerprint string    `json:"fingerprint"`
+	StatusCode  *int32    `json:"status_code"`
+	Headers     []byte    `json:"headers"`
+	Body        []byte    `json:"body"`
+	CreatedAt   time.Time `json:"created_at"`
+	LockedUntil time.Time `json:"locked_until"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type RateLimit struct {
+	Key string `json:"key"`
+	Tat int64  `json:"tat"`
+}
+
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int32     `json:"user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type Tenant struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
 type Todo struct {
 	ID          int32     `json:"id"`
 	Title       string    `json:"title"`
@@ -15,4 +65,44 @@ type Todo struct {
 	Completed   bool      `json:"completed"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+	OwnerID     int32     `json:"owner_id"`
+	TenantID    string    `json:"tenant_id"`
+	Version     int32     `json:"version"`
+}
+
+type User struct {
+	ID           int32     `json:"id"`
+	Subject      string    `json:"subject"`
+	Name         string    `json:"name"`
+	CreatedAt    time.Time `json:"created_at"`
+	Username     *string   `json:"username"`
+	PasswordHash *string   `json:"-"`
+	Scopes       []string  `json:"scopes"`
+	TenantID     *string   `json:"tenant_id"`
+}
+
+type WebhookDelivery struct {
+	ID             int32           `json:"id"`
+	SubscriptionID int32           `json:"subscription_id"`
+	EventKey       string          `json:"event_key"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int32           `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastStatusCode int32           `json:"last_status_code"`
+	LastError      string          `json:"last_error"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+type WebhookSubscription struct {
+	ID         int32     `json:"id"`
+	Url        string    `json:"url"`
+	Secret     string    `json:"-"`
+	EventTypes []string  `json:"event_types"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	TenantID   *string   `json:"tenant_id"`
 }
@@ -0,0 +1,125 @@
+package producer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+const (
+	eventBusBuffer = 100
+
+	// busRetryDelay doubles after each failed subscription, up to busMaxRetryDelay.
+	busRetryDelay    = time.Second
+	busMaxRetryDelay = 30 * time.Second
+)
+
+// Envelope wraps an event relayed over a Bus with the ID of the node that
+// produced it, so that a node can ignore its own events.
+type Envelope[T any] struct {
+	NodeID string `json:"node_id"`
+	Event  T      `json:"event"`
+}
+
+// Bus relays events between producers running on different nodes.
+type Bus[T any] interface {
+	// Publish sends an envelope to every node subscribed to the bus, including the sender.
+	Publish(ctx context.Context, envelope Envelope[T]) error
+	// Subscribe returns a channel of envelopes published by any node.
+	// The channel is closed once ctx is cancelled.
+	Subscribe(ctx context.Context) (<-chan Envelope[T], error)
+}
+
+// WithBus relays events passed to Publish to the producers on other nodes
+// through the given bus.
+func WithBus[T any](bus Bus[T]) ProducerOpt[T] {
+	return func(ep *Producer[T]) {
+		ep.bus = bus
+	}
+}
+
+// WithNodeID sets the ID used to recognise this node's own events on the bus.
+// A random ID is generated when not set.
+func WithNodeID[T any](nodeID string) ProducerOpt[T] {
+	return func(ep *Producer[T]) {
+		if nodeID != "" {
+			ep.nodeID = nodeID
+		}
+	}
+}
+
+// NodeID returns the ID this producer uses on the bus.
+func (ep *Producer[T]) NodeID() string {
+	return ep.nodeID
+}
+
+// Publish broadcasts an event to local subscribers and relays it to the other
+// nodes on the bus. Use Broadcast for events that every node already receives
+// on its own, such as database notifications. If relaying fails, the other
+// nodes are sent the resync event as soon as the bus accepts it.
+func (ep *Producer[T]) Publish(ctx context.Context, event T) error {
+	ep.Broadcast(ctx, event)
+
+	if ep.bus == nil {
+		return nil
+	}
+	err := ep.bus.Publish(ctx, Envelope[T]{NodeID: ep.nodeID, Event: event})
+	if err != nil {
+		ep.busMissed.Store(true)
+	}
+	if ep.resync != nil && ep.busMissed.CompareAndSwap(true, false) {
+		if err := ep.bus.Publish(ctx, Envelope[T]{NodeID: ep.nodeID, Event: ep.resync()}); err != nil {
+			ep.logger.Error("failed to publish resync to event bus", "error", err)
+			ep.busMissed.Store(true)
+		}
+	}
+	return err
+}
+
+// subscribeBus subscribes to the bus, retrying with backoff until it succeeds
+// or ctx is done, and relays its events. Events from other nodes are missed
+// while the subscription is failing, so local subscribers are sent the resync
+// event once it is back.
+func (ep *Producer[T]) subscribeBus(ctx context.Context) {
+	delay := ep.busRetryDelay
+	for failed := false; ; failed = true {
+		envelopes, err := ep.bus.Subscribe(ctx)
+		if err == nil {
+			if failed {
+				ep.logger.Info("event bus subscribed")
+				if ep.resync != nil {
+					ep.Broadcast(ctx, ep.resync())
+				}
+			}
+			ep.relay(ctx, envelopes)
+			return
+		}
+
+		ep.logger.Warn("failed to subscribe to event bus, retrying", "error", err, "retry_delay", delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay = min(delay*2, busMaxRetryDelay)
+	}
+}
+
+// relay broadcasts events from other nodes to local subscribers until the bus
+// channel is closed.
+func (ep *Producer[T]) relay(ctx context.Context, envelopes <-chan Envelope[T]) {
+	for envelope := range envelopes {
+		if envelope.NodeID == ep.nodeID {
+			// Already delivered locally by Publish
+			continue
+		}
+		ep.Broadcast(ctx, envelope.Event)
+	}
+}
+
+func newNodeID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,168 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startNodes starts n producers sharing one in-memory bus and waits until
+// each is subscribed to it.
+func startNodes(t *testing.T, n int) []*Producer[int] {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	bus := NewMemoryBus[int]()
+	nodes := make([]*Producer[int], n)
+	for i := range nodes {
+		nodes[i] = NewProducer(WithBus[int](bus))
+		go nodes[i].Start(ctx)
+	}
+
+	require.Eventually(t, func() bool { return bus.subscribers() == n }, time.Second, 5*time.Millisecond)
+	return nodes
+}
+
+func requireNoEvent(t *testing.T, sub *Subscription[int]) {
+	t.Helper()
+	select {
+	case ev := <-sub.Events():
+		t.Fatalf("unexpected event %d", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPublishFansOutToOtherNodes(t *testing.T) {
+	nodes := startNodes(t, 3)
+
+	subs := make([]*Subscription[int], len(nodes))
+	for i, node := range nodes {
+		subs[i] = node.Subscribe(10)
+	}
+
+	require.NoError(t, nodes[0].Publish(context.Background(), 42))
+
+	for i, sub := range subs {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		event, err := sub.Next(ctx)
+		cancel()
+		require.NoError(t, err, "node %d", i)
+		require.Equal(t, 42, event, "node %d", i)
+	}
+
+	// The publishing node must not re-deliver its own event from the bus
+	for _, sub := range subs {
+		requireNoEvent(t, sub)
+	}
+}
+
+func TestBroadcastStaysLocal(t *testing.T) {
+	nodes := startNodes(t, 2)
+
+	local := nodes[0].Subscribe(10)
+	remote := nodes[1].Subscribe(10)
+
+	nodes[0].Broadcast(context.Background(), 7)
+
+	event, err := local.Next(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 7, event)
+
+	requireNoEvent(t, remote)
+}
+
+func TestPublishWithoutBus(t *testing.T) {
+	producer := NewProducer[int]()
+	sub := producer.Subscribe(1)
+
+	require.NoError(t, producer.Publish(context.Background(), 1))
+
+	event, err := sub.Next(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, event)
+}
+
+func TestWithNodeID(t *testing.T) {
+	require.Equal(t, "node-a", NewProducer(WithNodeID[int]("node-a")).NodeID())
+	require.NotEqual(t, NewProducer[int]().NodeID(), NewProducer[int]().NodeID())
+}
+
+// failingBus refuses one event, like a bus refusing a payload that is too large.
+type failingBus struct {
+	*MemoryBus[int]
+	reject int
+}
+
+func (b failingBus) Publish(ctx context.Context, envelope Envelope[int]) error {
+	if envelope.Event == b.reject {
+		return errors.New("rejected")
+	}
+	return b.MemoryBus.Publish(ctx, envelope)
+}
+
+func TestPublishFailureSendsResync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	bus := NewMemoryBus[int]()
+	sender := NewProducer(WithBus[int](failingBus{MemoryBus: bus, reject: 13}), WithResync(func() int { return -1 }))
+	receiver := NewProducer(WithBus[int](bus))
+	go sender.Start(ctx)
+	go receiver.Start(ctx)
+	require.Eventually(t, func() bool { return bus.subscribers() == 2 }, time.Second, 5*time.Millisecond)
+
+	sub := receiver.Subscribe(10)
+	require.Error(t, sender.Publish(context.Background(), 13))
+
+	nextCtx, nextCancel := context.WithTimeout(context.Background(), time.Second)
+	defer nextCancel()
+	event, err := sub.Next(nextCtx)
+	require.NoError(t, err)
+	require.Equal(t, -1, event)
+}
+
+// flakyBus fails to subscribe a number of times before it succeeds.
+type flakyBus struct {
+	*MemoryBus[int]
+	failures atomic.Int32
+}
+
+func (b *flakyBus) Subscribe(ctx context.Context) (<-chan Envelope[int], error) {
+	if b.failures.Add(-1) >= 0 {
+		return nil, errors.New("unavailable")
+	}
+	return b.MemoryBus.Subscribe(ctx)
+}
+
+func TestStartRetriesBusSubscriptionAndResyncs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	bus := NewMemoryBus[int]()
+	flaky := &flakyBus{MemoryBus: bus}
+	flaky.failures.Store(2)
+	node := NewProducer(WithBus[int](flaky), WithResync(func() int { return -1 }))
+	node.busRetryDelay = time.Millisecond
+	sub := node.Subscribe(10)
+	go node.Start(ctx)
+
+	require.Eventually(t, func() bool { return bus.subscribers() == 1 }, time.Second, 5*time.Millisecond)
+
+	nextCtx, nextCancel := context.WithTimeout(context.Background(), time.Second)
+	defer nextCancel()
+	event, err := sub.Next(nextCtx)
+	require.NoError(t, err)
+	require.Equal(t, -1, event, "events missed while unsubscribed must be resynced")
+
+	// Events from other nodes are relayed once subscribed
+	require.NoError(t, bus.Publish(context.Background(), Envelope[int]{NodeID: "other", Event: 7}))
+	event, err = sub.Next(nextCtx)
+	require.NoError(t, err)
+	require.Equal(t, 7, event)
+}
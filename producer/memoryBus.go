@@ -0,0 +1,57 @@
+package producer
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryBus is an in-process Bus. Producers sharing one MemoryBus behave like
+// nodes sharing a real bus, which makes it useful for tests.
+type MemoryBus[T any] struct {
+	mu   sync.RWMutex
+	subs map[chan Envelope[T]]struct{}
+}
+
+func NewMemoryBus[T any]() *MemoryBus[T] {
+	return &MemoryBus[T]{subs: make(map[chan Envelope[T]]struct{})}
+}
+
+// Publish delivers the envelope to every subscriber, blocking until each has
+// accepted it or ctx is done.
+func (b *MemoryBus[T]) Publish(ctx context.Context, envelope Envelope[T]) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- envelope:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBus[T]) Subscribe(ctx context.Context) (<-chan Envelope[T], error) {
+	ch := make(chan Envelope[T], eventBusBuffer)
+
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		delete(b.subs, ch)
+		close(ch)
+		b.mu.Unlock()
+	}()
+
+	return ch, nil
+}
+
+func (b *MemoryBus[T]) subscribers() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subs)
+}
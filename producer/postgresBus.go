@@ -0,0 +1,124 @@
+package producer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/database"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// maxNotifyPayload is the largest payload Postgres accepts for NOTIFY.
+	maxNotifyPayload  = 7999
+	busReconnectDelay = time.Second
+)
+
+// ErrPayloadTooLarge is returned by PostgresBus.Publish when the encoded event
+// does not fit in a NOTIFY payload.
+var ErrPayloadTooLarge = errors.New("event bus payload exceeds the NOTIFY limit")
+
+// PostgresBus is a Bus that relays JSON encoded events over Postgres LISTEN/NOTIFY.
+type PostgresBus[T any] struct {
+	pool    *pgxpool.Pool
+	channel string
+	logger  *slog.Logger
+}
+
+func NewPostgresBus[T any](pool *pgxpool.Pool, channel string, logger *slog.Logger) *PostgresBus[T] {
+	return &PostgresBus[T]{pool: pool, channel: channel, logger: logger}
+}
+
+func (b *PostgresBus[T]) Publish(ctx context.Context, envelope Envelope[T]) error {
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	if len(payload) > maxNotifyPayload {
+		return ErrPayloadTooLarge
+	}
+
+	_, err = b.pool.Exec(ctx, "SELECT pg_notify($1, $2)", b.channel, string(payload))
+	return err
+}
+
+// Subscribe listens on the bus channel with a dedicated connection, which is
+// re-established if it is lost.
+func (b *PostgresBus[T]) Subscribe(ctx context.Context) (<-chan Envelope[T], error) {
+	listener := database.NewListener(b.pool)
+	if err := b.listen(ctx, listener); err != nil {
+		return nil, err
+	}
+
+	envelopes := make(chan Envelope[T], eventBusBuffer)
+	go func() {
+		defer close(envelopes)
+		defer func() {
+			closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := listener.Close(closeCtx); err != nil {
+				b.logger.Error("error closing event bus listener", "error", err)
+			}
+		}()
+
+		for {
+			notification, err := listener.WaitForNotification(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				b.logger.Error("error waiting for event bus notification", "error", err)
+				b.reconnect(ctx, listener)
+				continue
+			}
+
+			var envelope Envelope[T]
+			if err := json.Unmarshal(notification.Payload, &envelope); err != nil {
+				b.logger.Error("event bus decode error", "error", err)
+				continue
+			}
+
+			select {
+			case envelopes <- envelope:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return envelopes, nil
+}
+
+func (b *PostgresBus[T]) listen(ctx context.Context, listener database.Listener) error {
+	if err := listener.Connect(ctx); err != nil {
+		return fmt.Errorf("failed to connect event bus listener: %w", err)
+	}
+	if err := listener.ListenToChannel(ctx, b.channel); err != nil {
+		listener.Close(ctx)
+		return fmt.Errorf("failed to listen to event bus channel: %w", err)
+	}
+	return nil
+}
+
+// reconnect replaces the listener connection, retrying until it succeeds or ctx is done.
+func (b *PostgresBus[T]) reconnect(ctx context.Context, listener database.Listener) {
+	for {
+		select {
+		case <-time.After(busReconnectDelay):
+		case <-ctx.Done():
+			return
+		}
+
+		listener.Close(ctx)
+		if err := b.listen(ctx, listener); err != nil {
+			b.logger.Warn("event bus reconnect failed, retrying", "error", err, "retry_delay", busReconnectDelay)
+			continue
+		}
+		b.logger.Info("event bus listener reconnected")
+		return
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log/slog"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,6 +26,11 @@ type Producer[T any] struct {
 	broadcastTimeout time.Duration // maximum duration to wait for an event to be sent.
 	maxWorkers       int           // maximum concurrent goroutines per Broadcast call.
 	logger           *slog.Logger
+	bus              Bus[T] // optional bus relaying published events to other nodes.
+	nodeID           string
+	resync           func() T      // optional event sent to those that missed events.
+	busMissed        atomic.Bool   // an event failed to reach the bus, other nodes must resync.
+	busRetryDelay    time.Duration // first delay before subscribing to the bus again.
 }
 
 type ProducerOpt[T any] func(*Producer[T])
@@ -46,6 +52,17 @@ func WithMaxWorkers[T any](n int) ProducerOpt[T] {
 	}
 }
 
+// WithResync sends the event made by resync wherever events may have been
+// missed: to a subscriber whose send timed out, ahead of the next event it is
+// sent, and to the other nodes on the bus when relaying an event fails.
+// Subscribers holding state derived from events must reload it when they
+// receive it.
+func WithResync[T any](resync func() T) ProducerOpt[T] {
+	return func(ep *Producer[T]) {
+		ep.resync = resync
+	}
+}
+
 func WithCustomLogger[T any](logger *slog.Logger) ProducerOpt[T] {
 	return func(ep *Producer[T]) {
 		ep.logger = logger
@@ -59,6 +76,8 @@ func NewProducer[T any](opts ...ProducerOpt[T]) *Producer[T] {
 		broadcastTimeout: defaultBroadcastTimeout,
 		maxWorkers:       defaultMaxWorkers,
 		logger:           slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		nodeID:           newNodeID(),
+		busRetryDelay:    busRetryDelay,
 	}
 	for _, opt := range opts {
 		opt(producer)
@@ -67,13 +86,18 @@ func NewProducer[T any](opts ...ProducerOpt[T]) *Producer[T] {
 }
 
 // Start begins listening for subscription cancelation requests or context cancelation.
+// When a bus is configured, events published by other nodes are relayed to local subscribers.
 func (ep *Producer[T]) Start(ctx context.Context) {
+	if ep.bus != nil {
+		go ep.subscribeBus(ctx)
+	}
+
 	for {
 		select {
 		case id := <-ep.doneListener:
 			ep.Lock()
 			if sub, exists := ep.subs[id]; exists {
-				close(sub.events)
+				sub.close()
 				delete(ep.subs, id)
 			}
 			ep.Unlock()
@@ -82,7 +106,7 @@ func (ep *Producer[T]) Start(ctx context.Context) {
 			// Clean up all subscriptions
 			ep.Lock()
 			for _, sub := range ep.subs {
-				close(sub.events)
+				sub.close()
 			}
 			// Clear the map
 			ep.subs = make(map[subId]*Subscription[T])
@@ -113,10 +137,11 @@ func (ep *Producer[T]) Subscribe(bufferSize int) *Subscription[T] {
 	id := ep.nextID
 	ep.nextID++
 	sub := &Subscription[T]{
-		id:     id,
-		events: make(chan T, bufferSize),
-		done:   ep.doneListener,
-		logger: ep.logger,
+		id:      id,
+		events:  make(chan T, bufferSize),
+		closing: make(chan struct{}),
+		done:    ep.doneListener,
+		logger:  ep.logger,
 	}
 	ep.subs[id] = sub
 	return sub
@@ -141,28 +166,59 @@ func (ep *Producer[T]) Broadcast(ctx context.Context, event T) {
 		go func(listener *Subscription[T]) {
 			defer wg.Done()
 			defer func() { <-sem }()
-			select {
-			case listener.events <- event:
-			case <-time.After(ep.broadcastTimeout):
-				ep.logger.Warn("subscriber too slow, dropping event",
-					"subscriber_id", listener.id,
-					"buffer_capacity", cap(listener.events),
-					"broadcast_timeout", ep.broadcastTimeout,
-				)
-			case <-ctx.Done():
+			// The subscription cannot be closed while it is being sent to
+			listener.mu.RLock()
+			defer listener.mu.RUnlock()
+			if ep.resync != nil && listener.missed.CompareAndSwap(true, false) {
+				if !ep.send(ctx, listener, ep.resync()) {
+					return
+				}
 			}
+			ep.send(ctx, listener, event)
 		}(sub)
 	}
 	wg.Wait()
 }
 
+// send delivers an event to a subscription, reporting whether it was. An event
+// that is not delivered to an open subscription is remembered as missed.
+func (ep *Producer[T]) send(ctx context.Context, listener *Subscription[T], event T) bool {
+	select {
+	case listener.events <- event:
+		return true
+	case <-listener.closing:
+		return false
+	case <-time.After(ep.broadcastTimeout):
+		ep.logger.Warn("subscriber too slow, dropping event",
+			"subscriber_id", listener.id,
+			"buffer_capacity", cap(listener.events),
+			"broadcast_timeout", ep.broadcastTimeout,
+		)
+	case <-ctx.Done():
+	}
+	listener.missed.Store(true)
+	return false
+}
+
 // Subscription defines a generic handle to a subscription of
 // events from a producer.
 type Subscription[T any] struct {
 	id     subId
 	events chan T
-	done   chan subId
-	logger *slog.Logger
+	// closing is closed before events, so that sends waiting on a slow
+	// subscriber give up and release mu.
+	closing chan struct{}
+	mu      sync.RWMutex
+	missed  atomic.Bool
+	done    chan subId
+	logger  *slog.Logger
+}
+
+func (es *Subscription[T]) close() {
+	close(es.closing)
+	es.mu.Lock()
+	close(es.events)
+	es.mu.Unlock()
 }
 
 // Events returns a read-only channel of events from the subscription.
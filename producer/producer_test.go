@@ -50,6 +50,23 @@ func TestBroadcastTimeout(t *testing.T) {
 	require.Equal(t, 42, event)
 }
 
+func TestBroadcastTimeoutSendsResync(t *testing.T) {
+	producer := NewProducer(WithBroadcastTimeout[int](20*time.Millisecond), WithResync(func() int { return -1 }))
+	sub := producer.Subscribe(0)
+
+	// Nobody is reading, the event is dropped
+	producer.Broadcast(context.Background(), 1)
+
+	go producer.Broadcast(context.Background(), 2)
+
+	event, err := sub.Next(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, -1, event)
+	event, err = sub.Next(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, event)
+}
+
 func TestEventProducer_Start(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	producer := NewProducer[int]()
@@ -0,0 +1,39 @@
+package ratelimit
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	trackedDesc = prometheus.NewDesc(
+		"ratelimit_tracked_buckets",
+		"Rate limit buckets held in process.",
+		nil, nil,
+	)
+	evictionsDesc = prometheus.NewDesc(
+		"ratelimit_evictions_total",
+		"Rate limit buckets dropped from process, because the maximum was reached (lru) or they were idle (idle).",
+		[]string{"reason"}, nil,
+	)
+)
+
+type mapStats struct {
+	entries int
+	evicted int64
+	expired int64
+}
+
+func describe(ch chan<- *prometheus.Desc) {
+	ch <- trackedDesc
+	ch <- evictionsDesc
+}
+
+func collect(ch chan<- prometheus.Metric, stats ...mapStats) {
+	var total mapStats
+	for _, s := range stats {
+		total.entries += s.entries
+		total.evicted += s.evicted
+		total.expired += s.expired
+	}
+	ch <- prometheus.MustNewConstMetric(trackedDesc, prometheus.GaugeValue, float64(total.entries))
+	ch <- prometheus.MustNewConstMetric(evictionsDesc, prometheus.CounterValue, float64(total.evicted), "lru")
+	ch <- prometheus.MustNewConstMetric(evictionsDesc, prometheus.CounterValue, float64(total.expired), "idle")
+}
@@ -0,0 +1,189 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/doug-benn/go-server-starter/utilities"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+// PostgresConfig configures a PostgresStore.
+type PostgresConfig struct {
+	// FailOpen limits each replica on its own while the database is
+	// unavailable, instead of failing every request.
+	FailOpen bool
+	// LeaseSize is the most requests taken from a bucket in one round trip and
+	// handed out locally. It is capped at a quarter of the burst so that one
+	// replica cannot hold most of a small bucket.
+	LeaseSize int
+	// LeaseTTL is how long leased requests may be handed out, unused ones are
+	// lost.
+	LeaseTTL time.Duration
+	// Local bounds the leases held and the buckets used while failing open.
+	Local MemoryConfig
+}
+
+// DefaultPostgresConfig returns the configuration read from the environment.
+func DefaultPostgresConfig() PostgresConfig {
+	leaseSize, err := strconv.Atoi(utilities.GetEnvOrDefault("RATE_LIMIT_LEASE_SIZE", "10"))
+	if err != nil || leaseSize < 1 {
+		leaseSize = 10
+	}
+	return PostgresConfig{
+		FailOpen:  utilities.GetEnvOrDefault("RATE_LIMIT_FAIL_OPEN", "true") != "false",
+		LeaseSize: leaseSize,
+		LeaseTTL:  time.Second,
+		Local:     DefaultMemoryConfig(),
+	}
+}
+
+// lease is what a replica remembers about a bucket between round trips.
+type lease struct {
+	mu sync.Mutex
+	// tokens are leased requests not handed out yet
+	tokens    int
+	remaining int
+	tat       time.Time
+	expires   time.Time
+	// deniedUntil rejects requests locally while the bucket is known empty
+	deniedUntil time.Time
+	allowAt     time.Time
+}
+
+// PostgresStore keeps buckets in the rate_limits table so that the limit holds
+// across every replica. Buckets are tracked with the generic cell rate
+// algorithm, updated atomically by the take_rate_limit function.
+//
+// To avoid a round trip per request, requests are taken from a bucket in small
+// leases and a bucket known to be empty rejects requests locally until it
+// refills. Either way a client may briefly get slightly more or less than its
+// share.
+type PostgresStore struct {
+	repo     repository.Querier
+	logger   *slog.Logger
+	config   PostgresConfig
+	fallback *MemoryStore
+	now      func() time.Time
+	leases   *shardedMap[*lease]
+}
+
+func NewPostgresStore(repo repository.Querier, logger *slog.Logger, config PostgresConfig) *PostgresStore {
+	return &PostgresStore{
+		repo:     repo,
+		logger:   logger,
+		config:   config,
+		fallback: NewMemoryStore(config.Local),
+		now:      time.Now,
+		leases:   newShardedMap[*lease](config.Local.Shards, config.Local.MaxEntries),
+	}
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, limit rate.Limit, burst int, maxWait time.Duration) (Result, error) {
+	if limit == rate.Inf || limit <= 0 {
+		return s.fallback.Take(ctx, key, limit, burst, maxWait)
+	}
+
+	now := s.now()
+	if result, ok := s.takeLocal(key, now); ok {
+		return result, nil
+	}
+
+	interval := max(time.Duration(float64(time.Second)/float64(limit)), time.Microsecond)
+	row, err := s.repo.TakeRateLimit(ctx, repository.TakeRateLimitParams{
+		Bucket:           key,
+		NowUs:            now.UnixMicro(),
+		EmissionInterval: interval.Microseconds(),
+		Burst:            int32(burst),
+		MaxWait:          maxWait.Microseconds(),
+		Cost:             int32(max(min(s.config.LeaseSize, burst/4), 1)),
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to take rate limit", "key", key, "fail_open", s.config.FailOpen, "error", err)
+		if s.config.FailOpen {
+			return s.fallback.Take(ctx, key, limit, burst, maxWait)
+		}
+		return Result{}, err
+	}
+
+	tat := time.UnixMicro(row.Tat)
+	window := time.Duration(burst) * interval
+
+	if row.Granted == 0 {
+		allowAt := tat.Add(interval - window)
+		s.leases.set(key, &lease{tat: tat, deniedUntil: allowAt.Add(-maxWait), allowAt: allowAt}, now)
+		return Result{Delay: allowAt.Sub(now), Reset: tat.Sub(now)}, nil
+	}
+
+	remaining := max(int(now.Add(window).Sub(tat)/interval), 0)
+	leased := int(row.Granted) - 1
+	s.leases.set(key, &lease{tokens: leased, remaining: remaining, tat: tat, expires: now.Add(s.config.LeaseTTL)}, now)
+
+	return Result{
+		Allowed:   true,
+		Remaining: remaining + leased,
+		Delay:     max(tat.Add(-window).Sub(now), 0),
+		Reset:     tat.Sub(now),
+	}, nil
+}
+
+// takeLocal answers from the replica's lease on the bucket, if it can.
+func (s *PostgresStore) takeLocal(key string, now time.Time) (Result, bool) {
+	l, ok := s.leases.get(key, now)
+	if !ok {
+		return Result{}, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Before(l.deniedUntil) {
+		return Result{Delay: l.allowAt.Sub(now), Reset: l.tat.Sub(now)}, true
+	}
+	if l.tokens > 0 && now.Before(l.expires) {
+		l.tokens--
+		return Result{Allowed: true, Remaining: l.remaining + l.tokens, Reset: max(l.tat.Sub(now), 0)}, true
+	}
+	return Result{}, false
+}
+
+// Purge deletes full buckets and drops idle local state every interval until
+// ctx is cancelled. A full bucket behaves the same as a missing one, this only
+// reclaims space.
+func (s *PostgresStore) Purge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := s.now()
+
+			s.leases.purge(now.Add(-s.config.Local.IdleTimeout))
+			s.fallback.purge()
+
+			n, err := s.repo.DeleteFullRateLimits(ctx, now.UnixMicro())
+			if err != nil {
+				s.logger.ErrorContext(ctx, "failed to purge rate limits", "error", err)
+				continue
+			}
+			if n > 0 {
+				s.logger.DebugContext(ctx, "purged rate limits", "count", n)
+			}
+		}
+	}
+}
+
+func (s *PostgresStore) Describe(ch chan<- *prometheus.Desc) {
+	describe(ch)
+}
+
+func (s *PostgresStore) Collect(ch chan<- prometheus.Metric) {
+	collect(ch, s.leases.stats(), s.fallback.buckets.stats())
+}
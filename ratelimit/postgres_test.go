@@ -0,0 +1,111 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/doug-benn/go-server-starter/testutils"
+	"golang.org/x/time/rate"
+)
+
+// fakeTakeRateLimit mirrors the take_rate_limit function of the migration.
+func fakeTakeRateLimit(calls *int) func(ctx context.Context, arg repository.TakeRateLimitParams) (repository.TakeRateLimitRow, error) {
+	tats := make(map[string]int64)
+	return func(ctx context.Context, arg repository.TakeRateLimitParams) (repository.TakeRateLimitRow, error) {
+		*calls++
+		tat := max(tats[arg.Bucket], arg.NowUs)
+		window := int64(arg.Burst) * arg.EmissionInterval
+		available := min(int64(arg.Cost), (arg.NowUs+window-tat)/arg.EmissionInterval)
+		if available < 1 {
+			if tat+arg.EmissionInterval-window > arg.NowUs+arg.MaxWait {
+				return repository.TakeRateLimitRow{Granted: 0, Tat: tat}, nil
+			}
+			available = 1
+		}
+		tats[arg.Bucket] = tat + available*arg.EmissionInterval
+		return repository.TakeRateLimitRow{Granted: int32(available), Tat: tats[arg.Bucket]}, nil
+	}
+}
+
+func newTestPostgresStore(repo repository.Querier, config PostgresConfig) (*PostgresStore, *time.Time) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewPostgresStore(repo, slog.Default(), config)
+	store.now = func() time.Time { return now }
+	return store, &now
+}
+
+func TestPostgresStore_Take(t *testing.T) {
+	calls := 0
+	store, now := newTestPostgresStore(&testutils.MockQuerier{TakeRateLimitFunc: fakeTakeRateLimit(&calls)},
+		PostgresConfig{LeaseSize: 1, LeaseTTL: time.Second, Local: DefaultMemoryConfig()})
+	ctx := context.Background()
+
+	for i := range 2 {
+		result, err := store.Take(ctx, "a", rate.Every(10*time.Second), 2, 0)
+		if err != nil || !result.Allowed || result.Remaining != 1-i {
+			t.Fatalf("request %d: expected to be allowed, got %+v, %v", i, result, err)
+		}
+	}
+
+	result, _ := store.Take(ctx, "a", rate.Every(10*time.Second), 2, 0)
+	if result.Allowed || result.Delay != 10*time.Second || result.Reset != 20*time.Second {
+		t.Errorf("expected a rejection for 10s, got %+v", result)
+	}
+
+	// Rejected locally until the bucket refills
+	store.Take(ctx, "a", rate.Every(10*time.Second), 2, 0)
+	if calls != 3 {
+		t.Errorf("expected an empty bucket to reject without a round trip, got %d calls", calls)
+	}
+
+	*now = now.Add(10 * time.Second)
+	if result, _ := store.Take(ctx, "a", rate.Every(10*time.Second), 2, 0); !result.Allowed {
+		t.Errorf("expected the bucket to refill, got %+v", result)
+	}
+}
+
+func TestPostgresStore_Lease(t *testing.T) {
+	calls := 0
+	store, _ := newTestPostgresStore(&testutils.MockQuerier{TakeRateLimitFunc: fakeTakeRateLimit(&calls)},
+		PostgresConfig{LeaseSize: 5, LeaseTTL: time.Second, Local: DefaultMemoryConfig()})
+	ctx := context.Background()
+
+	allowed := 0
+	for range 30 {
+		if result, _ := store.Take(ctx, "a", rate.Every(time.Second), 20, 0); result.Allowed {
+			allowed++
+		}
+	}
+	if allowed != 20 {
+		t.Errorf("expected the burst to be allowed, got %d", allowed)
+	}
+	if calls >= 10 {
+		t.Errorf("expected requests to be leased, got %d calls", calls)
+	}
+}
+
+func TestPostgresStore_Unavailable(t *testing.T) {
+	repo := &testutils.MockQuerier{
+		TakeRateLimitFunc: func(ctx context.Context, arg repository.TakeRateLimitParams) (repository.TakeRateLimitRow, error) {
+			return repository.TakeRateLimitRow{}, errors.New("connection refused")
+		},
+	}
+	ctx := context.Background()
+
+	store, _ := newTestPostgresStore(repo, PostgresConfig{FailOpen: false, LeaseSize: 1, Local: DefaultMemoryConfig()})
+	if _, err := store.Take(ctx, "a", rate.Every(time.Second), 1, 0); err == nil {
+		t.Error("expected failing closed to return the error")
+	}
+
+	store, _ = newTestPostgresStore(repo, PostgresConfig{FailOpen: true, LeaseSize: 1, Local: DefaultMemoryConfig()})
+	if result, err := store.Take(ctx, "a", rate.Every(time.Second), 1, 0); err != nil || !result.Allowed {
+		t.Errorf("expected failing open to allow, got %+v, %v", result, err)
+	}
+	if result, _ := store.Take(ctx, "a", rate.Every(time.Second), 1, 0); result.Allowed {
+		t.Error("expected failing open to still limit in process")
+	}
+}
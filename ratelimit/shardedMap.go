@@ -0,0 +1,146 @@
+package ratelimit
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type entry[V any] struct {
+	key      string
+	value    V
+	lastSeen time.Time
+}
+
+type shard[V any] struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// lru orders entries from most to least recently used
+	lru *list.List
+}
+
+// shardedMap is a map from bucket keys to per bucket state, split into shards
+// so that requests for different keys rarely contend on the same lock. Each
+// shard holds at most its share of the maximum number of entries and evicts
+// its least recently used entry to make room.
+type shardedMap[V any] struct {
+	shards      []*shard[V]
+	maxPerShard int
+
+	evicted atomic.Int64
+	expired atomic.Int64
+}
+
+func newShardedMap[V any](shards, maxEntries int) *shardedMap[V] {
+	shards = max(shards, 1)
+	m := &shardedMap[V]{
+		shards:      make([]*shard[V], shards),
+		maxPerShard: max((maxEntries+shards-1)/shards, 1),
+	}
+	for i := range m.shards {
+		m.shards[i] = &shard[V]{entries: make(map[string]*list.Element), lru: list.New()}
+	}
+	return m
+}
+
+func (m *shardedMap[V]) shard(key string) *shard[V] {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return m.shards[h.Sum32()%uint32(len(m.shards))]
+}
+
+// get returns the value for key and marks it used at now.
+func (m *shardedMap[V]) get(key string, now time.Time) (V, bool) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[key]; ok {
+		e := el.Value.(*entry[V])
+		e.lastSeen = now
+		s.lru.MoveToFront(el)
+		return e.value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// getOrCreate returns the value for key, creating it with create if missing.
+func (m *shardedMap[V]) getOrCreate(key string, now time.Time, create func() V) V {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[key]; ok {
+		e := el.Value.(*entry[V])
+		e.lastSeen = now
+		s.lru.MoveToFront(el)
+		return e.value
+	}
+	value := create()
+	m.insert(s, key, value, now)
+	return value
+}
+
+// set stores value for key, replacing any previous value.
+func (m *shardedMap[V]) set(key string, value V, now time.Time) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[key]; ok {
+		e := el.Value.(*entry[V])
+		e.value = value
+		e.lastSeen = now
+		s.lru.MoveToFront(el)
+		return
+	}
+	m.insert(s, key, value, now)
+}
+
+// insert adds a new entry to s, which must be locked.
+func (m *shardedMap[V]) insert(s *shard[V], key string, value V, now time.Time) {
+	for s.lru.Len() >= m.maxPerShard {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.entries, oldest.Value.(*entry[V]).key)
+		m.evicted.Add(1)
+	}
+	s.entries[key] = s.lru.PushFront(&entry[V]{key: key, value: value, lastSeen: now})
+}
+
+// purge removes entries not used since before, returning how many.
+func (m *shardedMap[V]) purge(before time.Time) int {
+	purged := 0
+	for _, s := range m.shards {
+		s.mu.Lock()
+		for el := s.lru.Back(); el != nil; el = s.lru.Back() {
+			e := el.Value.(*entry[V])
+			if !e.lastSeen.Before(before) {
+				break
+			}
+			s.lru.Remove(el)
+			delete(s.entries, e.key)
+			purged++
+		}
+		s.mu.Unlock()
+	}
+	m.expired.Add(int64(purged))
+	return purged
+}
+
+func (m *shardedMap[V]) len() int {
+	n := 0
+	for _, s := range m.shards {
+		s.mu.Lock()
+		n += s.lru.Len()
+		s.mu.Unlock()
+	}
+	return n
+}
+
+func (m *shardedMap[V]) stats() mapStats {
+	return mapStats{entries: m.len(), evicted: m.evicted.Load(), expired: m.expired.Load()}
+}
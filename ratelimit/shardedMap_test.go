@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestShardedMap_EvictsLeastRecentlyUsed(t *testing.T) {
+	m := newShardedMap[int](1, 3)
+	now := time.Now()
+
+	for i := range 3 {
+		m.set(fmt.Sprint(i), i, now)
+	}
+	m.get("0", now)
+	m.set("3", 3, now)
+
+	if _, ok := m.get("1", now); ok {
+		t.Error("expected the least recently used entry to be evicted")
+	}
+	if _, ok := m.get("0", now); !ok {
+		t.Error("expected a recently used entry to be kept")
+	}
+	if stats := m.stats(); stats.entries != 3 || stats.evicted != 1 {
+		t.Errorf("expected 3 entries and 1 eviction, got %+v", stats)
+	}
+}
+
+func TestShardedMap_Purge(t *testing.T) {
+	m := newShardedMap[int](4, 100)
+	now := time.Now()
+
+	for i := range 10 {
+		m.set(fmt.Sprint(i), i, now.Add(-time.Duration(10-i)*time.Minute))
+	}
+	if purged := m.purge(now.Add(-5 * time.Minute)); purged != 5 {
+		t.Errorf("expected 5 idle entries purged, got %d", purged)
+	}
+	if m.len() != 5 {
+		t.Errorf("expected 5 entries left, got %d", m.len())
+	}
+}
+
+func TestShardedMap_Bounded(t *testing.T) {
+	m := newShardedMap[int](8, 100)
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Go(func() {
+			for i := range 1000 {
+				m.getOrCreate(fmt.Sprintf("%d-%d", w, i), now, func() int { return i })
+			}
+		})
+	}
+	wg.Wait()
+
+	if n := m.len(); n > 8*((100+7)/8) {
+		t.Errorf("expected at most the maximum entries, got %d", n)
+	}
+}
+
+func TestMemoryStore_Metrics(t *testing.T) {
+	store := NewMemoryStore(MemoryConfig{Shards: 1, MaxEntries: 2, IdleTimeout: time.Minute})
+	for _, key := range []string{"a", "b", "c"} {
+		store.Take(t.Context(), key, 1, 1, 0)
+	}
+
+	expected := `
+# HELP ratelimit_evictions_total Rate limit buckets dropped from process, because the maximum was reached (lru) or they were idle (idle).
+# TYPE ratelimit_evictions_total counter
+ratelimit_evictions_total{reason="idle"} 0
+ratelimit_evictions_total{reason="lru"} 1
+# HELP ratelimit_tracked_buckets Rate limit buckets held in process.
+# TYPE ratelimit_tracked_buckets gauge
+ratelimit_tracked_buckets 2
+`
+	if err := testutil.CollectAndCompare(store, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
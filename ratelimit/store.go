@@ -0,0 +1,154 @@
+// Package ratelimit implements the buckets rate limited requests are counted
+// in, kept in process or shared by every replica in Postgres.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/utilities"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+// Result is the outcome of counting a request against a bucket.
+type Result struct {
+	Allowed bool
+	// Remaining is how many more requests the bucket allows right now.
+	Remaining int
+	// Delay is how long an allowed request must wait before it proceeds, or
+	// how long until a rejected request would be allowed. It is zero for a
+	// rejected request that would never be allowed, such as with a zero burst.
+	Delay time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// Store holds rate limit buckets.
+type Store interface {
+	// Take counts a request against the bucket key, which refills at limit
+	// requests per second and holds up to burst. A request that would be
+	// allowed within maxWait is allowed with a Delay instead of rejected.
+	Take(ctx context.Context, key string, limit rate.Limit, burst int, maxWait time.Duration) (Result, error)
+}
+
+// MemoryConfig bounds the buckets a replica holds in process.
+type MemoryConfig struct {
+	// Shards splits the buckets so requests rarely contend on one lock.
+	Shards int
+	// MaxEntries is the most buckets held, the least recently used bucket is
+	// evicted to make room so that a spray of client addresses cannot grow
+	// memory without bound.
+	MaxEntries int
+	// IdleTimeout is how long an unused bucket is kept. A bucket idle for long
+	// enough to have refilled behaves the same as a new one.
+	IdleTimeout time.Duration
+	// PurgeInterval is how often StartMemoryStore drops idle buckets.
+	PurgeInterval time.Duration
+}
+
+// DefaultMemoryConfig returns the configuration read from the environment.
+func DefaultMemoryConfig() MemoryConfig {
+	maxEntries, err := strconv.Atoi(utilities.GetEnvOrDefault("RATE_LIMIT_MAX_CLIENTS", "100000"))
+	if err != nil || maxEntries < 1 {
+		maxEntries = 100000
+	}
+	return MemoryConfig{
+		Shards:        64,
+		MaxEntries:    maxEntries,
+		IdleTimeout:   5 * time.Minute,
+		PurgeInterval: time.Minute,
+	}
+}
+
+// MemoryStore keeps buckets in process, so every replica limits on its own.
+type MemoryStore struct {
+	buckets *shardedMap[*rate.Limiter]
+	config  MemoryConfig
+	now     func() time.Time
+}
+
+func NewMemoryStore(config MemoryConfig) *MemoryStore {
+	return &MemoryStore{
+		buckets: newShardedMap[*rate.Limiter](config.Shards, config.MaxEntries),
+		config:  config,
+		now:     time.Now,
+	}
+}
+
+// StartMemoryStore returns a MemoryStore whose idle buckets are dropped every
+// PurgeInterval until ctx is cancelled.
+func StartMemoryStore(ctx context.Context, config MemoryConfig) *MemoryStore {
+	s := NewMemoryStore(config)
+	if config.PurgeInterval > 0 {
+		go s.Purge(ctx, config.PurgeInterval)
+	}
+	return s
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit rate.Limit, burst int, maxWait time.Duration) (Result, error) {
+	now := s.now()
+	limiter := s.buckets.getOrCreate(key, now, func() *rate.Limiter {
+		return rate.NewLimiter(limit, burst)
+	})
+
+	reservation := limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		return Result{Reset: refill(float64(burst)-limiter.TokensAt(now), limit)}, nil
+	}
+	delay := reservation.DelayFrom(now)
+	allowed := delay <= maxWait
+	if !allowed {
+		reservation.CancelAt(now)
+	}
+
+	tokens := limiter.TokensAt(now)
+	return Result{
+		Allowed:   allowed,
+		Remaining: int(math.Floor(max(tokens, 0))),
+		Delay:     delay,
+		Reset:     refill(float64(burst)-tokens, limit),
+	}, nil
+}
+
+// Purge drops idle buckets every interval until ctx is cancelled.
+func (s *MemoryStore) Purge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.purge()
+		}
+	}
+}
+
+func (s *MemoryStore) purge() int {
+	return s.buckets.purge(s.now().Add(-s.config.IdleTimeout))
+}
+
+// Len returns how many buckets are held.
+func (s *MemoryStore) Len() int {
+	return s.buckets.len()
+}
+
+func (s *MemoryStore) Describe(ch chan<- *prometheus.Desc) {
+	describe(ch)
+}
+
+func (s *MemoryStore) Collect(ch chan<- prometheus.Metric) {
+	collect(ch, s.buckets.stats())
+}
+
+// refill returns how long limit takes to refill tokens.
+func refill(tokens float64, limit rate.Limit) time.Duration {
+	if tokens <= 0 || limit <= 0 || limit == rate.Inf {
+		return 0
+	}
+	return time.Duration(tokens / float64(limit) * float64(time.Second))
+}
@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestMemoryStore_Take(t *testing.T) {
+	store := NewMemoryStore(DefaultMemoryConfig())
+	ctx := context.Background()
+
+	for i := range 2 {
+		result, _ := store.Take(ctx, "a", rate.Every(10*time.Second), 2, 0)
+		if !result.Allowed || result.Remaining != 1-i {
+			t.Fatalf("request %d: expected to be allowed, got %+v", i, result)
+		}
+	}
+
+	result, _ := store.Take(ctx, "a", rate.Every(10*time.Second), 2, 0)
+	if result.Allowed || result.Delay <= 9*time.Second || result.Delay > 10*time.Second {
+		t.Errorf("expected a rejection for about 10s, got %+v", result)
+	}
+
+	if result, _ := store.Take(ctx, "b", rate.Every(10*time.Second), 2, 0); !result.Allowed {
+		t.Error("expected buckets to be independent")
+	}
+}
+
+func TestMemoryStore_MaxWait(t *testing.T) {
+	store := NewMemoryStore(DefaultMemoryConfig())
+	ctx := context.Background()
+
+	store.Take(ctx, "a", rate.Every(time.Second), 1, time.Second)
+	result, _ := store.Take(ctx, "a", rate.Every(time.Second), 1, 2*time.Second)
+	if !result.Allowed || result.Delay <= 0 {
+		t.Errorf("expected the request to be allowed after a delay, got %+v", result)
+	}
+}
+
+func TestStartMemoryStore_PurgesIdleBuckets(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := StartMemoryStore(ctx, MemoryConfig{Shards: 1, MaxEntries: 10, IdleTimeout: 10 * time.Millisecond, PurgeInterval: 5 * time.Millisecond})
+
+	store.Take(ctx, "a", rate.Every(time.Second), 1, 0)
+	deadline := time.Now().Add(time.Second)
+	for store.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the idle bucket to be purged, %d left", store.Len())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMemoryStore_NeverAllowed(t *testing.T) {
+	store := NewMemoryStore(DefaultMemoryConfig())
+
+	result, _ := store.Take(context.Background(), "a", rate.Every(time.Second), 0, time.Second)
+	if result.Allowed || result.Delay != 0 {
+		t.Errorf("expected a rejection without a delay, got %+v", result)
+	}
+}
@@ -0,0 +1,26 @@
+-- name: CreateApiKey :one
+INSERT INTO api_keys (name, prefix, key_hash, scopes, expires_at, created_at, tenant_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, name, prefix, key_hash, scopes, expires_at, revoked_at, last_used_at, created_at, tenant_id;
+
+-- name: GetApiKeyByHash :one
+SELECT id, name, prefix, key_hash, scopes, expires_at, revoked_at, last_used_at, created_at, tenant_id
+FROM api_keys
+WHERE key_hash = $1;
+
+-- name: ListApiKeys :many
+SELECT id, name, prefix, key_hash, scopes, expires_at, revoked_at, last_used_at, created_at, tenant_id
+FROM api_keys
+WHERE sqlc.narg(tenant_id)::text IS NULL OR tenant_id = sqlc.narg(tenant_id)
+ORDER BY id;
+
+-- name: RevokeApiKey :execrows
+UPDATE api_keys
+SET revoked_at = sqlc.arg(revoked_at)
+WHERE id = sqlc.arg(id) AND revoked_at IS NULL
+    AND (sqlc.narg(tenant_id)::text IS NULL OR tenant_id = sqlc.narg(tenant_id));
+
+-- name: TouchApiKey :exec
+UPDATE api_keys
+SET last_used_at = $1
+WHERE id = $2;
@@ -0,0 +1,32 @@
+-- name: GetCacheEntry :one
+SELECT value
+FROM cache_entries
+WHERE key = sqlc.arg(key) AND (expires_at IS NULL OR expires_at > sqlc.arg(now));
+
+-- name: SetCacheEntry :exec
+INSERT INTO cache_entries (key, value, expires_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (key) DO UPDATE
+SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at;
+
+-- name: DeleteCacheEntry :exec
+DELETE FROM cache_entries
+WHERE key = $1;
+
+-- name: IncrementCacheEntry :one
+INSERT INTO cache_entries AS e (key, value, expires_at)
+VALUES (sqlc.arg(key), convert_to(sqlc.arg(delta)::bigint::text, 'UTF8'), sqlc.arg(expires_at))
+ON CONFLICT (key) DO UPDATE
+SET value = CASE
+        WHEN e.expires_at <= sqlc.arg(now) THEN EXCLUDED.value
+        ELSE convert_to((convert_from(e.value, 'UTF8')::bigint + sqlc.arg(delta))::text, 'UTF8')
+    END,
+    expires_at = CASE
+        WHEN e.expires_at <= sqlc.arg(now) THEN EXCLUDED.expires_at
+        ELSE e.expires_at
+    END
+RETURNING value;
+
+-- name: DeleteExpiredCacheEntries :execrows
+DELETE FROM cache_entries
+WHERE expires_at <= $1;
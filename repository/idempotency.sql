@@ -0,0 +1,31 @@
+-- name: ClaimIdempotencyKey :execrows
+INSERT INTO idempotency_keys (key, fingerprint, locked_until, expires_at)
+VALUES (sqlc.arg(key), sqlc.arg(fingerprint), sqlc.arg(locked_until), sqlc.arg(expires_at))
+ON CONFLICT (key) DO UPDATE
+SET fingerprint = EXCLUDED.fingerprint,
+    status_code = NULL,
+    headers = NULL,
+    body = NULL,
+    created_at = NOW(),
+    locked_until = EXCLUDED.locked_until,
+    expires_at = EXCLUDED.expires_at
+WHERE idempotency_keys.expires_at < sqlc.arg(now)
+    OR (idempotency_keys.status_code IS NULL AND idempotency_keys.locked_until < sqlc.arg(now));
+
+-- name: GetIdempotencyKey :one
+SELECT key, fingerprint, status_code, headers, body, created_at, locked_until, expires_at
+FROM idempotency_keys
+WHERE key = $1;
+
+-- name: CompleteIdempotencyKey :exec
+UPDATE idempotency_keys
+SET status_code = $2, headers = $3, body = $4
+WHERE key = $1 AND status_code IS NULL;
+
+-- name: ReleaseIdempotencyKey :exec
+DELETE FROM idempotency_keys
+WHERE key = $1 AND status_code IS NULL;
+
+-- name: DeleteExpiredIdempotencyKeys :execrows
+DELETE FROM idempotency_keys
+WHERE expires_at < $1;
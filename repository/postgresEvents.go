@@ -12,13 +12,46 @@ import (
 	"github.com/doug-benn/go-server-starter/sse"
 )
 
-const eventChannelBuffer = 100
+const (
+	eventChannelBuffer     = 100
+	listenerReconnectDelay = time.Second
+)
 
 type DatabaseEvent struct {
-	Table     string         `json:"table"`
-	Action    string         `json:"action"`
-	Timestamp time.Time      `json:"timestamp"`
-	Data      map[string]any `json:"record"`
+	Table     string    `json:"table"`
+	Action    string    `json:"action"`
+	Timestamp time.Time `json:"timestamp"`
+	// Tenant is set for rows of tenant tables
+	Tenant string         `json:"tenant_id,omitempty"`
+	Data   map[string]any `json:"record"`
+}
+
+// ResyncEvent is broadcast when events may have been missed, because the
+// listener reconnected or events were dropped. Consumers holding state derived
+// from events must reload it.
+type ResyncEvent struct {
+	// Resync is always true, it tells the event apart once relayed from
+	// another node, where the data arrives as decoded JSON.
+	Resync    bool      `json:"resync"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// NewResyncEvent returns a ResyncEvent for the current time.
+func NewResyncEvent() sse.Event {
+	return sse.Event{Data: &ResyncEvent{Resync: true, Timestamp: time.Now()}}
+}
+
+// IsResyncEvent reports whether event is a ResyncEvent, either broadcast on
+// this node or relayed from another one.
+func IsResyncEvent(event sse.Event) bool {
+	switch data := event.Data.(type) {
+	case *ResyncEvent:
+		return true
+	case map[string]any:
+		resync, _ := data["resync"].(bool)
+		return resync
+	}
+	return false
 }
 
 func DecodeAsDatabaseEvent(payload []byte) (*DatabaseEvent, error) {
@@ -29,18 +62,34 @@ func DecodeAsDatabaseEvent(payload []byte) (*DatabaseEvent, error) {
 	return &event, nil
 }
 
-func NotificationProcessing(ctx context.Context, logger *slog.Logger, postgresListener database.Listener, sseProducer *producer.Producer[sse.Event]) {
+// NotificationProcessing broadcasts the notifications received on channel
+// until ctx is cancelled. The listener is reconnected if its connection is
+// lost, and a ResyncEvent is broadcast whenever notifications may have been
+// missed.
+func NotificationProcessing(ctx context.Context, logger *slog.Logger, postgresListener database.Listener, channel string, sseProducer *producer.Producer[sse.Event]) {
 	eventCh := make(chan sse.Event, eventChannelBuffer)
 
 	go drainAndBroadcast(ctx, eventCh, sseProducer)
 
+	resync := false
 	for {
+		// Sent as soon as there is room, ahead of the events that follow
+		if resync {
+			select {
+			case eventCh <- NewResyncEvent():
+				resync = false
+			default:
+			}
+		}
+
 		notification, err := postgresListener.WaitForNotification(ctx)
 		if err != nil {
 			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
 				return
 			}
 			logger.Error("error waiting for notification", "error", err)
+			reconnectListener(ctx, logger, postgresListener, channel)
+			resync = true
 			continue
 		}
 
@@ -64,7 +113,32 @@ func NotificationProcessing(ctx context.Context, logger *slog.Logger, postgresLi
 				"channel_capacity", cap(eventCh),
 				"channel_usage", len(eventCh),
 			)
+			resync = true
+		}
+	}
+}
+
+// reconnectListener replaces the listener connection, retrying until it
+// succeeds or ctx is done.
+func reconnectListener(ctx context.Context, logger *slog.Logger, postgresListener database.Listener, channel string) {
+	for {
+		select {
+		case <-time.After(listenerReconnectDelay):
+		case <-ctx.Done():
+			return
+		}
+
+		postgresListener.Close(ctx)
+		if err := postgresListener.Connect(ctx); err != nil {
+			logger.Warn("listener reconnect failed, retrying", "error", err, "retry_delay", listenerReconnectDelay)
+			continue
+		}
+		if err := postgresListener.ListenToChannel(ctx, channel); err != nil {
+			logger.Warn("listener reconnect failed, retrying", "error", err, "retry_delay", listenerReconnectDelay)
+			continue
 		}
+		logger.Info("listener reconnected", "channel", channel)
+		return
 	}
 }
 
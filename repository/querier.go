@@ -6,17 +6,60 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	models "github.com/doug-benn/go-server-starter/models"
 )
 
 type Querier interface {
-	CompleteTodo(ctx context.Context, arg CompleteTodoParams) (models.Todo, error)
+	ClaimDueWebhookDeliveries(ctx context.Context, arg ClaimDueWebhookDeliveriesParams) ([]models.WebhookDelivery, error)
+	ClaimIdempotencyKey(ctx context.Context, arg ClaimIdempotencyKeyParams) (int64, error)
+	CompleteIdempotencyKey(ctx context.Context, arg CompleteIdempotencyKeyParams) error
+	CompleteTodo(ctx context.Context, arg CompleteTodoParams) (CompleteTodoRow, error)
+	CreateApiKey(ctx context.Context, arg CreateApiKeyParams) (models.ApiKey, error)
+	CreateLocalUser(ctx context.Context, arg CreateLocalUserParams) (models.User, error)
+	CreateSession(ctx context.Context, arg CreateSessionParams) error
+	CreateTenant(ctx context.Context, arg CreateTenantParams) (models.Tenant, error)
 	CreateTodo(ctx context.Context, arg CreateTodoParams) (models.Todo, error)
-	DeleteTodo(ctx context.Context, id int32) error
-	GetTodo(ctx context.Context, id int32) (models.Todo, error)
-	ListTodos(ctx context.Context) ([]models.Todo, error)
-	UpdateTodo(ctx context.Context, arg UpdateTodoParams) (models.Todo, error)
+	CreateWebhookDelivery(ctx context.Context, arg CreateWebhookDeliveryParams) error
+	CreateWebhookSubscription(ctx context.Context, arg CreateWebhookSubscriptionParams) (models.WebhookSubscription, error)
+	DeleteCacheEntry(ctx context.Context, key string) error
+	DeleteExpiredCacheEntries(ctx context.Context, expiresAt time.Time) (int64, error)
+	DeleteExpiredIdempotencyKeys(ctx context.Context, expiresAt time.Time) (int64, error)
+	DeleteExpiredSessions(ctx context.Context, arg DeleteExpiredSessionsParams) (int64, error)
+	DeleteFullRateLimits(ctx context.Context, tat int64) (int64, error)
+	DeleteSession(ctx context.Context, id string) error
+	DeleteTodo(ctx context.Context, arg DeleteTodoParams) (models.Todo, error)
+	DeleteWebhookSubscription(ctx context.Context, arg DeleteWebhookSubscriptionParams) (int64, error)
+	GetApiKeyByHash(ctx context.Context, keyHash string) (models.ApiKey, error)
+	GetCacheEntry(ctx context.Context, arg GetCacheEntryParams) ([]byte, error)
+	GetIdempotencyKey(ctx context.Context, key string) (models.IdempotencyKey, error)
+	GetSession(ctx context.Context, id string) (models.Session, error)
+	GetTenant(ctx context.Context, id string) (models.Tenant, error)
+	GetTodo(ctx context.Context, arg GetTodoParams) (models.Todo, error)
+	GetUser(ctx context.Context, id int32) (models.User, error)
+	GetUserByUsername(ctx context.Context, username *string) (models.User, error)
+	GetWebhookDelivery(ctx context.Context, id int32) (models.WebhookDelivery, error)
+	GetWebhookSubscription(ctx context.Context, arg GetWebhookSubscriptionParams) (models.WebhookSubscription, error)
+	IncrementCacheEntry(ctx context.Context, arg IncrementCacheEntryParams) ([]byte, error)
+	ListActiveWebhookSubscriptions(ctx context.Context, tenantID *string) ([]models.WebhookSubscription, error)
+	ListApiKeys(ctx context.Context, tenantID *string) ([]models.ApiKey, error)
+	ListTenants(ctx context.Context) ([]models.Tenant, error)
+	ListTodos(ctx context.Context, arg ListTodosParams) ([]models.Todo, error)
+	ListWebhookDeliveries(ctx context.Context, arg ListWebhookDeliveriesParams) ([]models.WebhookDelivery, error)
+	ListWebhookSubscriptions(ctx context.Context, tenantID *string) ([]models.WebhookSubscription, error)
+	RecordWebhookDeliveryAttempt(ctx context.Context, arg RecordWebhookDeliveryAttemptParams) error
+	RedeliverWebhookDelivery(ctx context.Context, arg RedeliverWebhookDeliveryParams) (models.WebhookDelivery, error)
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+	RevokeApiKey(ctx context.Context, arg RevokeApiKeyParams) (int64, error)
+	SearchTodos(ctx context.Context, arg SearchTodosParams) ([]SearchTodosRow, error)
+	SetCacheEntry(ctx context.Context, arg SetCacheEntryParams) error
+	TakeRateLimit(ctx context.Context, arg TakeRateLimitParams) (TakeRateLimitRow, error)
+	TouchApiKey(ctx context.Context, arg TouchApiKeyParams) error
+	TouchSession(ctx context.Context, arg TouchSessionParams) error
+	UpdateTodo(ctx context.Context, arg UpdateTodoParams) (UpdateTodoRow, error)
+	UpdateWebhookSubscription(ctx context.Context, arg UpdateWebhookSubscriptionParams) (models.WebhookSubscription, error)
+	UpsertUser(ctx context.Context, arg UpsertUserParams) (models.User, error)
 }
 
 var _ Querier = (*Queries)(nil)
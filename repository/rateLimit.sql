@@ -0,0 +1,7 @@
+-- name: TakeRateLimit :one
+SELECT granted, tat
+FROM take_rate_limit($1, $2, $3, $4, $5, $6);
+
+-- name: DeleteFullRateLimits :execrows
+DELETE FROM rate_limits
+WHERE tat < $1;
@@ -0,0 +1,21 @@
+-- name: CreateSession :exec
+INSERT INTO sessions (id, user_id, created_at, last_seen_at, expires_at)
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: GetSession :one
+SELECT id, user_id, created_at, last_seen_at, expires_at
+FROM sessions
+WHERE id = $1;
+
+-- name: TouchSession :exec
+UPDATE sessions
+SET last_seen_at = $1
+WHERE id = $2;
+
+-- name: DeleteSession :exec
+DELETE FROM sessions
+WHERE id = $1;
+
+-- name: DeleteExpiredSessions :execrows
+DELETE FROM sessions
+WHERE expires_at < $1 OR last_seen_at < $2;
@@ -0,0 +1,14 @@
+-- name: GetTenant :one
+SELECT id, name, created_at
+FROM tenants
+WHERE id = $1;
+
+-- name: ListTenants :many
+SELECT id, name, created_at
+FROM tenants
+ORDER BY id;
+
+-- name: CreateTenant :one
+INSERT INTO tenants (id, name, created_at)
+VALUES ($1, $2, $3)
+RETURNING id, name, created_at;
@@ -1,30 +1,70 @@
 -- name: GetTodo :one
-SELECT id, title, description, completed, created_at, updated_at
+SELECT id, title, description, completed, created_at, updated_at, owner_id, tenant_id, version
 FROM todos
-WHERE id = $1;
+WHERE id = $1 AND owner_id = $2 AND tenant_id = $3;
 
 -- name: ListTodos :many
-SELECT id, title, description, completed, created_at, updated_at
+SELECT id, title, description, completed, created_at, updated_at, owner_id, tenant_id, version
 FROM todos
+WHERE owner_id = $1 AND tenant_id = $2
 ORDER BY created_at DESC;
 
 -- name: CreateTodo :one
-INSERT INTO todos (title, description, completed, created_at, updated_at)
-VALUES ($1, $2, $3, $4, $5)
-RETURNING id, title, description, completed, created_at, updated_at;
+INSERT INTO todos (title, description, completed, created_at, updated_at, owner_id, tenant_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, title, description, completed, created_at, updated_at, owner_id, tenant_id, version;
 
 -- name: UpdateTodo :one
 UPDATE todos
-SET title = $1, description = $2, completed = $3, updated_at = $4
-WHERE id = $5
-RETURNING id, title, description, completed, created_at, updated_at;
+SET title = sqlc.arg(title), description = sqlc.arg(description), completed = sqlc.arg(completed),
+    updated_at = sqlc.arg(updated_at), version = todos.version + 1
+FROM (
+    SELECT id, title, description, completed, updated_at, version
+    FROM todos
+    WHERE id = sqlc.arg(id) AND owner_id = sqlc.arg(owner_id) AND tenant_id = sqlc.arg(tenant_id)
+    FOR UPDATE
+) AS previous
+WHERE todos.id = previous.id
+    AND (sqlc.arg(expected_version)::integer = 0 OR todos.version = sqlc.arg(expected_version))
+RETURNING todos.id, todos.title, todos.description, todos.completed, todos.created_at, todos.updated_at,
+    todos.owner_id, todos.tenant_id, todos.version,
+    previous.title AS previous_title, previous.description AS previous_description,
+    previous.completed AS previous_completed, previous.updated_at AS previous_updated_at,
+    previous.version AS previous_version;
 
--- name: DeleteTodo :exec
+-- name: DeleteTodo :one
 DELETE FROM todos
-WHERE id = $1;
+WHERE id = sqlc.arg(id) AND owner_id = sqlc.arg(owner_id) AND tenant_id = sqlc.arg(tenant_id)
+    AND (sqlc.arg(expected_version)::integer = 0 OR version = sqlc.arg(expected_version))
+RETURNING id, title, description, completed, created_at, updated_at, owner_id, tenant_id, version;
 
 -- name: CompleteTodo :one
 UPDATE todos
-SET completed = true, updated_at = $1
-WHERE id = $2
-RETURNING id, title, description, completed, created_at, updated_at;
+SET completed = true, updated_at = sqlc.arg(updated_at), version = todos.version + 1
+FROM (
+    SELECT id, title, description, completed, updated_at, version
+    FROM todos
+    WHERE id = sqlc.arg(id) AND owner_id = sqlc.arg(owner_id) AND tenant_id = sqlc.arg(tenant_id)
+    FOR UPDATE
+) AS previous
+WHERE todos.id = previous.id
+RETURNING todos.id, todos.title, todos.description, todos.completed, todos.created_at, todos.updated_at,
+    todos.owner_id, todos.tenant_id, todos.version,
+    previous.title AS previous_title, previous.description AS previous_description,
+    previous.completed AS previous_completed, previous.updated_at AS previous_updated_at,
+    previous.version AS previous_version;
+
+-- name: SearchTodos :many
+WITH matches AS (
+    SELECT id, title, description, completed, created_at, updated_at, owner_id, tenant_id, version,
+        ts_rank(search_vector, query) AS rank, query
+    FROM todos, websearch_to_tsquery('english', sqlc.arg(query)) AS query
+    WHERE owner_id = sqlc.arg(owner_id) AND tenant_id = sqlc.arg(tenant_id) AND search_vector @@ query
+    ORDER BY rank DESC, id DESC
+    LIMIT sqlc.arg(page_size) OFFSET sqlc.arg(page_offset)
+)
+SELECT id, title, description, completed, created_at, updated_at, owner_id, tenant_id, version, rank,
+    ts_headline('english', title, query, E'HighlightAll=true, StartSel=\x02, StopSel=\x03') AS title_headline,
+    ts_headline('english', description, query, E'MaxFragments=2, StartSel=\x02, StopSel=\x03') AS description_headline
+FROM matches
+ORDER BY rank DESC, id DESC;
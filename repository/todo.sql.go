@@ -14,19 +14,53 @@ import (
 
 const completeTodo = `-- name: CompleteTodo :one
 UPDATE todos
-SET completed = true, updated_at = $1
-WHERE id = $2
-RETURNING id, title, description, completed, created_at, updated_at
+SET completed = true, updated_at = $1, version = todos.version + 1
+FROM (
+    SELECT id, title, description, completed, updated_at, version
+    FROM todos
+    WHERE id = $2 AND owner_id = $3 AND tenant_id = $4
+    FOR UPDATE
+) AS previous
+WHERE todos.id = previous.id
+RETURNING todos.id, todos.title, todos.description, todos.completed, todos.created_at, todos.updated_at,
+    todos.owner_id, todos.tenant_id, todos.version,
+    previous.title AS previous_title, previous.description AS previous_description,
+    previous.completed AS previous_completed, previous.updated_at AS previous_updated_at,
+    previous.version AS previous_version
 `
 
 type CompleteTodoParams struct {
 	UpdatedAt time.Time `json:"updated_at"`
 	ID        int32     `json:"id"`
+	OwnerID   int32     `json:"owner_id"`
+	TenantID  string    `json:"tenant_id"`
 }
 
-func (q *Queries) CompleteTodo(ctx context.Context, arg CompleteTodoParams) (models.Todo, error) {
-	row := q.db.QueryRow(ctx, completeTodo, arg.UpdatedAt, arg.ID)
-	var i models.Todo
+type CompleteTodoRow struct {
+	ID                  int32     `json:"id"`
+	Title               string    `json:"title"`
+	Description         string    `json:"description"`
+	Completed           bool      `json:"completed"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+	OwnerID             int32     `json:"owner_id"`
+	TenantID            string    `json:"tenant_id"`
+	Version             int32     `json:"version"`
+	PreviousTitle       string    `json:"previous_title"`
+	PreviousDescription string    `json:"previous_description"`
+	PreviousCompleted   bool      `json:"previous_completed"`
+	PreviousUpdatedAt   time.Time `json:"previous_updated_at"`
+	PreviousVersion     int32     `json:"previous_version"`
+}
+
+func (q *Queries) CompleteTodo(ctx context.Context, arg CompleteTodoParams) (CompleteTodoRow, error) {
+	row := q.db.QueryRow(ctx, completeTodo,
+		arg.UpdatedAt,
+		arg.ID,
+		arg.OwnerID,
+		arg.TenantID,
+	)
+	var i CompleteTodoRow
 	err := row.Scan(
 		&i.ID,
 		&i.Title,
@@ -34,14 +68,22 @@ func (q *Queries) CompleteTodo(ctx context.Context, arg CompleteTodoParams) (mod
 		&i.Completed,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.OwnerID,
+		&i.TenantID,
+		&i.Version,
+		&i.PreviousTitle,
+		&i.PreviousDescription,
+		&i.PreviousCompleted,
+		&i.PreviousUpdatedAt,
+		&i.PreviousVersion,
 	)
 	return i, err
 }
 
 const createTodo = `-- name: CreateTodo :one
-INSERT INTO todos (title, description, completed, created_at, updated_at)
-VALUES ($1, $2, $3, $4, $5)
-RETURNING id, title, description, completed, created_at, updated_at
+INSERT INTO todos (title, description, completed, created_at, updated_at, owner_id, tenant_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, title, description, completed, created_at, updated_at, owner_id, tenant_id, version
 `
 
 type CreateTodoParams struct {
@@ -50,6 +92,8 @@ type CreateTodoParams struct {
 	Completed   bool      `json:"completed"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+	OwnerID     int32     `json:"owner_id"`
+	TenantID    string    `json:"tenant_id"`
 }
 
 func (q *Queries) CreateTodo(ctx context.Context, arg CreateTodoParams) (models.Todo, error) {
@@ -59,6 +103,8 @@ func (q *Queries) CreateTodo(ctx context.Context, arg CreateTodoParams) (models.
 		arg.Completed,
 		arg.CreatedAt,
 		arg.UpdatedAt,
+		arg.OwnerID,
+		arg.TenantID,
 	)
 	var i models.Todo
 	err := row.Scan(
@@ -68,28 +114,67 @@ func (q *Queries) CreateTodo(ctx context.Context, arg CreateTodoParams) (models.
 		&i.Completed,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.OwnerID,
+		&i.TenantID,
+		&i.Version,
 	)
 	return i, err
 }
 
-const deleteTodo = `-- name: DeleteTodo :exec
+const deleteTodo = `-- name: DeleteTodo :one
 DELETE FROM todos
-WHERE id = $1
+WHERE id = $1 AND owner_id = $2 AND tenant_id = $3
+    AND ($4::integer = 0 OR version = $4)
+RETURNING id, title, description, completed, created_at, updated_at, owner_id, tenant_id, version
 `
 
-func (q *Queries) DeleteTodo(ctx context.Context, id int32) error {
-	_, err := q.db.Exec(ctx, deleteTodo, id)
-	return err
+type DeleteTodoParams struct {
+	ID              int32  `json:"id"`
+	OwnerID         int32  `json:"owner_id"`
+	TenantID        string `json:"tenant_id"`
+	ExpectedVersion int32  `json:"expected_version"`
+}
+
+func (q *Queries) DeleteTodo(ctx context.Context, arg DeleteTodoParams) (models.Todo, error) {
+	row := q.db.QueryRow(ctx, deleteTodo,
+		arg.ID,
+		arg.OwnerID,
+		arg.TenantID,
+		arg.ExpectedVersion,
+	)
+	var i models.Todo
+	err := row.Scan(
+		&i.ID,
+		&i.Title,
+		&i.Description,
+		&i.Completed,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.OwnerID,
+		&i.TenantID,
+		&i.Version,
+	)
+	return i, err
 }
 
 const getTodo = `-- name: GetTodo :one
-SELECT id, title, description, completed, created_at, updated_at
+SELECT id, title, description, completed, created_at, updated_at, owner_id, tenant_id, version
 FROM todos
-WHERE id = $1
+WHERE id = $1 AND owner_id = $2 AND tenant_id = $3
 `
 
-func (q *Queries) GetTodo(ctx context.Context, id int32) (models.Todo, error) {
-	row := q.db.QueryRow(ctx, getTodo, id)
+type GetTodoParams struct {
+	ID       int32  `json:"id"`
+	OwnerID  int32  `json:"owner_id"`
+	TenantID string `json:"tenant_id"`
+}
+
+func (q *Queries) GetTodo(ctx context.Context, arg GetTodoParams) (models.Todo, error) {
+	row := q.db.QueryRow(ctx, getTodo,
+		arg.ID,
+		arg.OwnerID,
+		arg.TenantID,
+	)
 	var i models.Todo
 	err := row.Scan(
 		&i.ID,
@@ -98,18 +183,27 @@ func (q *Queries) GetTodo(ctx context.Context, id int32) (models.Todo, error) {
 		&i.Completed,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.OwnerID,
+		&i.TenantID,
+		&i.Version,
 	)
 	return i, err
 }
 
 const listTodos = `-- name: ListTodos :many
-SELECT id, title, description, completed, created_at, updated_at
+SELECT id, title, description, completed, created_at, updated_at, owner_id, tenant_id, version
 FROM todos
+WHERE owner_id = $1 AND tenant_id = $2
 ORDER BY created_at DESC
 `
 
-func (q *Queries) ListTodos(ctx context.Context) ([]models.Todo, error) {
-	rows, err := q.db.Query(ctx, listTodos)
+type ListTodosParams struct {
+	OwnerID  int32  `json:"owner_id"`
+	TenantID string `json:"tenant_id"`
+}
+
+func (q *Queries) ListTodos(ctx context.Context, arg ListTodosParams) ([]models.Todo, error) {
+	rows, err := q.db.Query(ctx, listTodos, arg.OwnerID, arg.TenantID)
 	if err != nil {
 		return nil, err
 	}
@@ -124,6 +218,87 @@ func (q *Queries) ListTodos(ctx context.Context) ([]models.Todo, error) {
 			&i.Completed,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.OwnerID,
+			&i.TenantID,
+			&i.Version,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchTodos = `-- name: SearchTodos :many
+WITH matches AS (
+    SELECT id, title, description, completed, created_at, updated_at, owner_id, tenant_id, version,
+        ts_rank(search_vector, query) AS rank, query
+    FROM todos, websearch_to_tsquery('english', $1) AS query
+    WHERE owner_id = $2 AND tenant_id = $3 AND search_vector @@ query
+    ORDER BY rank DESC, id DESC
+    LIMIT $4 OFFSET $5
+)
+SELECT id, title, description, completed, created_at, updated_at, owner_id, tenant_id, version, rank,
+    ts_headline('english', title, query, E'HighlightAll=true, StartSel=\x02, StopSel=\x03') AS title_headline,
+    ts_headline('english', description, query, E'MaxFragments=2, StartSel=\x02, StopSel=\x03') AS description_headline
+FROM matches
+ORDER BY rank DESC, id DESC
+`
+
+type SearchTodosParams struct {
+	Query      string `json:"query"`
+	OwnerID    int32  `json:"owner_id"`
+	TenantID   string `json:"tenant_id"`
+	PageSize   int32  `json:"page_size"`
+	PageOffset int32  `json:"page_offset"`
+}
+
+type SearchTodosRow struct {
+	ID                  int32     `json:"id"`
+	Title               string    `json:"title"`
+	Description         string    `json:"description"`
+	Completed           bool      `json:"completed"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+	OwnerID             int32     `json:"owner_id"`
+	TenantID            string    `json:"tenant_id"`
+	Version             int32     `json:"version"`
+	Rank                float32   `json:"rank"`
+	TitleHeadline       string    `json:"title_headline"`
+	DescriptionHeadline string    `json:"description_headline"`
+}
+
+func (q *Queries) SearchTodos(ctx context.Context, arg SearchTodosParams) ([]SearchTodosRow, error) {
+	rows, err := q.db.Query(ctx, searchTodos,
+		arg.Query,
+		arg.OwnerID,
+		arg.TenantID,
+		arg.PageSize,
+		arg.PageOffset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchTodosRow
+	for rows.Next() {
+		var i SearchTodosRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Title,
+			&i.Description,
+			&i.Completed,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.OwnerID,
+			&i.TenantID,
+			&i.Version,
+			&i.Rank,
+			&i.TitleHeadline,
+			&i.DescriptionHeadline,
 		); err != nil {
 			return nil, err
 		}
@@ -137,28 +312,63 @@ func (q *Queries) ListTodos(ctx context.Context) ([]models.Todo, error) {
 
 const updateTodo = `-- name: UpdateTodo :one
 UPDATE todos
-SET title = $1, description = $2, completed = $3, updated_at = $4
-WHERE id = $5
-RETURNING id, title, description, completed, created_at, updated_at
+SET title = $1, description = $2, completed = $3,
+    updated_at = $4, version = todos.version + 1
+FROM (
+    SELECT id, title, description, completed, updated_at, version
+    FROM todos
+    WHERE id = $5 AND owner_id = $6 AND tenant_id = $7
+    FOR UPDATE
+) AS previous
+WHERE todos.id = previous.id
+    AND ($8::integer = 0 OR todos.version = $8)
+RETURNING todos.id, todos.title, todos.description, todos.completed, todos.created_at, todos.updated_at,
+    todos.owner_id, todos.tenant_id, todos.version,
+    previous.title AS previous_title, previous.description AS previous_description,
+    previous.completed AS previous_completed, previous.updated_at AS previous_updated_at,
+    previous.version AS previous_version
 `
 
 type UpdateTodoParams struct {
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Completed   bool      `json:"completed"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	ID          int32     `json:"id"`
+	Title           string    `json:"title"`
+	Description     string    `json:"description"`
+	Completed       bool      `json:"completed"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	ID              int32     `json:"id"`
+	OwnerID         int32     `json:"owner_id"`
+	TenantID        string    `json:"tenant_id"`
+	ExpectedVersion int32     `json:"expected_version"`
+}
+
+type UpdateTodoRow struct {
+	ID                  int32     `json:"id"`
+	Title               string    `json:"title"`
+	Description         string    `json:"description"`
+	Completed           bool      `json:"completed"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+	OwnerID             int32     `json:"owner_id"`
+	TenantID            string    `json:"tenant_id"`
+	Version             int32     `json:"version"`
+	PreviousTitle       string    `json:"previous_title"`
+	PreviousDescription string    `json:"previous_description"`
+	PreviousCompleted   bool      `json:"previous_completed"`
+	PreviousUpdatedAt   time.Time `json:"previous_updated_at"`
+	PreviousVersion     int32     `json:"previous_version"`
 }
 
-func (q *Queries) UpdateTodo(ctx context.Context, arg UpdateTodoParams) (models.Todo, error) {
+func (q *Queries) UpdateTodo(ctx context.Context, arg UpdateTodoParams) (UpdateTodoRow, error) {
 	row := q.db.QueryRow(ctx, updateTodo,
 		arg.Title,
 		arg.Description,
 		arg.Completed,
 		arg.UpdatedAt,
 		arg.ID,
+		arg.OwnerID,
+		arg.TenantID,
+		arg.ExpectedVersion,
 	)
-	var i models.Todo
+	var i UpdateTodoRow
 	err := row.Scan(
 		&i.ID,
 		&i.Title,
@@ -166,6 +376,14 @@ func (q *Queries) UpdateTodo(ctx context.Context, arg UpdateTodoParams) (models.
 		&i.Completed,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.OwnerID,
+		&i.TenantID,
+		&i.Version,
+		&i.PreviousTitle,
+		&i.PreviousDescription,
+		&i.PreviousCompleted,
+		&i.PreviousUpdatedAt,
+		&i.PreviousVersion,
 	)
 	return i, err
 }
@@ -0,0 +1,20 @@
+-- name: UpsertUser :one
+INSERT INTO users (subject, name, created_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (subject) DO UPDATE SET name = EXCLUDED.name
+RETURNING id, subject, name, created_at, username, password_hash, scopes, tenant_id;
+
+-- name: GetUser :one
+SELECT id, subject, name, created_at, username, password_hash, scopes, tenant_id
+FROM users
+WHERE id = $1;
+
+-- name: GetUserByUsername :one
+SELECT id, subject, name, created_at, username, password_hash, scopes, tenant_id
+FROM users
+WHERE username = $1;
+
+-- name: CreateLocalUser :one
+INSERT INTO users (subject, name, username, password_hash, scopes, tenant_id, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, subject, name, created_at, username, password_hash, scopes, tenant_id;
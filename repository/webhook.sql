@@ -0,0 +1,72 @@
+-- name: CreateWebhookSubscription :one
+INSERT INTO webhook_subscriptions (url, secret, event_types, active, created_at, updated_at, tenant_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, url, secret, event_types, active, created_at, updated_at, tenant_id;
+
+-- name: GetWebhookSubscription :one
+SELECT id, url, secret, event_types, active, created_at, updated_at, tenant_id
+FROM webhook_subscriptions
+WHERE id = sqlc.arg(id) AND (sqlc.narg(tenant_id)::text IS NULL OR tenant_id = sqlc.narg(tenant_id));
+
+-- name: ListWebhookSubscriptions :many
+SELECT id, url, secret, event_types, active, created_at, updated_at, tenant_id
+FROM webhook_subscriptions
+WHERE (sqlc.narg(tenant_id)::text IS NULL OR tenant_id = sqlc.narg(tenant_id))
+ORDER BY id;
+
+-- name: ListActiveWebhookSubscriptions :many
+SELECT id, url, secret, event_types, active, created_at, updated_at, tenant_id
+FROM webhook_subscriptions
+WHERE active = true AND (tenant_id IS NULL OR tenant_id = sqlc.narg(tenant_id))
+ORDER BY id;
+
+-- name: UpdateWebhookSubscription :one
+UPDATE webhook_subscriptions
+SET url = sqlc.arg(url), event_types = sqlc.arg(event_types), active = sqlc.arg(active), updated_at = sqlc.arg(updated_at)
+WHERE id = sqlc.arg(id) AND (sqlc.narg(tenant_id)::text IS NULL OR tenant_id = sqlc.narg(tenant_id))
+RETURNING id, url, secret, event_types, active, created_at, updated_at, tenant_id;
+
+-- name: DeleteWebhookSubscription :execrows
+DELETE FROM webhook_subscriptions
+WHERE id = sqlc.arg(id) AND (sqlc.narg(tenant_id)::text IS NULL OR tenant_id = sqlc.narg(tenant_id));
+
+-- name: CreateWebhookDelivery :exec
+INSERT INTO webhook_deliveries (subscription_id, event_key, event_type, payload, next_attempt_at, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (subscription_id, event_key) DO NOTHING;
+
+-- name: ClaimDueWebhookDeliveries :many
+UPDATE webhook_deliveries
+SET next_attempt_at = sqlc.arg(lease_until)
+WHERE id IN (
+    SELECT d.id
+    FROM webhook_deliveries d
+    WHERE d.status = 'pending' AND d.next_attempt_at <= sqlc.arg(now)
+    ORDER BY d.next_attempt_at
+    LIMIT sqlc.arg(batch_size)
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, subscription_id, event_key, event_type, payload, status, attempts, next_attempt_at, last_status_code, last_error, created_at, updated_at;
+
+-- name: RecordWebhookDeliveryAttempt :exec
+UPDATE webhook_deliveries
+SET status = $1, attempts = attempts + 1, last_status_code = $2, last_error = $3, next_attempt_at = $4, updated_at = $5
+WHERE id = $6;
+
+-- name: GetWebhookDelivery :one
+SELECT id, subscription_id, event_key, event_type, payload, status, attempts, next_attempt_at, last_status_code, last_error, created_at, updated_at
+FROM webhook_deliveries
+WHERE id = $1;
+
+-- name: ListWebhookDeliveries :many
+SELECT id, subscription_id, event_key, event_type, payload, status, attempts, next_attempt_at, last_status_code, last_error, created_at, updated_at
+FROM webhook_deliveries
+WHERE subscription_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
+
+-- name: RedeliverWebhookDelivery :one
+UPDATE webhook_deliveries
+SET status = 'pending', attempts = 0, next_attempt_at = $1, updated_at = $1
+WHERE id = $2 AND subscription_id = $3
+RETURNING id, subscription_id, event_key, event_type, payload, status, attempts, next_attempt_at, last_status_code, last_error, created_at, updated_at;
@@ -0,0 +1,129 @@
+package router
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/models"
+	"github.com/doug-benn/go-server-starter/services"
+)
+
+type apiKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresIn is a Go duration such as "720h", empty for a key that never expires.
+	ExpiresIn string `json:"expires_in,omitempty"`
+	// Tenant binds the key to a tenant, empty for an operator key.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// createdAPIKey is only returned on creation, the key is never shown again.
+type createdAPIKey struct {
+	models.ApiKey
+	Key string `json:"key"`
+}
+
+func HandleCreateAPIKey(logger *slog.Logger, apiKeyService services.APIKeyService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := decode[apiKeyRequest](r)
+		if err != nil {
+			http.Error(w, err.Error(), decodeStatus(err))
+			return
+		}
+
+		var ttl time.Duration
+		if req.ExpiresIn != "" {
+			ttl, err = time.ParseDuration(req.ExpiresIn)
+			if err != nil || ttl <= 0 {
+				http.Error(w, "invalid expires_in", http.StatusBadRequest)
+				return
+			}
+		}
+
+		principal, _ := auth.PrincipalFromContext(r.Context())
+		for _, scope := range req.Scopes {
+			if !canGrant(principal, scope) {
+				http.Error(w, "cannot grant scope "+scope, http.StatusForbidden)
+				return
+			}
+		}
+
+		// Admins bound to a tenant only mint keys for it, binding a key to
+		// any other tenant takes an operator
+		if tenant := principalTenant(r); tenant != "" {
+			req.Tenant = tenant
+		} else if req.Tenant != "" && (principal == nil || !principal.IsOperator()) {
+			http.Error(w, "binding a key to a tenant requires the operator scope", http.StatusForbidden)
+			return
+		}
+
+		key, plaintext, err := apiKeyService.CreateKey(r.Context(), req.Name, req.Scopes, ttl, req.Tenant)
+		if errors.Is(err, services.ErrAPIKeyNameRequired) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			writeError(w, errorStatus(err))
+			return
+		}
+
+		if err := encode(w, http.StatusCreated, createdAPIKey{*key, plaintext}); err != nil {
+			logger.Error("failed to encode api key response", "error", err)
+		}
+	}
+}
+
+func HandleListAPIKeys(logger *slog.Logger, apiKeyService services.APIKeyService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keys, err := apiKeyService.ListKeys(r.Context(), principalTenant(r))
+		if err != nil {
+			writeError(w, errorStatus(err))
+			return
+		}
+
+		if err := encode(w, http.StatusOK, keys); err != nil {
+			logger.Error("failed to encode api keys response", "error", err)
+		}
+	}
+}
+
+func HandleRevokeAPIKey(apiKeyService services.APIKeyService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := pathID(r, "id")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := apiKeyService.RevokeKey(r.Context(), id, principalTenant(r)); err != nil {
+			writeError(w, errorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// canGrant reports whether principal may hand scope on to a new key. Keys
+// never carry more than their creator holds, and the operator scope is only
+// passed on by operators themselves.
+func canGrant(principal *auth.Principal, scope string) bool {
+	if principal == nil {
+		return false
+	}
+	if scope == auth.ScopeOperator {
+		return principal.IsOperator()
+	}
+	return principal.HasScope(scope)
+}
+
+// principalTenant returns the tenant the caller is bound to, empty for
+// operators, who manage the keys of every tenant.
+func principalTenant(r *http.Request) string {
+	if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+		return principal.Tenant
+	}
+	return ""
+}
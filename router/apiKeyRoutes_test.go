@@ -0,0 +1,127 @@
+package router
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/models"
+	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/doug-benn/go-server-starter/services"
+	"github.com/doug-benn/go-server-starter/testutils"
+)
+
+func TestAPIKeyRoutesScopedToTenant(t *testing.T) {
+	var created, listed, revoked *string
+	repo := &testutils.MockQuerier{
+		CreateApiKeyFunc: func(ctx context.Context, arg repository.CreateApiKeyParams) (models.ApiKey, error) {
+			created = arg.TenantID
+			return models.ApiKey{ID: 1, Name: arg.Name, TenantID: arg.TenantID}, nil
+		},
+		ListApiKeysFunc: func(ctx context.Context, tenantID *string) ([]models.ApiKey, error) {
+			listed = tenantID
+			return nil, nil
+		},
+		RevokeApiKeyFunc: func(ctx context.Context, arg repository.RevokeApiKeyParams) (int64, error) {
+			revoked = arg.TenantID
+			return 1, nil
+		},
+	}
+	apiKeyService := services.NewAPIKeyService(repo, slog.Default())
+	mux := http.NewServeMux()
+	mux.Handle("POST /admin/api-keys", HandleCreateAPIKey(slog.Default(), apiKeyService))
+	mux.Handle("GET /admin/api-keys", HandleListAPIKeys(slog.Default(), apiKeyService))
+	mux.Handle("DELETE /admin/api-keys/{id}", HandleRevokeAPIKey(apiKeyService))
+
+	serve := func(principal *auth.Principal, method, path, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = req.WithContext(auth.WithPrincipal(req.Context(), principal))
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	tenantOf := func(p *string) string {
+		if p == nil {
+			return ""
+		}
+		return *p
+	}
+
+	// A tenant admin can neither mint operator keys nor keys of another tenant
+	tenantAdmin := &auth.Principal{ID: "user:1", Scopes: []string{auth.ScopeAdmin}, Tenant: "acme"}
+	for _, body := range []string{`{"name":"ci","tenant":""}`, `{"name":"ci","tenant":"globex"}`} {
+		if code := serve(tenantAdmin, http.MethodPost, "/admin/api-keys", body); code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d", code)
+		}
+		if tenantOf(created) != "acme" {
+			t.Errorf("expected a key bound to acme for %s, got %q", body, tenantOf(created))
+		}
+	}
+	serve(tenantAdmin, http.MethodGet, "/admin/api-keys", "")
+	serve(tenantAdmin, http.MethodDelete, "/admin/api-keys/1", "")
+	if tenantOf(listed) != "acme" || tenantOf(revoked) != "acme" {
+		t.Errorf("expected listing and revoking to be scoped to acme, got %q and %q", tenantOf(listed), tenantOf(revoked))
+	}
+
+	// Operators manage every tenant
+	operator := &auth.Principal{ID: "user:2", Scopes: []string{auth.ScopeAdmin, auth.ScopeOperator}}
+	serve(operator, http.MethodPost, "/admin/api-keys", `{"name":"ci","tenant":"globex"}`)
+	serve(operator, http.MethodGet, "/admin/api-keys", "")
+	if tenantOf(created) != "globex" || listed != nil {
+		t.Errorf("expected an operator to create for globex and list every key, got %q and %v", tenantOf(created), listed)
+	}
+}
+
+func TestCreateAPIKeyRefusesEscalation(t *testing.T) {
+	var calls int
+	repo := &testutils.MockQuerier{
+		CreateApiKeyFunc: func(ctx context.Context, arg repository.CreateApiKeyParams) (models.ApiKey, error) {
+			calls++
+			return models.ApiKey{ID: 1, Name: arg.Name, TenantID: arg.TenantID}, nil
+		},
+	}
+	handler := HandleCreateAPIKey(slog.Default(), services.NewAPIKeyService(repo, slog.Default()))
+
+	serve := func(principal *auth.Principal, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/admin/api-keys", strings.NewReader(body))
+		req = req.WithContext(auth.WithPrincipal(req.Context(), principal))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	tenantAdmin := &auth.Principal{ID: "user:1", Scopes: []string{auth.ScopeAdmin, auth.ScopeTodosRead}, Tenant: "acme"}
+	admin := &auth.Principal{ID: "user:2", Scopes: []string{auth.ScopeAdmin}}
+	operator := &auth.Principal{ID: "user:3", Scopes: []string{auth.ScopeAdmin, auth.ScopeOperator}}
+
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		body      string
+		want      int
+	}{
+		{"scope the caller holds", tenantAdmin, `{"name":"ci","scopes":["todos:read"]}`, http.StatusCreated},
+		{"scope the caller lacks", tenantAdmin, `{"name":"ci","scopes":["todos:read","todos:write"]}`, http.StatusForbidden},
+		{"operator scope from a tenant admin", &auth.Principal{ID: "user:4", Scopes: []string{auth.ScopeAdmin, auth.ScopeOperator}, Tenant: "acme"}, `{"name":"ci","scopes":["operator"]}`, http.StatusForbidden},
+		{"operator scope from a non-operator", admin, `{"name":"ci","scopes":["operator"]}`, http.StatusForbidden},
+		{"operator scope from an operator", operator, `{"name":"ci","scopes":["operator"]}`, http.StatusCreated},
+		{"tenant key from a non-operator", admin, `{"name":"ci","tenant":"globex"}`, http.StatusForbidden},
+		{"tenant key from an operator", operator, `{"name":"ci","tenant":"globex"}`, http.StatusCreated},
+		{"unbound key from a non-operator", admin, `{"name":"ci","scopes":["admin"]}`, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = 0
+			if code := serve(tt.principal, tt.body); code != tt.want {
+				t.Fatalf("expected %d, got %d", tt.want, code)
+			}
+			if tt.want == http.StatusForbidden && calls != 0 {
+				t.Error("expected no key to be created")
+			}
+		})
+	}
+}
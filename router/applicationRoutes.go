@@ -5,17 +5,16 @@ import (
 	"log/slog"
 	"net/http"
 
-	"github.com/patrickmn/go-cache"
+	"github.com/doug-benn/go-server-starter/cache"
 )
 
-func HandleHelloWorld(logger *slog.Logger, c *cache.Cache) http.HandlerFunc {
-	c.Add("hello_count", 0, 0)
-
-	return func(w http.ResponseWriter, _ *http.Request) {
-		count, err := c.IncrementInt("hello_count", 1)
+func HandleHelloWorld(logger *slog.Logger, c cache.Cache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		count, err := c.Increment(r.Context(), "hello_count", 1, 0)
 		if err != nil {
-			c.Add("hello_count", 1, 0)
-			count = 1
+			logger.ErrorContext(r.Context(), "failed to count hello world calls", "error", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
 		}
 
 		resp := map[string]any{
@@ -6,13 +6,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
-	"time"
 
-	"github.com/patrickmn/go-cache"
+	"github.com/doug-benn/go-server-starter/cache"
 )
 
 func TestHandleHelloWorld_RequestCounter(t *testing.T) {
-	c := cache.New(5*time.Minute, 10*time.Minute)
+	c := cache.NewMemoryCache(cache.MemoryConfig{})
 	handler := HandleHelloWorld(slog.Default(), c)
 
 	req := httptest.NewRequest(http.MethodGet, "/helloworld", nil)
@@ -0,0 +1,70 @@
+package router
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/doug-benn/go-server-starter/models"
+)
+
+// todoETag is a strong ETag for a todo, which changes with its version.
+func todoETag(todo *models.Todo) string {
+	return `"` + strconv.Itoa(int(todo.Version)) + `"`
+}
+
+// bodyETag is a strong ETag for a response body.
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header value lists
+// etag. If-Match compares strongly, so weak ETags never match it, while
+// If-None-Match compares weakly.
+func etagMatches(header, etag string, weak bool) bool {
+	for candidate := range strings.SplitSeq(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = candidate[2:]
+		}
+		if candidate == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified answers 304 when the client already has the representation
+// tagged etag.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	if header := r.Header.Get("If-None-Match"); header != "" && etagMatches(header, etag, true) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// expectedVersion checks If-Match against the current todo, returning the
+// version an update must still find or zero when the request has no
+// precondition. It answers 412 and returns false when the precondition fails.
+func expectedVersion(w http.ResponseWriter, r *http.Request, current *models.Todo) (int32, bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return 0, true
+	}
+	if !etagMatches(header, todoETag(current), false) {
+		writeError(w, http.StatusPreconditionFailed)
+		return 0, false
+	}
+	return current.Version, true
+}
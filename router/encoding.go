@@ -0,0 +1,67 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/doug-benn/go-server-starter/services"
+	"github.com/jackc/pgx/v5"
+)
+
+// encode writes v as a JSON response with the given status code.
+func encode[T any](w http.ResponseWriter, status int, v T) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		return fmt.Errorf("encode json: %w", err)
+	}
+	return nil
+}
+
+// decode reads a JSON request body into a value of type T.
+func decode[T any](r *http.Request) (T, error) {
+	var v T
+	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+		return v, fmt.Errorf("decode json: %w", err)
+	}
+	return v, nil
+}
+
+// decodeStatus maps an error from decode to the HTTP status code to respond with.
+func decodeStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// pathID parses a numeric path value such as {id}.
+func pathID(r *http.Request, name string) (int32, error) {
+	id, err := strconv.ParseInt(r.PathValue(name), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return int32(id), nil
+}
+
+// errorStatus maps a service error to the HTTP status code to respond with.
+func errorStatus(err error) int {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, services.ErrUnauthenticated) {
+		return http.StatusUnauthorized
+	}
+	if errors.Is(err, services.ErrVersionConflict) {
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusInternalServerError
+}
+
+func writeError(w http.ResponseWriter, status int) {
+	http.Error(w, http.StatusText(status), status)
+}
@@ -0,0 +1,89 @@
+package router
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/middleware"
+)
+
+// Route is a handler registered by AddRoutes together with the policy a caller
+// must satisfy to reach it.
+type Route struct {
+	Pattern string
+	Handler http.Handler
+	Policy  auth.Policy
+}
+
+// routeInfo describes a route in the introspection response.
+type routeInfo struct {
+	Pattern string   `json:"pattern"`
+	Method  string   `json:"method,omitempty"`
+	Path    string   `json:"path"`
+	Public  bool     `json:"public"`
+	Scopes  []string `json:"scopes"`
+	Roles   []string `json:"roles"`
+	Tenant  bool     `json:"tenant"`
+}
+
+// registerRoutes wraps every handler with its policy and adds it to mux.
+func registerRoutes(mux *http.ServeMux, routes []Route) {
+	for _, route := range routes {
+		mux.Handle(route.Pattern, middleware.Authorize(route.Policy)(route.Handler))
+	}
+}
+
+// PublicRoutes reports whether mux routes a request to a route whose policy
+// is public, so that it can be let through authentication without a
+// credential. Public access is then only declared in the route table.
+// Requests mux routes nowhere are let through as well, so that the mux answers
+// them with 404, or 405 for a known path called with another method.
+func PublicRoutes(mux *http.ServeMux, routes []Route) func(r *http.Request) bool {
+	public := make(map[string]bool)
+	for _, route := range routes {
+		if route.Policy.Public {
+			public[route.Pattern] = true
+		}
+	}
+	return func(r *http.Request) bool {
+		_, pattern := mux.Handler(r)
+		return pattern == "" || public[pattern]
+	}
+}
+
+// HandleListRoutes lists every registered route with its access requirements.
+func HandleListRoutes(logger *slog.Logger, routes []Route) http.HandlerFunc {
+	infos := make([]routeInfo, 0, len(routes))
+	for _, route := range routes {
+		method, path, ok := strings.Cut(route.Pattern, " ")
+		if !ok {
+			method, path = "", route.Pattern
+		}
+		infos = append(infos, routeInfo{
+			Pattern: route.Pattern,
+			Method:  method,
+			Path:    path,
+			Public:  route.Policy.Public,
+			Scopes:  nonNil(route.Policy.Scopes),
+			Roles:   nonNil(route.Policy.Roles),
+			Tenant:  route.Policy.Tenant,
+		})
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Routes only change on deploy
+		w.Header().Set("Cache-Control", "private, max-age=300")
+		if err := encode(w, http.StatusOK, infos); err != nil {
+			logger.Error("failed to encode routes response", "error", err)
+		}
+	}
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,112 @@
+package router
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/doug-benn/go-server-starter/auth"
+)
+
+func TestRegisterRoutesEnforcesPolicies(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	mux := http.NewServeMux()
+	registerRoutes(mux, []Route{
+		{"GET /health", ok, auth.PublicPolicy()},
+		{"GET /todos", ok, auth.RequireScopes(auth.ScopeTodosRead)},
+		{"POST /todos", ok, auth.RequireScopes(auth.ScopeTodosWrite)},
+	})
+
+	reader := &auth.Principal{ID: "user:1", Scopes: []string{auth.ScopeTodosRead}}
+	tests := []struct {
+		method, path string
+		principal    *auth.Principal
+		want         int
+	}{
+		{"GET", "/health", nil, http.StatusOK},
+		{"GET", "/todos", nil, http.StatusUnauthorized},
+		{"GET", "/todos", reader, http.StatusOK},
+		{"POST", "/todos", reader, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.principal != nil {
+			req = req.WithContext(auth.WithPrincipal(req.Context(), tt.principal))
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.path, tt.want, rec.Code)
+		}
+	}
+}
+
+func TestHandleListRoutes(t *testing.T) {
+	routes := []Route{
+		{"GET /todos", nil, auth.RequireScopes(auth.ScopeTodosRead)},
+		{"/events", nil, auth.Policy{Roles: []string{"viewer"}}},
+		{"GET /health", nil, auth.PublicPolicy()},
+	}
+
+	rec := httptest.NewRecorder()
+	HandleListRoutes(slog.Default(), routes).ServeHTTP(rec, httptest.NewRequest("GET", "/admin/routes", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+
+	var infos []routeInfo
+	if err := json.NewDecoder(rec.Body).Decode(&infos); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(infos) != 3 {
+		t.Fatalf("expected 3 routes, got %d", len(infos))
+	}
+	if infos[0].Method != "GET" || infos[0].Path != "/todos" || infos[0].Scopes[0] != auth.ScopeTodosRead {
+		t.Errorf("unexpected route %+v", infos[0])
+	}
+	if infos[1].Method != "" || infos[1].Path != "/events" || infos[1].Roles[0] != "viewer" {
+		t.Errorf("unexpected route %+v", infos[1])
+	}
+	if !infos[2].Public {
+		t.Errorf("expected /health to be public, got %+v", infos[2])
+	}
+}
+
+func TestPublicRoutes(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	mux := http.NewServeMux()
+	routes := []Route{
+		{"GET /health", ok, auth.PublicPolicy()},
+		{"GET /todos", ok, auth.RequireScopes(auth.ScopeTodosRead)},
+	}
+	registerRoutes(mux, routes)
+	public := PublicRoutes(mux, routes)
+
+	tests := []struct {
+		method, path string
+		want         bool
+		status       int
+	}{
+		{"GET", "/health", true, http.StatusOK},
+		{"GET", "/todos", false, http.StatusUnauthorized},
+		{"GET", "/missing", true, http.StatusNotFound},
+		// Known paths keep the mux's answer to another method
+		{"DELETE", "/todos", true, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := public(req); got != tt.want {
+			t.Errorf("%s %s: expected public %v, got %v", tt.method, tt.path, tt.want, got)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.status, rec.Code)
+		}
+	}
+}
@@ -4,27 +4,74 @@ import (
 	"log/slog"
 	"net/http"
 
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/cache"
 	"github.com/doug-benn/go-server-starter/producer"
 	"github.com/doug-benn/go-server-starter/services"
+	"github.com/doug-benn/go-server-starter/session"
 	"github.com/doug-benn/go-server-starter/sse"
-	"github.com/patrickmn/go-cache"
 )
 
+// AddRoutes registers every route on mux and returns them.
 func AddRoutes(
 	mux *http.ServeMux,
 	logger *slog.Logger,
-	appCache *cache.Cache,
+	appCache cache.Cache,
 	producer *producer.Producer[sse.Event],
+	userService services.UserService,
 	todoService services.TodoService,
-) {
+	webhookService services.WebhookService,
+	apiKeyService services.APIKeyService,
+	accountService services.AccountService,
+	sessions *session.Manager,
+) []Route {
+	read := auth.RequireScopes(auth.ScopeTodosRead).RequireTenant()
+	write := auth.RequireScopes(auth.ScopeTodosWrite).RequireTenant()
+	admin := auth.RequireScopes(auth.ScopeAdmin)
 
-	//Register all routes
-	mux.Handle("GET /helloworld", HandleHelloWorld(logger, appCache))
-	mux.Handle("GET /todos", HandleGetTodos(logger, todoService))
-	mux.Handle("/events", sse.SSEHandler(producer, logger))
+	// Every route with the scopes or roles needed to call it, todos live in a tenant
+	routes := []Route{
+		{"GET /helloworld", HandleHelloWorld(logger, appCache), auth.Policy{}},
 
-	// System Routes for debugging
-	mux.Handle("GET /health", HandleGetHealth())
-	mux.Handle("/debug/", HandleGetDebug())
-	mux.Handle("/", http.NotFoundHandler())
+		// Password login for browser clients
+		{"POST /login", HandleLogin(logger, accountService, sessions), auth.PublicPolicy()},
+		{"POST /logout", HandleLogout(sessions), auth.Policy{}},
+		{"GET /session", HandleGetSession(logger), auth.Policy{}},
+
+		{"GET /todos", HandleGetTodos(logger, todoService), read},
+		{"GET /todos/search", HandleSearchTodos(logger, todoService), read},
+		// Retried safely with an Idempotency-Key
+		{"POST /todos", HandleCreateTodo(logger, todoService), write},
+		{"GET /todos/{id}", HandleGetTodo(logger, todoService), read},
+		// Conditional on If-Match, so that concurrent edits are not lost
+		{"PUT /todos/{id}", HandleReplaceTodo(logger, todoService), write},
+		{"PATCH /todos/{id}", HandleUpdateTodo(logger, todoService), write},
+		{"DELETE /todos/{id}", HandleDeleteTodo(todoService), write},
+		{"GET /events", sse.NewSSEHandler(producer, logger, sse.OnEvent(services.TodoEventFilter(userService))), read},
+
+		// Webhook subscriptions and delivery logs
+		{"POST /webhooks", HandleCreateWebhook(logger, webhookService), admin},
+		{"GET /webhooks", HandleListWebhooks(logger, webhookService), admin},
+		{"GET /webhooks/{id}", HandleGetWebhook(logger, webhookService), admin},
+		{"PUT /webhooks/{id}", HandleUpdateWebhook(logger, webhookService), admin},
+		{"DELETE /webhooks/{id}", HandleDeleteWebhook(webhookService), admin},
+		{"GET /webhooks/{id}/deliveries", HandleListWebhookDeliveries(logger, webhookService), admin},
+		{"POST /webhooks/{id}/deliveries/{deliveryID}/redeliver", HandleRedeliverWebhook(logger, webhookService), admin},
+
+		// API key administration
+		{"POST /admin/api-keys", HandleCreateAPIKey(logger, apiKeyService), admin},
+		{"GET /admin/api-keys", HandleListAPIKeys(logger, apiKeyService), admin},
+		{"DELETE /admin/api-keys/{id}", HandleRevokeAPIKey(apiKeyService), admin},
+
+		// System Routes for debugging
+		{"GET /health", HandleGetHealth(), auth.PublicPolicy()},
+		{"/debug/", HandleGetDebug(), admin},
+	}
+
+	// Lets security reviews see every route's requirements without reading code
+	routes = append(routes, Route{"GET /admin/routes", nil, admin})
+	routes[len(routes)-1].Handler = HandleListRoutes(logger, routes)
+
+	registerRoutes(mux, routes)
+	return routes
 }
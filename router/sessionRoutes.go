@@ -0,0 +1,74 @@
+package router
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/services"
+	"github.com/doug-benn/go-server-starter/session"
+)
+
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// HandleLogin checks a username and password and starts a session. The
+// response sets the session cookie and the CSRF cookie that scripts echo in
+// the X-CSRF-Token header on unsafe requests.
+func HandleLogin(logger *slog.Logger, accountService services.AccountService, sessions *session.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := decode[loginRequest](r)
+		if err != nil {
+			http.Error(w, err.Error(), decodeStatus(err))
+			return
+		}
+
+		user, err := accountService.Login(r.Context(), req.Username, req.Password)
+		if errors.Is(err, services.ErrInvalidLogin) {
+			logger.WarnContext(r.Context(), "login failed", "remote_ip", r.RemoteAddr)
+			writeError(w, http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			writeError(w, errorStatus(err))
+			return
+		}
+
+		if err := sessions.Login(r.Context(), w, r, user.ID); err != nil {
+			writeError(w, errorStatus(err))
+			return
+		}
+		if err := encode(w, http.StatusOK, user); err != nil {
+			logger.Error("failed to encode login response", "error", err)
+		}
+	}
+}
+
+// HandleLogout ends the session of the request.
+func HandleLogout(sessions *session.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := sessions.Logout(r.Context(), w, r); err != nil {
+			writeError(w, errorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleGetSession returns the principal of the request, which lets the web UI
+// check whether its session is still valid.
+func HandleGetSession(logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := auth.PrincipalFromContext(r.Context())
+		if !ok {
+			writeError(w, http.StatusUnauthorized)
+			return
+		}
+		if err := encode(w, http.StatusOK, principal); err != nil {
+			logger.Error("failed to encode session response", "error", err)
+		}
+	}
+}
@@ -65,10 +65,6 @@ func HandleGetDebug() http.Handler {
 
 	// Profile route starts Pyroscope on first use
 	mux.HandleFunc("/debug/pprof/profile", func(w http.ResponseWriter, r *http.Request) {
-		// Extend deadline since CPU profiles typically exceed the server's WriteTimeout
-		rc := http.NewResponseController(w)
-		rc.SetWriteDeadline(time.Now().Add(60 * time.Second))
-
 		pyroscopeOnce.Do(func() {
 			pyroscope.Start(pyroscope.Config{
 				ApplicationName: "go-server-starter",
@@ -1,10 +1,15 @@
 package router
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
 
+	"github.com/doug-benn/go-server-starter/models"
 	"github.com/doug-benn/go-server-starter/services"
 )
 
@@ -12,15 +17,282 @@ func HandleGetTodos(logger *slog.Logger, todoService services.TodoService) http.
 	return func(w http.ResponseWriter, r *http.Request) {
 		data, err := todoService.GetAllTodos(r.Context())
 		if err != nil {
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			writeError(w, errorStatus(err))
+			return
+		}
+
+		// Encoded first so that the ETag can be derived from the body
+		var body bytes.Buffer
+		if err := json.NewEncoder(&body).Encode(data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		etag := bodyETag(body.Bytes())
+		if notModified(w, r, etag) {
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", etag)
 		w.WriteHeader(http.StatusOK)
-		if err := json.NewEncoder(w).Encode(data); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		w.Write(body.Bytes())
+	}
+}
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+
+	// maxUpdateAttempts bounds how often an update without If-Match is applied
+	// again after losing a race with another write.
+	maxUpdateAttempts = 3
+)
+
+// todoSearchResponse is a page of search results, NextOffset requests the
+// next page and is left out on the last one.
+type todoSearchResponse struct {
+	Results    []services.TodoSearchResult `json:"results"`
+	NextOffset *int                        `json:"next_offset,omitempty"`
+}
+
+// HandleSearchTodos searches titles and descriptions with ?q=, which takes web
+// search syntax: quoted phrases, OR and -excluded terms. Pages are set with
+// ?limit= and ?offset=.
+func HandleSearchTodos(logger *slog.Logger, todoService services.TodoService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("q")
+		if query == "" {
+			http.Error(w, "q is required", http.StatusBadRequest)
+			return
+		}
+		limit, err := queryInt(r, "limit", defaultSearchLimit)
+		if err != nil || limit < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, maxSearchLimit)
+		offset, err := queryInt(r, "offset", 0)
+		if err != nil || offset < 0 {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+
+		// One more than a page tells whether there is another
+		results, err := todoService.SearchTodos(r.Context(), query, int32(limit+1), int32(offset))
+		if err != nil {
+			writeError(w, errorStatus(err))
+			return
+		}
+
+		resp := todoSearchResponse{Results: results}
+		if len(results) > limit {
+			next := offset + limit
+			resp.Results, resp.NextOffset = results[:limit], &next
+		}
+		if resp.Results == nil {
+			resp.Results = []services.TodoSearchResult{}
+		}
+		if err := encode(w, http.StatusOK, resp); err != nil {
+			logger.Error("failed to encode todo search response", "error", err)
+		}
+	}
+}
+
+// queryInt reads a 32-bit integer query parameter, returning def when it is
+// absent.
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 32)
+	return int(n), err
+}
+
+type todoRequest struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+func HandleCreateTodo(logger *slog.Logger, todoService services.TodoService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := decode[todoRequest](r)
+		if err != nil {
+			http.Error(w, err.Error(), decodeStatus(err))
+			return
+		}
+		if req.Title == "" {
+			http.Error(w, "title is required", http.StatusBadRequest)
+			return
+		}
+
+		todo, err := todoService.CreateTodo(r.Context(), req.Title, req.Description)
+		if err != nil {
+			writeError(w, errorStatus(err))
+			return
+		}
+
+		w.Header().Set("Location", fmt.Sprintf("/todos/%d", todo.ID))
+		w.Header().Set("ETag", todoETag(todo))
+		if err := encode(w, http.StatusCreated, todo); err != nil {
+			logger.Error("failed to encode todo response", "error", err)
+		}
+	}
+}
+
+func HandleGetTodo(logger *slog.Logger, todoService services.TodoService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := pathID(r, "id")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		todo, err := todoService.GetTodoByID(r.Context(), id)
+		if err != nil {
+			writeError(w, errorStatus(err))
+			return
+		}
+		if notModified(w, r, todoETag(todo)) {
+			return
+		}
+
+		w.Header().Set("ETag", todoETag(todo))
+		if err := encode(w, http.StatusOK, todo); err != nil {
+			logger.Error("failed to encode todo response", "error", err)
+		}
+	}
+}
+
+type replaceTodoRequest struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Completed   bool   `json:"completed"`
+}
+
+// HandleReplaceTodo replaces a todo, if its ETag matches If-Match when sent.
+func HandleReplaceTodo(logger *slog.Logger, todoService services.TodoService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := decode[replaceTodoRequest](r)
+		if err != nil {
+			http.Error(w, err.Error(), decodeStatus(err))
+			return
+		}
+		if req.Title == "" {
+			http.Error(w, "title is required", http.StatusBadRequest)
+			return
+		}
+
+		updateTodo(w, r, logger, todoService, func(todo *models.Todo) {
+			todo.Title, todo.Description, todo.Completed = req.Title, req.Description, req.Completed
+		})
+	}
+}
+
+type updateTodoRequest struct {
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	Completed   *bool   `json:"completed"`
+}
+
+// HandleUpdateTodo changes the fields sent of a todo, if its ETag matches
+// If-Match when sent.
+func HandleUpdateTodo(logger *slog.Logger, todoService services.TodoService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := decode[updateTodoRequest](r)
+		if err != nil {
+			http.Error(w, err.Error(), decodeStatus(err))
+			return
+		}
+		if req.Title != nil && *req.Title == "" {
+			http.Error(w, "title is required", http.StatusBadRequest)
+			return
+		}
+
+		updateTodo(w, r, logger, todoService, func(todo *models.Todo) {
+			if req.Title != nil {
+				todo.Title = *req.Title
+			}
+			if req.Description != nil {
+				todo.Description = *req.Description
+			}
+			if req.Completed != nil {
+				todo.Completed = *req.Completed
+			}
+		})
+	}
+}
+
+// updateTodo applies change to the todo named in the path and saves it, failing
+// with 412 if it has changed since the version the client matched. The todo is
+// only written if it still has the version that was read, so that concurrent
+// changes to other fields are not lost; without If-Match the change is applied
+// again to the newer version, and a todo that keeps changing under it fails
+// with 409 and Retry-After, as the client never named a version to conflict.
+func updateTodo(w http.ResponseWriter, r *http.Request, logger *slog.Logger, todoService services.TodoService, change func(*models.Todo)) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conditional := r.Header.Get("If-Match") != ""
+
+	for attempt := 1; ; attempt++ {
+		todo, err := todoService.GetTodoByID(r.Context(), id)
+		if err != nil {
+			writeError(w, errorStatus(err))
+			return
+		}
+		if _, ok := expectedVersion(w, r, todo); !ok {
+			return
+		}
+
+		change(todo)
+		err = todoService.UpdateTodo(r.Context(), todo)
+		if errors.Is(err, services.ErrVersionConflict) && !conditional {
+			if attempt < maxUpdateAttempts {
+				continue
+			}
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusConflict)
+			return
+		}
+		if err != nil {
+			writeError(w, errorStatus(err))
+			return
+		}
+
+		w.Header().Set("ETag", todoETag(todo))
+		if err := encode(w, http.StatusOK, todo); err != nil {
+			logger.Error("failed to encode todo response", "error", err)
+		}
+		return
+	}
+}
+
+// HandleDeleteTodo deletes a todo, if its ETag matches If-Match when sent.
+func HandleDeleteTodo(todoService services.TodoService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := pathID(r, "id")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		todo, err := todoService.GetTodoByID(r.Context(), id)
+		if err != nil {
+			writeError(w, errorStatus(err))
+			return
+		}
+		version, ok := expectedVersion(w, r, todo)
+		if !ok {
+			return
+		}
+
+		if err := todoService.DeleteTodo(r.Context(), id, version); err != nil {
+			writeError(w, errorStatus(err))
 			return
 		}
+		w.WriteHeader(http.StatusNoContent)
 	}
 }
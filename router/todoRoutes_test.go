@@ -0,0 +1,247 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/doug-benn/go-server-starter/models"
+	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/doug-benn/go-server-starter/services"
+	"github.com/doug-benn/go-server-starter/testutils"
+	"github.com/jackc/pgx/v5"
+)
+
+// newTodoMux serves the todo routes over a single stored todo.
+func newTodoMux(stored *models.Todo) *http.ServeMux {
+	repo := &testutils.MockQuerier{
+		GetTodoFunc: func(ctx context.Context, arg repository.GetTodoParams) (models.Todo, error) {
+			if stored == nil || arg.ID != stored.ID {
+				return models.Todo{}, pgx.ErrNoRows
+			}
+			return *stored, nil
+		},
+		ListTodosFunc: func(ctx context.Context, arg repository.ListTodosParams) ([]models.Todo, error) {
+			return []models.Todo{*stored}, nil
+		},
+		UpdateTodoFunc: func(ctx context.Context, arg repository.UpdateTodoParams) (repository.UpdateTodoRow, error) {
+			if arg.ID != stored.ID || (arg.ExpectedVersion != 0 && arg.ExpectedVersion != stored.Version) {
+				return repository.UpdateTodoRow{}, pgx.ErrNoRows
+			}
+			previous := *stored
+			stored.Title, stored.Description, stored.Completed = arg.Title, arg.Description, arg.Completed
+			stored.Version++
+			return testutils.UpdateTodoRow(previous, *stored), nil
+		},
+		DeleteTodoFunc: func(ctx context.Context, arg repository.DeleteTodoParams) (models.Todo, error) {
+			if arg.ID != stored.ID || (arg.ExpectedVersion != 0 && arg.ExpectedVersion != stored.Version) {
+				return models.Todo{}, pgx.ErrNoRows
+			}
+			return *stored, nil
+		},
+		// Every search matches 25 copies of the stored todo
+		SearchTodosFunc: func(ctx context.Context, arg repository.SearchTodosParams) ([]repository.SearchTodosRow, error) {
+			var rows []repository.SearchTodosRow
+			for i := arg.PageOffset; i < min(arg.PageOffset+arg.PageSize, 25); i++ {
+				rows = append(rows, repository.SearchTodosRow{ID: i + 1, Title: stored.Title, TitleHeadline: stored.Title})
+			}
+			return rows, nil
+		},
+	}
+	users := &testutils.MockUserService{User: &models.User{ID: 1}}
+	todoService := services.NewTodoService(repo, slog.Default(), services.WithUserService(users))
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /todos", HandleGetTodos(slog.Default(), todoService))
+	mux.Handle("GET /todos/search", HandleSearchTodos(slog.Default(), todoService))
+	mux.Handle("GET /todos/{id}", HandleGetTodo(slog.Default(), todoService))
+	mux.Handle("PUT /todos/{id}", HandleReplaceTodo(slog.Default(), todoService))
+	mux.Handle("PATCH /todos/{id}", HandleUpdateTodo(slog.Default(), todoService))
+	mux.Handle("DELETE /todos/{id}", HandleDeleteTodo(todoService))
+	return mux
+}
+
+func serveTodo(mux *http.ServeMux, method, path, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestTodoETags(t *testing.T) {
+	mux := newTodoMux(&models.Todo{ID: 1, Title: "Old", Version: 3})
+
+	rr := serveTodo(mux, "GET", "/todos/1", "")
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"3"` {
+		t.Fatalf("expected the todo with its ETag, got %d %q", rr.Code, rr.Header().Get("ETag"))
+	}
+	if rr := serveTodo(mux, "GET", "/todos/1", "", "If-None-Match", `W/"3"`); rr.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for a matching If-None-Match, got %d", rr.Code)
+	}
+
+	list := serveTodo(mux, "GET", "/todos", "")
+	etag := list.Header().Get("ETag")
+	if list.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected the list with an ETag, got %d %q", list.Code, etag)
+	}
+	if rr := serveTodo(mux, "GET", "/todos", "", "If-None-Match", etag); rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Errorf("expected 304 for an unchanged list, got %d", rr.Code)
+	}
+}
+
+func TestTodoIfMatch(t *testing.T) {
+	stored := &models.Todo{ID: 1, Title: "Old", Description: "Desc", Version: 3}
+	mux := newTodoMux(stored)
+
+	tests := []struct {
+		name           string
+		method         string
+		body           string
+		ifMatch        string
+		expectedStatus int
+		expectedETag   string
+	}{
+		{"stale version", "PUT", `{"title":"New"}`, `"2"`, http.StatusPreconditionFailed, ""},
+		{"weak ETag", "PATCH", `{"title":"New"}`, `W/"3"`, http.StatusPreconditionFailed, ""},
+		{"current version", "PATCH", `{"completed":true}`, `"3"`, http.StatusOK, `"4"`},
+		{"any version", "PUT", `{"title":"New","completed":true}`, "*", http.StatusOK, `"5"`},
+		{"unconditional", "PATCH", `{"description":"Other"}`, "", http.StatusOK, `"6"`},
+		{"stale delete", "DELETE", "", `"5"`, http.StatusPreconditionFailed, ""},
+		{"delete", "DELETE", "", `"6"`, http.StatusNoContent, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var headers []string
+			if tt.ifMatch != "" {
+				headers = []string{"If-Match", tt.ifMatch}
+			}
+			rr := serveTodo(mux, tt.method, "/todos/1", tt.body, headers...)
+			if rr.Code != tt.expectedStatus || rr.Header().Get("ETag") != tt.expectedETag {
+				t.Errorf("expected %d %q, got %d %q", tt.expectedStatus, tt.expectedETag, rr.Code, rr.Header().Get("ETag"))
+			}
+		})
+	}
+
+	// A PATCH only changes the fields it sends
+	if stored.Title != "New" || stored.Description != "Other" || !stored.Completed {
+		t.Errorf("unexpected todo %+v", stored)
+	}
+}
+
+func TestTodoUpdateWithoutIfMatchKeepsConcurrentChanges(t *testing.T) {
+	stored := &models.Todo{ID: 1, Title: "Old", Version: 3}
+	reads := 0
+	repo := &testutils.MockQuerier{
+		GetTodoFunc: func(ctx context.Context, arg repository.GetTodoParams) (models.Todo, error) {
+			reads++
+			todo := *stored
+			if reads == 1 {
+				// Another request completes the todo after this one read it
+				stored.Completed = true
+				stored.Version++
+			}
+			return todo, nil
+		},
+		UpdateTodoFunc: func(ctx context.Context, arg repository.UpdateTodoParams) (repository.UpdateTodoRow, error) {
+			if arg.ExpectedVersion != stored.Version {
+				return repository.UpdateTodoRow{}, pgx.ErrNoRows
+			}
+			previous := *stored
+			stored.Title, stored.Description, stored.Completed = arg.Title, arg.Description, arg.Completed
+			stored.Version++
+			return testutils.UpdateTodoRow(previous, *stored), nil
+		},
+	}
+	users := &testutils.MockUserService{User: &models.User{ID: 1}}
+	mux := http.NewServeMux()
+	mux.Handle("PATCH /todos/{id}", HandleUpdateTodo(slog.Default(), services.NewTodoService(repo, slog.Default(), services.WithUserService(users))))
+
+	rr := serveTodo(mux, "PATCH", "/todos/1", `{"title":"New"}`)
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"5"` {
+		t.Fatalf("expected the update to be applied again, got %d %q", rr.Code, rr.Header().Get("ETag"))
+	}
+	if stored.Title != "New" || !stored.Completed {
+		t.Errorf("expected both changes to be kept, got %+v", stored)
+	}
+}
+
+func TestTodoUpdateWithoutIfMatchGivesUpOnContention(t *testing.T) {
+	stored := &models.Todo{ID: 1, Title: "Old", Version: 3}
+	updates := 0
+	repo := &testutils.MockQuerier{
+		GetTodoFunc: func(ctx context.Context, arg repository.GetTodoParams) (models.Todo, error) {
+			todo := *stored
+			// Another request writes the todo after every read
+			stored.Version++
+			return todo, nil
+		},
+		UpdateTodoFunc: func(ctx context.Context, arg repository.UpdateTodoParams) (repository.UpdateTodoRow, error) {
+			updates++
+			return repository.UpdateTodoRow{}, pgx.ErrNoRows
+		},
+	}
+	users := &testutils.MockUserService{User: &models.User{ID: 1}}
+	mux := http.NewServeMux()
+	mux.Handle("PATCH /todos/{id}", HandleUpdateTodo(slog.Default(), services.NewTodoService(repo, slog.Default(), services.WithUserService(users))))
+
+	rr := serveTodo(mux, "PATCH", "/todos/1", `{"title":"New"}`)
+	if rr.Code != http.StatusConflict || rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 409 with Retry-After, got %d %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if updates != maxUpdateAttempts {
+		t.Errorf("expected %d attempts, got %d", maxUpdateAttempts, updates)
+	}
+}
+
+func TestTodoSearch(t *testing.T) {
+	mux := newTodoMux(&models.Todo{ID: 1, Title: "Write report", Version: 1})
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedCount  int
+		expectedNext   int // zero on the last page
+	}{
+		{"first page", "q=report", http.StatusOK, 20, 20},
+		{"last page", "q=report&limit=10&offset=20", http.StatusOK, 5, 0},
+		{"past the end", "q=report&offset=40", http.StatusOK, 0, 0},
+		{"limit is capped", "q=report&limit=1000", http.StatusOK, 25, 0},
+		{"missing query", "", http.StatusBadRequest, 0, 0},
+		{"invalid limit", "q=report&limit=0", http.StatusBadRequest, 0, 0},
+		{"invalid offset", "q=report&offset=-1", http.StatusBadRequest, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serveTodo(mux, http.MethodGet, "/todos/search?"+tt.query, "")
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var resp todoSearchResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if resp.Results == nil || len(resp.Results) != tt.expectedCount {
+				t.Errorf("expected %d results, got %v", tt.expectedCount, resp.Results)
+			}
+			next := 0
+			if resp.NextOffset != nil {
+				next = *resp.NextOffset
+			}
+			if next != tt.expectedNext {
+				t.Errorf("expected next offset %d, got %d", tt.expectedNext, next)
+			}
+		})
+	}
+}
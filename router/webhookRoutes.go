@@ -0,0 +1,196 @@
+package router
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/doug-benn/go-server-starter/models"
+	"github.com/doug-benn/go-server-starter/services"
+)
+
+const (
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 200
+)
+
+type webhookRequest struct {
+	Url        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Active     *bool    `json:"active,omitempty"`
+}
+
+// createdWebhook is only returned on creation, the secret is never shown again.
+type createdWebhook struct {
+	models.WebhookSubscription
+	Secret string `json:"secret"`
+}
+
+func HandleCreateWebhook(logger *slog.Logger, webhookService services.WebhookService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := decode[webhookRequest](r)
+		if err != nil {
+			http.Error(w, err.Error(), decodeStatus(err))
+			return
+		}
+
+		subscription, err := webhookService.CreateSubscription(r.Context(), req.Url, req.EventTypes)
+		if errors.Is(err, services.ErrInvalidWebhookURL) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			writeError(w, errorStatus(err))
+			return
+		}
+
+		if err := encode(w, http.StatusCreated, createdWebhook{*subscription, subscription.Secret}); err != nil {
+			logger.Error("failed to encode webhook response", "error", err)
+		}
+	}
+}
+
+func HandleListWebhooks(logger *slog.Logger, webhookService services.WebhookService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subscriptions, err := webhookService.ListSubscriptions(r.Context())
+		if err != nil {
+			writeError(w, errorStatus(err))
+			return
+		}
+
+		if err := encode(w, http.StatusOK, subscriptions); err != nil {
+			logger.Error("failed to encode webhooks response", "error", err)
+		}
+	}
+}
+
+func HandleGetWebhook(logger *slog.Logger, webhookService services.WebhookService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := pathID(r, "id")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		subscription, err := webhookService.GetSubscription(r.Context(), id)
+		if err != nil {
+			writeError(w, errorStatus(err))
+			return
+		}
+
+		if err := encode(w, http.StatusOK, subscription); err != nil {
+			logger.Error("failed to encode webhook response", "error", err)
+		}
+	}
+}
+
+func HandleUpdateWebhook(logger *slog.Logger, webhookService services.WebhookService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := pathID(r, "id")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		req, err := decode[webhookRequest](r)
+		if err != nil {
+			http.Error(w, err.Error(), decodeStatus(err))
+			return
+		}
+
+		subscription := &models.WebhookSubscription{
+			ID:         id,
+			Url:        req.Url,
+			EventTypes: req.EventTypes,
+			Active:     req.Active == nil || *req.Active,
+		}
+		err = webhookService.UpdateSubscription(r.Context(), subscription)
+		if errors.Is(err, services.ErrInvalidWebhookURL) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			writeError(w, errorStatus(err))
+			return
+		}
+
+		if err := encode(w, http.StatusOK, subscription); err != nil {
+			logger.Error("failed to encode webhook response", "error", err)
+		}
+	}
+}
+
+func HandleDeleteWebhook(webhookService services.WebhookService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := pathID(r, "id")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := webhookService.DeleteSubscription(r.Context(), id); err != nil {
+			writeError(w, errorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleListWebhookDeliveries returns the delivery log of a subscription,
+// newest first. The page size is set with ?limit=.
+func HandleListWebhookDeliveries(logger *slog.Logger, webhookService services.WebhookService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := pathID(r, "id")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		limit := defaultDeliveryLimit
+		if l := r.URL.Query().Get("limit"); l != "" {
+			limit, err = strconv.Atoi(l)
+			if err != nil || limit < 1 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = min(limit, maxDeliveryLimit)
+		}
+
+		deliveries, err := webhookService.ListDeliveries(r.Context(), id, int32(limit))
+		if err != nil {
+			writeError(w, errorStatus(err))
+			return
+		}
+
+		if err := encode(w, http.StatusOK, deliveries); err != nil {
+			logger.Error("failed to encode webhook deliveries response", "error", err)
+		}
+	}
+}
+
+// HandleRedeliverWebhook queues a delivery to be sent again, including dead ones.
+func HandleRedeliverWebhook(logger *slog.Logger, webhookService services.WebhookService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := pathID(r, "id")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		deliveryID, err := pathID(r, "deliveryID")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		delivery, err := webhookService.Redeliver(r.Context(), id, deliveryID)
+		if err != nil {
+			writeError(w, errorStatus(err))
+			return
+		}
+
+		if err := encode(w, http.StatusAccepted, delivery); err != nil {
+			logger.Error("failed to encode webhook delivery response", "error", err)
+		}
+	}
+}
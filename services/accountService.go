@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/models"
+	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/jackc/pgx/v5"
+)
+
+// MinPasswordLength is the shortest password CreateAccount accepts.
+const MinPasswordLength = 12
+
+var (
+	// ErrInvalidLogin is returned for an unknown username or a wrong password,
+	// deliberately without saying which.
+	ErrInvalidLogin     = errors.New("invalid username or password")
+	ErrInvalidUsername  = errors.New("username must be 3 to 64 lowercase letters, digits, dots, dashes or underscores")
+	ErrPasswordTooShort = errors.New("password is too short")
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9._-]{3,64}$`)
+
+// dummyPasswordHash is verified against for unknown users so that the response
+// time does not reveal which usernames exist.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := auth.HashPassword("dummy password for unknown users")
+	return hash
+})
+
+// AccountService manages users that sign in with a password.
+type AccountService interface {
+	CreateAccount(ctx context.Context, username, password string, scopes []string, tenant string) (*models.User, error)
+	// Login returns the user whose password matches, or ErrInvalidLogin.
+	Login(ctx context.Context, username, password string) (*models.User, error)
+	// Principal returns the principal a user's session acts as.
+	Principal(ctx context.Context, userID int32) (*auth.Principal, error)
+}
+
+type AccountServiceImpl struct {
+	repo   repository.Querier
+	logger *slog.Logger
+}
+
+func NewAccountService(repo repository.Querier, logger *slog.Logger) AccountService {
+	return &AccountServiceImpl{repo: repo, logger: logger}
+}
+
+func (s *AccountServiceImpl) CreateAccount(ctx context.Context, username, password string, scopes []string, tenant string) (*models.User, error) {
+	if !usernamePattern.MatchString(username) {
+		return nil, ErrInvalidUsername
+	}
+	if len(password) < MinPasswordLength {
+		return nil, ErrPasswordTooShort
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	var tenantID *string
+	if tenant != "" {
+		tenantID = &tenant
+	}
+
+	user, err := s.repo.CreateLocalUser(ctx, repository.CreateLocalUserParams{
+		Subject:      auth.KindLocal + ":" + username,
+		Name:         username,
+		Username:     &username,
+		PasswordHash: &hash,
+		Scopes:       nonNil(scopes),
+		TenantID:     tenantID,
+		CreatedAt:    time.Now(),
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to create account", "username", username, "error", err)
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *AccountServiceImpl) Login(ctx context.Context, username, password string) (*models.User, error) {
+	user, err := s.repo.GetUserByUsername(ctx, &username)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		s.logger.ErrorContext(ctx, "failed to get user by username", "error", err)
+		return nil, err
+	}
+
+	hash := dummyPasswordHash()
+	if err == nil && user.PasswordHash != nil {
+		hash = *user.PasswordHash
+	}
+	ok, verifyErr := auth.VerifyPassword(hash, password)
+	if verifyErr != nil {
+		s.logger.ErrorContext(ctx, "failed to verify password", "user_id", user.ID, "error", verifyErr)
+		return nil, verifyErr
+	}
+	if err != nil || user.PasswordHash == nil || !ok {
+		return nil, ErrInvalidLogin
+	}
+	return &user, nil
+}
+
+func (s *AccountServiceImpl) Principal(ctx context.Context, userID int32) (*auth.Principal, error) {
+	user, err := s.repo.GetUser(ctx, userID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to get user", "id", userID, "error", err)
+		return nil, err
+	}
+
+	principal := &auth.Principal{
+		ID:     user.Subject,
+		Name:   user.Name,
+		Kind:   auth.KindLocal,
+		Scopes: user.Scopes,
+	}
+	if user.TenantID != nil {
+		principal.Tenant = *user.TenantID
+	}
+	return principal, nil
+}
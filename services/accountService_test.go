@@ -0,0 +1,93 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/models"
+	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/doug-benn/go-server-starter/services"
+	"github.com/doug-benn/go-server-starter/testutils"
+	"github.com/jackc/pgx/v5"
+)
+
+// accountStore is a minimal in-memory users table behind MockQuerier.
+func accountStore() *testutils.MockQuerier {
+	users := make(map[string]models.User)
+	return &testutils.MockQuerier{
+		CreateLocalUserFunc: func(ctx context.Context, arg repository.CreateLocalUserParams) (models.User, error) {
+			user := models.User{
+				ID:           int32(len(users) + 1),
+				Subject:      arg.Subject,
+				Name:         arg.Name,
+				Username:     arg.Username,
+				PasswordHash: arg.PasswordHash,
+				Scopes:       arg.Scopes,
+				TenantID:     arg.TenantID,
+			}
+			users[*arg.Username] = user
+			return user, nil
+		},
+		GetUserByUsernameFunc: func(ctx context.Context, username *string) (models.User, error) {
+			user, ok := users[*username]
+			if !ok {
+				return models.User{}, pgx.ErrNoRows
+			}
+			return user, nil
+		},
+		GetUserFunc: func(ctx context.Context, id int32) (models.User, error) {
+			for _, user := range users {
+				if user.ID == id {
+					return user, nil
+				}
+			}
+			return models.User{}, pgx.ErrNoRows
+		},
+	}
+}
+
+func TestAccountService_Login(t *testing.T) {
+	accounts := services.NewAccountService(accountStore(), slog.Default())
+	ctx := context.Background()
+
+	created, err := accounts.CreateAccount(ctx, "alice", "correct horse battery", []string{auth.ScopeTodosRead}, "acme")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if *created.PasswordHash == "correct horse battery" {
+		t.Fatal("Expected the password to be hashed")
+	}
+
+	user, err := accounts.Login(ctx, "alice", "correct horse battery")
+	if err != nil || user.ID != created.ID {
+		t.Errorf("Expected login to succeed, got %v", err)
+	}
+	if _, err := accounts.Login(ctx, "alice", "wrong password"); !errors.Is(err, services.ErrInvalidLogin) {
+		t.Errorf("Expected ErrInvalidLogin for a wrong password, got %v", err)
+	}
+	if _, err := accounts.Login(ctx, "mallory", "correct horse battery"); !errors.Is(err, services.ErrInvalidLogin) {
+		t.Errorf("Expected ErrInvalidLogin for an unknown user, got %v", err)
+	}
+
+	principal, err := accounts.Principal(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if principal.ID != "local:alice" || principal.Kind != auth.KindLocal || principal.Tenant != "acme" || !principal.HasScope(auth.ScopeTodosRead) {
+		t.Errorf("Unexpected principal %+v", principal)
+	}
+}
+
+func TestAccountService_CreateAccountValidates(t *testing.T) {
+	accounts := services.NewAccountService(accountStore(), slog.Default())
+
+	if _, err := accounts.CreateAccount(context.Background(), "Alice Smith", "correct horse battery", nil, ""); !errors.Is(err, services.ErrInvalidUsername) {
+		t.Errorf("Expected ErrInvalidUsername, got %v", err)
+	}
+	if _, err := accounts.CreateAccount(context.Background(), "alice", "short", nil, ""); !errors.Is(err, services.ErrPasswordTooShort) {
+		t.Errorf("Expected ErrPasswordTooShort, got %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/models"
+	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// apiKeyPrefix marks a credential as an API key.
+	apiKeyPrefix = "gss_"
+	// apiKeyDisplayLength is how much of a key is stored to identify it in listings.
+	apiKeyDisplayLength = len(apiKeyPrefix) + 8
+	// apiKeyTouchInterval limits how often last_used_at is written for a key.
+	apiKeyTouchInterval = time.Minute
+)
+
+// ErrAPIKeyNameRequired is returned when creating a key without a name.
+var ErrAPIKeyNameRequired = errors.New("api key name is required")
+
+// APIKeyService manages API keys and authenticates requests that carry one.
+type APIKeyService interface {
+	auth.Authenticator
+	// CreateKey stores a new key and returns it with the plaintext key, which
+	// cannot be retrieved again. A zero ttl creates a key that never expires
+	// and an empty tenant one that is not bound to a tenant.
+	CreateKey(ctx context.Context, name string, scopes []string, ttl time.Duration, tenant string) (*models.ApiKey, string, error)
+	// ListKeys and RevokeKey only see the keys bound to tenant, or every key
+	// for an empty tenant.
+	ListKeys(ctx context.Context, tenant string) ([]models.ApiKey, error)
+	RevokeKey(ctx context.Context, id int32, tenant string) error
+}
+
+type APIKeyServiceImpl struct {
+	repo   repository.Querier
+	logger *slog.Logger
+}
+
+func NewAPIKeyService(repo repository.Querier, logger *slog.Logger) APIKeyService {
+	return &APIKeyServiceImpl{repo: repo, logger: logger}
+}
+
+func (s *APIKeyServiceImpl) CreateKey(ctx context.Context, name string, scopes []string, ttl time.Duration, tenant string) (*models.ApiKey, string, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, "", ErrAPIKeyNameRequired
+	}
+
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	plaintext := apiKeyPrefix + hex.EncodeToString(b)
+
+	now := time.Now()
+	var expiresAt *time.Time
+	if ttl > 0 {
+		t := now.Add(ttl)
+		expiresAt = &t
+	}
+	key, err := s.repo.CreateApiKey(ctx, repository.CreateApiKeyParams{
+		Name:      name,
+		Prefix:    plaintext[:apiKeyDisplayLength],
+		KeyHash:   hashAPIKey(plaintext),
+		Scopes:    nonNil(scopes),
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+		TenantID:  tenantID(tenant),
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to create api key", "error", err)
+		return nil, "", err
+	}
+	return &key, plaintext, nil
+}
+
+func (s *APIKeyServiceImpl) ListKeys(ctx context.Context, tenant string) ([]models.ApiKey, error) {
+	keys, err := s.repo.ListApiKeys(ctx, tenantID(tenant))
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to list api keys", "error", err)
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RevokeKey revokes an active key, returning pgx.ErrNoRows if there is none with the id.
+func (s *APIKeyServiceImpl) RevokeKey(ctx context.Context, id int32, tenant string) error {
+	now := time.Now()
+	rows, err := s.repo.RevokeApiKey(ctx, repository.RevokeApiKeyParams{
+		RevokedAt: &now,
+		ID:        id,
+		TenantID:  tenantID(tenant),
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to revoke api key", "id", id, "error", err)
+		return err
+	}
+	if rows == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// Authenticate resolves the principal for an API key. Credentials without the
+// API key prefix are left to other authenticators.
+func (s *APIKeyServiceImpl) Authenticate(ctx context.Context, credential string) (*auth.Principal, error) {
+	if !strings.HasPrefix(credential, apiKeyPrefix) {
+		return nil, auth.ErrUnrecognized
+	}
+
+	key, err := s.repo.GetApiKeyByHash(ctx, hashAPIKey(credential))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, auth.ErrInvalidCredential
+	}
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to get api key", "error", err)
+		return nil, err
+	}
+
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && now.After(*key.ExpiresAt)) {
+		return nil, auth.ErrInvalidCredential
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > apiKeyTouchInterval {
+		if err := s.repo.TouchApiKey(ctx, repository.TouchApiKeyParams{LastUsedAt: &now, ID: key.ID}); err != nil {
+			s.logger.WarnContext(ctx, "failed to record api key use", "id", key.ID, "error", err)
+		}
+	}
+
+	principal := &auth.Principal{
+		ID:     auth.KindAPIKey + ":" + strconv.Itoa(int(key.ID)),
+		Name:   key.Name,
+		Kind:   auth.KindAPIKey,
+		Scopes: key.Scopes,
+	}
+	if key.TenantID != nil {
+		principal.Tenant = *key.TenantID
+	}
+	return principal, nil
+}
+
+// tenantID returns the tenant column for tenant, NULL for no tenant.
+func tenantID(tenant string) *string {
+	if tenant == "" {
+		return nil
+	}
+	return &tenant
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,194 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/models"
+	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/doug-benn/go-server-starter/services"
+	"github.com/doug-benn/go-server-starter/testutils"
+	"github.com/jackc/pgx/v5"
+)
+
+// apiKeyStore is a minimal in-memory api_keys table behind MockQuerier.
+func apiKeyStore() (*testutils.MockQuerier, map[string]*models.ApiKey) {
+	keys := make(map[string]*models.ApiKey)
+	repo := &testutils.MockQuerier{
+		CreateApiKeyFunc: func(ctx context.Context, arg repository.CreateApiKeyParams) (models.ApiKey, error) {
+			key := &models.ApiKey{
+				ID:        int32(len(keys) + 1),
+				Name:      arg.Name,
+				Prefix:    arg.Prefix,
+				KeyHash:   arg.KeyHash,
+				Scopes:    arg.Scopes,
+				ExpiresAt: arg.ExpiresAt,
+				CreatedAt: arg.CreatedAt,
+				TenantID:  arg.TenantID,
+			}
+			keys[arg.KeyHash] = key
+			return *key, nil
+		},
+		GetApiKeyByHashFunc: func(ctx context.Context, keyHash string) (models.ApiKey, error) {
+			key, ok := keys[keyHash]
+			if !ok {
+				return models.ApiKey{}, pgx.ErrNoRows
+			}
+			return *key, nil
+		},
+		TouchApiKeyFunc: func(ctx context.Context, arg repository.TouchApiKeyParams) error {
+			for _, key := range keys {
+				if key.ID == arg.ID {
+					key.LastUsedAt = arg.LastUsedAt
+				}
+			}
+			return nil
+		},
+	}
+	return repo, keys
+}
+
+func TestAPIKeyService_CreateAndAuthenticate(t *testing.T) {
+	repo, keys := apiKeyStore()
+	service := services.NewAPIKeyService(repo, slog.Default())
+	ctx := context.Background()
+
+	key, plaintext, err := service.CreateKey(ctx, "ci", []string{"admin"}, time.Hour, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.HasPrefix(plaintext, "gss_") || !strings.HasPrefix(plaintext, key.Prefix) {
+		t.Errorf("Expected key %q to start with gss_ and prefix %q", plaintext, key.Prefix)
+	}
+	if _, ok := keys[plaintext]; ok {
+		t.Error("Expected only the hash of the key to be stored")
+	}
+	if key.ExpiresAt == nil {
+		t.Error("Expected expiry to be set")
+	}
+
+	principal, err := service.Authenticate(ctx, plaintext)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if principal.Kind != auth.KindAPIKey || principal.Name != "ci" || !principal.HasScope("admin") {
+		t.Errorf("Unexpected principal %+v", principal)
+	}
+	if principal.ID != "api_key:1" {
+		t.Errorf("Expected principal ID api_key:1, got %q", principal.ID)
+	}
+	if keys[key.KeyHash].LastUsedAt == nil {
+		t.Error("Expected last_used_at to be recorded")
+	}
+}
+
+func TestAPIKeyService_CreateRequiresName(t *testing.T) {
+	repo, _ := apiKeyStore()
+	service := services.NewAPIKeyService(repo, slog.Default())
+
+	_, _, err := service.CreateKey(context.Background(), " ", nil, 0, "")
+	if !errors.Is(err, services.ErrAPIKeyNameRequired) {
+		t.Errorf("Expected ErrAPIKeyNameRequired, got %v", err)
+	}
+}
+
+func TestAPIKeyService_AuthenticateRejects(t *testing.T) {
+	repo, keys := apiKeyStore()
+	service := services.NewAPIKeyService(repo, slog.Default())
+	ctx := context.Background()
+
+	expired, expiredKey, _ := service.CreateKey(ctx, "expired", nil, time.Hour, "")
+	past := time.Now().Add(-time.Minute)
+	keys[expired.KeyHash].ExpiresAt = &past
+
+	revoked, revokedKey, _ := service.CreateKey(ctx, "revoked", nil, 0, "")
+	keys[revoked.KeyHash].RevokedAt = &past
+
+	tests := []struct {
+		name       string
+		credential string
+		want       error
+	}{
+		{"not an api key", "eyJhbGciOi.jwt.token", auth.ErrUnrecognized},
+		{"unknown key", "gss_0000", auth.ErrInvalidCredential},
+		{"expired key", expiredKey, auth.ErrInvalidCredential},
+		{"revoked key", revokedKey, auth.ErrInvalidCredential},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := service.Authenticate(ctx, tt.credential)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+			if principal != nil {
+				t.Errorf("Expected no principal, got %+v", principal)
+			}
+		})
+	}
+}
+
+func TestAPIKeyService_RevokeUnknownKey(t *testing.T) {
+	repo := &testutils.MockQuerier{
+		RevokeApiKeyFunc: func(ctx context.Context, arg repository.RevokeApiKeyParams) (int64, error) {
+			if arg.RevokedAt == nil {
+				t.Error("Expected revoked_at to be set")
+			}
+			return 0, nil
+		},
+	}
+	service := services.NewAPIKeyService(repo, slog.Default())
+
+	if err := service.RevokeKey(context.Background(), 42, ""); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("Expected pgx.ErrNoRows, got %v", err)
+	}
+}
+
+func TestTodoEventActorFromPrincipal(t *testing.T) {
+	publisher := &testutils.MockPublisher{}
+	repo := &testutils.MockQuerier{
+		CreateTodoFunc: func(ctx context.Context, arg repository.CreateTodoParams) (models.Todo, error) {
+			return models.Todo{ID: 3, Title: arg.Title, OwnerID: arg.OwnerID}, nil
+		},
+		UpsertUserFunc: func(ctx context.Context, arg repository.UpsertUserParams) (models.User, error) {
+			return models.User{ID: 1, Subject: arg.Subject}, nil
+		},
+	}
+	todoService := services.NewTodoService(repo, slog.Default(), services.WithEventPublisher(publisher))
+
+	ctx := auth.WithPrincipal(context.Background(), &auth.Principal{ID: "api_key:9", Kind: auth.KindAPIKey})
+	if _, err := todoService.CreateTodo(ctx, "title", "description"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	events := todoEvents(t, publisher)
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(events))
+	}
+	if events[0].Actor != "api_key:9" {
+		t.Errorf("Expected actor api_key:9, got %q", events[0].Actor)
+	}
+}
+
+func TestAPIKeyService_TenantKey(t *testing.T) {
+	repo, _ := apiKeyStore()
+	service := services.NewAPIKeyService(repo, slog.Default())
+	ctx := context.Background()
+
+	_, operatorKey, _ := service.CreateKey(ctx, "operator", nil, 0, "")
+	_, tenantKey, _ := service.CreateKey(ctx, "acme", nil, 0, "acme")
+
+	operator, err := service.Authenticate(ctx, operatorKey)
+	if err != nil || operator.Tenant != "" {
+		t.Errorf("Expected an unbound principal, got %+v, %v", operator, err)
+	}
+	principal, err := service.Authenticate(ctx, tenantKey)
+	if err != nil || principal.Tenant != "acme" {
+		t.Errorf("Expected a principal bound to acme, got %+v, %v", principal, err)
+	}
+}
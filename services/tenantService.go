@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/models"
+	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrInvalidTenantID is returned when a tenant ID cannot be used as a subdomain.
+var ErrInvalidTenantID = errors.New("tenant id must be lowercase letters, digits and dashes")
+
+// tenantIDPattern matches the check constraint on tenants.id.
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+type TenantService interface {
+	CreateTenant(ctx context.Context, id, name string) (*models.Tenant, error)
+	ListTenants(ctx context.Context) ([]models.Tenant, error)
+	// TenantExists reports whether a tenant with the id exists. Results are
+	// cached, tenants are only ever added outside of requests.
+	TenantExists(ctx context.Context, id string) (bool, error)
+}
+
+type TenantServiceImpl struct {
+	repo   repository.Querier
+	logger *slog.Logger
+
+	mu      sync.RWMutex
+	tenants map[string]bool // known tenant IDs, unknown ones are not cached
+}
+
+func NewTenantService(repo repository.Querier, logger *slog.Logger) TenantService {
+	return &TenantServiceImpl{repo: repo, logger: logger, tenants: make(map[string]bool)}
+}
+
+func (s *TenantServiceImpl) CreateTenant(ctx context.Context, id, name string) (*models.Tenant, error) {
+	if !tenantIDPattern.MatchString(id) {
+		return nil, ErrInvalidTenantID
+	}
+	if strings.TrimSpace(name) == "" {
+		name = id
+	}
+
+	tenant, err := s.repo.CreateTenant(ctx, repository.CreateTenantParams{
+		ID:        id,
+		Name:      name,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to create tenant", "id", id, "error", err)
+		return nil, err
+	}
+	return &tenant, nil
+}
+
+func (s *TenantServiceImpl) ListTenants(ctx context.Context) ([]models.Tenant, error) {
+	tenants, err := s.repo.ListTenants(ctx)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to list tenants", "error", err)
+		return nil, err
+	}
+	return tenants, nil
+}
+
+func (s *TenantServiceImpl) TenantExists(ctx context.Context, id string) (bool, error) {
+	if !tenantIDPattern.MatchString(id) {
+		return false, nil
+	}
+
+	s.mu.RLock()
+	known := s.tenants[id]
+	s.mu.RUnlock()
+	if known {
+		return true, nil
+	}
+
+	_, err := s.repo.GetTenant(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to get tenant", "id", id, "error", err)
+		return false, err
+	}
+
+	s.mu.Lock()
+	s.tenants[id] = true
+	s.mu.Unlock()
+	return true, nil
+}
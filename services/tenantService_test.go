@@ -0,0 +1,63 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/doug-benn/go-server-starter/models"
+	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/doug-benn/go-server-starter/services"
+	"github.com/doug-benn/go-server-starter/testutils"
+	"github.com/jackc/pgx/v5"
+)
+
+func TestTenantService_TenantExists(t *testing.T) {
+	lookups := 0
+	mockRepo := &testutils.MockQuerier{
+		GetTenantFunc: func(ctx context.Context, id string) (models.Tenant, error) {
+			lookups++
+			if id == "acme" {
+				return models.Tenant{ID: id}, nil
+			}
+			return models.Tenant{}, pgx.ErrNoRows
+		},
+	}
+	tenants := services.NewTenantService(mockRepo, slog.Default())
+	ctx := context.Background()
+
+	for range 2 {
+		exists, err := tenants.TenantExists(ctx, "acme")
+		if err != nil || !exists {
+			t.Fatalf("Expected acme to exist, got %v, %v", exists, err)
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("Expected known tenants to be cached, got %d lookups", lookups)
+	}
+
+	if exists, _ := tenants.TenantExists(ctx, "globex"); exists {
+		t.Error("Expected an unknown tenant not to exist")
+	}
+	if exists, _ := tenants.TenantExists(ctx, "Not A Tenant"); exists || lookups != 2 {
+		t.Error("Expected invalid tenant ids to be rejected without a lookup")
+	}
+}
+
+func TestTenantService_CreateTenantValidatesID(t *testing.T) {
+	mockRepo := &testutils.MockQuerier{
+		CreateTenantFunc: func(ctx context.Context, arg repository.CreateTenantParams) (models.Tenant, error) {
+			return models.Tenant{ID: arg.ID, Name: arg.Name}, nil
+		},
+	}
+	tenants := services.NewTenantService(mockRepo, slog.Default())
+
+	if _, err := tenants.CreateTenant(context.Background(), "Acme Corp", ""); !errors.Is(err, services.ErrInvalidTenantID) {
+		t.Errorf("Expected ErrInvalidTenantID, got %v", err)
+	}
+	tenant, err := tenants.CreateTenant(context.Background(), "acme", "")
+	if err != nil || tenant.Name != "acme" {
+		t.Errorf("Expected the name to default to the id, got %+v, %v", tenant, err)
+	}
+}
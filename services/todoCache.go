@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/cache"
+	"github.com/doug-benn/go-server-starter/database"
+	"github.com/doug-benn/go-server-starter/models"
+	"github.com/doug-benn/go-server-starter/producer"
+	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/doug-benn/go-server-starter/sse"
+)
+
+// todoCacheBuffer holds invalidations while the cache is busy, a subscriber
+// that falls behind has events dropped by the producer.
+const todoCacheBuffer = 1000
+
+// CachingTodoService serves todo reads from a cache, which is kept consistent
+// across replicas by the todo events every replica receives. Writes through it
+// evict what they change straight away, so that a caller reads its own writes.
+type CachingTodoService struct {
+	TodoService
+	users  UserService
+	cache  *cache.MemoryCache
+	ttl    time.Duration
+	logger *slog.Logger
+
+	// generation is bumped by every eviction, a read only stores its result if
+	// nothing was evicted while it ran, as it may have read the old value.
+	mu         sync.Mutex
+	generation uint64
+}
+
+// NewCachingTodoService caches the reads of next in c for up to ttl, which
+// bounds how stale a todo can be if an event is lost. c is flushed as a whole,
+// so it must not be shared.
+func NewCachingTodoService(next TodoService, users UserService, c *cache.MemoryCache, ttl time.Duration, logger *slog.Logger) *CachingTodoService {
+	return &CachingTodoService{TodoService: next, users: users, cache: c, ttl: ttl, logger: logger}
+}
+
+func (s *CachingTodoService) GetTodoByID(ctx context.Context, id int32) (*models.Todo, error) {
+	scope, err := s.scope(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if todo, ok, _ := cache.Get[models.Todo](ctx, s.cache, todoKey(scope)); ok {
+		return &todo, nil
+	}
+
+	generation := s.currentGeneration()
+	todo, err := s.TodoService.GetTodoByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.store(ctx, generation, todoKey(scope), *todo)
+	return todo, nil
+}
+
+func (s *CachingTodoService) GetAllTodos(ctx context.Context) ([]models.Todo, error) {
+	scope, err := s.scope(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	if todos, ok, _ := cache.Get[[]models.Todo](ctx, s.cache, todoListKey(scope)); ok {
+		return todos, nil
+	}
+
+	generation := s.currentGeneration()
+	todos, err := s.TodoService.GetAllTodos(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.store(ctx, generation, todoListKey(scope), todos)
+	return todos, nil
+}
+
+func (s *CachingTodoService) CreateTodo(ctx context.Context, title, description string) (*models.Todo, error) {
+	todo, err := s.TodoService.CreateTodo(ctx, title, description)
+	s.evictFor(ctx, 0)
+	return todo, err
+}
+
+// UpdateTodo evicts the todo even if the update failed, a version conflict
+// means the cached copy is out of date.
+func (s *CachingTodoService) UpdateTodo(ctx context.Context, todo *models.Todo) error {
+	id := todo.ID
+	err := s.TodoService.UpdateTodo(ctx, todo)
+	s.evictFor(ctx, id)
+	return err
+}
+
+func (s *CachingTodoService) DeleteTodo(ctx context.Context, id, version int32) error {
+	err := s.TodoService.DeleteTodo(ctx, id, version)
+	s.evictFor(ctx, id)
+	return err
+}
+
+func (s *CachingTodoService) CompleteTodo(ctx context.Context, id int32) error {
+	err := s.TodoService.CompleteTodo(ctx, id)
+	s.evictFor(ctx, id)
+	return err
+}
+
+// Invalidate evicts the todos that events from the producer are about until
+// ctx is cancelled, and flushes the cache when notifications were missed. It
+// subscribes before returning, so that no write made after it returns is
+// missed, and then consumes events in the background.
+func (s *CachingTodoService) Invalidate(ctx context.Context, events *producer.Producer[sse.Event]) {
+	subscription := events.Subscribe(todoCacheBuffer)
+
+	go func() {
+		// Nothing invalidates the cache once the subscription ends
+		defer s.Flush()
+
+		for {
+			select {
+			// The producer shares ctx and removes every subscription when it is
+			// cancelled, so the subscription is not closed here.
+			case <-ctx.Done():
+				return
+			case event, ok := <-subscription.Events():
+				if !ok {
+					return
+				}
+				s.handle(ctx, event)
+			}
+		}
+	}()
+}
+
+func (s *CachingTodoService) handle(ctx context.Context, event sse.Event) {
+	if repository.IsResyncEvent(event) {
+		s.logger.InfoContext(ctx, "flushing todo cache after events were missed")
+		s.Flush()
+		return
+	}
+	if scope, ok := TodoEventScope(event); ok {
+		s.evict(ctx, scope)
+	}
+}
+
+// Flush drops every cached todo.
+func (s *CachingTodoService) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generation++
+	s.cache.Flush()
+}
+
+// evict drops a todo and the list it is in, or only the list for a zero ID.
+func (s *CachingTodoService) evict(ctx context.Context, scope TodoScope) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generation++
+	s.cache.Delete(ctx, todoListKey(scope))
+	if scope.TodoID != 0 {
+		s.cache.Delete(ctx, todoKey(scope))
+	}
+}
+
+func (s *CachingTodoService) evictFor(ctx context.Context, id int32) {
+	scope, err := s.scope(ctx, id)
+	if err != nil {
+		// The write failed for the same reason, nothing changed
+		return
+	}
+	s.evict(ctx, scope)
+}
+
+func (s *CachingTodoService) currentGeneration() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.generation
+}
+
+func (s *CachingTodoService) store(ctx context.Context, generation uint64, key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.generation == generation {
+		if err := cache.Set(ctx, s.cache, key, value, s.ttl); err != nil {
+			s.logger.ErrorContext(ctx, "failed to cache todos", "error", err)
+		}
+	}
+}
+
+// scope returns the scope of a todo of the caller, whose reads are cached
+// apart from other users and tenants.
+func (s *CachingTodoService) scope(ctx context.Context, id int32) (TodoScope, error) {
+	user, err := s.users.CurrentUser(ctx)
+	if err != nil {
+		return TodoScope{}, err
+	}
+	tenant, _ := database.TenantFromContext(ctx)
+	return TodoScope{TodoID: id, OwnerID: user.ID, TenantID: tenant}, nil
+}
+
+func todoKey(scope TodoScope) string {
+	return fmt.Sprintf("todo|%s|%d|%d", scope.TenantID, scope.OwnerID, scope.TodoID)
+}
+
+func todoListKey(scope TodoScope) string {
+	return fmt.Sprintf("todos|%s|%d", scope.TenantID, scope.OwnerID)
+}
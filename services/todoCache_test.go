@@ -0,0 +1,235 @@
+package services_test
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/cache"
+	"github.com/doug-benn/go-server-starter/database"
+	"github.com/doug-benn/go-server-starter/models"
+	"github.com/doug-benn/go-server-starter/producer"
+	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/doug-benn/go-server-starter/services"
+	"github.com/doug-benn/go-server-starter/sse"
+	"github.com/doug-benn/go-server-starter/testutils"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/require"
+)
+
+// todoTable is a todos table shared by the nodes of a test, counting reads.
+type todoTable struct {
+	mu    sync.Mutex
+	todos map[int32]models.Todo
+	reads atomic.Int32
+}
+
+func (t *todoTable) querier() *testutils.MockQuerier {
+	return &testutils.MockQuerier{
+		GetTodoFunc: func(ctx context.Context, arg repository.GetTodoParams) (models.Todo, error) {
+			t.reads.Add(1)
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			todo, ok := t.todos[arg.ID]
+			if !ok {
+				return models.Todo{}, pgx.ErrNoRows
+			}
+			return todo, nil
+		},
+		ListTodosFunc: func(ctx context.Context, arg repository.ListTodosParams) ([]models.Todo, error) {
+			t.reads.Add(1)
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			var todos []models.Todo
+			for _, todo := range t.todos {
+				todos = append(todos, todo)
+			}
+			return todos, nil
+		},
+		UpdateTodoFunc: func(ctx context.Context, arg repository.UpdateTodoParams) (repository.UpdateTodoRow, error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			previous := t.todos[arg.ID]
+			todo := previous
+			todo.Title, todo.Description, todo.Completed = arg.Title, arg.Description, arg.Completed
+			todo.Version++
+			t.todos[arg.ID] = todo
+			return testutils.UpdateTodoRow(previous, todo), nil
+		},
+	}
+}
+
+// startCachingNodes starts n nodes with their own todo cache and producer,
+// sharing the table and an event bus.
+func startCachingNodes(t *testing.T, table *todoTable, n int) ([]*services.CachingTodoService, []*producer.Producer[sse.Event]) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	bus := producer.NewMemoryBus[sse.Event]()
+	users := &testutils.MockUserService{User: &models.User{ID: testOwnerID}}
+	nodes := make([]*services.CachingTodoService, n)
+	producers := make([]*producer.Producer[sse.Event], n)
+	for i := range nodes {
+		producers[i] = producer.NewProducer(producer.WithBus[sse.Event](bus), producer.WithCustomLogger[sse.Event](slog.Default()))
+		go producers[i].Start(ctx)
+
+		todoService := services.NewTodoService(table.querier(), slog.Default(),
+			services.WithUserService(users),
+			services.WithEventPublisher(producers[i]),
+		)
+		nodes[i] = services.NewCachingTodoService(todoService, users, cache.NewMemoryCache(cache.MemoryConfig{}), time.Minute, slog.Default())
+		nodes[i].Invalidate(ctx, producers[i])
+	}
+
+	// Wait until every node relays events from the bus. The subscriptions are
+	// drained rather than closed, pings may still be in flight.
+	for _, p := range producers[1:] {
+		sub := p.Subscribe(10)
+		require.Eventually(t, func() bool {
+			producers[0].Publish(ctx, sse.Event{Type: "ping"})
+			select {
+			case <-sub.Events():
+				return true
+			case <-time.After(10 * time.Millisecond):
+				return false
+			}
+		}, time.Second, time.Millisecond)
+		go func() {
+			for range sub.Events() {
+			}
+		}()
+	}
+	return nodes, producers
+}
+
+func tenantContext() context.Context {
+	return database.WithTenant(context.Background(), "acme")
+}
+
+func TestCachingTodoService_CachesReads(t *testing.T) {
+	table := &todoTable{todos: map[int32]models.Todo{1: {ID: 1, Title: "Cached", OwnerID: testOwnerID, TenantID: "acme"}}}
+	nodes, _ := startCachingNodes(t, table, 1)
+	ctx := tenantContext()
+
+	for range 3 {
+		todo, err := nodes[0].GetTodoByID(ctx, 1)
+		require.NoError(t, err)
+		require.Equal(t, "Cached", todo.Title)
+		_, err = nodes[0].GetAllTodos(ctx)
+		require.NoError(t, err)
+	}
+	require.EqualValues(t, 2, table.reads.Load(), "expected one read of the todo and one of the list")
+
+	// Errors are not cached
+	_, err := nodes[0].GetTodoByID(ctx, 2)
+	require.ErrorIs(t, err, pgx.ErrNoRows)
+	_, err = nodes[0].GetTodoByID(ctx, 2)
+	require.ErrorIs(t, err, pgx.ErrNoRows)
+	require.EqualValues(t, 4, table.reads.Load())
+}
+
+func TestCachingTodoService_WriteInvalidatesOtherNodes(t *testing.T) {
+	table := &todoTable{todos: map[int32]models.Todo{1: {ID: 1, Title: "Old", OwnerID: testOwnerID, TenantID: "acme", Version: 1}}}
+	nodes, _ := startCachingNodes(t, table, 3)
+	ctx := tenantContext()
+
+	for _, node := range nodes {
+		todo, err := node.GetTodoByID(ctx, 1)
+		require.NoError(t, err)
+		require.Equal(t, "Old", todo.Title)
+		_, err = node.GetAllTodos(ctx)
+		require.NoError(t, err)
+	}
+
+	todo, err := nodes[0].GetTodoByID(ctx, 1)
+	require.NoError(t, err)
+	todo.Title = "New"
+	require.NoError(t, nodes[0].UpdateTodo(ctx, todo))
+
+	// The writer reads its own write straight away
+	todo, err = nodes[0].GetTodoByID(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, "New", todo.Title)
+
+	for i, node := range nodes[1:] {
+		require.Eventually(t, func() bool {
+			todo, err := node.GetTodoByID(ctx, 1)
+			if err != nil || todo.Title != "New" {
+				return false
+			}
+			todos, err := node.GetAllTodos(ctx)
+			return err == nil && len(todos) == 1 && todos[0].Title == "New"
+		}, time.Second, 5*time.Millisecond, "node %d", i+1)
+	}
+}
+
+func TestCachingTodoService_DatabaseNotificationInvalidates(t *testing.T) {
+	table := &todoTable{todos: map[int32]models.Todo{1: {ID: 1, Title: "Old", OwnerID: testOwnerID, TenantID: "acme"}}}
+	nodes, producers := startCachingNodes(t, table, 1)
+	ctx := tenantContext()
+
+	_, err := nodes[0].GetTodoByID(ctx, 1)
+	require.NoError(t, err)
+
+	// Written by something other than the service, such as another replica
+	// without a bus or a migration
+	table.mu.Lock()
+	table.todos[1] = models.Todo{ID: 1, Title: "New", OwnerID: testOwnerID, TenantID: "acme"}
+	table.mu.Unlock()
+	producers[0].Broadcast(ctx, sse.Event{Data: &repository.DatabaseEvent{
+		Table:  "todos",
+		Action: "UPDATE",
+		Tenant: "acme",
+		Data:   map[string]any{"id": float64(1), "owner_id": float64(testOwnerID), "tenant_id": "acme"},
+	}})
+
+	require.Eventually(t, func() bool {
+		todo, err := nodes[0].GetTodoByID(ctx, 1)
+		return err == nil && todo.Title == "New"
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestCachingTodoService_ResyncFlushes(t *testing.T) {
+	table := &todoTable{todos: map[int32]models.Todo{1: {ID: 1, Title: "Old", OwnerID: testOwnerID, TenantID: "acme"}}}
+	nodes, producers := startCachingNodes(t, table, 1)
+	ctx := tenantContext()
+
+	_, err := nodes[0].GetAllTodos(ctx)
+	require.NoError(t, err)
+
+	// The notification about this change was missed
+	table.mu.Lock()
+	table.todos[2] = models.Todo{ID: 2, Title: "Missed", OwnerID: testOwnerID, TenantID: "acme"}
+	table.mu.Unlock()
+	producers[0].Broadcast(ctx, repository.NewResyncEvent())
+
+	require.Eventually(t, func() bool {
+		todos, err := nodes[0].GetAllTodos(ctx)
+		return err == nil && len(todos) == 2
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestCachingTodoService_RelayedResyncFlushes(t *testing.T) {
+	table := &todoTable{todos: map[int32]models.Todo{1: {ID: 1, Title: "Old", OwnerID: testOwnerID, TenantID: "acme"}}}
+	nodes, producers := startCachingNodes(t, table, 1)
+	ctx := tenantContext()
+
+	_, err := nodes[0].GetAllTodos(ctx)
+	require.NoError(t, err)
+
+	table.mu.Lock()
+	table.todos[2] = models.Todo{ID: 2, Title: "Missed", OwnerID: testOwnerID, TenantID: "acme"}
+	table.mu.Unlock()
+	// As decoded from the bus when another node failed to relay an event
+	producers[0].Broadcast(ctx, sse.Event{Data: map[string]any{"resync": true, "timestamp": time.Now().Format(time.RFC3339)}})
+
+	require.Eventually(t, func() bool {
+		todos, err := nodes[0].GetAllTodos(ctx)
+		return err == nil && len(todos) == 2
+	}, time.Second, 5*time.Millisecond)
+}
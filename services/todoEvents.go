@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/database"
+	"github.com/doug-benn/go-server-starter/models"
+	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/doug-benn/go-server-starter/sse"
+)
+
+// TodoEventSchemaVersion is bumped whenever the TodoEvent payload changes incompatibly.
+const TodoEventSchemaVersion = 1
+
+// systemActor is recorded as the actor when the context carries no user.
+const systemActor = "system"
+
+type TodoEventType string
+
+const (
+	TodoCreated   TodoEventType = "todo.created"
+	TodoCompleted TodoEventType = "todo.completed"
+	TodoRenamed   TodoEventType = "todo.renamed"
+	TodoDeleted   TodoEventType = "todo.deleted"
+)
+
+// TodoEvent is a domain event describing a change made through the TodoService.
+// Before is nil for created todos and After is nil for deleted ones.
+type TodoEvent struct {
+	Type          TodoEventType `json:"type"`
+	SchemaVersion int           `json:"schema_version"`
+	TodoID        int32         `json:"todo_id"`
+	Actor         string        `json:"actor"`
+	OccurredAt    time.Time     `json:"occurred_at"`
+	Before        *models.Todo  `json:"before,omitempty"`
+	After         *models.Todo  `json:"after,omitempty"`
+}
+
+// EventPublisher publishes events to subscribers on every node, it is
+// satisfied by *producer.Producer[sse.Event].
+type EventPublisher interface {
+	Publish(ctx context.Context, event sse.Event) error
+}
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx recording who is performing the operation.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// actorFromContext prefers the authenticated principal over an actor set with WithActor.
+func actorFromContext(ctx context.Context) string {
+	if principal, ok := auth.PrincipalFromContext(ctx); ok {
+		return principal.ID
+	}
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return systemActor
+}
+
+// publish emits a domain event if a publisher is configured. The change has
+// already been committed, so failures are logged rather than returned.
+func (s *TodoServiceImpl) publish(ctx context.Context, eventType TodoEventType, id int32, before, after *models.Todo) {
+	if s.events == nil {
+		return
+	}
+
+	event := TodoEvent{
+		Type:          eventType,
+		SchemaVersion: TodoEventSchemaVersion,
+		TodoID:        id,
+		Actor:         actorFromContext(ctx),
+		OccurredAt:    time.Now(),
+		Before:        before,
+		After:         after,
+	}
+
+	if err := s.events.Publish(ctx, sse.Event{Type: string(eventType), Data: event}); err != nil {
+		s.logger.ErrorContext(ctx, "failed to publish todo event", "type", eventType, "id", id, "error", err)
+	}
+}
+
+// TodoScope identifies a todo an event is about and who may see the event.
+type TodoScope struct {
+	TodoID   int32
+	OwnerID  int32
+	TenantID string
+}
+
+// TodoEventScope returns the todo an event is about with its owner and tenant. It
+// understands domain events, database notifications on the todos table and
+// either of them relayed from another node, where the data arrives as decoded
+// JSON.
+func TodoEventScope(event sse.Event) (TodoScope, bool) {
+	switch data := event.Data.(type) {
+	case TodoEvent:
+		return todoScope(data.After, data.Before)
+	case *repository.DatabaseEvent:
+		if data.Table != "todos" {
+			return TodoScope{}, false
+		}
+		id, _ := data.Data["id"].(float64)
+		owner, ok := data.Data["owner_id"].(float64)
+		return TodoScope{TodoID: int32(id), OwnerID: int32(owner), TenantID: data.Tenant}, ok && data.Tenant != ""
+	}
+
+	raw, err := json.Marshal(event.Data)
+	if err != nil {
+		return TodoScope{}, false
+	}
+	var data struct {
+		TodoEvent
+		Table  string       `json:"table"`
+		Record *models.Todo `json:"record"`
+	}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return TodoScope{}, false
+	}
+	if data.Table != "" {
+		if data.Table != "todos" || data.Record == nil {
+			return TodoScope{}, false
+		}
+		return todoScope(data.Record)
+	}
+	return todoScope(data.After, data.Before)
+}
+
+func todoScope(todos ...*models.Todo) (TodoScope, bool) {
+	for _, todo := range todos {
+		if todo != nil {
+			return TodoScope{TodoID: todo.ID, OwnerID: todo.OwnerID, TenantID: todo.TenantID}, todo.TenantID != ""
+		}
+	}
+	return TodoScope{}, false
+}
+
+// TodoEventFilter returns an sse.OnEvent hook that only lets through events
+// about todos owned by the subscriber in the tenant of the subscription. Events
+// without an owner or tenant are dropped.
+func TodoEventFilter(users UserService) func(r *http.Request, event sse.Event) bool {
+	return func(r *http.Request, event sse.Event) bool {
+		scope, ok := TodoEventScope(event)
+		if !ok {
+			return false
+		}
+		tenant, ok := database.TenantFromContext(r.Context())
+		if !ok || tenant != scope.TenantID {
+			return false
+		}
+		user, err := users.CurrentUser(r.Context())
+		if err != nil {
+			return false
+		}
+		return user.ID == scope.OwnerID
+	}
+}
@@ -2,32 +2,95 @@ package services
 
 import (
 	"context"
+	"errors"
+	"html"
 	"log/slog"
+	"strings"
 	"time"
 
+	"github.com/doug-benn/go-server-starter/database"
 	"github.com/doug-benn/go-server-starter/models"
 	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/jackc/pgx/v5"
 )
 
+// ErrVersionConflict is returned when a todo has changed since the version a
+// caller expected.
+var ErrVersionConflict = errors.New("todo has been modified")
+
+// TodoService manages the todos of the user authenticated in ctx. Todos owned
+// by other users are reported as not found.
 type TodoService interface {
 	CreateTodo(ctx context.Context, title, description string) (*models.Todo, error)
 	GetTodoByID(ctx context.Context, id int32) (*models.Todo, error)
 	GetAllTodos(ctx context.Context) ([]models.Todo, error)
+	// UpdateTodo overwrites a todo and updates it to the stored result. A
+	// non-zero Version must match the stored version.
 	UpdateTodo(ctx context.Context, todo *models.Todo) error
-	DeleteTodo(ctx context.Context, id int32) error
+	// DeleteTodo deletes a todo. A non-zero version must match the stored
+	// version.
+	DeleteTodo(ctx context.Context, id, version int32) error
 	CompleteTodo(ctx context.Context, id int32) error
+	// SearchTodos returns the todos matching query, written in web search
+	// syntax, best match first. It skips offset matches and returns at most
+	// limit.
+	SearchTodos(ctx context.Context, query string, limit, offset int32) ([]TodoSearchResult, error)
+}
+
+// TodoSearchResult is a todo matching a search. The highlights are HTML, the
+// matched terms of the title and of an excerpt of the description are wrapped
+// in <mark> elements and the rest is escaped.
+type TodoSearchResult struct {
+	models.Todo
+	Rank                 float32 `json:"rank"`
+	TitleHighlight       string  `json:"title_highlight"`
+	DescriptionHighlight string  `json:"description_highlight"`
 }
 
+// searchMarks replaces the delimiters the search query puts around matched
+// terms, they are control characters so that they survive escaping.
+var searchMarks = strings.NewReplacer("\x02", "<mark>", "\x03", "</mark>")
+
 type TodoServiceImpl struct {
 	repo   repository.Querier
 	logger *slog.Logger
+	users  UserService
+	events EventPublisher // optional, domain events are only emitted when set.
+}
+
+type TodoServiceOpt func(*TodoServiceImpl)
+
+// WithEventPublisher emits a TodoEvent through the publisher for every change.
+func WithEventPublisher(events EventPublisher) TodoServiceOpt {
+	return func(s *TodoServiceImpl) {
+		s.events = events
+	}
+}
+
+// WithUserService shares a UserService, and its cache, with other consumers.
+func WithUserService(users UserService) TodoServiceOpt {
+	return func(s *TodoServiceImpl) {
+		s.users = users
+	}
 }
 
-func NewTodoService(repo repository.Querier, logger *slog.Logger) TodoService {
-	return &TodoServiceImpl{repo: repo, logger: logger}
+func NewTodoService(repo repository.Querier, logger *slog.Logger, opts ...TodoServiceOpt) TodoService {
+	s := &TodoServiceImpl{repo: repo, logger: logger}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.users == nil {
+		s.users = NewUserService(repo, logger)
+	}
+	return s
 }
 
 func (s *TodoServiceImpl) CreateTodo(ctx context.Context, title, description string) (*models.Todo, error) {
+	scope, err := s.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	now := time.Now()
 	todo, err := s.repo.CreateTodo(ctx, repository.CreateTodoParams{
 		Title:       title,
@@ -35,16 +98,24 @@ func (s *TodoServiceImpl) CreateTodo(ctx context.Context, title, description str
 		Completed:   false,
 		CreatedAt:   now,
 		UpdatedAt:   now,
+		OwnerID:     scope.OwnerID,
+		TenantID:    scope.TenantID,
 	})
 	if err != nil {
 		s.logger.ErrorContext(ctx, "failed to create todo", "error", err)
 		return nil, err
 	}
+	s.publish(ctx, TodoCreated, todo.ID, nil, &todo)
 	return &todo, nil
 }
 
 func (s *TodoServiceImpl) GetTodoByID(ctx context.Context, id int32) (*models.Todo, error) {
-	todo, err := s.repo.GetTodo(ctx, id)
+	scope, err := s.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	todo, err := s.repo.GetTodo(ctx, repository.GetTodoParams{ID: id, OwnerID: scope.OwnerID, TenantID: scope.TenantID})
 	if err != nil {
 		s.logger.ErrorContext(ctx, "failed to get todo by id", "id", id, "error", err)
 		return nil, err
@@ -53,7 +124,12 @@ func (s *TodoServiceImpl) GetTodoByID(ctx context.Context, id int32) (*models.To
 }
 
 func (s *TodoServiceImpl) GetAllTodos(ctx context.Context) ([]models.Todo, error) {
-	todos, err := s.repo.ListTodos(ctx)
+	scope, err := s.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	todos, err := s.repo.ListTodos(ctx, repository.ListTodosParams{OwnerID: scope.OwnerID, TenantID: scope.TenantID})
 	if err != nil {
 		s.logger.ErrorContext(ctx, "failed to list todos", "error", err)
 		return nil, err
@@ -62,38 +138,165 @@ func (s *TodoServiceImpl) GetAllTodos(ctx context.Context) ([]models.Todo, error
 }
 
 func (s *TodoServiceImpl) UpdateTodo(ctx context.Context, todo *models.Todo) error {
-	updated, err := s.repo.UpdateTodo(ctx, repository.UpdateTodoParams{
-		Title:       todo.Title,
-		Description: todo.Description,
-		Completed:   todo.Completed,
-		UpdatedAt:   time.Now(),
-		ID:          todo.ID,
+	scope, err := s.scope(ctx)
+	if err != nil {
+		return err
+	}
+
+	row, err := s.repo.UpdateTodo(ctx, repository.UpdateTodoParams{
+		Title:           todo.Title,
+		Description:     todo.Description,
+		Completed:       todo.Completed,
+		UpdatedAt:       time.Now(),
+		ID:              todo.ID,
+		OwnerID:         scope.OwnerID,
+		TenantID:        scope.TenantID,
+		ExpectedVersion: todo.Version,
 	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return s.versionError(ctx, todo.ID, scope, todo.Version)
+	}
 	if err != nil {
 		s.logger.ErrorContext(ctx, "failed to update todo", "id", todo.ID, "error", err)
 		return err
 	}
+	before, updated := changed(row)
 	*todo = updated
+
+	if before.Title != updated.Title {
+		s.publish(ctx, TodoRenamed, updated.ID, &before, &updated)
+	}
+	if !before.Completed && updated.Completed {
+		s.publish(ctx, TodoCompleted, updated.ID, &before, &updated)
+	}
 	return nil
 }
 
-func (s *TodoServiceImpl) DeleteTodo(ctx context.Context, id int32) error {
-	err := s.repo.DeleteTodo(ctx, id)
+func (s *TodoServiceImpl) DeleteTodo(ctx context.Context, id, version int32) error {
+	scope, err := s.scope(ctx)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := s.repo.DeleteTodo(ctx, repository.DeleteTodoParams{ID: id, OwnerID: scope.OwnerID, TenantID: scope.TenantID, ExpectedVersion: version})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return s.versionError(ctx, id, scope, version)
+	}
 	if err != nil {
 		s.logger.ErrorContext(ctx, "failed to delete todo", "id", id, "error", err)
 		return err
 	}
+
+	s.publish(ctx, TodoDeleted, id, &deleted, nil)
 	return nil
 }
 
 func (s *TodoServiceImpl) CompleteTodo(ctx context.Context, id int32) error {
-	_, err := s.repo.CompleteTodo(ctx, repository.CompleteTodoParams{
+	scope, err := s.scope(ctx)
+	if err != nil {
+		return err
+	}
+
+	row, err := s.repo.CompleteTodo(ctx, repository.CompleteTodoParams{
 		UpdatedAt: time.Now(),
 		ID:        id,
+		OwnerID:   scope.OwnerID,
+		TenantID:  scope.TenantID,
 	})
 	if err != nil {
 		s.logger.ErrorContext(ctx, "failed to complete todo", "id", id, "error", err)
 		return err
 	}
+
+	if before, updated := changed(repository.UpdateTodoRow(row)); !before.Completed {
+		s.publish(ctx, TodoCompleted, id, &before, &updated)
+	}
 	return nil
 }
+
+func (s *TodoServiceImpl) SearchTodos(ctx context.Context, query string, limit, offset int32) ([]TodoSearchResult, error) {
+	scope, err := s.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.repo.SearchTodos(ctx, repository.SearchTodosParams{
+		Query:      query,
+		OwnerID:    scope.OwnerID,
+		TenantID:   scope.TenantID,
+		PageSize:   limit,
+		PageOffset: offset,
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to search todos", "error", err)
+		return nil, err
+	}
+
+	results := make([]TodoSearchResult, len(rows))
+	for i, row := range rows {
+		results[i] = TodoSearchResult{
+			Todo: models.Todo{
+				ID:          row.ID,
+				Title:       row.Title,
+				Description: row.Description,
+				Completed:   row.Completed,
+				CreatedAt:   row.CreatedAt,
+				UpdatedAt:   row.UpdatedAt,
+				OwnerID:     row.OwnerID,
+				TenantID:    row.TenantID,
+				Version:     row.Version,
+			},
+			Rank:                 row.Rank,
+			TitleHighlight:       searchMarks.Replace(html.EscapeString(row.TitleHeadline)),
+			DescriptionHighlight: searchMarks.Replace(html.EscapeString(row.DescriptionHeadline)),
+		}
+	}
+	return results, nil
+}
+
+// scope returns the user and tenant every query is scoped to. The tenant is
+// matched by the queries as well as by row-level security.
+func (s *TodoServiceImpl) scope(ctx context.Context) (TodoScope, error) {
+	user, err := s.users.CurrentUser(ctx)
+	if err != nil {
+		return TodoScope{}, err
+	}
+	tenant, _ := database.TenantFromContext(ctx)
+	return TodoScope{OwnerID: user.ID, TenantID: tenant}, nil
+}
+
+// versionError tells a todo that changed from one that does not exist after a
+// conditional write matched no rows.
+func (s *TodoServiceImpl) versionError(ctx context.Context, id int32, scope TodoScope, version int32) error {
+	if version == 0 {
+		return pgx.ErrNoRows
+	}
+	if _, err := s.repo.GetTodo(ctx, repository.GetTodoParams{ID: id, OwnerID: scope.OwnerID, TenantID: scope.TenantID}); err != nil {
+		return err
+	}
+	return ErrVersionConflict
+}
+
+// changed splits a row returned by an update into the todo before and after
+// it. The previous values are read by the update itself, with the row locked,
+// so a concurrent write cannot come between them.
+func changed(row repository.UpdateTodoRow) (before, after models.Todo) {
+	after = models.Todo{
+		ID:          row.ID,
+		Title:       row.Title,
+		Description: row.Description,
+		Completed:   row.Completed,
+		CreatedAt:   row.CreatedAt,
+		UpdatedAt:   row.UpdatedAt,
+		OwnerID:     row.OwnerID,
+		TenantID:    row.TenantID,
+		Version:     row.Version,
+	}
+	before = after
+	before.Title = row.PreviousTitle
+	before.Description = row.PreviousDescription
+	before.Completed = row.PreviousCompleted
+	before.UpdatedAt = row.PreviousUpdatedAt
+	before.Version = row.PreviousVersion
+	return before, after
+}
@@ -7,16 +7,26 @@ import (
 	"testing"
 	"time"
 
+	"github.com/doug-benn/go-server-starter/database"
 	"github.com/doug-benn/go-server-starter/models"
 	"github.com/doug-benn/go-server-starter/repository"
 	"github.com/doug-benn/go-server-starter/services"
 	"github.com/doug-benn/go-server-starter/testutils"
+	"github.com/jackc/pgx/v5"
 )
 
 func now() time.Time {
 	return time.Now().Truncate(time.Microsecond)
 }
 
+const testOwnerID = 11
+
+// newTodoService returns a TodoService acting as the user testOwnerID.
+func newTodoService(repo repository.Querier, opts ...services.TodoServiceOpt) services.TodoService {
+	users := &testutils.MockUserService{User: &models.User{ID: testOwnerID, Subject: "user:test"}}
+	return services.NewTodoService(repo, slog.Default(), append([]services.TodoServiceOpt{services.WithUserService(users)}, opts...)...)
+}
+
 func TestCreateTodo(t *testing.T) {
 	mockRepo := &testutils.MockQuerier{
 		CreateTodoFunc: func(ctx context.Context, arg repository.CreateTodoParams) (models.Todo, error) {
@@ -31,7 +41,7 @@ func TestCreateTodo(t *testing.T) {
 		},
 	}
 
-	todoService := services.NewTodoService(mockRepo, slog.Default())
+	todoService := newTodoService(mockRepo)
 	ctx := context.Background()
 
 	todo, err := todoService.CreateTodo(ctx, "Test Todo", "Test Description")
@@ -64,7 +74,7 @@ func TestCreateTodo_Error(t *testing.T) {
 		},
 	}
 
-	todoService := services.NewTodoService(mockRepo, slog.Default())
+	todoService := newTodoService(mockRepo)
 	ctx := context.Background()
 
 	todo, err := todoService.CreateTodo(ctx, "Test Todo", "Test Description")
@@ -89,15 +99,15 @@ func TestGetTodoByID(t *testing.T) {
 	}
 
 	mockRepo := &testutils.MockQuerier{
-		GetTodoFunc: func(ctx context.Context, id int32) (models.Todo, error) {
-			if id == 1 {
+		GetTodoFunc: func(ctx context.Context, arg repository.GetTodoParams) (models.Todo, error) {
+			if arg.ID == 1 && arg.OwnerID == testOwnerID {
 				return expectedTodo, nil
 			}
 			return models.Todo{}, errors.New("not found")
 		},
 	}
 
-	todoService := services.NewTodoService(mockRepo, slog.Default())
+	todoService := newTodoService(mockRepo)
 	ctx := context.Background()
 
 	todo, err := todoService.GetTodoByID(ctx, 1)
@@ -138,13 +148,19 @@ func TestGetAllTodos(t *testing.T) {
 	}
 
 	mockRepo := &testutils.MockQuerier{
-		ListTodosFunc: func(ctx context.Context) ([]models.Todo, error) {
+		ListTodosFunc: func(ctx context.Context, arg repository.ListTodosParams) ([]models.Todo, error) {
+			if arg.OwnerID != testOwnerID {
+				t.Errorf("Expected todos of owner %d, got %d", testOwnerID, arg.OwnerID)
+			}
+			if arg.TenantID != "acme" {
+				t.Errorf("Expected todos of tenant acme, got %q", arg.TenantID)
+			}
 			return expectedTodos, nil
 		},
 	}
 
-	todoService := services.NewTodoService(mockRepo, slog.Default())
-	ctx := context.Background()
+	todoService := newTodoService(mockRepo)
+	ctx := database.WithTenant(context.Background(), "acme")
 
 	todos, err := todoService.GetAllTodos(ctx)
 
@@ -164,16 +180,53 @@ func TestGetAllTodos(t *testing.T) {
 	}
 }
 
+func TestSearchTodos(t *testing.T) {
+	mockRepo := &testutils.MockQuerier{
+		SearchTodosFunc: func(ctx context.Context, arg repository.SearchTodosParams) ([]repository.SearchTodosRow, error) {
+			if arg.OwnerID != testOwnerID || arg.Query != "groceries" || arg.PageSize != 10 || arg.PageOffset != 20 {
+				t.Errorf("Unexpected search params %+v", arg)
+			}
+			return []repository.SearchTodosRow{{
+				ID:                  1,
+				Title:               "Buy <b>groceries</b>",
+				Description:         "Milk & eggs",
+				Rank:                0.6,
+				TitleHeadline:       "Buy <b>\x02groceries\x03</b>",
+				DescriptionHeadline: "Milk & eggs",
+			}}, nil
+		},
+	}
+
+	results, err := newTodoService(mockRepo).SearchTodos(context.Background(), "groceries", 10, 20)
+
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("Expected 1 result, got %d", len(results))
+	}
+	if results[0].ID != 1 || results[0].Title != "Buy <b>groceries</b>" || results[0].Rank != 0.6 {
+		t.Errorf("Unexpected result %+v", results[0])
+	}
+	// Only the matched terms are markup, the text around them is escaped
+	if got, want := results[0].TitleHighlight, "Buy &lt;b&gt;<mark>groceries</mark>&lt;/b&gt;"; got != want {
+		t.Errorf("Expected title highlight %q, got %q", want, got)
+	}
+	if got, want := results[0].DescriptionHighlight, "Milk &amp; eggs"; got != want {
+		t.Errorf("Expected description highlight %q, got %q", want, got)
+	}
+}
+
 func TestCompleteTodo(t *testing.T) {
 	var completedID int32
 	mockRepo := &testutils.MockQuerier{
-		CompleteTodoFunc: func(ctx context.Context, arg repository.CompleteTodoParams) (models.Todo, error) {
+		CompleteTodoFunc: func(ctx context.Context, arg repository.CompleteTodoParams) (repository.CompleteTodoRow, error) {
 			completedID = arg.ID
-			return models.Todo{}, nil
+			return repository.CompleteTodoRow{}, nil
 		},
 	}
 
-	todoService := services.NewTodoService(mockRepo, slog.Default())
+	todoService := newTodoService(mockRepo)
 	ctx := context.Background()
 
 	err := todoService.CompleteTodo(ctx, 1)
@@ -185,3 +238,204 @@ func TestCompleteTodo(t *testing.T) {
 		t.Errorf("Expected to complete todo with ID 1, got %d", completedID)
 	}
 }
+
+func todoEvents(t *testing.T, publisher *testutils.MockPublisher) []services.TodoEvent {
+	t.Helper()
+	events := make([]services.TodoEvent, 0, len(publisher.Events))
+	for _, e := range publisher.Events {
+		event, ok := e.Data.(services.TodoEvent)
+		if !ok {
+			t.Fatalf("Expected TodoEvent data, got %T", e.Data)
+		}
+		if e.Type != string(event.Type) {
+			t.Errorf("Expected SSE type %q, got %q", event.Type, e.Type)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestCreateTodo_PublishesCreated(t *testing.T) {
+	mockRepo := &testutils.MockQuerier{
+		CreateTodoFunc: func(ctx context.Context, arg repository.CreateTodoParams) (models.Todo, error) {
+			return models.Todo{ID: 7, Title: arg.Title, Description: arg.Description}, nil
+		},
+	}
+	publisher := &testutils.MockPublisher{}
+
+	todoService := newTodoService(mockRepo, services.WithEventPublisher(publisher))
+	ctx := services.WithActor(context.Background(), "alice")
+
+	if _, err := todoService.CreateTodo(ctx, "Test Todo", "Test Description"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	events := todoEvents(t, publisher)
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(events))
+	}
+	event := events[0]
+	if event.Type != services.TodoCreated {
+		t.Errorf("Expected type %q, got %q", services.TodoCreated, event.Type)
+	}
+	if event.SchemaVersion != services.TodoEventSchemaVersion {
+		t.Errorf("Expected schema version %d, got %d", services.TodoEventSchemaVersion, event.SchemaVersion)
+	}
+	if event.TodoID != 7 || event.Actor != "alice" {
+		t.Errorf("Expected todo 7 by alice, got todo %d by %q", event.TodoID, event.Actor)
+	}
+	if event.Before != nil || event.After == nil || event.After.Title != "Test Todo" {
+		t.Errorf("Expected only an after value, got before=%v after=%v", event.Before, event.After)
+	}
+}
+
+func TestUpdateTodo_PublishesRenamedAndCompleted(t *testing.T) {
+	mockRepo := &testutils.MockQuerier{
+		// The before values come from the update itself
+		UpdateTodoFunc: func(ctx context.Context, arg repository.UpdateTodoParams) (repository.UpdateTodoRow, error) {
+			return repository.UpdateTodoRow{
+				ID: arg.ID, Title: arg.Title, Description: arg.Description, Completed: arg.Completed, Version: 2,
+				PreviousTitle: "Old", PreviousDescription: "Desc", PreviousVersion: 1,
+			}, nil
+		},
+	}
+	publisher := &testutils.MockPublisher{}
+
+	todoService := newTodoService(mockRepo, services.WithEventPublisher(publisher))
+
+	todo := &models.Todo{ID: 1, Title: "New", Description: "Desc", Completed: true}
+	if err := todoService.UpdateTodo(context.Background(), todo); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	events := todoEvents(t, publisher)
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+	if events[0].Type != services.TodoRenamed || events[1].Type != services.TodoCompleted {
+		t.Errorf("Expected renamed then completed, got %q then %q", events[0].Type, events[1].Type)
+	}
+	if events[0].Before.Title != "Old" || events[0].After.Title != "New" {
+		t.Errorf("Expected rename from Old to New, got %q to %q", events[0].Before.Title, events[0].After.Title)
+	}
+	if events[0].Before.Version != 1 || events[0].After.Version != 2 {
+		t.Errorf("Expected versions 1 and 2, got %d and %d", events[0].Before.Version, events[0].After.Version)
+	}
+	if events[0].Actor != "system" {
+		t.Errorf("Expected system actor, got %q", events[0].Actor)
+	}
+}
+
+func TestUpdateTodo_DescriptionOnlyPublishesNothing(t *testing.T) {
+	mockRepo := &testutils.MockQuerier{
+		UpdateTodoFunc: func(ctx context.Context, arg repository.UpdateTodoParams) (repository.UpdateTodoRow, error) {
+			return repository.UpdateTodoRow{ID: arg.ID, Title: arg.Title, Description: arg.Description, PreviousTitle: "Same"}, nil
+		},
+	}
+	publisher := &testutils.MockPublisher{}
+
+	todoService := newTodoService(mockRepo, services.WithEventPublisher(publisher))
+
+	if err := todoService.UpdateTodo(context.Background(), &models.Todo{ID: 1, Title: "Same", Description: "New"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(publisher.Events) != 0 {
+		t.Errorf("Expected no events, got %d", len(publisher.Events))
+	}
+}
+
+func TestCompleteTodo_PublishesCompletedOnce(t *testing.T) {
+	completed := false
+	mockRepo := &testutils.MockQuerier{
+		CompleteTodoFunc: func(ctx context.Context, arg repository.CompleteTodoParams) (repository.CompleteTodoRow, error) {
+			row := repository.CompleteTodoRow{ID: arg.ID, Completed: true, PreviousCompleted: completed}
+			completed = true
+			return row, nil
+		},
+	}
+	publisher := &testutils.MockPublisher{}
+
+	todoService := newTodoService(mockRepo, services.WithEventPublisher(publisher))
+
+	for range 2 {
+		if err := todoService.CompleteTodo(context.Background(), 3); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	events := todoEvents(t, publisher)
+	if len(events) != 1 || events[0].Type != services.TodoCompleted {
+		t.Fatalf("Expected a single completed event, got %v", events)
+	}
+	if events[0].Before.Completed || !events[0].After.Completed {
+		t.Errorf("Expected before incomplete and after complete")
+	}
+}
+
+func TestDeleteTodo_PublishesDeleted(t *testing.T) {
+	mockRepo := &testutils.MockQuerier{
+		DeleteTodoFunc: func(ctx context.Context, arg repository.DeleteTodoParams) (models.Todo, error) {
+			return models.Todo{ID: arg.ID, Title: "Gone"}, nil
+		},
+	}
+	publisher := &testutils.MockPublisher{Err: errors.New("bus down")}
+
+	todoService := newTodoService(mockRepo, services.WithEventPublisher(publisher))
+
+	// Publish failures must not fail the already committed delete
+	if err := todoService.DeleteTodo(context.Background(), 5, 0); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	events := todoEvents(t, publisher)
+	if len(events) != 1 || events[0].Type != services.TodoDeleted {
+		t.Fatalf("Expected a single deleted event, got %v", events)
+	}
+	if events[0].After != nil || events[0].Before.Title != "Gone" {
+		t.Errorf("Expected only a before value, got before=%v after=%v", events[0].Before, events[0].After)
+	}
+}
+
+func TestUpdateTodo_VersionConflict(t *testing.T) {
+	stored := models.Todo{ID: 1, Title: "Old", Version: 3}
+	mockRepo := &testutils.MockQuerier{
+		GetTodoFunc: func(ctx context.Context, arg repository.GetTodoParams) (models.Todo, error) {
+			if arg.ID != stored.ID {
+				return models.Todo{}, pgx.ErrNoRows
+			}
+			return stored, nil
+		},
+		UpdateTodoFunc: func(ctx context.Context, arg repository.UpdateTodoParams) (repository.UpdateTodoRow, error) {
+			if arg.ID != stored.ID || (arg.ExpectedVersion != 0 && arg.ExpectedVersion != stored.Version) {
+				return repository.UpdateTodoRow{}, pgx.ErrNoRows
+			}
+			return repository.UpdateTodoRow{ID: arg.ID, Title: arg.Title, Version: stored.Version + 1}, nil
+		},
+		DeleteTodoFunc: func(ctx context.Context, arg repository.DeleteTodoParams) (models.Todo, error) {
+			if arg.ID != stored.ID || (arg.ExpectedVersion != 0 && arg.ExpectedVersion != stored.Version) {
+				return models.Todo{}, pgx.ErrNoRows
+			}
+			return stored, nil
+		},
+	}
+	todoService := newTodoService(mockRepo)
+	ctx := context.Background()
+
+	if err := todoService.UpdateTodo(ctx, &models.Todo{ID: 1, Title: "New", Version: 2}); !errors.Is(err, services.ErrVersionConflict) {
+		t.Errorf("Expected a version conflict, got %v", err)
+	}
+	if err := todoService.UpdateTodo(ctx, &models.Todo{ID: 2, Title: "New", Version: 2}); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("Expected a missing todo to be not found, got %v", err)
+	}
+	todo := &models.Todo{ID: 1, Title: "New", Version: 3}
+	if err := todoService.UpdateTodo(ctx, todo); err != nil || todo.Version != 4 {
+		t.Errorf("Expected the matching version to update, got %v %+v", err, todo)
+	}
+
+	if err := todoService.DeleteTodo(ctx, 1, 2); !errors.Is(err, services.ErrVersionConflict) {
+		t.Errorf("Expected a version conflict, got %v", err)
+	}
+	if err := todoService.DeleteTodo(ctx, 1, 0); err != nil {
+		t.Errorf("Expected an unconditional delete, got %v", err)
+	}
+}
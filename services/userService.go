@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/models"
+	"github.com/doug-benn/go-server-starter/repository"
+)
+
+// ErrUnauthenticated is returned when an operation is scoped to a user but the
+// context carries no principal.
+var ErrUnauthenticated = errors.New("no authenticated principal")
+
+type UserService interface {
+	// CurrentUser returns the user for the principal in ctx, creating it the
+	// first time the principal is seen.
+	CurrentUser(ctx context.Context) (*models.User, error)
+}
+
+type UserServiceImpl struct {
+	repo   repository.Querier
+	logger *slog.Logger
+
+	mu    sync.RWMutex
+	users map[string]models.User // by principal ID
+}
+
+func NewUserService(repo repository.Querier, logger *slog.Logger) UserService {
+	return &UserServiceImpl{repo: repo, logger: logger, users: make(map[string]models.User)}
+}
+
+func (s *UserServiceImpl) CurrentUser(ctx context.Context) (*models.User, error) {
+	principal, ok := auth.PrincipalFromContext(ctx)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+
+	s.mu.RLock()
+	user, ok := s.users[principal.ID]
+	s.mu.RUnlock()
+	if ok {
+		return &user, nil
+	}
+
+	user, err := s.repo.UpsertUser(ctx, repository.UpsertUserParams{
+		Subject:   principal.ID,
+		Name:      principal.Name,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to upsert user", "subject", principal.ID, "error", err)
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.users[principal.ID] = user
+	s.mu.Unlock()
+	return &user, nil
+}
@@ -0,0 +1,170 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/database"
+	"github.com/doug-benn/go-server-starter/models"
+	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/doug-benn/go-server-starter/services"
+	"github.com/doug-benn/go-server-starter/sse"
+	"github.com/doug-benn/go-server-starter/testutils"
+	"github.com/jackc/pgx/v5"
+)
+
+func withPrincipal(id string) context.Context {
+	return auth.WithPrincipal(context.Background(), &auth.Principal{ID: id, Name: id})
+}
+
+func TestUserService_CurrentUser(t *testing.T) {
+	upserts := 0
+	mockRepo := &testutils.MockQuerier{
+		UpsertUserFunc: func(ctx context.Context, arg repository.UpsertUserParams) (models.User, error) {
+			upserts++
+			return models.User{ID: int32(upserts), Subject: arg.Subject, Name: arg.Name}, nil
+		},
+	}
+	users := services.NewUserService(mockRepo, slog.Default())
+
+	alice, err := users.CurrentUser(withPrincipal("user:alice"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if alice.Subject != "user:alice" {
+		t.Errorf("Expected subject user:alice, got %q", alice.Subject)
+	}
+
+	again, _ := users.CurrentUser(withPrincipal("user:alice"))
+	if again.ID != alice.ID || upserts != 1 {
+		t.Errorf("Expected the user to be cached, got %d upserts", upserts)
+	}
+
+	bob, _ := users.CurrentUser(withPrincipal("user:bob"))
+	if bob.ID == alice.ID {
+		t.Error("Expected a different user for a different principal")
+	}
+
+	if _, err := users.CurrentUser(context.Background()); !errors.Is(err, services.ErrUnauthenticated) {
+		t.Errorf("Expected ErrUnauthenticated, got %v", err)
+	}
+}
+
+func TestTodoService_RequiresPrincipal(t *testing.T) {
+	mockRepo := &testutils.MockQuerier{
+		ListTodosFunc: func(ctx context.Context, arg repository.ListTodosParams) ([]models.Todo, error) {
+			t.Fatal("Expected no query without a principal")
+			return nil, nil
+		},
+	}
+	todoService := services.NewTodoService(mockRepo, slog.Default())
+
+	if _, err := todoService.GetAllTodos(context.Background()); !errors.Is(err, services.ErrUnauthenticated) {
+		t.Errorf("Expected ErrUnauthenticated, got %v", err)
+	}
+}
+
+func TestTodoService_OtherUsersTodosAreNotFound(t *testing.T) {
+	// Alice is user 1 and owns todo 10, bob is user 2
+	mockRepo := &testutils.MockQuerier{
+		UpsertUserFunc: func(ctx context.Context, arg repository.UpsertUserParams) (models.User, error) {
+			if arg.Subject == "user:alice" {
+				return models.User{ID: 1}, nil
+			}
+			return models.User{ID: 2}, nil
+		},
+		GetTodoFunc: func(ctx context.Context, arg repository.GetTodoParams) (models.Todo, error) {
+			if arg.ID == 10 && arg.OwnerID == 1 {
+				return models.Todo{ID: 10, OwnerID: 1}, nil
+			}
+			return models.Todo{}, pgx.ErrNoRows
+		},
+		DeleteTodoFunc: func(ctx context.Context, arg repository.DeleteTodoParams) (models.Todo, error) {
+			if arg.ID == 10 && arg.OwnerID == 1 {
+				return models.Todo{ID: 10, OwnerID: 1}, nil
+			}
+			return models.Todo{}, pgx.ErrNoRows
+		},
+	}
+	todoService := services.NewTodoService(mockRepo, slog.Default())
+
+	if _, err := todoService.GetTodoByID(withPrincipal("user:bob"), 10); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("Expected pgx.ErrNoRows reading another user's todo, got %v", err)
+	}
+	if err := todoService.DeleteTodo(withPrincipal("user:bob"), 10, 0); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("Expected pgx.ErrNoRows deleting another user's todo, got %v", err)
+	}
+	if err := todoService.DeleteTodo(withPrincipal("user:alice"), 10, 0); err != nil {
+		t.Errorf("Expected owner to delete the todo, got %v", err)
+	}
+}
+
+func TestTodoEventScope(t *testing.T) {
+	todoEvent := services.TodoEvent{Type: services.TodoDeleted, Before: &models.Todo{ID: 1, OwnerID: 4, TenantID: "acme"}}
+	relayed := func(v any) any {
+		b, _ := json.Marshal(v)
+		var m map[string]any
+		json.Unmarshal(b, &m)
+		return m
+	}
+	dbEvent := &repository.DatabaseEvent{Table: "todos", Action: "INSERT", Tenant: "acme", Data: map[string]any{"id": float64(2), "owner_id": float64(4), "tenant_id": "acme"}}
+	todoScope := services.TodoScope{TodoID: 1, OwnerID: 4, TenantID: "acme"}
+	dbScope := services.TodoScope{TodoID: 2, OwnerID: 4, TenantID: "acme"}
+
+	tests := []struct {
+		name  string
+		data  any
+		scope services.TodoScope
+		ok    bool
+	}{
+		{"domain event", todoEvent, todoScope, true},
+		{"relayed domain event", relayed(todoEvent), todoScope, true},
+		{"database notification", dbEvent, dbScope, true},
+		{"relayed database notification", relayed(dbEvent), dbScope, true},
+		{"database notification without tenant", &repository.DatabaseEvent{Table: "todos", Data: map[string]any{"owner_id": float64(4)}}, services.TodoScope{OwnerID: 4}, false},
+		{"other table", &repository.DatabaseEvent{Table: "users", Data: map[string]any{}}, services.TodoScope{}, false},
+		{"unrelated data", map[string]string{"hello": "world"}, services.TodoScope{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope, ok := services.TodoEventScope(sse.Event{Data: tt.data})
+			if scope != tt.scope || ok != tt.ok {
+				t.Errorf("Expected (%+v, %v), got (%+v, %v)", tt.scope, tt.ok, scope, ok)
+			}
+		})
+	}
+}
+
+func TestTodoEventFilter(t *testing.T) {
+	filter := services.TodoEventFilter(&testutils.MockUserService{User: &models.User{ID: 4}})
+	req := httptest.NewRequest("GET", "/events", nil)
+	req = req.WithContext(database.WithTenant(req.Context(), "acme"))
+
+	own := sse.Event{Data: services.TodoEvent{After: &models.Todo{OwnerID: 4, TenantID: "acme"}}}
+	other := sse.Event{Data: services.TodoEvent{After: &models.Todo{OwnerID: 5, TenantID: "acme"}}}
+	otherTenant := sse.Event{Data: services.TodoEvent{After: &models.Todo{OwnerID: 4, TenantID: "globex"}}}
+
+	if !filter(req, own) {
+		t.Error("Expected the subscriber's own todo event to be delivered")
+	}
+	if filter(req, other) {
+		t.Error("Expected another user's todo event to be filtered")
+	}
+	if filter(req, otherTenant) {
+		t.Error("Expected another tenant's todo event to be filtered")
+	}
+	if filter(httptest.NewRequest("GET", "/events", nil), own) {
+		t.Error("Expected events to be filtered without a tenant")
+	}
+
+	unauthenticated := services.TodoEventFilter(&testutils.MockUserService{Err: services.ErrUnauthenticated})
+	if unauthenticated(req, own) {
+		t.Error("Expected events to be filtered without a user")
+	}
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/models"
+	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	WebhookStatusPending   = "pending"
+	WebhookStatusSucceeded = "succeeded"
+	WebhookStatusDead      = "dead"
+)
+
+// ErrInvalidWebhookURL is returned when a subscription URL is not an absolute http(s) URL.
+var ErrInvalidWebhookURL = errors.New("webhook url must be an absolute http or https url")
+
+// WebhookService manages webhook subscriptions. Callers bound to a tenant only
+// see the subscriptions of their tenant, which only receive its events.
+type WebhookService interface {
+	CreateSubscription(ctx context.Context, rawURL string, eventTypes []string) (*models.WebhookSubscription, error)
+	GetSubscription(ctx context.Context, id int32) (*models.WebhookSubscription, error)
+	ListSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error)
+	UpdateSubscription(ctx context.Context, subscription *models.WebhookSubscription) error
+	DeleteSubscription(ctx context.Context, id int32) error
+	ListDeliveries(ctx context.Context, subscriptionID int32, limit int32) ([]models.WebhookDelivery, error)
+	Redeliver(ctx context.Context, subscriptionID, deliveryID int32) (*models.WebhookDelivery, error)
+}
+
+type WebhookServiceImpl struct {
+	repo   repository.Querier
+	logger *slog.Logger
+}
+
+func NewWebhookService(repo repository.Querier, logger *slog.Logger) WebhookService {
+	return &WebhookServiceImpl{repo: repo, logger: logger}
+}
+
+// CreateSubscription stores a new subscription with a generated signing secret,
+// bound to the tenant of the caller. An empty eventTypes list subscribes to
+// every event.
+func (s *WebhookServiceImpl) CreateSubscription(ctx context.Context, rawURL string, eventTypes []string) (*models.WebhookSubscription, error) {
+	if err := validateWebhookURL(rawURL); err != nil {
+		return nil, err
+	}
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	subscription, err := s.repo.CreateWebhookSubscription(ctx, repository.CreateWebhookSubscriptionParams{
+		Url:        rawURL,
+		Secret:     secret,
+		EventTypes: nonNil(eventTypes),
+		Active:     true,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		TenantID:   callerTenant(ctx),
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to create webhook subscription", "error", err)
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+func (s *WebhookServiceImpl) GetSubscription(ctx context.Context, id int32) (*models.WebhookSubscription, error) {
+	subscription, err := s.repo.GetWebhookSubscription(ctx, repository.GetWebhookSubscriptionParams{ID: id, TenantID: callerTenant(ctx)})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to get webhook subscription", "id", id, "error", err)
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+func (s *WebhookServiceImpl) ListSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error) {
+	subscriptions, err := s.repo.ListWebhookSubscriptions(ctx, callerTenant(ctx))
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to list webhook subscriptions", "error", err)
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// UpdateSubscription changes the url, event filter and active flag. The secret is never changed.
+func (s *WebhookServiceImpl) UpdateSubscription(ctx context.Context, subscription *models.WebhookSubscription) error {
+	if err := validateWebhookURL(subscription.Url); err != nil {
+		return err
+	}
+
+	updated, err := s.repo.UpdateWebhookSubscription(ctx, repository.UpdateWebhookSubscriptionParams{
+		Url:        subscription.Url,
+		EventTypes: nonNil(subscription.EventTypes),
+		Active:     subscription.Active,
+		UpdatedAt:  time.Now(),
+		ID:         subscription.ID,
+		TenantID:   callerTenant(ctx),
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to update webhook subscription", "id", subscription.ID, "error", err)
+		return err
+	}
+	*subscription = updated
+	return nil
+}
+
+// DeleteSubscription deletes a subscription, returning pgx.ErrNoRows if there
+// is none with the id.
+func (s *WebhookServiceImpl) DeleteSubscription(ctx context.Context, id int32) error {
+	rows, err := s.repo.DeleteWebhookSubscription(ctx, repository.DeleteWebhookSubscriptionParams{ID: id, TenantID: callerTenant(ctx)})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to delete webhook subscription", "id", id, "error", err)
+		return err
+	}
+	if rows == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// ListDeliveries returns the most recent deliveries for a subscription, newest first.
+func (s *WebhookServiceImpl) ListDeliveries(ctx context.Context, subscriptionID int32, limit int32) ([]models.WebhookDelivery, error) {
+	if _, err := s.GetSubscription(ctx, subscriptionID); err != nil {
+		return nil, err
+	}
+
+	deliveries, err := s.repo.ListWebhookDeliveries(ctx, repository.ListWebhookDeliveriesParams{
+		SubscriptionID: subscriptionID,
+		Limit:          limit,
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to list webhook deliveries", "subscription_id", subscriptionID, "error", err)
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// Redeliver resets a delivery to pending with a fresh attempt budget so the
+// worker sends it again on its next poll.
+func (s *WebhookServiceImpl) Redeliver(ctx context.Context, subscriptionID, deliveryID int32) (*models.WebhookDelivery, error) {
+	if _, err := s.GetSubscription(ctx, subscriptionID); err != nil {
+		return nil, err
+	}
+
+	delivery, err := s.repo.RedeliverWebhookDelivery(ctx, repository.RedeliverWebhookDeliveryParams{
+		NextAttemptAt:  time.Now(),
+		ID:             deliveryID,
+		SubscriptionID: subscriptionID,
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to redeliver webhook", "subscription_id", subscriptionID, "delivery_id", deliveryID, "error", err)
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// callerTenant returns the tenant of the caller, nil for operators and
+// background work, which see every subscription.
+func callerTenant(ctx context.Context) *string {
+	if principal, ok := auth.PrincipalFromContext(ctx); ok {
+		return tenantID(principal.Tenant)
+	}
+	return nil
+}
+
+func validateWebhookURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidWebhookURL
+	}
+	return nil
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// nonNil keeps the NOT NULL event_types column from receiving a NULL array.
+func nonNil(eventTypes []string) []string {
+	if eventTypes == nil {
+		return []string{}
+	}
+	return eventTypes
+}
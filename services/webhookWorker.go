@@ -0,0 +1,448 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/models"
+	"github.com/doug-benn/go-server-starter/producer"
+	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/doug-benn/go-server-starter/sse"
+	"github.com/doug-benn/go-server-starter/utilities"
+	"github.com/jackc/pgx/v5"
+)
+
+// Headers sent with every webhook request.
+const (
+	WebhookIDHeader        = "X-Webhook-ID"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// ErrWebhookAddressNotAllowed is returned when a delivery would connect to an
+// internal address that is not in the allowed networks.
+var ErrWebhookAddressNotAllowed = errors.New("webhook address is not allowed")
+
+// WebhookWorkerConfig holds the delivery worker settings.
+type WebhookWorkerConfig struct {
+	PollInterval   time.Duration
+	BatchSize      int32
+	Concurrency    int
+	MaxAttempts    int32
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	RequestTimeout time.Duration
+	// LeaseDuration is how long a claimed delivery is hidden from other workers.
+	// It must comfortably exceed the time needed to send a batch.
+	LeaseDuration time.Duration
+	BufferSize    int
+	// AllowedNetworks are CIDRs deliveries may reach even though they are
+	// loopback, private or link-local, such as a receiver on the local host
+	// during development. Deliveries to any other internal address are refused.
+	AllowedNetworks []string
+}
+
+// DefaultWebhookWorkerConfig returns a configuration with sensible defaults
+func DefaultWebhookWorkerConfig() WebhookWorkerConfig {
+	var allowedNetworks []string
+	if cidrs := utilities.GetEnvOrDefault("WEBHOOK_ALLOWED_NETWORKS", ""); cidrs != "" {
+		allowedNetworks = strings.Split(cidrs, ",")
+	}
+
+	return WebhookWorkerConfig{
+		PollInterval:    5 * time.Second,
+		BatchSize:       20,
+		Concurrency:     4,
+		MaxAttempts:     8,
+		InitialBackoff:  30 * time.Second,
+		MaxBackoff:      time.Hour,
+		RequestTimeout:  10 * time.Second,
+		LeaseDuration:   2 * time.Minute,
+		BufferSize:      100,
+		AllowedNetworks: allowedNetworks,
+	}
+}
+
+// WebhookWorker turns producer events into webhook deliveries and sends them.
+// Deliveries are stored before they are sent, so retries survive restarts and
+// any replica can pick up due deliveries.
+type WebhookWorker struct {
+	repo   repository.Querier
+	client *http.Client
+	logger *slog.Logger
+	config WebhookWorkerConfig
+}
+
+func NewWebhookWorker(repo repository.Querier, logger *slog.Logger, config WebhookWorkerConfig) (*WebhookWorker, error) {
+	allowed, err := parseNetworks(config.AllowedNetworks)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: webhookControl(allowed)}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// Deliveries connect directly, so every address they reach is checked
+	transport.Proxy = nil
+
+	return &WebhookWorker{
+		repo:   repo,
+		client: &http.Client{Timeout: config.RequestTimeout, Transport: transport},
+		logger: logger,
+		config: config,
+	}, nil
+}
+
+// webhookControl refuses connections to loopback, private and link-local
+// addresses outside the allowed networks, which would let a subscription reach
+// internal services or cloud metadata endpoints. It runs for every address
+// dialled after name resolution, redirects included, so a hostname cannot be
+// pointed at an internal address once the subscription was validated.
+func webhookControl(allowed []netip.Prefix) func(network, address string, conn syscall.RawConn) error {
+	return func(network, address string, conn syscall.RawConn) error {
+		addrPort, err := netip.ParseAddrPort(address)
+		if err != nil {
+			return err
+		}
+		addr := addrPort.Addr().Unmap()
+		if slices.ContainsFunc(allowed, func(prefix netip.Prefix) bool { return prefix.Contains(addr) }) {
+			return nil
+		}
+		if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+			addr.IsMulticast() || addr.IsUnspecified() {
+			return fmt.Errorf("%w: %s", ErrWebhookAddressNotAllowed, addr)
+		}
+		return nil
+	}
+}
+
+// parseNetworks parses CIDRs such as 10.0.0.0/8, a bare address stands for
+// itself.
+func parseNetworks(cidrs []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(cidr); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook network %q", cidr)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+// Start enqueues deliveries for events from the producer and dispatches due
+// deliveries until ctx is cancelled.
+func (w *WebhookWorker) Start(ctx context.Context, events *producer.Producer[sse.Event]) {
+	subscription := events.Subscribe(w.config.BufferSize)
+
+	go w.dispatchLoop(ctx)
+
+	for {
+		select {
+		// The producer shares ctx and removes every subscription when it is
+		// cancelled, so the subscription is not closed here.
+		case <-ctx.Done():
+			return
+		case event, ok := <-subscription.Events():
+			if !ok {
+				return
+			}
+			if err := w.Enqueue(ctx, event); err != nil {
+				w.logger.ErrorContext(ctx, "failed to enqueue webhook deliveries", "type", event.Type, "error", err)
+			}
+		}
+	}
+}
+
+// Enqueue stores a pending delivery for every active subscription matching the
+// event type, in the tenant of the event or bound to no tenant. Untyped events,
+// such as raw database notifications, are ignored.
+func (w *WebhookWorker) Enqueue(ctx context.Context, event sse.Event) error {
+	if event.Type == "" {
+		return nil
+	}
+
+	payload, err := canonicalJSON(event.Data)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+	key := eventKey(event.Type, payload)
+
+	// Events without a tenant only reach operator subscriptions
+	var tenant *string
+	if scope, ok := TodoEventScope(event); ok {
+		tenant = &scope.TenantID
+	}
+	subscriptions, err := w.repo.ListActiveWebhookSubscriptions(ctx, tenant)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, subscription := range subscriptions {
+		if !MatchesEventType(subscription.EventTypes, event.Type) {
+			continue
+		}
+
+		err := w.repo.CreateWebhookDelivery(ctx, repository.CreateWebhookDeliveryParams{
+			SubscriptionID: subscription.ID,
+			EventKey:       key,
+			EventType:      event.Type,
+			Payload:        payload,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *WebhookWorker) dispatchLoop(ctx context.Context) {
+	ticker := time.NewTicker(w.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Keep draining while full batches are being claimed
+			for {
+				n, err := w.DispatchDue(ctx)
+				if err != nil {
+					if ctx.Err() == nil {
+						w.logger.ErrorContext(ctx, "failed to dispatch webhook deliveries", "error", err)
+					}
+					break
+				}
+				if n < int(w.config.BatchSize) {
+					break
+				}
+			}
+		}
+	}
+}
+
+// DispatchDue claims a batch of due deliveries, sends them and records the
+// outcome. It returns the number of deliveries claimed.
+func (w *WebhookWorker) DispatchDue(ctx context.Context) (int, error) {
+	now := time.Now()
+	deliveries, err := w.repo.ClaimDueWebhookDeliveries(ctx, repository.ClaimDueWebhookDeliveriesParams{
+		LeaseUntil: now.Add(w.config.LeaseDuration),
+		Now:        now,
+		BatchSize:  w.config.BatchSize,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	subscriptions := make(map[int32]*models.WebhookSubscription)
+	sem := make(chan struct{}, max(w.config.Concurrency, 1))
+	var wg sync.WaitGroup
+
+	for _, delivery := range deliveries {
+		subscription, ok := subscriptions[delivery.SubscriptionID]
+		if !ok {
+			s, err := w.repo.GetWebhookSubscription(ctx, repository.GetWebhookSubscriptionParams{ID: delivery.SubscriptionID})
+			if errors.Is(err, pgx.ErrNoRows) {
+				// Deleted subscriptions cascade to their deliveries
+				continue
+			}
+			if err != nil {
+				return len(deliveries), err
+			}
+			subscription = &s
+			subscriptions[delivery.SubscriptionID] = subscription
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(delivery models.WebhookDelivery) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			w.attempt(ctx, subscription, delivery)
+		}(delivery)
+	}
+	wg.Wait()
+
+	return len(deliveries), nil
+}
+
+// attempt sends a delivery once and schedules a retry, marks it succeeded or
+// moves it to the dead-letter state once the attempts are exhausted.
+func (w *WebhookWorker) attempt(ctx context.Context, subscription *models.WebhookSubscription, delivery models.WebhookDelivery) {
+	var statusCode int
+	var err error
+	if subscription.Active {
+		statusCode, err = w.send(ctx, subscription, delivery)
+	} else {
+		err = errors.New("subscription is disabled")
+	}
+
+	now := time.Now()
+	attempts := delivery.Attempts + 1
+	params := repository.RecordWebhookDeliveryAttemptParams{
+		Status:         WebhookStatusSucceeded,
+		LastStatusCode: int32(statusCode),
+		NextAttemptAt:  now,
+		UpdatedAt:      now,
+		ID:             delivery.ID,
+	}
+
+	if err != nil {
+		params.LastError = err.Error()
+		switch {
+		case !subscription.Active, attempts >= w.config.MaxAttempts:
+			params.Status = WebhookStatusDead
+			w.logger.WarnContext(ctx, "webhook delivery moved to dead letter",
+				"delivery_id", delivery.ID,
+				"subscription_id", subscription.ID,
+				"attempts", attempts,
+				"error", err,
+			)
+		default:
+			params.Status = WebhookStatusPending
+			params.NextAttemptAt = now.Add(w.backoff(attempts))
+		}
+	}
+
+	if err := w.repo.RecordWebhookDeliveryAttempt(ctx, params); err != nil {
+		w.logger.ErrorContext(ctx, "failed to record webhook delivery attempt", "delivery_id", delivery.ID, "error", err)
+	}
+}
+
+func (w *WebhookWorker) send(ctx context.Context, subscription *models.WebhookSubscription, delivery models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-server-starter-webhooks")
+	req.Header.Set(WebhookIDHeader, strconv.Itoa(int(delivery.ID)))
+	req.Header.Set(WebhookEventHeader, delivery.EventType)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(subscription.Secret, timestamp, delivery.Payload))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff doubles the delay after each failed attempt, up to MaxBackoff.
+func (w *WebhookWorker) backoff(attempts int32) time.Duration {
+	delay := w.config.InitialBackoff
+	for range attempts - 1 {
+		delay *= 2
+		if delay >= w.config.MaxBackoff {
+			return w.config.MaxBackoff
+		}
+	}
+	return delay
+}
+
+// SignWebhookPayload returns the signature header value for a payload: the
+// hex encoded HMAC-SHA256 of "<timestamp>.<payload>" keyed with the secret.
+func SignWebhookPayload(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature checks a received signature and rejects timestamps
+// older than tolerance, guarding receivers against replayed requests.
+func VerifyWebhookSignature(secret, timestamp, signature string, payload []byte, tolerance time.Duration) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := time.Since(time.Unix(ts, 0)); age > tolerance || age < -tolerance {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(SignWebhookPayload(secret, ts, payload)))
+}
+
+// MatchesEventType reports whether a subscription filter accepts an event type.
+// An empty filter accepts everything, "*" matches any type and "todo.*" matches
+// any type starting with "todo.".
+func MatchesEventType(filter []string, eventType string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(filter, func(pattern string) bool {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			return strings.HasPrefix(eventType, prefix)
+		}
+		return pattern == eventType
+	})
+}
+
+// canonicalJSON encodes data with sorted object keys, so the same event gives
+// the same bytes whether it was produced locally or relayed from another node.
+func canonicalJSON(data any) ([]byte, error) {
+	var raw []byte
+	switch d := data.(type) {
+	case json.RawMessage:
+		raw = d
+	case []byte:
+		raw = d
+	default:
+		b, err := json.Marshal(d)
+		if err != nil {
+			return nil, err
+		}
+		raw = b
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+func eventKey(eventType string, payload []byte) string {
+	sum := sha256.Sum256(append([]byte(eventType+"\n"), payload...))
+	return hex.EncodeToString(sum[:])
+}
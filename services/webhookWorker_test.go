@@ -0,0 +1,344 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/models"
+	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/doug-benn/go-server-starter/services"
+	"github.com/doug-benn/go-server-starter/sse"
+	"github.com/doug-benn/go-server-starter/testutils"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "whsec_test"
+
+// webhookHarness runs a worker against a single pending delivery and records
+// the attempt written back to the repository.
+type webhookHarness struct {
+	worker   *services.WebhookWorker
+	mu       sync.Mutex
+	recorded []repository.RecordWebhookDeliveryAttemptParams
+}
+
+func newWebhookHarness(t *testing.T, receiverURL string, delivery models.WebhookDelivery, config services.WebhookWorkerConfig) *webhookHarness {
+	t.Helper()
+	h := &webhookHarness{}
+	claimed := false
+	mockRepo := &testutils.MockQuerier{
+		ClaimDueWebhookDeliveriesFunc: func(ctx context.Context, arg repository.ClaimDueWebhookDeliveriesParams) ([]models.WebhookDelivery, error) {
+			if claimed {
+				return nil, nil
+			}
+			claimed = true
+			return []models.WebhookDelivery{delivery}, nil
+		},
+		GetWebhookSubscriptionFunc: func(ctx context.Context, arg repository.GetWebhookSubscriptionParams) (models.WebhookSubscription, error) {
+			return models.WebhookSubscription{ID: arg.ID, Url: receiverURL, Secret: testSecret, Active: true}, nil
+		},
+		RecordWebhookDeliveryAttemptFunc: func(ctx context.Context, arg repository.RecordWebhookDeliveryAttemptParams) error {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			h.recorded = append(h.recorded, arg)
+			return nil
+		},
+	}
+	h.worker = newWebhookWorker(t, mockRepo, config)
+	return h
+}
+
+func newWebhookWorker(t *testing.T, repo repository.Querier, config services.WebhookWorkerConfig) *services.WebhookWorker {
+	t.Helper()
+	worker, err := services.NewWebhookWorker(repo, slog.Default(), config)
+	require.NoError(t, err)
+	return worker
+}
+
+// testWebhookConfig lets deliveries reach receivers started by httptest.
+func testWebhookConfig() services.WebhookWorkerConfig {
+	config := services.DefaultWebhookWorkerConfig()
+	config.AllowedNetworks = []string{"127.0.0.1"}
+	return config
+}
+
+func testDelivery(attempts int32) models.WebhookDelivery {
+	return models.WebhookDelivery{
+		ID:             9,
+		SubscriptionID: 3,
+		EventType:      "todo.created",
+		Payload:        []byte(`{"todo_id":1}`),
+		Status:         services.WebhookStatusPending,
+		Attempts:       attempts,
+	}
+}
+
+func TestWebhookWorker_DeliversSignedPayload(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.JSONEq(t, `{"todo_id":1}`, string(body))
+		assert.True(t, services.VerifyWebhookSignature(testSecret,
+			r.Header.Get(services.WebhookTimestampHeader),
+			r.Header.Get(services.WebhookSignatureHeader),
+			body, 5*time.Minute), "signature should verify")
+		received <- r
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(receiver.Close)
+
+	h := newWebhookHarness(t, receiver.URL, testDelivery(0), testWebhookConfig())
+
+	n, err := h.worker.DispatchDue(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	r := <-received
+	assert.Equal(t, "9", r.Header.Get(services.WebhookIDHeader))
+	assert.Equal(t, "todo.created", r.Header.Get(services.WebhookEventHeader))
+
+	require.Len(t, h.recorded, 1)
+	assert.Equal(t, services.WebhookStatusSucceeded, h.recorded[0].Status)
+	assert.Equal(t, int32(http.StatusNoContent), h.recorded[0].LastStatusCode)
+	assert.Empty(t, h.recorded[0].LastError)
+}
+
+func TestWebhookWorker_RetriesWithExponentialBackoff(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(receiver.Close)
+
+	config := testWebhookConfig()
+	config.InitialBackoff = time.Minute
+
+	// Third failed attempt waits 1m * 2^2
+	h := newWebhookHarness(t, receiver.URL, testDelivery(2), config)
+
+	before := time.Now()
+	_, err := h.worker.DispatchDue(context.Background())
+	require.NoError(t, err)
+
+	require.Len(t, h.recorded, 1)
+	attempt := h.recorded[0]
+	assert.Equal(t, services.WebhookStatusPending, attempt.Status)
+	assert.Equal(t, int32(http.StatusBadGateway), attempt.LastStatusCode)
+	assert.Contains(t, attempt.LastError, "502")
+	assert.WithinDuration(t, before.Add(4*time.Minute), attempt.NextAttemptAt, 5*time.Second)
+}
+
+func TestWebhookWorker_DeadLetterAfterMaxAttempts(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(receiver.Close)
+
+	config := testWebhookConfig()
+	h := newWebhookHarness(t, receiver.URL, testDelivery(config.MaxAttempts-1), config)
+
+	_, err := h.worker.DispatchDue(context.Background())
+	require.NoError(t, err)
+
+	require.Len(t, h.recorded, 1)
+	assert.Equal(t, services.WebhookStatusDead, h.recorded[0].Status)
+}
+
+func TestWebhookWorker_RefusesInternalAddresses(t *testing.T) {
+	var hit atomic.Bool
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit.Store(true)
+	}))
+	t.Cleanup(receiver.Close)
+
+	// Without an allowlist the loopback receiver counts as an internal service,
+	// and a hostname resolving to it is refused just the same
+	config := services.DefaultWebhookWorkerConfig()
+	config.AllowedNetworks = nil
+	for _, url := range []string{receiver.URL, strings.Replace(receiver.URL, "127.0.0.1", "localhost", 1)} {
+		h := newWebhookHarness(t, url, testDelivery(0), config)
+		_, err := h.worker.DispatchDue(context.Background())
+		require.NoError(t, err)
+
+		require.Len(t, h.recorded, 1)
+		assert.Equal(t, services.WebhookStatusPending, h.recorded[0].Status)
+		assert.Contains(t, h.recorded[0].LastError, services.ErrWebhookAddressNotAllowed.Error())
+	}
+	assert.False(t, hit.Load(), "the receiver must not be reached")
+}
+
+func TestNewWebhookWorker_RejectsInvalidNetworks(t *testing.T) {
+	config := services.DefaultWebhookWorkerConfig()
+	config.AllowedNetworks = []string{"10.0.0.0/8", "not-a-network"}
+	_, err := services.NewWebhookWorker(&testutils.MockQuerier{}, slog.Default(), config)
+	assert.Error(t, err)
+}
+
+func TestWebhookWorker_EnqueueMatchesSubscriptions(t *testing.T) {
+	var created []repository.CreateWebhookDeliveryParams
+	mockRepo := &testutils.MockQuerier{
+		ListActiveWebhookSubscriptionsFunc: func(ctx context.Context, tenantID *string) ([]models.WebhookSubscription, error) {
+			if tenantID != nil {
+				t.Errorf("Expected an event without a tenant to reach operator subscriptions only, got %q", *tenantID)
+			}
+			return []models.WebhookSubscription{
+				{ID: 1, EventTypes: []string{}},
+				{ID: 2, EventTypes: []string{"todo.*"}},
+				{ID: 3, EventTypes: []string{"todo.deleted"}},
+			}, nil
+		},
+		CreateWebhookDeliveryFunc: func(ctx context.Context, arg repository.CreateWebhookDeliveryParams) error {
+			created = append(created, arg)
+			return nil
+		},
+	}
+	worker := newWebhookWorker(t, mockRepo, testWebhookConfig())
+
+	event := services.TodoEvent{Type: services.TodoCreated, SchemaVersion: 1, TodoID: 4, Actor: "system"}
+	require.NoError(t, worker.Enqueue(context.Background(), sse.Event{Type: string(event.Type), Data: event}))
+
+	require.Len(t, created, 2)
+	assert.Equal(t, int32(1), created[0].SubscriptionID)
+	assert.Equal(t, int32(2), created[1].SubscriptionID)
+
+	// The same event relayed from another node arrives as decoded JSON and
+	// must produce the same key so it is not delivered twice.
+	b, err := json.Marshal(event)
+	require.NoError(t, err)
+	var relayed map[string]any
+	require.NoError(t, json.Unmarshal(b, &relayed))
+	require.NoError(t, worker.Enqueue(context.Background(), sse.Event{Type: string(event.Type), Data: relayed}))
+
+	require.Len(t, created, 4)
+	assert.Equal(t, created[0].EventKey, created[2].EventKey)
+	assert.Equal(t, created[0].Payload, created[2].Payload)
+}
+
+func TestWebhookWorker_EnqueueScopesToTenant(t *testing.T) {
+	var tenant *string
+	mockRepo := &testutils.MockQuerier{
+		ListActiveWebhookSubscriptionsFunc: func(ctx context.Context, tenantID *string) ([]models.WebhookSubscription, error) {
+			tenant = tenantID
+			return nil, nil
+		},
+	}
+	worker := newWebhookWorker(t, mockRepo, testWebhookConfig())
+
+	todo := &models.Todo{ID: 4, OwnerID: 1, TenantID: "acme"}
+	event := services.TodoEvent{Type: services.TodoCreated, SchemaVersion: 1, TodoID: 4, After: todo}
+	require.NoError(t, worker.Enqueue(context.Background(), sse.Event{Type: string(event.Type), Data: event}))
+
+	require.NotNil(t, tenant)
+	assert.Equal(t, "acme", *tenant)
+}
+
+func TestWebhookWorker_EnqueueIgnoresUntypedEvents(t *testing.T) {
+	worker := newWebhookWorker(t, &testutils.MockQuerier{}, testWebhookConfig())
+	require.NoError(t, worker.Enqueue(context.Background(), sse.Event{Data: map[string]any{"table": "todos"}}))
+}
+
+func TestMatchesEventType(t *testing.T) {
+	tests := []struct {
+		filter []string
+		event  string
+		want   bool
+	}{
+		{nil, "todo.created", true},
+		{[]string{"*"}, "todo.created", true},
+		{[]string{"todo.*"}, "todo.created", true},
+		{[]string{"todo.*"}, "user.created", false},
+		{[]string{"todo.created"}, "todo.created", true},
+		{[]string{"todo.created"}, "todo.deleted", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, services.MatchesEventType(tt.filter, tt.event), "%v %s", tt.filter, tt.event)
+	}
+}
+
+func TestVerifyWebhookSignature_RejectsStaleAndTampered(t *testing.T) {
+	payload := []byte(`{"ok":true}`)
+	ts := time.Now().Add(-time.Hour).Unix()
+	sig := services.SignWebhookPayload(testSecret, ts, payload)
+
+	assert.False(t, services.VerifyWebhookSignature(testSecret, strconv.FormatInt(ts, 10), sig, payload, 5*time.Minute))
+
+	ts = time.Now().Unix()
+	sig = services.SignWebhookPayload(testSecret, ts, payload)
+	assert.True(t, services.VerifyWebhookSignature(testSecret, strconv.FormatInt(ts, 10), sig, payload, 5*time.Minute))
+	assert.False(t, services.VerifyWebhookSignature(testSecret, strconv.FormatInt(ts, 10), sig, []byte(`{"ok":false}`), 5*time.Minute))
+	assert.False(t, services.VerifyWebhookSignature("other", strconv.FormatInt(ts, 10), sig, payload, 5*time.Minute))
+}
+
+func TestWebhookService_CreateRejectsInvalidURL(t *testing.T) {
+	webhookService := services.NewWebhookService(&testutils.MockQuerier{}, slog.Default())
+
+	for _, rawURL := range []string{"", "ftp://example.com", "/relative", "http://"} {
+		_, err := webhookService.CreateSubscription(context.Background(), rawURL, nil)
+		assert.ErrorIs(t, err, services.ErrInvalidWebhookURL, rawURL)
+	}
+}
+
+func TestWebhookService_CreateGeneratesSecret(t *testing.T) {
+	mockRepo := &testutils.MockQuerier{
+		CreateWebhookSubscriptionFunc: func(ctx context.Context, arg repository.CreateWebhookSubscriptionParams) (models.WebhookSubscription, error) {
+			return models.WebhookSubscription{ID: 1, Url: arg.Url, Secret: arg.Secret, EventTypes: arg.EventTypes, Active: arg.Active}, nil
+		},
+	}
+	webhookService := services.NewWebhookService(mockRepo, slog.Default())
+
+	subscription, err := webhookService.CreateSubscription(context.Background(), "https://example.com/hook", nil)
+	require.NoError(t, err)
+	assert.Regexp(t, `^whsec_[0-9a-f]{64}$`, subscription.Secret)
+	assert.NotNil(t, subscription.EventTypes)
+
+	// The secret must never be serialised
+	b, err := json.Marshal(subscription)
+	require.NoError(t, err)
+	assert.NotContains(t, string(b), subscription.Secret)
+}
+
+func TestWebhookService_ScopedToCallerTenant(t *testing.T) {
+	var created, listed *string
+	mockRepo := &testutils.MockQuerier{
+		CreateWebhookSubscriptionFunc: func(ctx context.Context, arg repository.CreateWebhookSubscriptionParams) (models.WebhookSubscription, error) {
+			created = arg.TenantID
+			return models.WebhookSubscription{ID: 1, TenantID: arg.TenantID}, nil
+		},
+		ListWebhookSubscriptionsFunc: func(ctx context.Context, tenantID *string) ([]models.WebhookSubscription, error) {
+			listed = tenantID
+			return nil, nil
+		},
+		GetWebhookSubscriptionFunc: func(ctx context.Context, arg repository.GetWebhookSubscriptionParams) (models.WebhookSubscription, error) {
+			// Subscription 1 belongs to another tenant
+			return models.WebhookSubscription{}, pgx.ErrNoRows
+		},
+	}
+	webhookService := services.NewWebhookService(mockRepo, slog.Default())
+	ctx := auth.WithPrincipal(context.Background(), &auth.Principal{ID: "user:1", Scopes: []string{auth.ScopeAdmin}, Tenant: "acme"})
+
+	_, err := webhookService.CreateSubscription(ctx, "https://example.com/hook", []string{"*"})
+	require.NoError(t, err)
+	_, err = webhookService.ListSubscriptions(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, created)
+	require.NotNil(t, listed)
+	assert.Equal(t, "acme", *created)
+	assert.Equal(t, "acme", *listed)
+
+	// Deliveries of a subscription in another tenant are not found
+	_, err = webhookService.ListDeliveries(ctx, 1, 10)
+	assert.ErrorIs(t, err, pgx.ErrNoRows)
+}
@@ -0,0 +1,231 @@
+// Package session implements cookie based sessions for browser clients that
+// sign in with a password.
+package session
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/auth"
+	"github.com/doug-benn/go-server-starter/models"
+	"github.com/doug-benn/go-server-starter/utilities"
+)
+
+const (
+	// TokenPrefix identifies session tokens among other credentials.
+	TokenPrefix = "sess_"
+
+	DefaultCookieName     = "session"
+	DefaultCSRFCookieName = "csrf_token"
+	// CSRFHeader must echo the CSRF cookie on unsafe requests.
+	CSRFHeader = "X-CSRF-Token"
+
+	// touchInterval limits how often last_seen_at is written for a session.
+	touchInterval = time.Minute
+)
+
+// Config configures session lifetimes and cookies.
+type Config struct {
+	CookieName     string
+	CSRFCookieName string
+	// IdleTimeout ends sessions that are not used for this long.
+	IdleTimeout time.Duration
+	// AbsoluteTimeout ends sessions this long after login, however active.
+	AbsoluteTimeout time.Duration
+	// Secure restricts the cookies to HTTPS.
+	Secure   bool
+	SameSite http.SameSite
+}
+
+// DefaultConfig returns the configuration read from the environment.
+func DefaultConfig() Config {
+	return Config{
+		CookieName:      DefaultCookieName,
+		CSRFCookieName:  DefaultCSRFCookieName,
+		IdleTimeout:     30 * time.Minute,
+		AbsoluteTimeout: 12 * time.Hour,
+		// Only disabled for local development over plain HTTP
+		Secure:   utilities.GetEnvOrDefault("SESSION_COOKIE_SECURE", "true") != "false",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// Accounts resolves the principal a session acts as.
+type Accounts interface {
+	Principal(ctx context.Context, userID int32) (*auth.Principal, error)
+}
+
+// Manager starts and ends sessions and authenticates the session token sent in
+// the session cookie. Only a hash of the token is stored.
+type Manager struct {
+	store    Store
+	accounts Accounts
+	logger   *slog.Logger
+	config   Config
+	now      func() time.Time
+}
+
+func NewManager(store Store, accounts Accounts, logger *slog.Logger, config Config) *Manager {
+	return &Manager{store: store, accounts: accounts, logger: logger, config: config, now: time.Now}
+}
+
+// Login starts a session for the user and sets the session and CSRF cookies.
+// Any session the request already carried is ended, so a session token fixed
+// before login is never promoted.
+func (m *Manager) Login(ctx context.Context, w http.ResponseWriter, r *http.Request, userID int32) error {
+	if err := m.end(ctx, r); err != nil {
+		return err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	token := TokenPrefix + hex.EncodeToString(b)
+
+	now := m.now()
+	session := models.Session{
+		ID:         hashToken(token),
+		UserID:     userID,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(m.config.AbsoluteTimeout),
+	}
+	if err := m.store.Create(ctx, session); err != nil {
+		m.logger.ErrorContext(ctx, "failed to create session", "user_id", userID, "error", err)
+		return err
+	}
+
+	m.setCookies(w, token, CSRFToken(token), session.ExpiresAt)
+	return nil
+}
+
+// Logout ends the session the request carries and clears its cookies.
+func (m *Manager) Logout(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	if err := m.end(ctx, r); err != nil {
+		return err
+	}
+	m.setCookies(w, "", "", time.Unix(0, 0))
+	return nil
+}
+
+// Authenticate resolves the principal for a session token. Tokens of unknown,
+// idle or expired sessions are invalid, credentials that are not session
+// tokens are left to other authenticators.
+func (m *Manager) Authenticate(ctx context.Context, credential string) (*auth.Principal, error) {
+	if !strings.HasPrefix(credential, TokenPrefix) {
+		return nil, auth.ErrUnrecognized
+	}
+
+	id := hashToken(credential)
+	session, err := m.store.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, auth.ErrInvalidCredential
+	}
+	if err != nil {
+		m.logger.ErrorContext(ctx, "failed to get session", "error", err)
+		return nil, err
+	}
+
+	now := m.now()
+	if now.After(session.ExpiresAt) || now.Sub(session.LastSeenAt) > m.config.IdleTimeout {
+		if err := m.store.Delete(ctx, id); err != nil {
+			m.logger.WarnContext(ctx, "failed to delete expired session", "error", err)
+		}
+		return nil, auth.ErrInvalidCredential
+	}
+
+	if now.Sub(session.LastSeenAt) > touchInterval {
+		if err := m.store.Touch(ctx, id, now); err != nil {
+			m.logger.WarnContext(ctx, "failed to record session use", "error", err)
+		}
+	}
+
+	return m.accounts.Principal(ctx, session.UserID)
+}
+
+// PurgeExpired deletes expired and idle sessions every interval until ctx is
+// cancelled. Expired sessions are rejected either way, this only reclaims space.
+func (m *Manager) PurgeExpired(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := m.now()
+			n, err := m.store.DeleteExpired(ctx, now, now.Add(-m.config.IdleTimeout))
+			if err != nil {
+				m.logger.ErrorContext(ctx, "failed to purge expired sessions", "error", err)
+				continue
+			}
+			if n > 0 {
+				m.logger.InfoContext(ctx, "purged expired sessions", "count", n)
+			}
+		}
+	}
+}
+
+// end deletes the session the request carries, if any.
+func (m *Manager) end(ctx context.Context, r *http.Request) error {
+	cookie, err := r.Cookie(m.config.CookieName)
+	if err != nil || !strings.HasPrefix(cookie.Value, TokenPrefix) {
+		return nil
+	}
+	if err := m.store.Delete(ctx, hashToken(cookie.Value)); err != nil {
+		m.logger.ErrorContext(ctx, "failed to delete session", "error", err)
+		return err
+	}
+	return nil
+}
+
+func (m *Manager) setCookies(w http.ResponseWriter, token, csrfToken string, expires time.Time) {
+	maxAge := int(expires.Sub(m.now()).Seconds())
+	if token == "" {
+		maxAge = -1
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.config.CookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		MaxAge:   maxAge,
+		Secure:   m.config.Secure,
+		HttpOnly: true,
+		SameSite: m.config.SameSite,
+	})
+	// Readable by scripts so they can echo it in the CSRF header
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.config.CSRFCookieName,
+		Value:    csrfToken,
+		Path:     "/",
+		Expires:  expires,
+		MaxAge:   maxAge,
+		Secure:   m.config.Secure,
+		SameSite: m.config.SameSite,
+	})
+}
+
+// CSRFToken derives the CSRF token of a session. Binding it to the session
+// token means a CSRF cookie planted by another site or subdomain is rejected.
+func CSRFToken(sessionToken string) string {
+	mac := hmac.New(sha256.New, []byte(sessionToken))
+	mac.Write([]byte("csrf"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
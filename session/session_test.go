@@ -0,0 +1,148 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/auth"
+)
+
+var testAccounts = accountsFunc(func(ctx context.Context, userID int32) (*auth.Principal, error) {
+	return &auth.Principal{ID: "local:alice", Kind: auth.KindLocal}, nil
+})
+
+type accountsFunc func(ctx context.Context, userID int32) (*auth.Principal, error)
+
+func (f accountsFunc) Principal(ctx context.Context, userID int32) (*auth.Principal, error) {
+	return f(ctx, userID)
+}
+
+func newTestManager(store Store) (*Manager, *time.Time) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	m := NewManager(store, testAccounts, slog.Default(), DefaultConfig())
+	m.now = func() time.Time { return now }
+	return m, &now
+}
+
+// login starts a session and returns the cookies the response set.
+func login(t *testing.T, m *Manager, r *http.Request) map[string]*http.Cookie {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	if err := m.Login(context.Background(), rr, r, 1); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	cookies := make(map[string]*http.Cookie)
+	for _, cookie := range rr.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	return cookies
+}
+
+func TestManager_Login(t *testing.T) {
+	m, _ := newTestManager(NewMemoryStore())
+	cookies := login(t, m, httptest.NewRequest("POST", "/login", nil))
+
+	sessionCookie := cookies[DefaultCookieName]
+	if sessionCookie == nil || !sessionCookie.HttpOnly || !sessionCookie.Secure || sessionCookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("expected a secure HttpOnly SameSite session cookie, got %+v", sessionCookie)
+	}
+	csrfCookie := cookies[DefaultCSRFCookieName]
+	if csrfCookie == nil || csrfCookie.HttpOnly || csrfCookie.Value != CSRFToken(sessionCookie.Value) {
+		t.Fatalf("expected a script readable CSRF cookie bound to the session, got %+v", csrfCookie)
+	}
+
+	principal, err := m.Authenticate(context.Background(), sessionCookie.Value)
+	if err != nil || principal.ID != "local:alice" {
+		t.Errorf("expected the session to authenticate, got %+v, %v", principal, err)
+	}
+	if _, err := m.Authenticate(context.Background(), "gss_api_key"); !errors.Is(err, auth.ErrUnrecognized) {
+		t.Errorf("expected other credentials to be unrecognized, got %v", err)
+	}
+}
+
+func TestManager_LoginRotatesSession(t *testing.T) {
+	m, _ := newTestManager(NewMemoryStore())
+	first := login(t, m, httptest.NewRequest("POST", "/login", nil))[DefaultCookieName]
+
+	r := httptest.NewRequest("POST", "/login", nil)
+	r.AddCookie(first)
+	second := login(t, m, r)[DefaultCookieName]
+
+	if second.Value == first.Value {
+		t.Fatal("expected a new session token on login")
+	}
+	if _, err := m.Authenticate(context.Background(), first.Value); !errors.Is(err, auth.ErrInvalidCredential) {
+		t.Errorf("expected the previous session to be ended, got %v", err)
+	}
+}
+
+func TestManager_Timeouts(t *testing.T) {
+	store := NewMemoryStore()
+	m, now := newTestManager(store)
+	token := login(t, m, httptest.NewRequest("POST", "/login", nil))[DefaultCookieName].Value
+
+	// Activity within the idle timeout keeps the session alive
+	for range 3 {
+		*now = now.Add(m.config.IdleTimeout - time.Minute)
+		if _, err := m.Authenticate(context.Background(), token); err != nil {
+			t.Fatalf("expected an active session to stay valid, got %v", err)
+		}
+	}
+
+	*now = now.Add(m.config.IdleTimeout + time.Minute)
+	if _, err := m.Authenticate(context.Background(), token); !errors.Is(err, auth.ErrInvalidCredential) {
+		t.Errorf("expected an idle session to expire, got %v", err)
+	}
+	if _, err := store.Get(context.Background(), hashToken(token)); !errors.Is(err, ErrNotFound) {
+		t.Error("expected the idle session to be deleted")
+	}
+
+	token = login(t, m, httptest.NewRequest("POST", "/login", nil))[DefaultCookieName].Value
+	for elapsed := time.Duration(0); elapsed < m.config.AbsoluteTimeout; elapsed += 20 * time.Minute {
+		*now = now.Add(20 * time.Minute)
+		m.Authenticate(context.Background(), token)
+	}
+	*now = now.Add(time.Minute)
+	if _, err := m.Authenticate(context.Background(), token); !errors.Is(err, auth.ErrInvalidCredential) {
+		t.Errorf("expected the session to expire after the absolute timeout, got %v", err)
+	}
+}
+
+func TestManager_Logout(t *testing.T) {
+	m, _ := newTestManager(NewMemoryStore())
+	sessionCookie := login(t, m, httptest.NewRequest("POST", "/login", nil))[DefaultCookieName]
+
+	r := httptest.NewRequest("POST", "/logout", nil)
+	r.AddCookie(sessionCookie)
+	rr := httptest.NewRecorder()
+	if err := m.Logout(context.Background(), rr, r); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.MaxAge >= 0 || cookie.Value != "" {
+			t.Errorf("expected cookie %s to be cleared, got %+v", cookie.Name, cookie)
+		}
+	}
+	if _, err := m.Authenticate(context.Background(), sessionCookie.Value); !errors.Is(err, auth.ErrInvalidCredential) {
+		t.Errorf("expected the session to be ended, got %v", err)
+	}
+}
+
+func TestMemoryStore_DeleteExpired(t *testing.T) {
+	store := NewMemoryStore()
+	m, now := newTestManager(store)
+	login(t, m, httptest.NewRequest("POST", "/login", nil))
+	*now = now.Add(time.Hour)
+	login(t, m, httptest.NewRequest("POST", "/login", nil))
+
+	n, err := store.DeleteExpired(context.Background(), *now, now.Add(-m.config.IdleTimeout))
+	if err != nil || n != 1 {
+		t.Errorf("expected the idle session to be purged, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,128 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/doug-benn/go-server-starter/models"
+	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrNotFound is returned by a Store when there is no session with the id.
+var ErrNotFound = errors.New("session not found")
+
+// Store persists sessions by the hash of their token.
+type Store interface {
+	Create(ctx context.Context, session models.Session) error
+	Get(ctx context.Context, id string) (*models.Session, error)
+	Touch(ctx context.Context, id string, lastSeenAt time.Time) error
+	Delete(ctx context.Context, id string) error
+	// DeleteExpired removes sessions past their absolute expiry or idle since
+	// idleSince, returning how many were removed.
+	DeleteExpired(ctx context.Context, now, idleSince time.Time) (int64, error)
+}
+
+// PostgresStore keeps sessions in the sessions table, shared by every replica.
+type PostgresStore struct {
+	repo repository.Querier
+}
+
+func NewPostgresStore(repo repository.Querier) *PostgresStore {
+	return &PostgresStore{repo: repo}
+}
+
+func (s *PostgresStore) Create(ctx context.Context, session models.Session) error {
+	return s.repo.CreateSession(ctx, repository.CreateSessionParams{
+		ID:         session.ID,
+		UserID:     session.UserID,
+		CreatedAt:  session.CreatedAt,
+		LastSeenAt: session.LastSeenAt,
+		ExpiresAt:  session.ExpiresAt,
+	})
+}
+
+func (s *PostgresStore) Get(ctx context.Context, id string) (*models.Session, error) {
+	session, err := s.repo.GetSession(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *PostgresStore) Touch(ctx context.Context, id string, lastSeenAt time.Time) error {
+	return s.repo.TouchSession(ctx, repository.TouchSessionParams{LastSeenAt: lastSeenAt, ID: id})
+}
+
+func (s *PostgresStore) Delete(ctx context.Context, id string) error {
+	return s.repo.DeleteSession(ctx, id)
+}
+
+func (s *PostgresStore) DeleteExpired(ctx context.Context, now, idleSince time.Time) (int64, error) {
+	return s.repo.DeleteExpiredSessions(ctx, repository.DeleteExpiredSessionsParams{
+		ExpiresAt:  now,
+		LastSeenAt: idleSince,
+	})
+}
+
+// MemoryStore keeps sessions in process, for tests and single node development.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]models.Session
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[string]models.Session)}
+}
+
+func (s *MemoryStore) Create(ctx context.Context, session models.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[session.ID] = session
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, id string) (*models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &session, nil
+}
+
+func (s *MemoryStore) Touch(ctx context.Context, id string, lastSeenAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.sessions[id]; ok {
+		session.LastSeenAt = lastSeenAt
+		s.sessions[id] = session
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *MemoryStore) DeleteExpired(ctx context.Context, now, idleSince time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for id, session := range s.sessions {
+		if session.ExpiresAt.Before(now) || session.LastSeenAt.Before(idleSince) {
+			delete(s.sessions, id)
+			n++
+		}
+	}
+	return n, nil
+}
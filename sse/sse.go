@@ -2,8 +2,10 @@
 package sse
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -12,8 +14,33 @@ import (
 	"github.com/doug-benn/go-server-starter/producer"
 )
 
-// WriteTimeout is the timeout for writing to the client.
-const WriteTimeout = 5 * time.Second
+const (
+	// DefaultWriteTimeout is the timeout for writing to the client.
+	DefaultWriteTimeout = 5 * time.Second
+	// DefaultKeepaliveInterval is how often a keepalive is sent on an idle stream.
+	DefaultKeepaliveInterval = 25 * time.Second
+	// DefaultBufferSize is the subscription buffer size for each connection.
+	DefaultBufferSize = 100
+)
+
+var (
+	// ErrConnectionExpired is passed to the OnDisconnect hook when a stream is
+	// closed because it reached its maximum lifetime.
+	ErrConnectionExpired = errors.New("sse: connection reached max lifetime")
+	// ErrProducerClosed is passed to the OnDisconnect hook when the producer
+	// shuts down and closes the subscription.
+	ErrProducerClosed = errors.New("sse: producer closed")
+)
+
+// KeepaliveFormat controls how keepalives are written to the stream.
+type KeepaliveFormat int
+
+const (
+	// KeepaliveEvent sends a named `keepalive` event carrying a timestamp.
+	KeepaliveEvent KeepaliveFormat = iota
+	// KeepaliveComment sends a `:` comment line, which EventSource ignores.
+	KeepaliveComment
+)
 
 // Event represents an SSE event
 // Data can be:
@@ -27,97 +54,258 @@ type Event struct {
 	Retry int
 }
 
-// SSEHandler creates an HTTP handler that serves Server-Sent Events using the producer
-func SSEHandler(producer *producer.Producer[Event], logger *slog.Logger) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Set SSE headers
-		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Cache-Control", "no-cache")
-		w.Header().Set("Connection", "keep-alive")
+// SSEHandler is an http.Handler that serves Server-Sent Events from a producer.
+type SSEHandler struct {
+	producer          *producer.Producer[Event]
+	logger            *slog.Logger
+	keepaliveInterval time.Duration
+	keepaliveFormat   KeepaliveFormat
+	maxLifetime       time.Duration // zero means connections never expire.
+	reconnectDelay    time.Duration // retry hint sent with the reconnect event.
+	bufferSize        int
+	writeTimeout      time.Duration
+	onConnect         func(r *http.Request) error
+	onDisconnect      func(r *http.Request, err error)
+	onEvent           func(r *http.Request, event Event) bool
+}
+
+type SSEHandlerOpt func(*SSEHandler)
+
+// WithKeepalive sets the keepalive interval and format.
+func WithKeepalive(interval time.Duration, format KeepaliveFormat) SSEHandlerOpt {
+	return func(h *SSEHandler) {
+		if interval > 0 {
+			h.keepaliveInterval = interval
+		}
+		h.keepaliveFormat = format
+	}
+}
+
+// WithMaxLifetime closes each stream after the given duration with a
+// `reconnect` event, forcing the client to reconnect. This spreads long-lived
+// connections across replicas after a deploy or scale-out. The optional retry
+// delay is sent as the event's retry field.
+func WithMaxLifetime(lifetime, retry time.Duration) SSEHandlerOpt {
+	return func(h *SSEHandler) {
+		h.maxLifetime = lifetime
+		h.reconnectDelay = retry
+	}
+}
+
+// WithBufferSize sets the producer subscription buffer size for each connection.
+func WithBufferSize(size int) SSEHandlerOpt {
+	return func(h *SSEHandler) {
+		if size >= 0 {
+			h.bufferSize = size
+		}
+	}
+}
+
+// WithWriteTimeout sets the deadline applied to every write to the client.
+func WithWriteTimeout(timeout time.Duration) SSEHandlerOpt {
+	return func(h *SSEHandler) {
+		if timeout > 0 {
+			h.writeTimeout = timeout
+		}
+	}
+}
+
+// OnConnect registers a hook run before the stream is opened. Returning an
+// error rejects the connection with 403 Forbidden.
+func OnConnect(fn func(r *http.Request) error) SSEHandlerOpt {
+	return func(h *SSEHandler) {
+		h.onConnect = fn
+	}
+}
 
-		rc := http.NewResponseController(w)
+// OnDisconnect registers a hook run when the stream ends, with the reason it ended.
+func OnDisconnect(fn func(r *http.Request, err error)) SSEHandlerOpt {
+	return func(h *SSEHandler) {
+		h.onDisconnect = fn
+	}
+}
+
+// OnEvent registers a hook run before each event is written. Returning false
+// skips the event for this connection.
+func OnEvent(fn func(r *http.Request, event Event) bool) SSEHandlerOpt {
+	return func(h *SSEHandler) {
+		h.onEvent = fn
+	}
+}
 
-		// Subscribe to the producer with a reasonable buffer size
-		// Adjust buffer size based on your expected event rate
-		subscription := producer.Subscribe(100)
+// NewSSEHandler creates an SSEHandler for the given producer.
+func NewSSEHandler(producer *producer.Producer[Event], logger *slog.Logger, opts ...SSEHandlerOpt) *SSEHandler {
+	h := &SSEHandler{
+		producer:          producer,
+		logger:            logger,
+		keepaliveInterval: DefaultKeepaliveInterval,
+		keepaliveFormat:   KeepaliveEvent,
+		bufferSize:        DefaultBufferSize,
+		writeTimeout:      DefaultWriteTimeout,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
 
-		// Send initial connection message
-		if _, err := fmt.Fprintf(w, "event: connected\ndata: {\"timestamp\":\"%s\"}\n\n", time.Now().Format(time.RFC3339)); err != nil {
-			logger.Error("failed to write connected message", "error", err)
+func (h *SSEHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.onConnect != nil {
+		if err := h.onConnect(r); err != nil {
+			h.logger.Warn("sse connection rejected", "error", err)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 			return
 		}
-		rc.Flush()
+	}
 
-		// Create context that cancels when client disconnects
-		ctx, cancel := context.WithCancel(r.Context())
-		defer cancel()
+	// Set SSE headers
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	rc := http.NewResponseController(w)
+
+	subscription := h.producer.Subscribe(h.bufferSize)
+
+	err := h.stream(w, r, rc, subscription)
+	if h.onDisconnect != nil {
+		h.onDisconnect(r, err)
+	}
+}
 
-		keepalive := time.NewTicker(25 * time.Second)
-		defer keepalive.Stop()
+// stream writes events to the client until the connection ends, returning the reason.
+func (h *SSEHandler) stream(w http.ResponseWriter, r *http.Request, rc *http.ResponseController, subscription *producer.Subscription[Event]) error {
+	// Create context that cancels when client disconnects
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
 
-		// Listen for events and send them to the client
-		for {
-			select {
-			case <-ctx.Done():
+	// Send initial connection message
+	if err := h.write(w, rc, timestampEvent("connected")); err != nil {
+		h.logger.Error("failed to write connected message", "error", err)
+		subscription.Close()
+		return err
+	}
+
+	keepalive := time.NewTicker(h.keepaliveInterval)
+	defer keepalive.Stop()
+
+	var expired <-chan time.Time
+	if h.maxLifetime > 0 {
+		timer := time.NewTimer(h.maxLifetime)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	// Listen for events and send them to the client
+	for {
+		select {
+		case <-ctx.Done():
+			subscription.Close()
+			return ctx.Err()
+		case <-expired:
+			subscription.Close()
+			reconnect := timestampEvent("reconnect")
+			reconnect.Retry = int(h.reconnectDelay.Milliseconds())
+			if err := h.write(w, rc, reconnect); err != nil {
+				return err
+			}
+			return ErrConnectionExpired
+		case <-keepalive.C:
+			if err := h.writeKeepalive(w, rc); err != nil {
+				h.logger.Debug("failed to write keepalive", "error", err)
+				subscription.Close()
+				return err
+			}
+		case event, ok := <-subscription.Events():
+			if !ok {
+				return ErrProducerClosed
+			}
+
+			if h.onEvent != nil && !h.onEvent(r, event) {
+				continue
+			}
+
+			if err := h.write(w, rc, event); err != nil {
+				h.logger.Error("failed to write event", "error", err)
 				subscription.Close()
-				return
-			case <-keepalive.C:
-				fmt.Fprintf(w, "event: keepalive\ndata: {\"timestamp\":\"%s\"}\n\n", time.Now().Format(time.RFC3339))
-				rc.Flush()
-			case event, ok := <-subscription.Events():
-				if !ok {
-					return
-				}
-
-				if err := rc.SetWriteDeadline(time.Now().Add(WriteTimeout)); err != nil {
-					logger.Warn("write deadline not supported by underlying writer")
-				}
-
-				// Write optional fields
-				if event.ID > 0 {
-					w.Write(fmt.Appendf(nil, "id: %d\n", event.ID))
-				}
-				if event.Retry > 0 {
-					w.Write(fmt.Appendf(nil, "retry: %d\n", event.Retry))
-				}
-
-				if event.Type != "" && event.Type != "message" {
-					// `message` is the default, so no need to transmit it.
-					w.Write([]byte("event: " + event.Type + "\n"))
-				}
-
-				// Write the message data.
-				w.Write([]byte("data: "))
-
-				// Handle different data types
-				switch data := event.Data.(type) {
-				case json.RawMessage: // Already valid JSON bytes - write directly
-					w.Write(data)
-				case []byte: // Treat as JSON RawMessage
-					w.Write(data)
-				case string:
-					b, err := json.Marshal(data)
-					if err != nil {
-						logger.Error("failed to encode string data", "error", err)
-						return
-					}
-					w.Write(b)
-				default:
-					b, err := json.Marshal(data)
-					if err != nil {
-						logger.Error("failed to encode data", "error", err)
-						return
-					}
-					w.Write(b)
-				}
-
-				w.Write([]byte("\n\n"))
-
-				if err := rc.Flush(); err != nil {
-					logger.Error("unable to flush", "error", err)
-					return
-				}
+				return err
 			}
 		}
 	}
 }
+
+func (h *SSEHandler) writeKeepalive(w http.ResponseWriter, rc *http.ResponseController) error {
+	if h.keepaliveFormat == KeepaliveComment {
+		h.setWriteDeadline(rc)
+		if _, err := w.Write([]byte(": keepalive\n\n")); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	return h.write(w, rc, timestampEvent("keepalive"))
+}
+
+// write encodes a single event, writes it under the write timeout and flushes it.
+func (h *SSEHandler) write(w http.ResponseWriter, rc *http.ResponseController, event Event) error {
+	b, err := encodeEvent(event)
+	if err != nil {
+		return err
+	}
+
+	h.setWriteDeadline(rc)
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
+
+func (h *SSEHandler) setWriteDeadline(rc *http.ResponseController) {
+	if err := rc.SetWriteDeadline(time.Now().Add(h.writeTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		h.logger.Warn("failed to set write deadline", "error", err)
+	}
+}
+
+// encodeEvent renders an event in the text/event-stream wire format.
+func encodeEvent(event Event) ([]byte, error) {
+	var buf bytes.Buffer
+
+	// Write optional fields
+	if event.ID > 0 {
+		fmt.Fprintf(&buf, "id: %d\n", event.ID)
+	}
+	if event.Retry > 0 {
+		fmt.Fprintf(&buf, "retry: %d\n", event.Retry)
+	}
+
+	if event.Type != "" && event.Type != "message" {
+		// `message` is the default, so no need to transmit it.
+		buf.WriteString("event: " + event.Type + "\n")
+	}
+
+	// Write the message data.
+	buf.WriteString("data: ")
+
+	// Handle different data types
+	switch data := event.Data.(type) {
+	case json.RawMessage: // Already valid JSON bytes - write directly
+		buf.Write(data)
+	case []byte: // Treat as JSON RawMessage
+		buf.Write(data)
+	default:
+		b, err := json.Marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode data: %w", err)
+		}
+		buf.Write(b)
+	}
+
+	buf.WriteString("\n\n")
+	return buf.Bytes(), nil
+}
+
+func timestampEvent(eventType string) Event {
+	return Event{
+		Type: eventType,
+		Data: json.RawMessage(fmt.Sprintf(`{"timestamp":"%s"}`, time.Now().Format(time.RFC3339))),
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"log/slog"
 	"net/http"
@@ -24,7 +25,7 @@ type testSSEHarness struct {
 	resp     *http.Response
 }
 
-func setupSSETest(t *testing.T) *testSSEHarness {
+func setupSSETest(t *testing.T, opts ...SSEHandlerOpt) *testSSEHarness {
 	t.Helper()
 
 	pCtx, pCancel := context.WithCancel(context.Background())
@@ -35,7 +36,7 @@ func setupSSETest(t *testing.T) *testSSEHarness {
 	)
 	go p.Start(pCtx)
 
-	handler := SSEHandler(p, slog.Default())
+	handler := NewSSEHandler(p, slog.Default(), opts...)
 	server := httptest.NewServer(handler)
 	t.Cleanup(server.Close)
 
@@ -285,3 +286,91 @@ func TestSSEHandler_StreamingLineFormat(t *testing.T) {
 	assert.Equal(t, `data: {"ok":true}`, lines[msgIdx+2])
 	assert.Empty(t, lines[msgIdx+3])
 }
+
+func TestSSEHandler_KeepaliveComment(t *testing.T) {
+	h := setupSSETest(t, WithKeepalive(20*time.Millisecond, KeepaliveComment))
+
+	time.Sleep(70 * time.Millisecond)
+	body := readBodyAfterStop(t, h)
+
+	assert.Contains(t, body, ": keepalive\n\n")
+	assert.NotContains(t, body, "event: keepalive")
+}
+
+func TestSSEHandler_KeepaliveEvent(t *testing.T) {
+	h := setupSSETest(t, WithKeepalive(20*time.Millisecond, KeepaliveEvent))
+
+	time.Sleep(70 * time.Millisecond)
+	body := readBodyAfterStop(t, h)
+
+	assert.Contains(t, body, "event: keepalive")
+}
+
+func TestSSEHandler_MaxLifetimeForcesReconnect(t *testing.T) {
+	var disconnectErr error
+	disconnected := make(chan struct{})
+
+	h := setupSSETest(t,
+		WithMaxLifetime(50*time.Millisecond, 2*time.Second),
+		OnDisconnect(func(_ *http.Request, err error) {
+			disconnectErr = err
+			close(disconnected)
+		}),
+	)
+
+	// The server ends the response on its own, so the body can be read to EOF.
+	body, err := io.ReadAll(h.resp.Body)
+	require.NoError(t, err)
+
+	assert.Contains(t, string(body), "retry: 2000\nevent: reconnect\n")
+
+	select {
+	case <-disconnected:
+		assert.ErrorIs(t, disconnectErr, ErrConnectionExpired)
+	case <-time.After(time.Second):
+		t.Fatal("OnDisconnect was not called")
+	}
+}
+
+func TestSSEHandler_OnConnectRejects(t *testing.T) {
+	p := producer.NewProducer[Event]()
+	handler := NewSSEHandler(p, slog.Default(), OnConnect(func(r *http.Request) error {
+		return errors.New("not allowed")
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/events", nil))
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.NotEqual(t, "text/event-stream", rr.Header().Get("Content-Type"))
+}
+
+func TestSSEHandler_OnEventFilters(t *testing.T) {
+	h := setupSSETest(t, OnEvent(func(_ *http.Request, event Event) bool {
+		return event.Type != "secret"
+	}))
+
+	h.producer.Broadcast(context.Background(), Event{Type: "secret", Data: json.RawMessage(`{}`)})
+	h.producer.Broadcast(context.Background(), Event{Type: "public", Data: json.RawMessage(`{}`)})
+
+	body := readBodyAfterStop(t, h)
+
+	assert.NotContains(t, body, "event: secret")
+	assert.Contains(t, body, "event: public")
+}
+
+func TestSSEHandler_OnDisconnectProducerClosed(t *testing.T) {
+	disconnected := make(chan error, 1)
+	h := setupSSETest(t, OnDisconnect(func(_ *http.Request, err error) {
+		disconnected <- err
+	}))
+
+	_ = readBodyAfterStop(t, h)
+
+	select {
+	case err := <-disconnected:
+		assert.ErrorIs(t, err, ErrProducerClosed)
+	case <-time.After(time.Second):
+		t.Fatal("OnDisconnect was not called")
+	}
+}
@@ -2,42 +2,300 @@ package testutils
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	"github.com/doug-benn/go-server-starter/models"
 	"github.com/doug-benn/go-server-starter/repository"
+	"github.com/doug-benn/go-server-starter/sse"
 )
 
 type MockQuerier struct {
-	CreateTodoFunc   func(ctx context.Context, arg repository.CreateTodoParams) (models.Todo, error)
-	GetTodoFunc      func(ctx context.Context, id int32) (models.Todo, error)
-	ListTodosFunc    func(ctx context.Context) ([]models.Todo, error)
-	UpdateTodoFunc   func(ctx context.Context, arg repository.UpdateTodoParams) (models.Todo, error)
-	DeleteTodoFunc   func(ctx context.Context, id int32) error
-	CompleteTodoFunc func(ctx context.Context, arg repository.CompleteTodoParams) (models.Todo, error)
+	CreateTodoFunc                     func(ctx context.Context, arg repository.CreateTodoParams) (models.Todo, error)
+	GetTodoFunc                        func(ctx context.Context, arg repository.GetTodoParams) (models.Todo, error)
+	ListTodosFunc                      func(ctx context.Context, arg repository.ListTodosParams) ([]models.Todo, error)
+	UpdateTodoFunc                     func(ctx context.Context, arg repository.UpdateTodoParams) (repository.UpdateTodoRow, error)
+	DeleteTodoFunc                     func(ctx context.Context, arg repository.DeleteTodoParams) (models.Todo, error)
+	CompleteTodoFunc                   func(ctx context.Context, arg repository.CompleteTodoParams) (repository.CompleteTodoRow, error)
+	ClaimDueWebhookDeliveriesFunc      func(ctx context.Context, arg repository.ClaimDueWebhookDeliveriesParams) ([]models.WebhookDelivery, error)
+	CreateWebhookDeliveryFunc          func(ctx context.Context, arg repository.CreateWebhookDeliveryParams) error
+	CreateWebhookSubscriptionFunc      func(ctx context.Context, arg repository.CreateWebhookSubscriptionParams) (models.WebhookSubscription, error)
+	DeleteWebhookSubscriptionFunc      func(ctx context.Context, arg repository.DeleteWebhookSubscriptionParams) (int64, error)
+	GetWebhookDeliveryFunc             func(ctx context.Context, id int32) (models.WebhookDelivery, error)
+	GetWebhookSubscriptionFunc         func(ctx context.Context, arg repository.GetWebhookSubscriptionParams) (models.WebhookSubscription, error)
+	ListActiveWebhookSubscriptionsFunc func(ctx context.Context, tenantID *string) ([]models.WebhookSubscription, error)
+	ListWebhookDeliveriesFunc          func(ctx context.Context, arg repository.ListWebhookDeliveriesParams) ([]models.WebhookDelivery, error)
+	ListWebhookSubscriptionsFunc       func(ctx context.Context, tenantID *string) ([]models.WebhookSubscription, error)
+	RecordWebhookDeliveryAttemptFunc   func(ctx context.Context, arg repository.RecordWebhookDeliveryAttemptParams) error
+	RedeliverWebhookDeliveryFunc       func(ctx context.Context, arg repository.RedeliverWebhookDeliveryParams) (models.WebhookDelivery, error)
+	UpdateWebhookSubscriptionFunc      func(ctx context.Context, arg repository.UpdateWebhookSubscriptionParams) (models.WebhookSubscription, error)
+	CreateApiKeyFunc                   func(ctx context.Context, arg repository.CreateApiKeyParams) (models.ApiKey, error)
+	GetApiKeyByHashFunc                func(ctx context.Context, keyHash string) (models.ApiKey, error)
+	ListApiKeysFunc                    func(ctx context.Context, tenantID *string) ([]models.ApiKey, error)
+	RevokeApiKeyFunc                   func(ctx context.Context, arg repository.RevokeApiKeyParams) (int64, error)
+	TouchApiKeyFunc                    func(ctx context.Context, arg repository.TouchApiKeyParams) error
+	UpsertUserFunc                     func(ctx context.Context, arg repository.UpsertUserParams) (models.User, error)
+	CreateTenantFunc                   func(ctx context.Context, arg repository.CreateTenantParams) (models.Tenant, error)
+	GetTenantFunc                      func(ctx context.Context, id string) (models.Tenant, error)
+	ListTenantsFunc                    func(ctx context.Context) ([]models.Tenant, error)
+	CreateLocalUserFunc                func(ctx context.Context, arg repository.CreateLocalUserParams) (models.User, error)
+	CreateSessionFunc                  func(ctx context.Context, arg repository.CreateSessionParams) error
+	DeleteExpiredSessionsFunc          func(ctx context.Context, arg repository.DeleteExpiredSessionsParams) (int64, error)
+	DeleteSessionFunc                  func(ctx context.Context, id string) error
+	GetSessionFunc                     func(ctx context.Context, id string) (models.Session, error)
+	GetUserFunc                        func(ctx context.Context, id int32) (models.User, error)
+	GetUserByUsernameFunc              func(ctx context.Context, username *string) (models.User, error)
+	TouchSessionFunc                   func(ctx context.Context, arg repository.TouchSessionParams) error
+	DeleteFullRateLimitsFunc           func(ctx context.Context, tat int64) (int64, error)
+	TakeRateLimitFunc                  func(ctx context.Context, arg repository.TakeRateLimitParams) (repository.TakeRateLimitRow, error)
+	ClaimIdempotencyKeyFunc            func(ctx context.Context, arg repository.ClaimIdempotencyKeyParams) (int64, error)
+	CompleteIdempotencyKeyFunc         func(ctx context.Context, arg repository.CompleteIdempotencyKeyParams) error
+	DeleteExpiredIdempotencyKeysFunc   func(ctx context.Context, expiresAt time.Time) (int64, error)
+	GetIdempotencyKeyFunc              func(ctx context.Context, key string) (models.IdempotencyKey, error)
+	ReleaseIdempotencyKeyFunc          func(ctx context.Context, key string) error
+	DeleteCacheEntryFunc               func(ctx context.Context, key string) error
+	DeleteExpiredCacheEntriesFunc      func(ctx context.Context, expiresAt time.Time) (int64, error)
+	GetCacheEntryFunc                  func(ctx context.Context, arg repository.GetCacheEntryParams) ([]byte, error)
+	IncrementCacheEntryFunc            func(ctx context.Context, arg repository.IncrementCacheEntryParams) ([]byte, error)
+	SetCacheEntryFunc                  func(ctx context.Context, arg repository.SetCacheEntryParams) error
+	SearchTodosFunc                    func(ctx context.Context, arg repository.SearchTodosParams) ([]repository.SearchTodosRow, error)
 }
 
 func (m *MockQuerier) CreateTodo(ctx context.Context, arg repository.CreateTodoParams) (models.Todo, error) {
 	return m.CreateTodoFunc(ctx, arg)
 }
 
-func (m *MockQuerier) GetTodo(ctx context.Context, id int32) (models.Todo, error) {
-	return m.GetTodoFunc(ctx, id)
+func (m *MockQuerier) GetTodo(ctx context.Context, arg repository.GetTodoParams) (models.Todo, error) {
+	return m.GetTodoFunc(ctx, arg)
 }
 
-func (m *MockQuerier) ListTodos(ctx context.Context) ([]models.Todo, error) {
-	return m.ListTodosFunc(ctx)
+func (m *MockQuerier) ListTodos(ctx context.Context, arg repository.ListTodosParams) ([]models.Todo, error) {
+	return m.ListTodosFunc(ctx, arg)
 }
 
-func (m *MockQuerier) UpdateTodo(ctx context.Context, arg repository.UpdateTodoParams) (models.Todo, error) {
+func (m *MockQuerier) UpdateTodo(ctx context.Context, arg repository.UpdateTodoParams) (repository.UpdateTodoRow, error) {
 	return m.UpdateTodoFunc(ctx, arg)
 }
 
-func (m *MockQuerier) DeleteTodo(ctx context.Context, id int32) error {
-	return m.DeleteTodoFunc(ctx, id)
+func (m *MockQuerier) DeleteTodo(ctx context.Context, arg repository.DeleteTodoParams) (models.Todo, error) {
+	return m.DeleteTodoFunc(ctx, arg)
 }
 
-func (m *MockQuerier) CompleteTodo(ctx context.Context, arg repository.CompleteTodoParams) (models.Todo, error) {
+func (m *MockQuerier) CompleteTodo(ctx context.Context, arg repository.CompleteTodoParams) (repository.CompleteTodoRow, error) {
 	return m.CompleteTodoFunc(ctx, arg)
 }
 
+func (m *MockQuerier) ClaimDueWebhookDeliveries(ctx context.Context, arg repository.ClaimDueWebhookDeliveriesParams) ([]models.WebhookDelivery, error) {
+	return m.ClaimDueWebhookDeliveriesFunc(ctx, arg)
+}
+
+func (m *MockQuerier) CreateWebhookDelivery(ctx context.Context, arg repository.CreateWebhookDeliveryParams) error {
+	return m.CreateWebhookDeliveryFunc(ctx, arg)
+}
+
+func (m *MockQuerier) CreateWebhookSubscription(ctx context.Context, arg repository.CreateWebhookSubscriptionParams) (models.WebhookSubscription, error) {
+	return m.CreateWebhookSubscriptionFunc(ctx, arg)
+}
+
+func (m *MockQuerier) DeleteWebhookSubscription(ctx context.Context, arg repository.DeleteWebhookSubscriptionParams) (int64, error) {
+	return m.DeleteWebhookSubscriptionFunc(ctx, arg)
+}
+
+func (m *MockQuerier) GetWebhookDelivery(ctx context.Context, id int32) (models.WebhookDelivery, error) {
+	return m.GetWebhookDeliveryFunc(ctx, id)
+}
+
+func (m *MockQuerier) GetWebhookSubscription(ctx context.Context, arg repository.GetWebhookSubscriptionParams) (models.WebhookSubscription, error) {
+	return m.GetWebhookSubscriptionFunc(ctx, arg)
+}
+
+func (m *MockQuerier) ListActiveWebhookSubscriptions(ctx context.Context, tenantID *string) ([]models.WebhookSubscription, error) {
+	return m.ListActiveWebhookSubscriptionsFunc(ctx, tenantID)
+}
+
+func (m *MockQuerier) ListWebhookDeliveries(ctx context.Context, arg repository.ListWebhookDeliveriesParams) ([]models.WebhookDelivery, error) {
+	return m.ListWebhookDeliveriesFunc(ctx, arg)
+}
+
+func (m *MockQuerier) ListWebhookSubscriptions(ctx context.Context, tenantID *string) ([]models.WebhookSubscription, error) {
+	return m.ListWebhookSubscriptionsFunc(ctx, tenantID)
+}
+
+func (m *MockQuerier) RecordWebhookDeliveryAttempt(ctx context.Context, arg repository.RecordWebhookDeliveryAttemptParams) error {
+	return m.RecordWebhookDeliveryAttemptFunc(ctx, arg)
+}
+
+func (m *MockQuerier) RedeliverWebhookDelivery(ctx context.Context, arg repository.RedeliverWebhookDeliveryParams) (models.WebhookDelivery, error) {
+	return m.RedeliverWebhookDeliveryFunc(ctx, arg)
+}
+
+func (m *MockQuerier) UpdateWebhookSubscription(ctx context.Context, arg repository.UpdateWebhookSubscriptionParams) (models.WebhookSubscription, error) {
+	return m.UpdateWebhookSubscriptionFunc(ctx, arg)
+}
+
+func (m *MockQuerier) CreateApiKey(ctx context.Context, arg repository.CreateApiKeyParams) (models.ApiKey, error) {
+	return m.CreateApiKeyFunc(ctx, arg)
+}
+
+func (m *MockQuerier) GetApiKeyByHash(ctx context.Context, keyHash string) (models.ApiKey, error) {
+	return m.GetApiKeyByHashFunc(ctx, keyHash)
+}
+
+func (m *MockQuerier) ListApiKeys(ctx context.Context, tenantID *string) ([]models.ApiKey, error) {
+	return m.ListApiKeysFunc(ctx, tenantID)
+}
+
+func (m *MockQuerier) RevokeApiKey(ctx context.Context, arg repository.RevokeApiKeyParams) (int64, error) {
+	return m.RevokeApiKeyFunc(ctx, arg)
+}
+
+func (m *MockQuerier) TouchApiKey(ctx context.Context, arg repository.TouchApiKeyParams) error {
+	return m.TouchApiKeyFunc(ctx, arg)
+}
+
+func (m *MockQuerier) UpsertUser(ctx context.Context, arg repository.UpsertUserParams) (models.User, error) {
+	return m.UpsertUserFunc(ctx, arg)
+}
+
+func (m *MockQuerier) CreateTenant(ctx context.Context, arg repository.CreateTenantParams) (models.Tenant, error) {
+	return m.CreateTenantFunc(ctx, arg)
+}
+
+func (m *MockQuerier) GetTenant(ctx context.Context, id string) (models.Tenant, error) {
+	return m.GetTenantFunc(ctx, id)
+}
+
+func (m *MockQuerier) ListTenants(ctx context.Context) ([]models.Tenant, error) {
+	return m.ListTenantsFunc(ctx)
+}
+
+func (m *MockQuerier) CreateLocalUser(ctx context.Context, arg repository.CreateLocalUserParams) (models.User, error) {
+	return m.CreateLocalUserFunc(ctx, arg)
+}
+
+func (m *MockQuerier) CreateSession(ctx context.Context, arg repository.CreateSessionParams) error {
+	return m.CreateSessionFunc(ctx, arg)
+}
+
+func (m *MockQuerier) DeleteExpiredSessions(ctx context.Context, arg repository.DeleteExpiredSessionsParams) (int64, error) {
+	return m.DeleteExpiredSessionsFunc(ctx, arg)
+}
+
+func (m *MockQuerier) DeleteSession(ctx context.Context, id string) error {
+	return m.DeleteSessionFunc(ctx, id)
+}
+
+func (m *MockQuerier) GetSession(ctx context.Context, id string) (models.Session, error) {
+	return m.GetSessionFunc(ctx, id)
+}
+
+func (m *MockQuerier) GetUser(ctx context.Context, id int32) (models.User, error) {
+	return m.GetUserFunc(ctx, id)
+}
+
+func (m *MockQuerier) GetUserByUsername(ctx context.Context, username *string) (models.User, error) {
+	return m.GetUserByUsernameFunc(ctx, username)
+}
+
+func (m *MockQuerier) TouchSession(ctx context.Context, arg repository.TouchSessionParams) error {
+	return m.TouchSessionFunc(ctx, arg)
+}
+
+func (m *MockQuerier) DeleteFullRateLimits(ctx context.Context, tat int64) (int64, error) {
+	return m.DeleteFullRateLimitsFunc(ctx, tat)
+}
+
+func (m *MockQuerier) TakeRateLimit(ctx context.Context, arg repository.TakeRateLimitParams) (repository.TakeRateLimitRow, error) {
+	return m.TakeRateLimitFunc(ctx, arg)
+}
+
+func (m *MockQuerier) ClaimIdempotencyKey(ctx context.Context, arg repository.ClaimIdempotencyKeyParams) (int64, error) {
+	return m.ClaimIdempotencyKeyFunc(ctx, arg)
+}
+
+func (m *MockQuerier) CompleteIdempotencyKey(ctx context.Context, arg repository.CompleteIdempotencyKeyParams) error {
+	return m.CompleteIdempotencyKeyFunc(ctx, arg)
+}
+
+func (m *MockQuerier) DeleteExpiredIdempotencyKeys(ctx context.Context, expiresAt time.Time) (int64, error) {
+	return m.DeleteExpiredIdempotencyKeysFunc(ctx, expiresAt)
+}
+
+func (m *MockQuerier) GetIdempotencyKey(ctx context.Context, key string) (models.IdempotencyKey, error) {
+	return m.GetIdempotencyKeyFunc(ctx, key)
+}
+
+func (m *MockQuerier) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	return m.ReleaseIdempotencyKeyFunc(ctx, key)
+}
+
+func (m *MockQuerier) DeleteCacheEntry(ctx context.Context, key string) error {
+	return m.DeleteCacheEntryFunc(ctx, key)
+}
+
+func (m *MockQuerier) DeleteExpiredCacheEntries(ctx context.Context, expiresAt time.Time) (int64, error) {
+	return m.DeleteExpiredCacheEntriesFunc(ctx, expiresAt)
+}
+
+func (m *MockQuerier) GetCacheEntry(ctx context.Context, arg repository.GetCacheEntryParams) ([]byte, error) {
+	return m.GetCacheEntryFunc(ctx, arg)
+}
+
+func (m *MockQuerier) IncrementCacheEntry(ctx context.Context, arg repository.IncrementCacheEntryParams) ([]byte, error) {
+	return m.IncrementCacheEntryFunc(ctx, arg)
+}
+
+func (m *MockQuerier) SetCacheEntry(ctx context.Context, arg repository.SetCacheEntryParams) error {
+	return m.SetCacheEntryFunc(ctx, arg)
+}
+
+func (m *MockQuerier) SearchTodos(ctx context.Context, arg repository.SearchTodosParams) ([]repository.SearchTodosRow, error) {
+	return m.SearchTodosFunc(ctx, arg)
+}
+
 var _ repository.Querier = (*MockQuerier)(nil)
+
+// MockPublisher records every published event.
+type MockPublisher struct {
+	mu     sync.Mutex
+	Events []sse.Event
+	Err    error
+}
+
+func (m *MockPublisher) Publish(ctx context.Context, event sse.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Events = append(m.Events, event)
+	return m.Err
+}
+
+// MockUserService returns User, or Err, for every context.
+type MockUserService struct {
+	User *models.User
+	Err  error
+}
+
+func (m *MockUserService) CurrentUser(ctx context.Context) (*models.User, error) {
+	return m.User, m.Err
+}
+
+// UpdateTodoRow returns the row UpdateTodo returns when it changes before into
+// after.
+func UpdateTodoRow(before, after models.Todo) repository.UpdateTodoRow {
+	return repository.UpdateTodoRow{
+		ID:                  after.ID,
+		Title:               after.Title,
+		Description:         after.Description,
+		Completed:           after.Completed,
+		CreatedAt:           after.CreatedAt,
+		UpdatedAt:           after.UpdatedAt,
+		OwnerID:             after.OwnerID,
+		TenantID:            after.TenantID,
+		Version:             after.Version,
+		PreviousTitle:       before.Title,
+		PreviousDescription: before.Description,
+		PreviousCompleted:   before.Completed,
+		PreviousUpdatedAt:   before.UpdatedAt,
+		PreviousVersion:     before.Version,
+	}
+}